@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xmidt-org/wrpnng/internal/backoff"
+)
+
+// Backoff is the retry policy used when restarting after a failure.  The delay
+// before the first attempt is Initial, and it doubles after each failed attempt
+// up to Max.
+type Backoff struct {
+	// Initial is the delay before the first attempt.
+	Initial time.Duration
+
+	// Max is the longest delay between attempts.  Zero means there is no limit.
+	Max time.Duration
+
+	// MaxFailures is the number of consecutive failed attempts before giving
+	// up.  Zero means never give up.
+	MaxFailures int
+}
+
+// validate returns an error if any of the values are negative.
+func (b Backoff) validate() error {
+	if b.Initial < 0 || b.Max < 0 || b.MaxFailures < 0 {
+		return fmt.Errorf("invalid backoff: %+v", b)
+	}
+	return nil
+}
+
+// delay returns how long to wait before the given attempt, starting at 0.
+func (b Backoff) delay(attempt int) time.Duration {
+	return backoff.Policy(b).Delay(attempt)
+}
+
+// giveUp returns true if no more attempts should be made after the given
+// number of consecutive failures.
+func (b Backoff) giveUp(failures int) bool {
+	return backoff.Policy(b).GiveUp(failures)
+}
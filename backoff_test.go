@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_delay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		want    time.Duration
+	}{
+		{
+			name: "No delay",
+		}, {
+			name:    "First attempt",
+			backoff: Backoff{Initial: time.Second},
+			want:    time.Second,
+		}, {
+			name:    "Doubles each attempt",
+			backoff: Backoff{Initial: time.Second},
+			attempt: 3,
+			want:    8 * time.Second,
+		}, {
+			name:    "Capped",
+			backoff: Backoff{Initial: time.Second, Max: 5 * time.Second},
+			attempt: 3,
+			want:    5 * time.Second,
+		}, {
+			name:    "Initial larger than the cap",
+			backoff: Backoff{Initial: time.Minute, Max: time.Second},
+			want:    time.Second,
+		}, {
+			name:    "Many attempts don't overflow",
+			backoff: Backoff{Initial: time.Second, Max: time.Hour},
+			attempt: 1000,
+			want:    time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.backoff.delay(tt.attempt)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBackoff_delayUncapped(t *testing.T) {
+	b := Backoff{Initial: time.Second}
+	assert.Positive(t, b.delay(1000))
+}
+
+func TestBackoff_giveUp(t *testing.T) {
+	assert.False(t, Backoff{}.giveUp(1000))
+	assert.False(t, Backoff{MaxFailures: 3}.giveUp(2))
+	assert.True(t, Backoff{MaxFailures: 3}.giveUp(3))
+}
+
+func TestBackoff_validate(t *testing.T) {
+	assert.NoError(t, Backoff{}.validate())
+	assert.NoError(t, Backoff{Initial: time.Second, Max: time.Minute, MaxFailures: 3}.validate())
+	assert.Error(t, Backoff{Initial: -1}.validate())
+	assert.Error(t, Backoff{Max: -1}.validate())
+	assert.Error(t, Backoff{MaxFailures: -1}.validate())
+}
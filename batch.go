@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// ProcessWRPBatch processes the messages in order, the same as calling
+// ProcessWRP for each one, and returns the error for each message at the same
+// index.  It is a convenience only: nothing is shared between the messages,
+// since each one passes through the ingress chain and is encoded by its sender
+// on its own, so a batch costs the same as calling ProcessWRP in a loop.  A nil error means the message was sent.  Once the context is done,
+// the remaining messages are not processed and their errors are the context's
+// error.  The returned slice is nil if there are no messages.
+func (srv *Server) ProcessWRPBatch(ctx context.Context, msgs []wrp.Message) []error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(errs); j++ {
+				errs[j] = err
+			}
+			break
+		}
+
+		errs[i] = srv.ProcessWRP(ctx, msg)
+	}
+
+	return errs
+}
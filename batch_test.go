@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestServer_ProcessWRPBatch(t *testing.T) {
+	routable := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/service",
+	}
+	unroutable := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/unknown",
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		msgs       []wrp.Message
+		expect     []error
+		expectSent int
+	}{
+		{
+			name: "no messages",
+		}, {
+			name:       "mixed",
+			ctx:        context.Background(),
+			msgs:       []wrp.Message{routable, unroutable, {}, routable},
+			expect:     []error{nil, ErrNoRoute, ErrInvalidMessage, nil},
+			expectSent: 2,
+		}, {
+			name:       "nil context",
+			msgs:       []wrp.Message{routable},
+			expect:     []error{nil},
+			expectSent: 1,
+		}, {
+			name:   "context done",
+			ctx:    canceled,
+			msgs:   []wrp.Message{routable, routable},
+			expect: []error{context.Canceled, context.Canceled},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(withReceiver(&mockReceiver{}))
+			require.NoError(t, err)
+
+			ms := &mockSender{}
+			srv.senders.senders = map[string]limitedSender{
+				"service": ms,
+			}
+
+			errs := srv.ProcessWRPBatch(tt.ctx, tt.msgs)
+			require.Len(t, errs, len(tt.expect))
+			for i, expect := range tt.expect {
+				if expect == nil {
+					assert.NoError(t, errs[i], i)
+				} else {
+					assert.ErrorIs(t, errs[i], expect, i)
+				}
+			}
+			assert.Equal(t, tt.expectSent, ms.processCount)
+		})
+	}
+}
+
+func TestServer_ProcessWRPBatchCanceled(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+
+	// The context is canceled while the second message is sent, so the rest
+	// of the batch is skipped.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ms := &mockSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"service": &cancelingSender{mockSender: ms, after: 2, cancel: cancel},
+	}
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/service",
+	}
+	errs := srv.ProcessWRPBatch(ctx, []wrp.Message{msg, msg, msg, msg})
+	require.Len(t, errs, 4)
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.ErrorIs(t, errs[2], context.Canceled)
+	assert.ErrorIs(t, errs[3], context.Canceled)
+	assert.Equal(t, 2, ms.processCount)
+}
+
+// cancelingSender cancels the context once it has sent after messages.
+type cancelingSender struct {
+	*mockSender
+	after  int
+	cancel context.CancelFunc
+}
+
+func (s *cancelingSender) ProcessWRP(ctx context.Context, msg wrp.Message) error {
+	err := s.mockSender.ProcessWRP(ctx, msg)
+	if s.processCount >= s.after {
+		s.cancel()
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/filters"
+)
+
+// MessageCategory groups the message types that are handled the same way.
+type MessageCategory = filters.Category
+
+const (
+	// CategoryUnknown is the category of the invalid and unknown message
+	// types.
+	CategoryUnknown = filters.CategoryUnknown
+
+	// CategoryEvent is the category of SimpleEvent messages.
+	CategoryEvent = filters.CategoryEvent
+
+	// CategoryRequest is the category of SimpleRequestResponse messages.
+	CategoryRequest = filters.CategoryRequest
+
+	// CategoryCRUD is the category of Create, Retrieve, Update and Delete
+	// messages.
+	CategoryCRUD = filters.CategoryCRUD
+
+	// CategoryLocal is the category of Authorization, ServiceRegistration and
+	// ServiceAlive messages.
+	CategoryLocal = filters.CategoryLocal
+)
+
+// CategoryOf returns the category of the message type.
+func CategoryOf(t wrp.MessageType) MessageCategory {
+	return filters.CategoryOf(t)
+}
+
+// SplitByCategory returns a processor that passes each message to the
+// processor for the category of its type, such as a dedicated handler for CRUD
+// messages, and returns wrp.ErrNotHandled for the other categories.  Use it
+// with WithIngressProcessor or WithEgressProcessor.  The handler's result is
+// treated the same as any other processor's in that chain; for example, in the
+// ingress chain a handler returning nil has handled the message, so it isn't
+// sent.
+func SplitByCategory(routes map[MessageCategory]wrp.Processor) wrp.Processor {
+	return filters.SplitByCategory(routes)
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestSplitByCategory(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType wrp.MessageType
+		expectCRUD  int
+		expectSent  int
+	}{
+		{name: "Create", messageType: wrp.CreateMessageType, expectCRUD: 1},
+		{name: "Retrieve", messageType: wrp.RetrieveMessageType, expectCRUD: 1},
+		{name: "Update", messageType: wrp.UpdateMessageType, expectCRUD: 1},
+		{name: "Delete", messageType: wrp.DeleteMessageType, expectCRUD: 1},
+		{name: "Event", messageType: wrp.SimpleEventMessageType, expectSent: 1},
+		{name: "Request", messageType: wrp.SimpleRequestResponseMessageType, expectSent: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var crud int
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithIngressProcessor(SplitByCategory(map[MessageCategory]wrp.Processor{
+					CategoryCRUD: wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+						crud++
+						return nil
+					}),
+				}), AfterFilters),
+			)
+			require.NoError(t, err)
+
+			ms := &mockSender{}
+			srv.senders.senders = map[string]limitedSender{
+				"service": ms,
+			}
+
+			err = srv.ProcessWRP(context.Background(), wrp.Message{
+				Type:            tt.messageType,
+				Source:          "dns:example.com",
+				Destination:     "mac:112233445566/service",
+				TransactionUUID: "1234",
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectCRUD, crud)
+			assert.Equal(t, tt.expectSent, ms.processCount)
+			assert.Equal(t, CategoryOf(tt.messageType) == CategoryCRUD, crud == 1)
+		})
+	}
+}
@@ -5,8 +5,14 @@ package wrpnng
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/xmidt-org/eventor"
 	"github.com/xmidt-org/wrp-go/v3"
@@ -14,12 +20,26 @@ import (
 	"github.com/xmidt-org/wrpnng/internal/sender"
 )
 
+var (
+	// ErrPartnerMismatch is returned when the Client was created using
+	// WithPartnerIDs and a message's partner IDs don't include any of the
+	// configured partner IDs.
+	ErrPartnerMismatch = errors.New("partner ids don't match")
+
+	errClientNotStarted = errors.New("client is not started")
+)
+
+// registerTimeout bounds sending the registration message to the server.
+const registerTimeout = 5 * time.Second
+
 // Client is a WRP <-> nanomsg client.  The client is responsible for sending
 // messages to the network and receiving messages from the network.  It also
 // handles the registration message and sends heartbeats at regular intervals.
 type Client struct {
-	clientURL string
-	serverURL string
+	clientURL   string
+	serverURL   string
+	serviceName string
+	formats     []wrp.Format
 
 	rOpts []receiver.Option
 	r     *receiver.Receiver
@@ -27,15 +47,43 @@ type Client struct {
 	sOpts []sender.Option
 	s     *sender.Sender
 
-	egress eventor.Eventor[wrp.Modifier]
+	egress     eventor.Eventor[wrp.Modifier]
+	partnerIDs []string
+
+	reconnect bool
+	backoff   Backoff
+	onGiveUp  eventor.Eventor[func(error)]
+
+	// connected is true while the Client is started and, if it reconnects,
+	// registered with the server.  dialed is true once Start has connected.
+	connected atomic.Bool
+	dialed    atomic.Bool
+
+	heartbeatInterval time.Duration
+	clock             clock
+	cancel            context.CancelFunc
+	wg                sync.WaitGroup
+	lock              sync.Mutex
+
+	// goLock keeps Stop from waiting on the goroutines while a pipe event is
+	// starting a new one.  It also guards recovering and lostAgain.
+	goLock sync.Mutex
+
+	// recovering is true while the Client is reconnecting to the server, and
+	// lostAgain is set if the connection is lost again in the meantime.
+	recovering bool
+	lostAgain  bool
 }
 
 // NewClient creates a new client.  The client is not started until Start is
-// called.
+// called.  The default heartbeat interval is 30 seconds.
 func NewClient(opts ...ClientOption) (*Client, error) {
 	var client Client
 
-	defaults := []ClientOption{} // nolint:prealloc
+	defaults := []ClientOption{ // nolint:prealloc
+		WithClientHeartbeatInterval(30 * time.Second),
+		withClientClock(realClock{}),
+	}
 
 	vadors := []ClientOption{
 		determineClientURL(),
@@ -53,24 +101,340 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 		}
 	}
 
-	return &Client{}, nil
+	return &client, nil
 }
 
-// Start starts the client.  This call is idempotent.
+// Start starts the client by connecting to the server and sending heartbeats.
+// If the Client has a service name, it first listens on the client URL, then
+// registers with the server once connected.  This call is idempotent.
 func (c *Client) Start() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.s != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var s *sender.Sender
+	opts := append(slices.Clip(c.sOpts), sender.WithURL(c.serverURL))
+	if c.reconnect {
+		opts = append(opts,
+			sender.WithReconnectInterval(c.backoff.Initial, c.backoff.Max),
+			sender.WithPipeEventListener(func(ev sender.PipeEvent) {
+				c.pipeEvent(ctx, s, ev)
+			}),
+			sender.WithCloseListener(func(err error) {
+				// Stop closes the sender without an error.
+				if err != nil {
+					c.lost(ctx, s)
+				}
+			}),
+		)
+	}
+
+	s, err := sender.New(opts...)
+	if err == nil {
+		err = c.listen()
+	}
+	if err == nil {
+		err = s.Dial()
+	}
+	if err == nil {
+		err = c.register(ctx, s)
+	}
+	if err != nil {
+		cancel()
+		if s != nil {
+			_ = s.Close()
+		}
+		_ = c.closeReceiver()
+		return err
+	}
+
+	c.s = s
+	c.cancel = cancel
+	c.connected.Store(true)
+	c.dialed.Store(true)
+
+	if c.heartbeatInterval > 0 {
+		c.wg.Add(1)
+		go c.sendHeartbeat(ctx, s)
+	}
+
 	return nil
 }
 
+// listen starts receiving messages on the client URL if the Client has a
+// service name, so the server can send to it once registered.  The lock must
+// be held.
+func (c *Client) listen() error {
+	if c.serviceName == "" {
+		return nil
+	}
+
+	opts := append(slices.Clip(c.rOpts),
+		receiver.WithURL(c.clientURL),
+		receiver.WithModifyWRP(wrp.ProcessorAsModifier(wrp.ProcessorFunc(c.received))),
+	)
+
+	r, err := receiver.New(opts...)
+	if err != nil {
+		return err
+	}
+
+	if err = r.Listen(); err != nil {
+		return err
+	}
+
+	c.r = r
+	return nil
+}
+
+// closeReceiver stops receiving messages.  The lock must be held.
+func (c *Client) closeReceiver() error {
+	if c.r == nil {
+		return nil
+	}
+
+	err := c.r.Close()
+	c.r = nil
+	return err
+}
+
+// register sends the registration message to the server if the Client has a
+// service name.
+func (c *Client) register(ctx context.Context, s *sender.Sender) error {
+	if c.serviceName == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, registerTimeout)
+	defer cancel()
+
+	msg := wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: c.serviceName,
+		URL:         c.clientURL,
+	}
+
+	if len(c.formats) > 0 {
+		cts := make([]string, 0, len(c.formats))
+		for _, f := range c.formats {
+			cts = append(cts, f.ContentType())
+		}
+		msg.Metadata = map[string]string{
+			FormatsMetadataKey: strings.Join(cts, ","),
+		}
+	}
+
+	return s.ProcessWRP(ctx, msg)
+}
+
+// pipeEvent tracks the connection to the server when the Client reconnects.
+// Losing the connection stops sends until the Client has reconnected.
+func (c *Client) pipeEvent(ctx context.Context, s *sender.Sender, ev sender.PipeEvent) {
+	if ev.Type == sender.PipeDetached {
+		c.lost(ctx, s)
+	}
+}
+
+// lost stops sends and starts reconnecting to the server, unless the Client is
+// already reconnecting, in which case it has to register once more.
+func (c *Client) lost(ctx context.Context, s *sender.Sender) {
+	c.connected.Store(false)
+
+	// Start connects the first time.
+	if !c.dialed.Load() {
+		return
+	}
+
+	c.goLock.Lock()
+	defer c.goLock.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	if c.recovering {
+		c.lostAgain = true
+		return
+	}
+
+	// Sending on the socket from its pipe hook would deadlock.
+	c.recovering = true
+	c.wg.Add(1)
+	go c.reconnectServer(ctx, s)
+}
+
+// reconnectServer dials the server again if a failed send closed the
+// connection, since the socket only redials on its own while it is open, then
+// registers again and allows sends.  Failed attempts are retried using the
+// backoff.  If it gives up, the reconnect failure listeners are called and the
+// Client stays disconnected.
+func (c *Client) reconnectServer(ctx context.Context, s *sender.Sender) {
+	defer c.wg.Done()
+
+	var err error
+	for failures := 0; !c.backoff.giveUp(failures); {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(c.backoff.delay(failures)):
+		}
+
+		c.goLock.Lock()
+		c.lostAgain = false
+		c.goLock.Unlock()
+
+		if err = s.DialContext(ctx); err == nil {
+			err = c.register(ctx, s)
+		}
+		if err != nil {
+			failures++
+			continue
+		}
+
+		// Only allow sends if the connection wasn't lost again meanwhile.
+		c.goLock.Lock()
+		if !c.lostAgain {
+			c.recovering = false
+			c.connected.Store(true)
+			c.goLock.Unlock()
+			return
+		}
+		c.goLock.Unlock()
+		failures = 0
+	}
+
+	c.goLock.Lock()
+	c.recovering = false
+	c.goLock.Unlock()
+
+	if ctx.Err() == nil {
+		err = fmt.Errorf("reconnecting to %s: %w", c.serverURL, err)
+		c.onGiveUp.Visit(func(f func(error)) {
+			f(err)
+		})
+	}
+}
+
 // Stop stops the client.  This call is idempotent.
 func (c *Client) Stop() error {
-	return nil
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.connected.Store(false)
+	c.dialed.Store(false)
+
+	if c.cancel != nil {
+		c.goLock.Lock()
+		c.cancel()
+		c.goLock.Unlock()
+		c.cancel = nil
+	}
+
+	var err error
+	if c.s != nil {
+		err = c.s.Close()
+		c.s = nil
+	}
+
+	c.wg.Wait()
+
+	return errors.Join(err, c.closeReceiver())
+}
+
+// sendHeartbeat sends a ServiceAlive message to the server at regular intervals
+// until the context is canceled.
+func (c *Client) sendHeartbeat(ctx context.Context, s *sender.Sender) {
+	defer c.wg.Done()
+
+	msg := wrp.Message{
+		Type: wrp.ServiceAliveMessageType,
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(c.heartbeatInterval):
+			if !c.connected.Load() {
+				continue
+			}
+
+			// Bound the send so a stuck server can't delay the next heartbeat.
+			sendCtx, cancel := context.WithTimeout(ctx, c.heartbeatInterval)
+			_ = s.ProcessWRP(sendCtx, msg)
+			cancel()
+		}
+	}
 }
 
-// ProcessWRP is called when a message should be sent to the network.
+// ProcessWRP is called when a message should be sent to the network.  The
+// Client must be started.  If the Client was created using WithClientReconnect
+// and the connection to the server was lost, the message is not sent or
+// buffered; an error matching ErrConnClosed is returned until the Client has
+// reconnected.  If the Client was created using WithPartnerIDs, a
+// message without partner IDs is sent with the configured partner IDs.  A
+// message that already has partner IDs keeps them, but it is rejected with
+// ErrPartnerMismatch unless one of them is a configured partner ID.
 func (c *Client) ProcessWRP(ctx context.Context, msg wrp.Message) error {
+	if len(c.partnerIDs) > 0 && len(msg.PartnerIDs) == 0 {
+		msg.PartnerIDs = slices.Clone(c.partnerIDs)
+	}
+
+	if err := c.checkPartners(msg); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	s := c.s
+	c.lock.Unlock()
+
+	if s == nil {
+		return errClientNotStarted
+	}
+
+	if !c.connected.Load() {
+		return ErrConnClosed
+	}
+
+	return s.ProcessWRP(ctx, msg)
+}
+
+// received handles a message received from the network.  Messages for other
+// partners are dropped, and the rest are passed to the received modifiers.
+func (c *Client) received(ctx context.Context, msg wrp.Message) error {
+	if err := c.checkPartners(msg); err != nil {
+		return err
+	}
+
+	c.egress.Visit(func(m wrp.Modifier) {
+		_, _ = m.ModifyWRP(ctx, msg)
+	})
+
 	return nil
 }
 
+// checkPartners returns ErrPartnerMismatch if partner IDs are configured and
+// the message doesn't have any of them.  ServiceAlive messages don't belong to
+// a partner and are always allowed.
+func (c *Client) checkPartners(msg wrp.Message) error {
+	if len(c.partnerIDs) == 0 || msg.Type == wrp.ServiceAliveMessageType {
+		return nil
+	}
+
+	for _, id := range msg.PartnerIDs {
+		if slices.Contains(c.partnerIDs, id) {
+			return nil
+		}
+	}
+
+	return ErrPartnerMismatch
+}
+
 func findOpenURL() (string, error) {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -4,9 +4,15 @@
 package wrpnng
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"slices"
+	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+	"github.com/xmidt-org/wrpnng/internal/sender"
 )
 
 // ClientOption is the interface implemented by types that can be used to
@@ -38,14 +44,217 @@ func WithClientURL(url string) ClientOption {
 }
 
 // WithServerURL sets the URL used for connecting to the network server.  This is
-// required.  The URL should be in the format of "tcp://<ip>:<port>" unless other
-// transports are registered.
+// required.  The URL should be in the format of "tcp://<ip>:<port>", or a ws or
+// wss URL.  See RXURL for using WebSocket URLs.
 func WithServerURL(url string) ClientOption {
 	return clientOptionFunc(func(c *Client) {
 		c.serverURL = url
 	})
 }
 
+// WithClientTLSConfig sets the TLS config used for wss URLs.  It is used to
+// dial the server, and when the client URL is a wss URL, it must include the
+// certificate the Client presents when listening.  It is ignored for the other
+// transports.
+func WithClientTLSConfig(cfg *tls.Config) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.rOpts = append(c.rOpts, receiver.WithTLSConfig(cfg))
+		c.sOpts = append(c.sOpts, sender.WithTLSConfig(cfg))
+	})
+}
+
+// WithClientServiceName sets the name the Client registers with the server.
+// When set, Start listens on the client URL and sends the server a
+// registration message, so the server can send messages for the service to
+// the Client.  By default, the Client doesn't register.
+func WithClientServiceName(name string) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.serviceName = name
+	})
+}
+
+// WithClientReconnect makes the Client recover from server outages.  After the
+// connection is lost, including when a send fails, the server is dialed again
+// and the Client registers again.  The attempts start after backoff.Initial,
+// the delay doubles up to backoff.Max, and after backoff.MaxFailures failed
+// attempts in a row the Client gives up, calls the listeners added using
+// WithClientReconnectFailureListener, and stays disconnected until it is
+// stopped and started again.  Messages are not buffered while disconnected;
+// ProcessWRP fails with ErrConnClosed until the Client has registered again,
+// and heartbeats are skipped.
+func WithClientReconnect(backoff Backoff) ClientOption {
+	return errClientOptionFunc(func(c *Client) error {
+		if err := backoff.validate(); err != nil {
+			return err
+		}
+
+		c.reconnect = true
+		c.backoff = backoff
+		return nil
+	})
+}
+
+// WithClientReconnectFailureListener adds a listener that is called when the
+// Client, created using WithClientReconnect, gives up reconnecting to the
+// server.  The error is the reason the last attempt failed.  The optional
+// cancel function pointers are set to functions that remove the listener.
+func WithClientReconnectFailureListener(f func(error), cancel ...*func()) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		cancelFn := c.onGiveUp.Add(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
+// WithClientPayloadCompression makes the Client gzip the messages it sends to
+// the server using the compression level, such as gzip.DefaultCompression, and
+// decompress the compressed messages it receives.  Messages that don't get
+// smaller are sent uncompressed.  The server must use WithPayloadCompression so
+// it can read the compressed messages.  Received messages that decompress to
+// more than the size set using WithClientMaxMessageBytes, or 16 MiB if it
+// isn't set, are dropped.  By default, messages are not compressed.
+func WithClientPayloadCompression(level int) ClientOption {
+	return errClientOptionFunc(func(c *Client) error {
+		if err := validateCompression(level); err != nil {
+			return err
+		}
+
+		c.sOpts = append(c.sOpts, sender.WithCompression(level))
+		c.rOpts = append(c.rOpts, receiver.WithDecompression())
+		return nil
+	})
+}
+
+// WithClientSendRetries makes the Client retry a failed send to the server up
+// to count times, waiting backoff before each retry and dialing the server
+// again first.  Only messages that are safe to send twice are retried, such as
+// simple events; requests fail on the first error.  By default, sends are not
+// retried.  Negative values are an error.
+func WithClientSendRetries(count int, backoff time.Duration) ClientOption {
+	return withClientSenderOption(sender.WithSendRetries(count, backoff))
+}
+
+// WithClientDropOnFull makes the Client drop a message when the send times out
+// because the connection's queue is full, instead of closing the connection.
+// The send fails with ErrDropped.  This suits lossy event streams.
+func WithClientDropOnFull() ClientOption {
+	return withClientSenderOption(sender.WithDropOnFull())
+}
+
+// WithClientSendBuffer puts a queue holding up to n messages in front of the
+// connection to the server, so bursts don't fail while the connection's own
+// short queue is full.  ProcessWRP returns once the message is queued.
+// Messages still queued when the Client stops are discarded.  The default of
+// zero means no buffer.  A negative size is an error.
+func WithClientSendBuffer(n int) ClientOption {
+	return withClientSenderOption(sender.WithSendBuffer(n))
+}
+
+// WithClientAutoRedial makes the Client dial the server again when a message
+// is sent after the connection was closed, such as after a send failure,
+// instead of failing the send.  Only a single attempt is made per message.
+// Unlike WithClientReconnect, the Client doesn't register again.
+func WithClientAutoRedial() ClientOption {
+	return withClientSenderOption(sender.WithAutoRedial())
+}
+
+// WithClientSenderCloseTimeout bounds how long Stop waits for the connection
+// to the server to close, including for a send still in progress.  After the
+// timeout the connection is left to close in the background.  The default of
+// zero waits as long as it takes.  A negative timeout is an error.
+func WithClientSenderCloseTimeout(d time.Duration) ClientOption {
+	return withClientSenderOption(sender.WithCloseTimeout(d))
+}
+
+// WithClientWriteQueueLen sets the length of the write queue of the connection
+// to the server, in messages.  The default of 1 means a dead connection is
+// detected by the next send.  A longer queue absorbs bursts, but sends succeed
+// while the queue has room even if the connection is dead, and the queued
+// messages are lost when it is closed.  WithClientSendBuffer buffers bursts
+// without this tradeoff.  The length must be at least 1.
+func WithClientWriteQueueLen(n int) ClientOption {
+	return withClientSenderOption(sender.WithWriteQueueLen(n))
+}
+
+// WithClientSenderSocketOption sets a mangos socket option, such as
+// mangos.OptionKeepAlive, on the connection to the server before it is
+// dialed.  If the socket rejects the option, Start fails.  The name must not
+// be empty.
+func WithClientSenderSocketOption(name string, value any) ClientOption {
+	return withClientSenderOption(sender.WithSocketOption(name, value))
+}
+
+// withClientSenderOption adds an option for the connection to the server.  It
+// is checked right away, so a bad value is reported by NewClient instead of
+// Start.
+func withClientSenderOption(opt sender.Option) ClientOption {
+	return errClientOptionFunc(func(c *Client) error {
+		if err := checkSenderOption(opt); err != nil {
+			return err
+		}
+
+		c.sOpts = append(c.sOpts, opt)
+		return nil
+	})
+}
+
+// WithClientMaxMessageBytes sets the largest message the Client accepts from
+// the server.  A larger message has its connection dropped by the transport
+// before the message is read into memory, and when
+// WithClientPayloadCompression is used, it also limits the size of each
+// message once decompressed.  A value of zero or less keeps the transport's
+// default limit, which is the default.
+func WithClientMaxMessageBytes(n int) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.rOpts = append(c.rOpts, receiver.WithMaxMessageBytes(n))
+	})
+}
+
+// WithClientFormats sets the WRP formats the Client receives, in the order it
+// prefers them.  They are advertised in the registration message (see
+// FormatsMetadataKey), so the server sends messages to the Client using one of
+// them.  Only msgpack and JSON are supported.  By default, the Client only
+// receives msgpack.
+func WithClientFormats(preferred ...wrp.Format) ClientOption {
+	return errClientOptionFunc(func(c *Client) error {
+		for _, f := range preferred {
+			if !slices.Contains(encodableFormats, f) {
+				return fmt.Errorf("unsupported client format: %s", f)
+			}
+		}
+
+		c.formats = append(c.formats[:0:0], preferred...)
+		c.rOpts = append(c.rOpts, receiver.WithFormats(preferred...))
+		return nil
+	})
+}
+
+// WithClientHeartbeatInterval sets the interval for sending heartbeats to the
+// server.  A zero or negative interval disables heartbeats.
+func WithClientHeartbeatInterval(interval time.Duration) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.heartbeatInterval = interval
+	})
+}
+
+// WithPartnerIDs sets the partner IDs the Client belongs to.  Messages sent
+// without partner IDs are given these partner IDs.  Other messages sent, and
+// all messages received except ServiceAlive, must include at least one of
+// these partner IDs or they are rejected with ErrPartnerMismatch.  Empty IDs
+// are ignored.  By default, partner IDs are neither set nor checked.
+func WithPartnerIDs(ids ...string) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		for _, id := range ids {
+			if id != "" && !slices.Contains(c.partnerIDs, id) {
+				c.partnerIDs = append(c.partnerIDs, id)
+			}
+		}
+	})
+}
+
 // WithReceivedModifier adds a modifier to the list of modifiers that are informed
 // of messages received by the client.  The modifier can change the message, but
 // any error returned by the modifier is ignored.
@@ -62,6 +271,14 @@ func WithReceivedModifier(modifier wrp.Modifier, cancel ...*func()) ClientOption
 
 //------------------------------------------------------------------------------
 
+// withClientClock sets the clock used for the heartbeats and the reconnects.
+// This is intended for testing.
+func withClientClock(clk clock) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.clock = clk
+	})
+}
+
 func determineClientURL() ClientOption {
 	return errClientOptionFunc(func(c *Client) error {
 		if c.clientURL != "" {
@@ -0,0 +1,867 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestClient_Heartbeat(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		atLeast  int64
+	}{
+		{
+			name:     "Heartbeats are sent",
+			interval: 20 * time.Millisecond,
+			atLeast:  3,
+		}, {
+			name: "Heartbeats are disabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := findOpenURL()
+			require.NoError(t, err)
+
+			var heartbeats atomic.Int64
+			srv, err := NewServer(
+				RXURL(url),
+				RXTimeout(10*time.Millisecond),
+				WithHeartbeatInterval(0),
+				WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+					if msg.Type == wrp.ServiceAliveMessageType {
+						heartbeats.Add(1)
+					}
+				})),
+			)
+			require.NoError(t, err)
+			require.NoError(t, srv.Start())
+			defer srv.Stop() // nolint:errcheck
+
+			client, err := NewClient(
+				WithServerURL(url),
+				WithClientHeartbeatInterval(tt.interval),
+			)
+			require.NoError(t, err)
+			require.NoError(t, client.Start())
+			defer client.Stop() // nolint:errcheck
+
+			if tt.atLeast == 0 {
+				time.Sleep(200 * time.Millisecond)
+				assert.Zero(t, heartbeats.Load())
+				return
+			}
+
+			assert.Eventually(t, func() bool {
+				return heartbeats.Load() >= tt.atLeast
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// No more heartbeats once the client is stopped.
+			require.NoError(t, client.Stop())
+			time.Sleep(50 * time.Millisecond)
+			stopped := heartbeats.Load()
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, stopped, heartbeats.Load())
+		})
+	}
+}
+
+func TestClient_HeartbeatClock(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	var heartbeats atomic.Int64
+	srv, err := NewServer(
+		RXURL(url),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+		WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+			if msg.Type == wrp.ServiceAliveMessageType {
+				heartbeats.Add(1)
+			}
+		})),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	fc := newFakeClock()
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientHeartbeatInterval(time.Minute),
+		withClientClock(fc),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	// A heartbeat is only sent once the clock reaches the interval.
+	for i := int64(1); i <= 3; i++ {
+		fc.BlockUntil(1)
+		fc.Advance(time.Minute - time.Second)
+		assert.Equal(t, i-1, heartbeats.Load())
+
+		fc.Advance(time.Second)
+		assert.Eventually(t, func() bool {
+			return heartbeats.Load() == i
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestClient_PartnerIDs(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	received := make(chan wrp.Message, 10)
+	srv, err := NewServer(
+		RXURL(url),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+		WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+			if msg.Type == wrp.SimpleEventMessageType {
+				received <- msg
+			}
+		})),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	tests := []struct {
+		name        string
+		partnerIDs  []string
+		msgIDs      []string
+		expectErr   error
+		expectedIDs []string
+	}{
+		{
+			name: "No partner IDs configured",
+		}, {
+			name:        "No partner IDs configured, message keeps its own",
+			msgIDs:      []string{"comcast"},
+			expectedIDs: []string{"comcast"},
+		}, {
+			name:        "Partner IDs are stamped",
+			partnerIDs:  []string{"comcast", "sky"},
+			expectedIDs: []string{"comcast", "sky"},
+		}, {
+			name:        "Matching partner IDs are kept",
+			partnerIDs:  []string{"comcast", "sky"},
+			msgIDs:      []string{"other", "sky"},
+			expectedIDs: []string{"other", "sky"},
+		}, {
+			name:       "Other partner IDs are rejected",
+			partnerIDs: []string{"comcast"},
+			msgIDs:     []string{"other"},
+			expectErr:  ErrPartnerMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(
+				WithServerURL(url),
+				WithClientHeartbeatInterval(0),
+				WithPartnerIDs(tt.partnerIDs...),
+			)
+			require.NoError(t, err)
+			require.NoError(t, client.Start())
+			defer client.Stop() // nolint:errcheck
+
+			msg := wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "mac:112233445566/service",
+				Destination: "event:status",
+				PartnerIDs:  tt.msgIDs,
+			}
+			err = client.ProcessWRP(context.Background(), msg)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+
+			select {
+			case got := <-received:
+				assert.Equal(t, tt.expectedIDs, got.PartnerIDs)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "message not received")
+			}
+		})
+	}
+}
+
+func TestClient_ProcessWRPNotStarted(t *testing.T) {
+	client, err := NewClient(WithServerURL("tcp://127.0.0.1:1"))
+	require.NoError(t, err)
+
+	err = client.ProcessWRP(context.Background(), wrp.Message{
+		Type: wrp.SimpleEventMessageType,
+	})
+	assert.ErrorIs(t, err, errClientNotStarted)
+}
+
+func TestClient_ReceivedPartnerIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		partnerIDs []string
+		msg        wrp.Message
+		expectErr  error
+	}{
+		{
+			name: "No partner IDs configured",
+			msg: wrp.Message{
+				Type: wrp.SimpleEventMessageType,
+			},
+		}, {
+			name:       "Matching partner ID",
+			partnerIDs: []string{"comcast", "sky"},
+			msg: wrp.Message{
+				Type:       wrp.SimpleEventMessageType,
+				PartnerIDs: []string{"sky"},
+			},
+		}, {
+			name:       "Other partner ID",
+			partnerIDs: []string{"comcast"},
+			msg: wrp.Message{
+				Type:       wrp.SimpleEventMessageType,
+				PartnerIDs: []string{"sky"},
+			},
+			expectErr: ErrPartnerMismatch,
+		}, {
+			name:       "Missing partner IDs",
+			partnerIDs: []string{"comcast"},
+			msg: wrp.Message{
+				Type: wrp.SimpleEventMessageType,
+			},
+			expectErr: ErrPartnerMismatch,
+		}, {
+			name:       "ServiceAlive is always accepted",
+			partnerIDs: []string{"comcast"},
+			msg: wrp.Message{
+				Type: wrp.ServiceAliveMessageType,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var count int
+			client, err := NewClient(
+				WithServerURL("tcp://127.0.0.1:1"),
+				WithPartnerIDs(tt.partnerIDs...),
+				WithReceivedModifier(wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+					count++
+					return msg, nil
+				})),
+			)
+			require.NoError(t, err)
+
+			err = client.received(context.Background(), tt.msg)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				assert.Zero(t, count)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 1, count)
+		})
+	}
+}
+
+func TestWithPartnerIDs(t *testing.T) {
+	client, err := NewClient(
+		WithServerURL("tcp://127.0.0.1:1"),
+		WithPartnerIDs("comcast", "", "sky"),
+		WithPartnerIDs("comcast"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"comcast", "sky"}, client.partnerIDs)
+}
+
+func TestClient_Register(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	srv, err := NewServer(
+		RXURL(url),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	received := make(chan wrp.Message, 10)
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(0),
+		WithReceivedModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				if msg.Type == wrp.SimpleEventMessageType {
+					received <- msg
+				}
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The server can send to the client.
+	require.NoError(t, srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "event:status",
+		Destination: "mac:112233445566/client",
+	}))
+
+	select {
+	case got := <-received:
+		assert.Equal(t, "mac:112233445566/client", got.Destination)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestClient_Formats(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	srv, err := NewServer(
+		RXURL(url),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	// The client only decodes JSON, so only JSON messages are received.
+	received := make(chan wrp.Message, 10)
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(0),
+		WithClientFormats(wrp.JSON),
+		WithReceivedModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				if msg.Type == wrp.SimpleEventMessageType {
+					received <- msg
+				}
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	srv.senders.lock.RLock()
+	s := srv.senders.senders["client"]
+	srv.senders.lock.RUnlock()
+	assert.Equal(t, wrp.JSON, s.Format())
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, srv.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "event:status",
+			Destination: "mac:112233445566/client",
+		}))
+
+		select {
+		case got := <-received:
+			assert.Equal(t, "mac:112233445566/client", got.Destination)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "message not received")
+		}
+	}
+}
+
+func TestWithClientFormats(t *testing.T) {
+	client, err := NewClient(
+		WithServerURL("tcp://127.0.0.1:1"),
+		WithClientFormats(wrp.JSON, wrp.Msgpack),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []wrp.Format{wrp.JSON, wrp.Msgpack}, client.formats)
+
+	_, err = NewClient(
+		WithServerURL("tcp://127.0.0.1:1"),
+		WithClientFormats(wrp.Format(99)),
+	)
+	assert.Error(t, err)
+}
+
+func TestWithClientMaxMessageBytes(t *testing.T) {
+	client, err := NewClient(
+		WithServerURL("tcp://127.0.0.1:1"),
+		WithClientMaxMessageBytes(1024),
+	)
+	require.NoError(t, err)
+
+	// The Client only creates its receiver when it starts, so create one the
+	// same way.
+	r, err := receiver.New(append(client.rOpts, receiver.WithURL("tcp://127.0.0.1:1"))...)
+	require.NoError(t, err)
+
+	var buf []byte
+	require.NoError(t, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "event:status",
+		Destination: "mac:112233445566/client",
+		Payload:     make([]byte, 2048),
+	}))
+	assert.ErrorIs(t, r.Inject(context.Background(), buf), receiver.ErrMessageTooLarge)
+}
+
+func TestClient_PayloadCompression(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	payload := bytes.Repeat([]byte("compressible payload "), 256)
+
+	toServer := make(chan wrp.Message, 10)
+	srv, err := NewServer(
+		RXURL(url),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+		WithPayloadCompression(gzip.DefaultCompression),
+		WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+			if msg.Type == wrp.SimpleEventMessageType {
+				toServer <- msg
+			}
+		})),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	toClient := make(chan wrp.Message, 10)
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(0),
+		WithClientPayloadCompression(gzip.BestSpeed),
+		WithReceivedModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				if msg.Type == wrp.SimpleEventMessageType {
+					toClient <- msg
+				}
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, client.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/client",
+		Destination: "event:status",
+		Payload:     payload,
+	}))
+	require.NoError(t, srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "event:status",
+		Destination: "mac:112233445566/client",
+		Payload:     payload,
+	}))
+
+	for _, ch := range []chan wrp.Message{toServer, toClient} {
+		select {
+		case got := <-ch:
+			assert.Equal(t, payload, got.Payload)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message not received")
+		}
+	}
+}
+
+func TestClient_WebSocket(t *testing.T) {
+	wsURL := func() string {
+		url, err := findOpenURL()
+		require.NoError(t, err)
+		return strings.Replace(url, "tcp://", "ws://", 1) + "/wrp"
+	}
+	serverURL, clientURL := wsURL(), wsURL()
+
+	toServer := make(chan wrp.Message, 10)
+	srv, err := NewServer(
+		RXURL(serverURL),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+		WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+			if msg.Type == wrp.SimpleEventMessageType {
+				toServer <- msg
+			}
+		})),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	toClient := make(chan wrp.Message, 10)
+	client, err := NewClient(
+		WithServerURL(serverURL),
+		WithClientURL(clientURL),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(0),
+		WithReceivedModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				if msg.Type == wrp.SimpleEventMessageType {
+					toClient <- msg
+				}
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, client.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "mac:112233445566/client",
+			Destination: "event:status",
+		}))
+		require.NoError(t, srv.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "event:status",
+			Destination: "mac:112233445566/client",
+		}))
+	}
+
+	for _, ch := range []chan wrp.Message{toServer, toClient, toServer, toClient} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message not received")
+		}
+	}
+}
+
+func TestWithPayloadCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       int
+		expectError bool
+	}{
+		{
+			name:  "default level",
+			level: gzip.DefaultCompression,
+		}, {
+			name:  "best compression",
+			level: gzip.BestCompression,
+		}, {
+			name:        "too high",
+			level:       gzip.BestCompression + 1,
+			expectError: true,
+		}, {
+			name:        "too low",
+			level:       gzip.HuffmanOnly - 1,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(withReceiver(&mockReceiver{}), WithPayloadCompression(tt.level))
+			c, cErr := NewClient(WithServerURL("tcp://127.0.0.1:6000"), WithClientPayloadCompression(tt.level))
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, srv)
+				assert.Error(t, cErr)
+				assert.Nil(t, c)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, srv)
+			assert.NoError(t, cErr)
+			assert.NotNil(t, c)
+		})
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	var events atomic.Int64
+	startServer := func() *Server {
+		srv, err := NewServer(
+			RXURL(url),
+			RXTimeout(10*time.Millisecond),
+			WithHeartbeatInterval(0),
+			WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				if msg.Type == wrp.SimpleEventMessageType {
+					events.Add(1)
+				}
+			})),
+		)
+		require.NoError(t, err)
+		require.NoError(t, srv.Start())
+		return srv
+	}
+
+	srv := startServer()
+
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(10*time.Millisecond),
+		WithClientReconnect(Backoff{
+			Initial: 10 * time.Millisecond,
+			Max:     50 * time.Millisecond,
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	event := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/client",
+		Destination: "event:status",
+	}
+	send := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return client.ProcessWRP(ctx, event)
+	}
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, send())
+	require.Eventually(t, func() bool {
+		return events.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// While the server is down, sends fail instead of being buffered.
+	require.NoError(t, srv.Stop())
+	require.Eventually(t, func() bool {
+		return errors.Is(send(), ErrConnClosed)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A new server learns about the client when it registers again.
+	srv = startServer()
+	defer srv.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return send() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return events.Load() >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClient_ReconnectAfterSendFailure(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	startServer := func() *Server {
+		srv, err := NewServer(
+			RXURL(url),
+			RXTimeout(10*time.Millisecond),
+			WithHeartbeatInterval(0),
+		)
+		require.NoError(t, err)
+		require.NoError(t, srv.Start())
+		return srv
+	}
+
+	srv := startServer()
+
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(0),
+		WithClientReconnect(Backoff{
+			Initial: 10 * time.Millisecond,
+			Max:     50 * time.Millisecond,
+		}),
+	)
+	require.NoError(t, err)
+
+	// Sends fail quickly once the server is gone.
+	client.sOpts = append(client.sOpts, sender.WithSendTimeout(50*time.Millisecond))
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Send straight through the sender, the same as a send racing with the
+	// outage, until one fails and closes the connection.
+	require.NoError(t, srv.Stop())
+	event := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/client",
+		Destination: "event:status",
+	}
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = client.s.ProcessWRP(ctx, event)
+		return !client.s.IsConnected()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The closed connection is dialed again once the server is back.
+	srv = startServer()
+	defer srv.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return client.ProcessWRP(ctx, event) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClient_ReconnectGivesUp(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	srv, err := NewServer(
+		RXURL(url),
+		RXTimeout(10*time.Millisecond),
+		WithHeartbeatInterval(0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+
+	gaveUp := make(chan error, 1)
+	client, err := NewClient(
+		WithServerURL(url),
+		WithClientServiceName("client"),
+		WithClientHeartbeatInterval(0),
+		WithClientReconnect(Backoff{
+			Initial:     10 * time.Millisecond,
+			MaxFailures: 2,
+		}),
+		WithClientReconnectFailureListener(func(err error) {
+			select {
+			case gaveUp <- err:
+			default:
+			}
+		}),
+	)
+	require.NoError(t, err)
+
+	client.sOpts = append(client.sOpts, sender.WithSendTimeout(50*time.Millisecond))
+	require.NoError(t, client.Start())
+	defer client.Stop() // nolint:errcheck
+
+	require.Eventually(t, func() bool {
+		return srv.IsServiceConnected("client")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The server never comes back.
+	require.NoError(t, srv.Stop())
+
+	select {
+	case err := <-gaveUp:
+		assert.ErrorContains(t, err, url)
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "the client did not give up")
+	}
+
+	err = client.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/client",
+		Destination: "event:status",
+	})
+	assert.ErrorIs(t, err, ErrConnClosed)
+}
+
+func TestWithClientSenderOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		opt         ClientOption
+		expectError bool
+	}{
+		{name: "send retries", opt: WithClientSendRetries(3, time.Second)},
+		{name: "negative retries", opt: WithClientSendRetries(-1, 0), expectError: true},
+		{name: "drop on full", opt: WithClientDropOnFull()},
+		{name: "send buffer", opt: WithClientSendBuffer(16)},
+		{name: "negative send buffer", opt: WithClientSendBuffer(-1), expectError: true},
+		{name: "auto redial", opt: WithClientAutoRedial()},
+		{name: "close timeout", opt: WithClientSenderCloseTimeout(time.Second)},
+		{name: "negative close timeout", opt: WithClientSenderCloseTimeout(-1), expectError: true},
+		{name: "write queue length", opt: WithClientWriteQueueLen(8)},
+		{name: "empty write queue", opt: WithClientWriteQueueLen(0), expectError: true},
+		{name: "socket option", opt: WithClientSenderSocketOption(mangos.OptionKeepAlive, true)},
+		{name: "unnamed socket option", opt: WithClientSenderSocketOption("", true), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(WithServerURL("tcp://127.0.0.1:1"), tt.opt)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, client)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, client.sOpts, 1)
+		})
+	}
+}
+
+func TestWithClientReconnect(t *testing.T) {
+	client, err := NewClient(
+		WithServerURL("tcp://127.0.0.1:1"),
+		WithClientReconnect(Backoff{Initial: time.Second}),
+	)
+	require.NoError(t, err)
+	assert.True(t, client.reconnect)
+	assert.Equal(t, time.Second, client.backoff.Initial)
+
+	client, err = NewClient(
+		WithServerURL("tcp://127.0.0.1:1"),
+		WithClientReconnect(Backoff{Initial: -1}),
+	)
+	assert.Error(t, err)
+	assert.Nil(t, client)
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when Advance is called.
+type fakeClock struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	fc := &fakeClock{
+		now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	fc.cond = sync.NewCond(&fc.lock)
+	return fc
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.now
+		return ch
+	}
+
+	fc.waiters = append(fc.waiters, fakeWaiter{at: fc.now.Add(d), ch: ch})
+	fc.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward, firing the waiters that are due.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.now = fc.now.Add(d)
+
+	var pending []fakeWaiter
+	for _, w := range fc.waiters {
+		if w.at.After(fc.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- fc.now
+	}
+	fc.waiters = pending
+}
+
+// BlockUntil waits until n callers are waiting on After.
+func (fc *fakeClock) BlockUntil(n int) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	for len(fc.waiters) < n {
+		fc.cond.Wait()
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	fc := newFakeClock()
+	start := fc.Now()
+
+	after := fc.After(time.Second)
+
+	fc.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(500*time.Millisecond), fc.Now())
+	assert.Empty(t, after)
+
+	fc.Advance(500 * time.Millisecond)
+	require.Len(t, after, 1)
+	assert.Equal(t, start.Add(time.Second), <-after)
+
+	fired := fc.After(0)
+	assert.Len(t, fired, 1)
+}
+
+func TestRealClock(t *testing.T) {
+	var c clock = realClock{}
+
+	before := time.Now()
+	assert.False(t, c.Now().Before(before))
+
+	<-c.After(time.Millisecond)
+}
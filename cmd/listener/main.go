@@ -6,6 +6,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
@@ -29,15 +31,11 @@ func mainE() error {
 		return err
 	}
 
-	fmt.Println("Starting server...")
-	err = server.Start()
-	if err != nil {
-		return err
-	}
-	defer server.Stop() // nolint:errcheck
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	// wait forever
-	select {}
+	fmt.Println("Starting server...")
+	return server.Run(ctx)
 }
 
 func main() {
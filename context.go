@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+)
+
+// RXURLFromContext returns the rx URL that the message being processed was
+// received on.  The context passed to the rx observers, rx transforms, egress
+// processors and egress modifiers carries it, and so does any context derived
+// from it.  It returns false for other contexts, such as for messages passed
+// to InjectRaw.
+func RXURLFromContext(ctx context.Context) (string, bool) {
+	return receiver.URLFromContext(ctx)
+}
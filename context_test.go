@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+)
+
+func TestRXURLFromContext(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	type seen struct {
+		url string
+		ok  bool
+	}
+	egress := make(chan seen, 1)
+	ingress := make(chan seen, 1)
+
+	var srv *Server
+	srv, err = NewServer(
+		RXURL(url),
+		WithHeartbeatInterval(0),
+		WithEgressProcessor(wrp.ProcessorFunc(func(ctx context.Context, msg wrp.Message) error {
+			u, ok := RXURLFromContext(ctx)
+			egress <- seen{url: u, ok: ok}
+
+			// The application passes the message on with the same context.
+			_ = srv.ProcessWRP(ctx, msg)
+			return nil
+		})),
+		WithIngressProcessor(wrp.ProcessorFunc(func(ctx context.Context, _ wrp.Message) error {
+			u, ok := RXURLFromContext(ctx)
+			ingress <- seen{url: u, ok: ok}
+			return nil
+		}), BeforeSenders),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	s, err := sender.New(sender.WithURL(url))
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	require.NoError(t, s.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566",
+		Destination: "event:device-status",
+	}))
+
+	for _, ch := range []chan seen{egress, ingress} {
+		select {
+		case got := <-ch:
+			assert.Equal(t, seen{url: url, ok: true}, got)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the message was not processed")
+		}
+	}
+
+	// Messages that didn't come from the network have no rx URL.
+	_ = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566",
+		Destination: "event:device-status",
+	})
+	assert.Equal(t, seen{}, <-ingress)
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// fanout is a processor that sends copies of a message to each of the named
+// services returned by targets.
+type fanout struct {
+	senders *senderMap
+	targets func(wrp.Message) []string
+}
+
+var _ wrp.Processor = (*fanout)(nil)
+
+// ProcessWRP sends the message to every service returned by targets.  Each
+// service is sent to at most once.  The message is handled once it has been
+// fanned out, so it isn't routed to its destination as well.  If targets
+// returns no services, wrp.ErrNotHandled is returned so the message continues
+// down the chain.  The errors from each failed send are joined together.
+func (f *fanout) ProcessWRP(ctx context.Context, msg wrp.Message) error {
+	names := f.targets(msg)
+	if len(names) == 0 {
+		return wrp.ErrNotHandled
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	var errs []error
+	for _, name := range names {
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		if err := f.senders.sendTo(ctx, name, msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
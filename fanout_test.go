@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestFanout_ProcessWRP(t *testing.T) {
+	errUnknown := errors.New("unknown")
+
+	tests := []struct {
+		description string
+		targets     []string
+		failing     string
+		expectErrIs []error
+		expectCount map[string]int
+	}{
+		{
+			description: "no targets",
+			expectErrIs: []error{wrp.ErrNotHandled},
+			expectCount: map[string]int{"a": 0, "b": 0, "c": 0},
+		}, {
+			description: "one target",
+			targets:     []string{"b"},
+			expectCount: map[string]int{"a": 0, "b": 1, "c": 0},
+		}, {
+			description: "several targets",
+			targets:     []string{"a", "b", "c"},
+			expectCount: map[string]int{"a": 1, "b": 1, "c": 1},
+		}, {
+			description: "duplicate targets are sent once",
+			targets:     []string{"a", "a", "c"},
+			expectCount: map[string]int{"a": 1, "b": 0, "c": 1},
+		}, {
+			description: "one target fails",
+			targets:     []string{"a", "b", "c"},
+			failing:     "b",
+			expectErrIs: []error{errUnknown},
+			expectCount: map[string]int{"a": 1, "b": 1, "c": 1},
+		}, {
+			description: "missing target",
+			targets:     []string{"a", "missing"},
+			expectErrIs: []error{ErrNoRoute},
+			expectCount: map[string]int{"a": 1, "b": 0, "c": 0},
+		}, {
+			description: "missing and failing targets",
+			targets:     []string{"missing", "b", "c"},
+			failing:     "c",
+			expectErrIs: []error{ErrNoRoute, errUnknown},
+			expectCount: map[string]int{"a": 0, "b": 1, "c": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			mocks := map[string]*mockSender{
+				"a": {},
+				"b": {},
+				"c": {},
+			}
+			if tt.failing != "" {
+				mocks[tt.failing].processErr = errUnknown
+			}
+
+			sm := senderMap{
+				senders: map[string]limitedSender{},
+			}
+			for name, m := range mocks {
+				sm.senders[name] = m
+			}
+
+			f := fanout{
+				senders: &sm,
+				targets: func(wrp.Message) []string {
+					return tt.targets
+				},
+			}
+
+			err := f.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "self:/service",
+				Destination: "event:device-status",
+			})
+
+			if len(tt.expectErrIs) == 0 {
+				assert.NoError(t, err)
+			}
+			for _, want := range tt.expectErrIs {
+				assert.ErrorIs(t, err, want)
+			}
+			for name, count := range tt.expectCount {
+				assert.Equal(t, count, mocks[name].processCount, name)
+			}
+		})
+	}
+}
+
+func TestServer_Fanout(t *testing.T) {
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithFanout(func(msg wrp.Message) []string {
+			switch msg.Type {
+			case wrp.SimpleEventMessageType:
+				return []string{"service", "audit"}
+			case wrp.CreateMessageType:
+				return []string{"audit"}
+			}
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	service := &mockSender{}
+	audit := &mockSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"service": service,
+		"audit":   audit,
+	}
+
+	// Events are copied to both services.
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/service",
+		Destination: "event:device-status",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, service.processCount)
+	assert.Equal(t, 1, audit.processCount)
+
+	// Everything else is routed normally.
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleRequestResponseMessageType,
+		Source:      "mac:112233445566/service",
+		Destination: "mac:112233445566/service",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, service.processCount)
+	assert.Equal(t, 1, audit.processCount)
+
+	// Fanning out replaces routing, so the destination's service only gets
+	// the message if it is one of the targets.
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.CreateMessageType,
+		Source:      "mac:112233445566/service",
+		Destination: "mac:112233445566/service",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, service.processCount)
+	assert.Equal(t, 2, audit.processCount)
+}
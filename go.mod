@@ -3,6 +3,7 @@ module github.com/xmidt-org/wrpnng
 go 1.23.1
 
 require (
+	github.com/google/uuid v1.6.0
 	github.com/stretchr/testify v1.11.1
 	github.com/xmidt-org/eventor v1.0.49
 	github.com/xmidt-org/wrp-go/v3 v3.7.0
@@ -11,7 +12,7 @@ require (
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
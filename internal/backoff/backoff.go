@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package backoff
+
+import (
+	"math"
+	"time"
+)
+
+// Policy is a retry policy where the delay before the first attempt is
+// Initial, and it doubles after each failed attempt up to Max.
+type Policy struct {
+	// Initial is the delay before the first attempt.
+	Initial time.Duration
+
+	// Max is the longest delay between attempts.  Zero means there is no limit.
+	Max time.Duration
+
+	// MaxFailures is the number of consecutive failed attempts before giving
+	// up.  Zero means never give up.
+	MaxFailures int
+}
+
+// Delay returns how long to wait before the given attempt, starting at 0.
+func (p Policy) Delay(attempt int) time.Duration {
+	d := p.Initial
+
+	// Stop doubling before the duration overflows.
+	for i := 0; i < attempt && d > 0 && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+
+	if p.Max > 0 && d > p.Max {
+		return p.Max
+	}
+	return d
+}
+
+// GiveUp returns true if no more attempts should be made after the given
+// number of consecutive failures.
+func (p Policy) GiveUp(failures int) bool {
+	return p.MaxFailures > 0 && failures >= p.MaxFailures
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+
+	assert.Equal(t, 10*time.Millisecond, p.Delay(0))
+	assert.Equal(t, 20*time.Millisecond, p.Delay(1))
+	assert.Equal(t, 40*time.Millisecond, p.Delay(2))
+	assert.Equal(t, 50*time.Millisecond, p.Delay(3))
+	assert.Equal(t, 50*time.Millisecond, p.Delay(100))
+	assert.Positive(t, Policy{Initial: time.Second}.Delay(1000))
+}
+
+func TestPolicy_GiveUp(t *testing.T) {
+	assert.False(t, Policy{}.GiveUp(1000))
+	assert.False(t, Policy{MaxFailures: 3}.GiveUp(2))
+	assert.True(t, Policy{MaxFailures: 3}.GiveUp(3))
+}
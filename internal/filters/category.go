@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package filters
+
+import (
+	"context"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// Category groups the message types that are handled the same way.
+type Category int
+
+const (
+	// CategoryUnknown is the category of the invalid and unknown message
+	// types.
+	CategoryUnknown Category = iota
+
+	// CategoryEvent is the category of SimpleEvent messages.
+	CategoryEvent
+
+	// CategoryRequest is the category of SimpleRequestResponse messages.
+	CategoryRequest
+
+	// CategoryCRUD is the category of Create, Retrieve, Update and Delete
+	// messages.
+	CategoryCRUD
+
+	// CategoryLocal is the category of the message types used between a
+	// Server and its services: Authorization, ServiceRegistration and
+	// ServiceAlive.
+	CategoryLocal
+)
+
+// CategoryOf returns the category of the message type.
+func CategoryOf(t wrp.MessageType) Category {
+	switch t {
+	case wrp.SimpleEventMessageType:
+		return CategoryEvent
+	case wrp.SimpleRequestResponseMessageType:
+		return CategoryRequest
+	case wrp.CreateMessageType,
+		wrp.RetrieveMessageType,
+		wrp.UpdateMessageType,
+		wrp.DeleteMessageType:
+		return CategoryCRUD
+	case wrp.AuthorizationMessageType,
+		wrp.ServiceRegistrationMessageType,
+		wrp.ServiceAliveMessageType:
+		return CategoryLocal
+	}
+	return CategoryUnknown
+}
+
+// SplitByCategory returns a ProcessorFunc that passes each message to the
+// processor for the category of its type, and returns the processor's result.
+// If there is no processor for the category, the ProcessorFunc returns
+// wrp.ErrNotHandled.
+func SplitByCategory(routes map[Category]wrp.Processor) wrp.ProcessorFunc {
+	// Copy the routes so later changes to the map don't race with processing.
+	copied := make(map[Category]wrp.Processor, len(routes))
+	for c, p := range routes {
+		if p != nil {
+			copied[c] = p
+		}
+	}
+
+	return func(ctx context.Context, m wrp.Message) error {
+		if p, found := copied[CategoryOf(m.Type)]; found {
+			return p.ProcessWRP(ctx, m)
+		}
+		return wrp.ErrNotHandled
+	}
+}
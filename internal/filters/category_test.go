@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package filters
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestCategoryOf(t *testing.T) {
+	tests := []struct {
+		messageType wrp.MessageType
+		expected    Category
+	}{
+		{messageType: wrp.Invalid0MessageType, expected: CategoryUnknown},
+		{messageType: wrp.Invalid1MessageType, expected: CategoryUnknown},
+		{messageType: wrp.AuthorizationMessageType, expected: CategoryLocal},
+		{messageType: wrp.SimpleRequestResponseMessageType, expected: CategoryRequest},
+		{messageType: wrp.SimpleEventMessageType, expected: CategoryEvent},
+		{messageType: wrp.CreateMessageType, expected: CategoryCRUD},
+		{messageType: wrp.RetrieveMessageType, expected: CategoryCRUD},
+		{messageType: wrp.UpdateMessageType, expected: CategoryCRUD},
+		{messageType: wrp.DeleteMessageType, expected: CategoryCRUD},
+		{messageType: wrp.ServiceRegistrationMessageType, expected: CategoryLocal},
+		{messageType: wrp.ServiceAliveMessageType, expected: CategoryLocal},
+		{messageType: wrp.UnknownMessageType, expected: CategoryUnknown},
+		{messageType: wrp.LastMessageType, expected: CategoryUnknown},
+		{messageType: -1, expected: CategoryUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.messageType.String(), func(t *testing.T) {
+			assert.Equal(t, tt.expected, CategoryOf(tt.messageType))
+		})
+	}
+}
+
+func TestSplitByCategory(t *testing.T) {
+	crudErr := errors.New("crud handled")
+
+	tests := []struct {
+		name        string
+		messageType wrp.MessageType
+		expectedErr error
+		expectCRUD  int
+		expectEvent int
+	}{
+		{
+			name:        "Create",
+			messageType: wrp.CreateMessageType,
+			expectedErr: crudErr,
+			expectCRUD:  1,
+		}, {
+			name:        "Retrieve",
+			messageType: wrp.RetrieveMessageType,
+			expectedErr: crudErr,
+			expectCRUD:  1,
+		}, {
+			name:        "Update",
+			messageType: wrp.UpdateMessageType,
+			expectedErr: crudErr,
+			expectCRUD:  1,
+		}, {
+			name:        "Delete",
+			messageType: wrp.DeleteMessageType,
+			expectedErr: crudErr,
+			expectCRUD:  1,
+		}, {
+			name:        "Event",
+			messageType: wrp.SimpleEventMessageType,
+			expectEvent: 1,
+		}, {
+			name:        "Request has no route",
+			messageType: wrp.SimpleRequestResponseMessageType,
+			expectedErr: wrp.ErrNotHandled,
+		}, {
+			name:        "Unknown has no route",
+			messageType: wrp.LastMessageType,
+			expectedErr: wrp.ErrNotHandled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var crud, event int
+			routes := map[Category]wrp.Processor{
+				CategoryCRUD: wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+					crud++
+					return crudErr
+				}),
+				CategoryEvent: wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+					event++
+					return nil
+				}),
+				CategoryRequest: nil,
+			}
+			processor := SplitByCategory(routes)
+
+			// Changing the map afterwards has no effect.
+			delete(routes, CategoryCRUD)
+
+			err := processor(context.Background(), wrp.Message{Type: tt.messageType})
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectCRUD, crud)
+			assert.Equal(t, tt.expectEvent, event)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package filters
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+var (
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrMissingTransaction = errors.New("missing transaction UUID")
+)
+
+// ValidateMessage returns a ProcessorFunc that returns an error wrapping
+// ErrInvalidMessage if the message fails any of the validators.  If the message
+// is valid, the ProcessorFunc returns wrp.ErrNotHandled.
+//
+// The validators are applied to a copy of the message, so only the validating
+// wrp.NormifierOptions (such as wrp.ValidateSource) are meaningful.  If no
+// validators are provided, the fields required by the message type are
+// validated:
+//
+//   - All messages must only contain UTF-8 strings.
+//   - Events must have valid source and destination locators.
+//   - Request/response and CRUD messages must also have a transaction UUID.
+//
+// Every rule is checked, so the error reports all of the rules the message
+// breaks, not just the first.
+func ValidateMessage(validators ...wrp.NormifierOption) wrp.ProcessorFunc {
+	var n *wrp.Normifier
+	if len(validators) > 0 {
+		n = wrp.NewNormifier(validators...)
+	}
+
+	return func(_ context.Context, m wrp.Message) error {
+		var err error
+		if n != nil {
+			err = n.Normify(&m)
+		} else {
+			err = validateByType(m)
+		}
+
+		if err != nil {
+			return errors.Join(err, ErrInvalidMessage)
+		}
+		return wrp.ErrNotHandled
+	}
+}
+
+// validateByType validates the fields required by the message type.  Each
+// validator is run on its own, since a normifier stops at the first failure,
+// and the errors are joined.
+func validateByType(m wrp.Message) error {
+	validators := []wrp.NormifierOption{
+		wrp.ValidateOnlyUTF8Strings(),
+	}
+
+	var errs []error
+	switch m.Type {
+	case wrp.SimpleRequestResponseMessageType,
+		wrp.CreateMessageType,
+		wrp.RetrieveMessageType,
+		wrp.UpdateMessageType,
+		wrp.DeleteMessageType:
+		if m.TransactionUUID == "" {
+			errs = append(errs, ErrMissingTransaction)
+		}
+		fallthrough
+	case wrp.SimpleEventMessageType:
+		validators = append(validators,
+			wrp.ValidateSource(),
+			wrp.ValidateDestination(),
+		)
+	}
+
+	for _, v := range validators {
+		errs = append(errs, wrp.NewNormifier(v).Normify(&m))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package filters
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestValidateMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		validators  []wrp.NormifierOption
+		msg         wrp.Message
+		expectedErr []error
+	}{
+		{
+			name: "Valid event",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "mac:112233445566",
+				Destination: "event:device-status/online",
+			},
+		}, {
+			name: "Valid request",
+			msg: wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:example.com",
+				Destination:     "mac:112233445566/config",
+				TransactionUUID: "1234",
+			},
+		}, {
+			name: "Event with an invalid source",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "invalid",
+				Destination: "event:device-status/online",
+			},
+			expectedErr: []error{ErrInvalidMessage, wrp.ErrInvalidSource},
+		}, {
+			name: "Request with an invalid destination",
+			msg: wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:example.com",
+				TransactionUUID: "1234",
+			},
+			expectedErr: []error{ErrInvalidMessage, wrp.ErrInvalidDest},
+		}, {
+			name: "Request without a transaction UUID",
+			msg: wrp.Message{
+				Type:        wrp.RetrieveMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/config",
+			},
+			expectedErr: []error{ErrInvalidMessage, ErrMissingTransaction},
+		}, {
+			name: "Every broken rule is reported",
+			msg: wrp.Message{
+				Type:        wrp.UpdateMessageType,
+				Source:      "invalid",
+				Destination: "mac:112233445566/config",
+				ServiceName: "\xff",
+			},
+			expectedErr: []error{
+				ErrInvalidMessage,
+				ErrMissingTransaction,
+				wrp.ErrInvalidSource,
+				wrp.ErrInvalidString,
+			},
+		}, {
+			name: "Invalid UTF-8",
+			msg: wrp.Message{
+				Type:        wrp.ServiceAliveMessageType,
+				ServiceName: "\xff",
+			},
+			expectedErr: []error{ErrInvalidMessage, wrp.ErrInvalidString},
+		}, {
+			name:       "Custom validators ignore the type rules",
+			validators: []wrp.NormifierOption{wrp.ValidateSource()},
+			msg: wrp.Message{
+				Type:   wrp.SimpleRequestResponseMessageType,
+				Source: "dns:example.com",
+			},
+		}, {
+			name:       "Custom validators fail",
+			validators: []wrp.NormifierOption{wrp.ValidateIsPartner("comcast")},
+			msg: wrp.Message{
+				Type:       wrp.SimpleEventMessageType,
+				PartnerIDs: []string{"other"},
+			},
+			expectedErr: []error{ErrInvalidMessage, wrp.ErrInvalidPartnerID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := ValidateMessage(tt.validators...)
+			err := processor(context.Background(), tt.msg)
+			if len(tt.expectedErr) == 0 {
+				assert.ErrorIs(t, err, wrp.ErrNotHandled)
+				return
+			}
+
+			for _, e := range tt.expectedErr {
+				assert.ErrorIs(t, err, e)
+			}
+		})
+	}
+}
@@ -18,8 +18,12 @@ type Processors []wrp.Processor
 // wrp.ErrNotHandled will stop the iteration and return the error (or nil) value.
 // If all Processors return ErrNotHandled, then ErrNotHandled is returned. If
 // the context is canceled, the iteration stops and the context error value is
-// returned.
+// returned.  A nil context is treated as context.Background().
 func (p Processors) ProcessWRP(ctx context.Context, msg wrp.Message) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	for _, proc := range p {
 		if ctx.Err() != nil {
 			return ctx.Err()
@@ -91,3 +91,26 @@ func TestProcessors_ProcessWRP(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessors_ProcessWRPNilContext(t *testing.T) {
+	var called int
+	checkCtx := wrp.ProcessorFunc(func(ctx context.Context, _ wrp.Message) error {
+		called++
+		assert.NotNil(t, ctx)
+		return wrp.ErrNotHandled
+	})
+
+	processors := Processors{
+		checkCtx,
+		nil,
+		checkCtx,
+		&mockProcessor{err: nil},
+	}
+
+	var ctx context.Context
+	assert.NotPanics(t, func() {
+		err := processors.ProcessWRP(ctx, wrp.Message{})
+		assert.NoError(t, err)
+	})
+	assert.Equal(t, 2, called)
+}
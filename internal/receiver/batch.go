@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidBatch = errors.New("invalid batch")
+)
+
+// batchHeaderLen is the size of the length prefix in front of each message in
+// a batch.
+const batchHeaderLen = 4
+
+// splitBatch splits a batch of length-prefixed messages into the individual
+// encoded messages.  The batch framing is a sequence of zero or more frames,
+// where each frame is:
+//
+//   - a 4 byte, big-endian, unsigned length of the encoded message
+//   - the msgpack encoded WRP message of that length
+//
+// If the batch is not exactly made up of complete frames, ErrInvalidBatch is
+// returned and no frames are returned.
+func splitBatch(buf []byte) ([][]byte, error) {
+	var frames [][]byte
+
+	for len(buf) > 0 {
+		if len(buf) < batchHeaderLen {
+			return nil, ErrInvalidBatch
+		}
+
+		size := binary.BigEndian.Uint32(buf)
+		buf = buf[batchHeaderLen:]
+
+		if uint64(len(buf)) < uint64(size) {
+			return nil, ErrInvalidBatch
+		}
+
+		frames = append(frames, buf[:size])
+		buf = buf[size:]
+	}
+
+	return frames, nil
+}
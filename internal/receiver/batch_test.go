@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		buf         []byte
+		expect      [][]byte
+		expectedErr error
+	}{
+		{
+			name: "Empty batch",
+		}, {
+			name:   "Single frame",
+			buf:    []byte{0, 0, 0, 3, 'a', 'b', 'c'},
+			expect: [][]byte{[]byte("abc")},
+		}, {
+			name: "Multiple frames",
+			buf: []byte{
+				0, 0, 0, 1, 'a',
+				0, 0, 0, 0,
+				0, 0, 0, 2, 'b', 'c',
+			},
+			expect: [][]byte{[]byte("a"), {}, []byte("bc")},
+		}, {
+			name:        "Truncated header",
+			buf:         []byte{0, 0, 0, 1, 'a', 0, 0},
+			expectedErr: ErrInvalidBatch,
+		}, {
+			name:        "Truncated frame",
+			buf:         []byte{0, 0, 0, 3, 'a', 'b'},
+			expectedErr: ErrInvalidBatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitBatch(tt.buf)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, got)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
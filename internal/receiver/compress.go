@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// gzipMagic is the start of every gzip stream.  Neither a msgpack nor a JSON
+// encoded message can start with it.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isCompressed reports if the frame is gzip compressed.
+func isCompressed(frame []byte) bool {
+	return bytes.HasPrefix(frame, gzipMagic)
+}
+
+// DefaultMaxDecompressedBytes is the largest size a compressed message may
+// decompress to when the maximum message size isn't set.
+const DefaultMaxDecompressedBytes = 16 << 20
+
+// inflate decompresses the gzip compressed frame.  The size of the
+// decompressed frame is limited to the maximum message size, or
+// DefaultMaxDecompressedBytes if it isn't set, so a small frame can't expand
+// without bound.
+func (r *Receiver) inflate(frame []byte) ([]byte, error) {
+	limit := r.maxBytes
+	if limit <= 0 {
+		limit = DefaultMaxDecompressedBytes
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCompression, err)
+	}
+	defer zr.Close() // nolint:errcheck
+
+	buf, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCompression, err)
+	}
+	if len(buf) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes decompressed", ErrMessageTooLarge, limit)
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import "context"
+
+// urlKey is the context key for the URL a message was received on.
+type urlKey struct{}
+
+// withURL returns a context carrying the URL messages are received on.
+func withURL(ctx context.Context, url string) context.Context {
+	return context.WithValue(ctx, urlKey{}, url)
+}
+
+// URLFromContext returns the URL of the Receiver that received the message
+// being handled.  It returns false if the context didn't come from a
+// Receiver's listener, such as for injected messages.
+func URLFromContext(ctx context.Context) (string, bool) {
+	url, ok := ctx.Value(urlKey{}).(string)
+	return url, ok
+}
@@ -4,11 +4,24 @@
 package receiver_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/big"
 	"net"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,7 +29,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/wrpnng/internal/receiver"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+	"github.com/xmidt-org/wrpnng/wrpnngtest"
 	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/pub"
 	"go.nanomsg.org/mangos/v3/protocol/push"
 
 	// register transports
@@ -25,25 +41,14 @@ import (
 
 func TestEnd2End(t *testing.T) {
 	require := require.New(t)
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
 
 	port, err := findOpenPort()
 	require.NoError(err)
 	require.NotZero(port)
 
-	var lock sync.Mutex
-	var got []wrp.Message
-	wrpRecorder := wrp.ObserverAsModifier(
-		wrp.ObserverFunc(
-			func(_ context.Context, m wrp.Message) {
-				lock.Lock()
-				defer lock.Unlock()
-				got = append(got, m)
-			},
-		),
-	)
+	got := wrpnngtest.NewCollector()
 
+	var lock sync.Mutex
 	var closed []error
 	closeRecorder := func(err error) {
 		lock.Lock()
@@ -58,7 +63,7 @@ func TestEnd2End(t *testing.T) {
 	r, err := receiver.New(
 		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
 		receiver.WithRecvTimeout(100*time.Millisecond),
-		receiver.WithModifyWRP(wrpRecorder, nil, &wrpCancelFn),
+		receiver.WithModifyWRP(got, nil, &wrpCancelFn),
 		receiver.WithCloseListener(closeRecorder, &listenerCancelFn, nil),
 	)
 	require.NoError(err)
@@ -69,8 +74,6 @@ func TestEnd2End(t *testing.T) {
 	require.NoError(err)
 	defer r.Close() // nolint:errcheck
 
-	///time.Sleep(1 * time.Second)
-
 	send := []wrp.Message{
 		{
 			Type:   wrp.SimpleEventMessageType,
@@ -83,8 +86,64 @@ func TestEnd2End(t *testing.T) {
 	// Send a message to the receiver.
 	sock, err := sendMsgs(send, port)
 	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
 
 	// Wait for the message to be received.
+	received, err := got.Wait(len(send), 60*time.Second)
+	require.NoError(err)
+
+	assert.ElementsMatch(t, send, received)
+}
+
+func TestEnd2EndBatch(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	port, err := findOpenPort()
+	require.NoError(err)
+	require.NotZero(port)
+
+	var lock sync.Mutex
+	var got []wrp.Message
+	wrpRecorder := wrp.ObserverAsModifier(
+		wrp.ObserverFunc(
+			func(_ context.Context, m wrp.Message) {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, m)
+			},
+		),
+	)
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithBatchDecoding(),
+		receiver.WithModifyWRP(wrpRecorder),
+	)
+	require.NoError(err)
+
+	err = r.Listen()
+	require.NoError(err)
+	defer r.Close() // nolint:errcheck
+
+	send := []wrp.Message{
+		{
+			Type:   wrp.SimpleEventMessageType,
+			Source: "11111",
+		}, {
+			Type:   wrp.SimpleEventMessageType,
+			Source: "22222",
+		}, {
+			Type:   wrp.SimpleEventMessageType,
+			Source: "33333",
+		},
+	}
+
+	sock, err := sendBatch(send, port)
+	require.NoError(err)
+
 	for {
 		if ctx.Err() != nil {
 			require.Fail("timed out waiting for message")
@@ -102,67 +161,1481 @@ func TestEnd2End(t *testing.T) {
 	}
 
 	assert.ElementsMatch(t, send, got)
-
 }
 
-// findOpenPort finds an open port for listening on.
-func findOpenPort() (int, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return 0, err
+func TestCloseWaitsForHandlers(t *testing.T) {
+	tests := []struct {
+		name        string
+		drain       bool
+		timeout     time.Duration
+		expectedErr error
+	}{
+		{
+			name: "Close",
+		}, {
+			name:    "Drain",
+			drain:   true,
+			timeout: 10 * time.Second,
+		}, {
+			name:        "Drain times out",
+			drain:       true,
+			timeout:     10 * time.Millisecond,
+			expectedErr: context.DeadlineExceeded,
+		},
 	}
-	defer listener.Close()
 
-	addr := listener.Addr().(*net.TCPAddr)
-	return addr.Port, nil
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			port, err := findOpenPort()
+			require.NoError(err)
+
+			started := make(chan struct{}, 1)
+			release := make(chan struct{})
+			var lock sync.Mutex
+			var finished int
+
+			r, err := receiver.New(
+				receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+				receiver.WithRecvTimeout(10*time.Millisecond),
+				receiver.WithModifyWRP(wrp.ModifierFunc(
+					func(_ context.Context, m wrp.Message) (wrp.Message, error) {
+						started <- struct{}{}
+						<-release
+						lock.Lock()
+						finished++
+						lock.Unlock()
+						return m, nil
+					},
+				)),
+			)
+			require.NoError(err)
+			require.NoError(r.Listen())
+
+			sock, err := sendMsgs([]wrp.Message{{Type: wrp.SimpleEventMessageType}}, port)
+			require.NoError(err)
+			defer sock.Close() // nolint:errcheck
+
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				require.Fail("timed out waiting for the handler")
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				if !tt.drain {
+					done <- r.Close()
+					return
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+				defer cancel()
+				done <- r.Drain(ctx)
+			}()
+
+			if tt.expectedErr != nil {
+				err = <-done
+				assert.ErrorIs(t, err, tt.expectedErr)
+				close(release)
+				require.NoError(r.Close())
+				return
+			}
+
+			// The handler is still running, so closing must not be done.
+			select {
+			case <-done:
+				require.Fail("returned before the handler finished")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(release)
+			assert.NoError(t, <-done)
+
+			lock.Lock()
+			assert.Equal(t, 1, finished)
+			lock.Unlock()
+		})
+	}
 }
 
-// sendMsgs sends a list of messages to the specified port.
-// Send back the socket so it can be closed after all messages have been
-// received.  Otherwise the messages may not be sent if aa defer sock.Close()
-// is used.
-func sendMsgs(msgs []wrp.Message, port int) (mangos.Socket, error) {
-	sock, err := push.NewSocket()
-	if err != nil {
-		return nil, err
+func TestCloseWhileFlooded(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var inFlight, handled atomic.Int64
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ModifierFunc(
+			func(_ context.Context, m wrp.Message) (wrp.Message, error) {
+				inFlight.Add(1)
+				defer inFlight.Add(-1)
+
+				// Make the handler heavy enough that some are still running
+				// when Close is called.
+				time.Sleep(5 * time.Millisecond)
+				m.Payload = append(m.Payload, '!')
+				handled.Add(1)
+				return m, nil
+			},
+		)),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+
+	send := make([]wrp.Message, 200)
+	for i := range send {
+		send[i] = wrp.Message{
+			Type:    wrp.SimpleEventMessageType,
+			Payload: []byte(fmt.Sprintf("%d", i)),
+		}
 	}
 
-	// Set the write queue length to 1.  This is the only way to ensure that
-	// message delivery faiures are detected
-	if err := sock.SetOption(mangos.OptionWriteQLen, 1); err != nil {
-		return nil, err
+	sock, err := sendMsgs(send, port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	for handled.Load() == 0 {
+		time.Sleep(time.Millisecond)
 	}
 
-	// Set the send timeout to the configured value.  The other methods of
-	// setting the timeout are not supported by the mangos library
-	if err := sock.SetOption(mangos.OptionSendDeadline, 10*time.Millisecond); err != nil {
-		return nil, err
+	require.NoError(r.Close())
+
+	// No handler may still be running once Close returns.
+	assert.Zero(t, inFlight.Load())
+}
+
+func TestEnd2EndSubscribe(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	got := make(map[string][]wrp.Message)
+	recorder := func(name string) wrp.Modifier {
+		return wrp.ObserverAsModifier(
+			wrp.ObserverFunc(
+				func(_ context.Context, m wrp.Message) {
+					lock.Lock()
+					defer lock.Unlock()
+					got[name] = append(got[name], m)
+				},
+			),
+		)
 	}
 
-	err = sock.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", port))
+	subscribers := []struct {
+		name   string
+		topics []string
+		expect []string
+	}{
+		{
+			name:   "status only",
+			topics: []string{"event:device-status/"},
+			expect: []string{"event:device-status/online"},
+		}, {
+			name:   "everything",
+			expect: []string{"event:device-status/online", "event:other/thing"},
+		},
+	}
 
-	if err != nil {
-		return sock, err
+	publisher, err := pub.NewSocket()
+	require.NoError(err)
+	defer publisher.Close() // nolint:errcheck
+
+	var attached atomic.Int64
+	publisher.SetPipeEventHook(func(ev mangos.PipeEvent, _ mangos.Pipe) {
+		if ev == mangos.PipeEventAttached {
+			attached.Add(1)
+		}
+	})
+
+	for _, s := range subscribers {
+		port, err := findOpenPort()
+		require.NoError(err)
+
+		url := fmt.Sprintf("tcp://127.0.0.1:%d", port)
+		r, err := receiver.New(
+			receiver.WithURL(url),
+			receiver.WithRecvTimeout(100*time.Millisecond),
+			receiver.WithSubscribe(s.topics...),
+			receiver.WithModifyWRP(recorder(s.name)),
+		)
+		require.NoError(err)
+		require.NoError(r.Listen())
+		defer r.Close() // nolint:errcheck
+
+		require.NoError(publisher.Dial(url))
 	}
 
-	for _, msg := range msgs {
-		var buf []byte
-		if err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(msg); err != nil {
-			return sock, err
+	// Publishing before the subscribers are attached drops the messages.
+	for attached.Load() < int64(len(subscribers)) {
+		if ctx.Err() != nil {
+			require.Fail("timed out waiting for subscribers")
 		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, dest := range []string{"event:device-status/online", "event:other/thing"} {
+		var buf []byte
+		err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "mac:112233445566",
+			Destination: dest,
+		})
+		require.NoError(err)
+
+		frame := append([]byte(dest), 0)
+		require.NoError(publisher.Send(append(frame, buf...)))
+	}
 
+	for _, s := range subscribers {
 		for {
-			if err := sock.Send(buf); err != nil {
-				if errors.Is(err, mangos.ErrSendTimeout) {
-					continue
-				}
+			if ctx.Err() != nil {
+				require.Fail("timed out waiting for message")
+			}
 
-				return sock, err
+			lock.Lock()
+			n := len(got[s.name])
+			lock.Unlock()
+			if n >= len(s.expect) {
+				break
 			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Give any unexpected messages a chance to arrive.
+	time.Sleep(100 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, s := range subscribers {
+		var dests []string
+		for _, m := range got[s.name] {
+			dests = append(dests, m.Destination)
+		}
+		assert.ElementsMatch(t, s.expect, dests, s.name)
+	}
+}
+
+func TestMaxMessageBytes(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var lock sync.Mutex
+	var got []wrp.Message
+	var decodeErrs []error
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithMaxMessageBytes(1024),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, m)
+			}),
+		)),
+		receiver.WithDecodeErrorListener(func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			decodeErrs = append(decodeErrs, err)
+		}),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	large := wrp.Message{
+		Type:    wrp.SimpleEventMessageType,
+		Source:  "large",
+		Payload: make([]byte, 2048),
+	}
+	small := wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "small",
+	}
+
+	sock, err := sendMsgs([]wrp.Message{large}, port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	// The transport drops the connection that sent the oversized message, so
+	// keep sending the small one until the socket has dialed again.
+	for {
+		if ctx.Err() != nil {
+			require.Fail("timed out waiting for message")
+		}
 
+		lock.Lock()
+		n := len(got)
+		lock.Unlock()
+		if n > 0 {
 			break
 		}
+
+		var buf []byte
+		require.NoError(wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(small))
+		_ = sock.Send(buf)
+		time.Sleep(50 * time.Millisecond)
 	}
 
-	return sock, nil
+	lock.Lock()
+	for _, m := range got {
+		assert.Equal(t, "small", m.Source)
+	}
+
+	// The oversized message never reached the decoder.
+	assert.Empty(t, decodeErrs)
+	lock.Unlock()
+
+	// Oversized frames that get past the transport are still dropped.
+	var buf []byte
+	require.NoError(wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(large))
+	assert.ErrorIs(t, r.Inject(context.Background(), buf), receiver.ErrMessageTooLarge)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(decodeErrs, 1)
+	assert.ErrorIs(t, decodeErrs[0], receiver.ErrMessageTooLarge)
+}
+
+func TestAcceptedTypes(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var lock sync.Mutex
+	var got []wrp.MessageType
+	var decodeErrs []error
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithAcceptedTypes(wrp.SimpleEventMessageType),
+		receiver.WithAcceptedTypes(wrp.ServiceAliveMessageType),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, m.Type)
+			}),
+		)),
+		receiver.WithDecodeErrorListener(func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			decodeErrs = append(decodeErrs, err)
+		}),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	send := []wrp.Message{
+		{
+			Type:   wrp.SimpleRequestResponseMessageType,
+			Source: "dropped",
+		}, {
+			Type:   wrp.SimpleEventMessageType,
+			Source: "event",
+		}, {
+			Type:   wrp.CreateMessageType,
+			Source: "dropped",
+		}, {
+			Type: wrp.ServiceAliveMessageType,
+		},
+	}
+
+	sock, err := sendMsgs(send, port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	require.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(got) == 2 && len(decodeErrs) == 2
+	}, 60*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.ElementsMatch(t,
+		[]wrp.MessageType{wrp.SimpleEventMessageType, wrp.ServiceAliveMessageType},
+		got)
+	for _, err := range decodeErrs {
+		assert.ErrorIs(t, err, receiver.ErrTypeNotAccepted)
+	}
+}
+
+func TestAddModifier(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	// always sees every message, so it shows when a message was dispatched.
+	var always, added atomic.Int64
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(context.Context, wrp.Message) {
+				always.Add(1)
+			}),
+		)),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	sock, err := dialPush(port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	send := func(want int64) {
+		var buf []byte
+		require.NoError(wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+			Type: wrp.SimpleEventMessageType,
+		}))
+		require.NoError(sendBuf(sock, buf))
+		require.Eventually(func() bool {
+			return always.Load() == want
+		}, 60*time.Second, 10*time.Millisecond)
+	}
+
+	// Handlers added while running see later messages.
+	send(1)
+	cancel := r.AddModifier(wrp.ObserverAsModifier(
+		wrp.ObserverFunc(func(context.Context, wrp.Message) {
+			added.Add(1)
+		}),
+	))
+	send(2)
+	require.Eventually(func() bool {
+		return added.Load() == 1
+	}, 60*time.Second, 10*time.Millisecond)
+
+	// Canceled handlers don't.
+	cancel()
+	cancel()
+	send(3)
+	assert.Equal(t, int64(1), added.Load())
+
+	// Nil handlers are ignored.
+	r.AddModifier(nil)()
+	send(4)
+
+	// A handler can remove itself, and add another, while it is handling a
+	// message.
+	var once, later atomic.Int64
+	var remove func()
+	removed := make(chan struct{})
+	remove = r.AddModifier(wrp.ObserverAsModifier(
+		wrp.ObserverFunc(func(context.Context, wrp.Message) {
+			if once.Add(1) == 1 {
+				remove()
+				r.AddModifier(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(context.Context, wrp.Message) {
+						later.Add(1)
+					}),
+				))
+				close(removed)
+			}
+		}),
+	))
+	send(5)
+	select {
+	case <-removed:
+	case <-time.After(5 * time.Second):
+		require.Fail("the handler deadlocked changing the handlers")
+	}
+	send(6)
+	require.Eventually(func() bool {
+		return later.Load() == 1
+	}, 60*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), once.Load())
+}
+
+func TestListenDuringClose(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var r *receiver.Receiver
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	relistened := make(chan error, 1)
+	var once sync.Once
+
+	// Both a handler and a close listener start the Receiver again while it
+	// is being closed.
+	relisten := func() {
+		once.Do(func() {
+			relistened <- r.Listen()
+		})
+	}
+
+	r, err = receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ModifierFunc(
+			func(_ context.Context, m wrp.Message) (wrp.Message, error) {
+				started <- struct{}{}
+				<-release
+				relisten()
+				return m, nil
+			},
+		)),
+		receiver.WithCloseListener(func(error) {
+			relisten()
+		}),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+
+	sock, err := sendMsgs([]wrp.Message{{Type: wrp.SimpleEventMessageType}}, port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		require.Fail("timed out waiting for the handler")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Close()
+	}()
+
+	close(release)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("Close deadlocked with Listen")
+	}
+
+	assert.NoError(t, <-relistened)
+	require.NoError(r.Close())
+}
+
+func TestAddCloseListener(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+
+	var called, canceled atomic.Int64
+	r.AddCloseListener(func(error) {
+		called.Add(1)
+	})
+	cancel := r.AddCloseListener(func(error) {
+		canceled.Add(1)
+	})
+	cancel()
+	r.AddCloseListener(nil)
+
+	require.NoError(r.Close())
+
+	require.Eventually(func() bool {
+		return called.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, canceled.Load())
+}
+
+// findOpenPort finds an open port for listening on.
+func findOpenPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.Port, nil
+}
+
+// sendMsgs sends a list of messages to the specified port.
+// Send back the socket so it can be closed after all messages have been
+// received.  Otherwise the messages may not be sent if aa defer sock.Close()
+// is used.
+func sendMsgs(msgs []wrp.Message, port int) (mangos.Socket, error) {
+	sock, err := dialPush(port)
+	if err != nil {
+		return sock, err
+	}
+
+	for _, msg := range msgs {
+		var buf []byte
+		if err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(msg); err != nil {
+			return sock, err
+		}
+
+		if err := sendBuf(sock, buf); err != nil {
+			return sock, err
+		}
+	}
+
+	return sock, nil
+}
+
+// sendBatch sends a list of messages to the specified port as a single
+// length-prefixed batch.  The socket is returned for the same reason as
+// sendMsgs.
+func sendBatch(msgs []wrp.Message, port int) (mangos.Socket, error) {
+	sock, err := dialPush(port)
+	if err != nil {
+		return sock, err
+	}
+
+	var batch []byte
+	for _, msg := range msgs {
+		var buf []byte
+		if err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(msg); err != nil {
+			return sock, err
+		}
+
+		batch = binary.BigEndian.AppendUint32(batch, uint32(len(buf)))
+		batch = append(batch, buf...)
+	}
+
+	return sock, sendBuf(sock, batch)
+}
+
+// dialPush creates a push socket connected to the specified port.
+func dialPush(port int) (mangos.Socket, error) {
+	sock, err := push.NewSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the write queue length to 1.  This is the only way to ensure that
+	// message delivery faiures are detected
+	if err := sock.SetOption(mangos.OptionWriteQLen, 1); err != nil {
+		return nil, err
+	}
+
+	// Set the send timeout to the configured value.  The other methods of
+	// setting the timeout are not supported by the mangos library
+	if err := sock.SetOption(mangos.OptionSendDeadline, 10*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	err = sock.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", port))
+
+	return sock, err
+}
+
+// sendBuf sends the buffer, retrying if the send times out.
+func sendBuf(sock mangos.Socket, buf []byte) error {
+	for {
+		if err := sock.Send(buf); err != nil {
+			if errors.Is(err, mangos.ErrSendTimeout) {
+				continue
+			}
+
+			return err
+		}
+
+		return nil
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var got atomic.Int64
+	var lock sync.Mutex
+	var panics []error
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(context.Context, wrp.Message) {
+				panic("boom")
+			}),
+		)),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(context.Context, wrp.Message) {
+				got.Add(1)
+			}),
+		)),
+		receiver.WithPanicListener(func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			panics = append(panics, err)
+		}),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	send := []wrp.Message{
+		{Type: wrp.SimpleEventMessageType},
+		{Type: wrp.SimpleEventMessageType},
+	}
+
+	sock, err := sendMsgs(send, port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	// The panicking handler doesn't stop the other handler or later messages.
+	require.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return got.Load() == 2 && len(panics) == 2
+	}, 60*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, err := range panics {
+		assert.ErrorIs(t, err, receiver.ErrHandlerPanic)
+		assert.ErrorContains(t, err, "boom")
+	}
+}
+
+func TestFormatDetector(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var lock sync.Mutex
+	var got []string
+	var decodeErrs []error
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithFormatDetector(nil),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, m.Source)
+			}),
+		)),
+		receiver.WithDecodeErrorListener(func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			decodeErrs = append(decodeErrs, err)
+		}),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	sock, err := dialPush(port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	frames := []struct {
+		source string
+		format wrp.Format
+	}{
+		{source: "msgpack-1", format: wrp.Msgpack},
+		{source: "json-1", format: wrp.JSON},
+		{source: "msgpack-2", format: wrp.Msgpack},
+		{source: "json-2", format: wrp.JSON},
+	}
+	for _, f := range frames {
+		var buf []byte
+		require.NoError(wrp.NewEncoderBytes(&buf, f.format).Encode(wrp.Message{
+			Type:   wrp.SimpleEventMessageType,
+			Source: f.source,
+		}))
+		require.NoError(sendBuf(sock, buf))
+	}
+
+	// JSON that isn't a message can't be decoded.
+	require.NoError(sendBuf(sock, []byte("{not json")))
+
+	require.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(got) == 4 && len(decodeErrs) == 1
+	}, 60*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.ElementsMatch(t,
+		[]string{"msgpack-1", "json-1", "msgpack-2", "json-2"},
+		got)
+}
+
+func TestFormatDetector_NotAccepted(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var lock sync.Mutex
+	var got []string
+	var decodeErrs []error
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithFormats(wrp.Msgpack),
+		receiver.WithFormatDetector(func(buf []byte) wrp.Format {
+			// The first byte is a format marker.
+			return wrp.Format(buf[0])
+		}),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, m.Source)
+			}),
+		)),
+		receiver.WithDecodeErrorListener(func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			decodeErrs = append(decodeErrs, err)
+		}),
+	)
+	require.NoError(err)
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	sock, err := dialPush(port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+
+	// A frame marked as JSON isn't accepted, and neither is an unknown mark.
+	for _, mark := range []byte{byte(wrp.JSON), 9} {
+		require.NoError(sendBuf(sock, []byte{mark, '{', '}'}))
+	}
+
+	require.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(decodeErrs) == 2
+	}, 60*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Empty(t, got)
+	for _, err := range decodeErrs {
+		assert.ErrorIs(t, err, receiver.ErrUnknownFormat)
+	}
+}
+
+func TestDecompression(t *testing.T) {
+	large := wrp.Message{
+		Type:    wrp.SimpleEventMessageType,
+		Source:  "large",
+		Payload: bytes.Repeat([]byte("compressible payload "), 256),
+	}
+	small := wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "small",
+	}
+
+	tests := []struct {
+		name      string
+		sOpts     []sender.Option
+		rOpts     []receiver.Option
+		expect    []wrp.Message
+		expectErr error
+	}{
+		{
+			name:   "compressed",
+			sOpts:  []sender.Option{sender.WithCompression(gzip.DefaultCompression)},
+			rOpts:  []receiver.Option{receiver.WithDecompression()},
+			expect: []wrp.Message{large, small},
+		}, {
+			name:   "uncompressed",
+			rOpts:  []receiver.Option{receiver.WithDecompression()},
+			expect: []wrp.Message{large, small},
+		}, {
+			name:   "decompression not enabled",
+			sOpts:  []sender.Option{sender.WithCompression(gzip.DefaultCompression)},
+			expect: []wrp.Message{small},
+		}, {
+			name:  "too large once decompressed",
+			sOpts: []sender.Option{sender.WithCompression(gzip.BestCompression)},
+			rOpts: []receiver.Option{
+				receiver.WithDecompression(),
+				receiver.WithMaxMessageBytes(1024),
+			},
+			expect:    []wrp.Message{small},
+			expectErr: receiver.ErrMessageTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			port, err := findOpenPort()
+			require.NoError(err)
+			url := fmt.Sprintf("tcp://127.0.0.1:%d", port)
+
+			var lock sync.Mutex
+			var got []wrp.Message
+			var decodeErrs []error
+
+			opts := append([]receiver.Option{
+				receiver.WithURL(url),
+				receiver.WithRecvTimeout(100 * time.Millisecond),
+				receiver.WithModifyWRP(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+						lock.Lock()
+						defer lock.Unlock()
+						got = append(got, m)
+					}),
+				)),
+				receiver.WithDecodeErrorListener(func(err error) {
+					lock.Lock()
+					defer lock.Unlock()
+					decodeErrs = append(decodeErrs, err)
+				}),
+			}, tt.rOpts...)
+			r, err := receiver.New(opts...)
+			require.NoError(err)
+			require.NoError(r.Listen())
+			defer r.Close() // nolint:errcheck
+
+			s, err := sender.New(append([]sender.Option{sender.WithURL(url)}, tt.sOpts...)...)
+			require.NoError(err)
+			require.NoError(s.Dial())
+			defer s.Close() // nolint:errcheck
+
+			// The large message is sent first, so once the small one arrives
+			// the large one has been handled.
+			require.NoError(s.ProcessWRP(ctx, large))
+			require.NoError(s.ProcessWRP(ctx, small))
+
+			for {
+				if ctx.Err() != nil {
+					require.Fail("timed out waiting for message")
+				}
+
+				lock.Lock()
+				done := len(got) == len(tt.expect)
+				lock.Unlock()
+				if done {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.ElementsMatch(t, tt.expect, got)
+			if len(tt.expect) == 2 {
+				assert.Empty(t, decodeErrs)
+				return
+			}
+			require.Len(decodeErrs, 1)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, decodeErrs[0], tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestReceiveWorkers(t *testing.T) {
+	tests := []struct {
+		name        string
+		workers     int
+		expectOrder bool
+		expectError bool
+	}{
+		{
+			name: "goroutine per message",
+		}, {
+			name:        "one worker keeps the order",
+			workers:     1,
+			expectOrder: true,
+		}, {
+			name:    "several workers",
+			workers: 4,
+		}, {
+			name:        "negative",
+			workers:     -1,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			port, err := findOpenPort()
+			require.NoError(err)
+			url := fmt.Sprintf("tcp://127.0.0.1:%d", port)
+
+			var lock sync.Mutex
+			var got []string
+			var active, maxActive atomic.Int64
+
+			r, err := receiver.New(
+				receiver.WithURL(url),
+				receiver.WithRecvTimeout(100*time.Millisecond),
+				receiver.WithReceiveWorkers(tt.workers),
+				receiver.WithModifyWRP(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+						n := active.Add(1)
+						defer active.Add(-1)
+						for {
+							highest := maxActive.Load()
+							if n <= highest || maxActive.CompareAndSwap(highest, n) {
+								break
+							}
+						}
+
+						// Give the other workers a chance to overlap.
+						time.Sleep(time.Millisecond)
+
+						lock.Lock()
+						defer lock.Unlock()
+						got = append(got, m.Source)
+					}),
+				)),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, r)
+				return
+			}
+			require.NoError(err)
+			require.NoError(r.Listen())
+
+			var send []wrp.Message
+			var sources []string
+			for i := 0; i < 50; i++ {
+				source := fmt.Sprintf("mac:%012d", i)
+				sources = append(sources, source)
+				send = append(send, wrp.Message{
+					Type:   wrp.SimpleEventMessageType,
+					Source: source,
+				})
+			}
+
+			sock, err := sendMsgs(send, port)
+			require.NoError(err)
+			defer sock.Close() // nolint:errcheck
+
+			require.Eventually(func() bool {
+				lock.Lock()
+				defer lock.Unlock()
+				return len(got) == len(send)
+			}, 60*time.Second, time.Millisecond)
+			require.NoError(r.Close())
+
+			lock.Lock()
+			defer lock.Unlock()
+			if tt.expectOrder {
+				assert.Equal(t, sources, got)
+			} else {
+				assert.ElementsMatch(t, sources, got)
+			}
+			if tt.workers > 0 {
+				assert.LessOrEqual(t, maxActive.Load(), int64(tt.workers))
+			}
+		})
+	}
+}
+
+func BenchmarkReceiveWorkers(b *testing.B) {
+	for _, workers := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			port, err := findOpenPort()
+			require.NoError(b, err)
+
+			var handled atomic.Int64
+			r, err := receiver.New(
+				receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+				receiver.WithRecvTimeout(100*time.Millisecond),
+				receiver.WithReceiveWorkers(workers),
+				receiver.WithModifyWRP(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(context.Context, wrp.Message) {
+						handled.Add(1)
+					}),
+				)),
+			)
+			require.NoError(b, err)
+			require.NoError(b, r.Listen())
+			defer r.Close() // nolint:errcheck
+
+			sock, err := dialPush(port)
+			require.NoError(b, err)
+			defer sock.Close() // nolint:errcheck
+
+			var buf []byte
+			require.NoError(b, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+				Type:    wrp.SimpleEventMessageType,
+				Source:  "mac:112233445566",
+				Payload: make([]byte, 256),
+			}))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, sendBuf(sock, buf))
+			}
+			for handled.Load() < int64(b.N) {
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestListenCloseRace(t *testing.T) {
+	port, err := findOpenPort()
+	require.NoError(t, err)
+
+	before := runtime.NumGoroutine()
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	// Every Listen uses the same port, so a second socket listening before the
+	// first one is closed would fail to bind.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, r.Listen())
+				assert.NoError(t, r.Close())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The port is free once the Receiver is closed.
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Listen())
+	require.NoError(t, r.Close())
+
+	// The socket's own goroutines may take a moment to exit.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestListenAfterClose(t *testing.T) {
+	var closes atomic.Int64
+	r, err := receiver.New(
+		receiver.WithURL("tcp://127.0.0.1:0"),
+		receiver.WithRecvTimeout(time.Millisecond),
+		receiver.WithCloseListener(func(error) {
+			closes.Add(1)
+		}),
+	)
+	require.NoError(t, err)
+
+	// Listening right after Close must not be undone by the previous receive
+	// loop as it finishes closing.
+	for i := int64(0); i < 50; i++ {
+		require.NoError(t, r.Listen())
+		time.Sleep(time.Millisecond)
+		require.LessOrEqual(t, closes.Load(), i, "the receiver was closed early")
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestProtocolRep(t *testing.T) {
+	port, err := findOpenPort()
+	require.NoError(t, err)
+	url := fmt.Sprintf("tcp://127.0.0.1:%d", port)
+
+	// Only requests with a payload are answered, and the observer doesn't
+	// answer at all.
+	observed := wrpnngtest.NewCollector()
+	r, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithProtocol(receiver.ProtocolRep),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(observed)),
+		receiver.WithModifyWRP(wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+			if len(msg.Payload) == 0 {
+				return msg, wrp.ErrNotHandled
+			}
+			msg.Payload = slices.Clone(msg.Payload)
+			slices.Reverse(msg.Payload)
+			return msg, nil
+		})),
+	)
+	require.NoError(t, err)
+	require.NoError(t, r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	s, err := sender.New(
+		sender.WithURL(url),
+		sender.WithReqRep(),
+		sender.WithSendTimeout(5*time.Second),
+	)
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	got, err := s.Request(context.Background(), wrp.Message{
+		Type:    wrp.SimpleRequestResponseMessageType,
+		Payload: []byte("abc"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cba"), got.Payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = s.Request(ctx, wrp.Message{
+		Type: wrp.SimpleRequestResponseMessageType,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	msgs, err := observed.Wait(2, 5*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, msgs, 2)
+}
+
+func TestRawFrameObserver(t *testing.T) {
+	require := require.New(t)
+
+	port, err := findOpenPort()
+	require.NoError(err)
+
+	var lock sync.Mutex
+	var frames [][]byte
+	var canceled [][]byte
+	var cancel func()
+
+	r, err := receiver.New(
+		receiver.WithURL(fmt.Sprintf("tcp://127.0.0.1:%d", port)),
+		receiver.WithRecvTimeout(100*time.Millisecond),
+		receiver.WithRawFrameObserver(func(buf []byte) {
+			lock.Lock()
+			defer lock.Unlock()
+			frames = append(frames, bytes.Clone(buf))
+		}),
+		receiver.WithRawFrameObserver(func(buf []byte) {
+			canceled = append(canceled, buf)
+		}, &cancel),
+	)
+	require.NoError(err)
+	require.NotNil(cancel)
+	cancel()
+	require.NoError(r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	var valid []byte
+	require.NoError(wrp.NewEncoderBytes(&valid, wrp.Msgpack).Encode(wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "dns:example.com",
+	}))
+
+	// Frames that fail to decode are observed as well.
+	send := [][]byte{valid, []byte("not a wrp message")}
+
+	sock, err := dialPush(port)
+	require.NoError(err)
+	defer sock.Close() // nolint:errcheck
+	for _, buf := range send {
+		require.NoError(sendBuf(sock, buf))
+	}
+
+	require.Eventually(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(frames) == len(send)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, send, frames)
+	assert.Empty(t, canceled)
+}
+
+func TestInject(t *testing.T) {
+	var decodeErrs []error
+	var raw int
+	collector := wrpnngtest.NewCollector()
+	r, err := receiver.New(
+		receiver.WithURL("tcp://127.0.0.1:0"),
+		receiver.WithModifyWRP(collector),
+		receiver.WithDecodeErrorListener(func(err error) {
+			decodeErrs = append(decodeErrs, err)
+		}),
+		receiver.WithRawFrameObserver(func([]byte) {
+			raw++
+		}),
+	)
+	require.NoError(t, err)
+
+	var frame []byte
+	require.NoError(t, wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "dns:example.com",
+	}))
+
+	// The handlers are done once Inject returns, even without listening.
+	require.NoError(t, r.Inject(context.Background(), frame))
+	assert.Equal(t, 1, collector.Len())
+
+	err = r.Inject(context.Background(), []byte("not a wrp message"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, collector.Len())
+	require.Len(t, decodeErrs, 1)
+	assert.ErrorIs(t, err, decodeErrs[0])
+
+	// Injected frames aren't observed, so a replay isn't recorded again.
+	assert.Zero(t, raw)
+}
+
+// selfSignedTLS returns TLS configs for a listener on 127.0.0.1 using a new
+// self-signed certificate, and for a dialer that trusts it.
+func selfSignedTLS(t *testing.T) (listen, dial *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	listen = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	dial = &tls.Config{
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+	}
+	return listen, dial
+}
+
+func TestWebSocket(t *testing.T) {
+	listenTLS, dialTLS := selfSignedTLS(t)
+
+	tests := []struct {
+		name   string
+		scheme string
+		rOpts  []receiver.Option
+		sOpts  []sender.Option
+	}{
+		{
+			name:   "ws",
+			scheme: "ws",
+		}, {
+			name:   "wss",
+			scheme: "wss",
+			rOpts:  []receiver.Option{receiver.WithTLSConfig(listenTLS)},
+			sOpts:  []sender.Option{sender.WithTLSConfig(dialTLS)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			port, err := findOpenPort()
+			require.NoError(err)
+			url := fmt.Sprintf("%s://127.0.0.1:%d/wrp", tt.scheme, port)
+
+			got := make(chan wrp.Message, 10)
+			opts := append([]receiver.Option{
+				receiver.WithURL(url),
+				receiver.WithRecvTimeout(100 * time.Millisecond),
+				receiver.WithModifyWRP(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+						got <- m
+					}),
+				)),
+			}, tt.rOpts...)
+			r, err := receiver.New(opts...)
+			require.NoError(err)
+			require.NoError(r.Listen())
+			defer r.Close() // nolint:errcheck
+
+			s, err := sender.New(append([]sender.Option{sender.WithURL(url)}, tt.sOpts...)...)
+			require.NoError(err)
+			require.NoError(s.Dial())
+			defer s.Close() // nolint:errcheck
+
+			sent := []wrp.Message{
+				{
+					Type:        wrp.SimpleEventMessageType,
+					Source:      "mac:112233445566/first",
+					Destination: "event:status",
+				}, {
+					Type:        wrp.SimpleEventMessageType,
+					Source:      "mac:112233445566/second",
+					Destination: "event:status",
+					Payload:     []byte("payload"),
+				},
+			}
+			for _, m := range sent {
+				require.NoError(s.ProcessWRP(ctx, m))
+			}
+
+			// The handlers run concurrently, so the order isn't checked.
+			var received []wrp.Message
+			for range sent {
+				select {
+				case m := <-got:
+					received = append(received, m)
+				case <-ctx.Done():
+					require.Fail("timed out waiting for message")
+				}
+			}
+			assert.ElementsMatch(t, sent, received)
+		})
+	}
+}
+
+func TestWebSocketTLSRequired(t *testing.T) {
+	port, err := findOpenPort()
+	require.NoError(t, err)
+
+	r, err := receiver.New(receiver.WithURL(fmt.Sprintf("wss://127.0.0.1:%d/wrp", port)))
+	require.NoError(t, err)
+	assert.Error(t, r.Listen())
 }
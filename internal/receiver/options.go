@@ -4,10 +4,14 @@
 package receiver
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/backoff"
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
 )
 
 // Option is a functional option for configuring a Receiver.
@@ -35,12 +39,103 @@ func WithURL(url string) Option {
 	})
 }
 
-// WithRecvTimeout sets the receiving timeout for the Receiver.
+// WithRecvTimeout sets the receiving timeout for the Receiver.  The timeout is
+// how long the underlying socket waits for a message before checking again; it
+// does not limit how quickly Close returns, since Close also closes the socket.
+// A zero timeout uses the default of 1 second rather than waiting forever.  A
+// negative timeout is an error.
 func WithRecvTimeout(timeout time.Duration) Option {
+	return errOptionFunc(func(r *Receiver) error {
+		if timeout < 0 {
+			return errors.New("recv timeout must not be negative")
+		}
+
+		if timeout == 0 {
+			timeout = DefaultRecvTimeout
+		}
+
+		r.timeout = timeout
+		return nil
+	})
+}
+
+// WithBatchDecoding enables decoding of multiple messages from each received
+// buffer.  Each message in the buffer is prefixed by its length as a 4 byte,
+// big-endian, unsigned integer, and is decoded the same as a message received
+// on its own: using the formats set by WithFormats or the detector set by
+// WithFormatDetector, after it is decompressed if WithDecompression is used.
+// Buffers that are not correctly framed are dropped.  Since a request only has
+// one reply, it can't be used with ProtocolRep.
+func WithBatchDecoding() Option {
+	return optionFunc(func(r *Receiver) {
+		r.batch = true
+	})
+}
+
+// WithFormats sets the formats the Receiver accepts, in the order they are
+// tried when decoding a message.  The default is msgpack only.
+func WithFormats(formats ...wrp.Format) Option {
+	return optionFunc(func(r *Receiver) {
+		r.formats = append(r.formats[:0:0], formats...)
+	})
+}
+
+// WithFormatDetector sets a function that picks the format of each received
+// message from its encoded bytes, such as a gateway's format byte, so JSON and
+// msgpack messages can be mixed on one Receiver.  Only the detected format is
+// tried, and it must be one of the formats set using WithFormats, if any were
+// set.  Messages detected as any other format are dropped with
+// ErrUnknownFormat.  A nil detector uses DetectFormat.
+func WithFormatDetector(detect func([]byte) wrp.Format) Option {
 	return optionFunc(func(r *Receiver) {
-		if timeout >= 0 {
-			r.timeout = timeout
+		if detect == nil {
+			detect = DetectFormat
 		}
+		r.detect = detect
+	})
+}
+
+// WithDecompression makes the Receiver decompress gzip compressed messages,
+// such as those sent by a sender using compression, before decoding them.
+// Uncompressed messages are still accepted.  The decompressed size is limited
+// to the maximum message size, or DefaultMaxDecompressedBytes if it isn't set,
+// and larger messages are dropped with ErrMessageTooLarge.  By default,
+// compressed messages fail to decode.
+func WithDecompression() Option {
+	return optionFunc(func(r *Receiver) {
+		r.inflates = true
+	})
+}
+
+// WithSubscribe makes the Receiver use a sub socket instead of a pull socket,
+// subscribed to the topics provided.  This allows multiple receivers to get the
+// same messages from a pub socket.  If no topics are provided, all messages are
+// received.
+//
+// Each frame published must be the WRP destination of the message, a single
+// zero byte, then the encoded message.  A topic matches a frame if it is a
+// prefix of the destination, so "event:device-status/" matches all device
+// status events.  Frames without the zero byte are dropped.
+func WithSubscribe(topics ...string) Option {
+	return optionFunc(func(r *Receiver) {
+		r.protocol = ProtocolSub
+		r.topics = append(r.topics, topics...)
+	})
+}
+
+// WithProtocol sets the protocol the Receiver listens with.  ProtocolSub
+// receives all messages unless WithSubscribe is also used to pick the topics.
+// With ProtocolRep, the handlers of a request are called in turn so the reply
+// can be picked, and WithReceiveWorkers has no effect.  The default is
+// ProtocolPull.
+func WithProtocol(p Protocol) Option {
+	return errOptionFunc(func(r *Receiver) error {
+		if p < ProtocolPull || p > ProtocolRep {
+			return fmt.Errorf("invalid protocol: %d", p)
+		}
+
+		r.protocol = p
+		return nil
 	})
 }
 
@@ -52,9 +147,13 @@ func WithRecvTimeout(timeout time.Duration) Option {
 //   - The returned value of the wrp.Modifier is ignored.
 //   - The handlers are called on a separate goroutine, so they do not block the
 //     Receiver, but can impact other handlers.
+//   - Close and Drain wait for running handlers to finish.
+//
+// Handlers can also be added after the Receiver is created using
+// Receiver.AddModifier.
 func WithModifyWRP(m wrp.Modifier, cancel ...*func()) Option {
 	return optionFunc(func(r *Receiver) {
-		cancelFn := r.onMsg.Add(m)
+		cancelFn := r.AddModifier(m)
 		for i := range cancel {
 			if cancel[i] != nil {
 				*cancel[i] = cancelFn
@@ -68,12 +167,202 @@ func WithModifyWRP(m wrp.Modifier, cancel ...*func()) Option {
 //
 //   - There can be multiple listeners.
 //   - The order of the listeners is not guaranteed.
-//   - The error parameter is the reason for the close.
-//   - The listeners are called on a separate goroutine, so they do not block
-//     the Receiver, but can impact other listeners.
+//   - The error parameter is the reason for the close.  It is nil when the
+//     Receiver was stopped using Close or Drain, and non-nil when the
+//     transport failed.
+//   - The listeners are called one at a time on the goroutine that ran the
+//     receive loop, once its socket is closed, so a slow listener delays the
+//     others but not the Receiver.  A listener may call Listen or Close.
 func WithCloseListener(f func(error), cancel ...*func()) Option {
 	return optionFunc(func(r *Receiver) {
-		cancelFn := r.onFailure.Add(f)
+		cancelFn := r.AddCloseListener(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
+// WithRebindBackoff makes the Receiver open its socket again when the receive
+// loop fails, such as when the listener loses its bind, instead of closing.
+// The first attempt is made after initial, and the delay doubles after each
+// failed attempt up to maxDelay, where zero means no limit.  An attempt fails
+// if the socket can't be opened, or if its receive loop fails before a message
+// was received on it.  After maxFailures failed attempts in a row, zero meaning
+// never, the Receiver gives up and the close listeners are called with the last
+// error.  A failure to bind in Listen is still returned by Listen.  Negative
+// values are an error.
+func WithRebindBackoff(initial, maxDelay time.Duration, maxFailures int) Option {
+	return errOptionFunc(func(r *Receiver) error {
+		if initial < 0 || maxDelay < 0 || maxFailures < 0 {
+			return fmt.Errorf("invalid rebind backoff: initial %s, max %s, max failures %d",
+				initial, maxDelay, maxFailures)
+		}
+
+		r.rebindBackoff = &backoff.Policy{
+			Initial:     initial,
+			Max:         maxDelay,
+			MaxFailures: maxFailures,
+		}
+		return nil
+	})
+}
+
+// WithRecvBufferSize sets the length of the socket's read queue, in messages.
+// A longer queue lets the peers keep sending while the Receiver is busy.  Zero
+// uses the mangos default.  A negative size is an error.
+func WithRecvBufferSize(n int) Option {
+	return errOptionFunc(func(r *Receiver) error {
+		if n < 0 {
+			return errors.New("recv buffer size must not be negative")
+		}
+
+		r.readQLen = n
+		return nil
+	})
+}
+
+// WithReceiveWorkers makes a fixed pool of n workers decode and dispatch the
+// received messages, instead of decoding them in the read loop and calling the
+// handlers on a new goroutine for each message.  This bounds the CPU and the
+// number of goroutines used.  When all the workers are busy, no more messages
+// are read, so the peers are pushed back on by the socket's read queue.
+//
+// Messages are handled in the order received when n is 1.  With more workers,
+// messages are handled concurrently, and the order is not guaranteed.  Zero
+// uses a goroutine per message, which is the default.  A negative n is an
+// error.
+func WithReceiveWorkers(n int) Option {
+	return errOptionFunc(func(r *Receiver) error {
+		if n < 0 {
+			return errors.New("receive workers must not be negative")
+		}
+
+		r.workers = n
+		return nil
+	})
+}
+
+// WithSocketOption sets a mangos socket option, such as
+// mangos.OptionMaxRecvSize, on the socket before it listens.  This allows
+// tuning options that don't have their own Option.  The options are set in
+// order after the Receiver's own options, so they take precedence.  If the
+// socket rejects the option, Listen fails with the error.
+func WithSocketOption(name string, value any) Option {
+	return errOptionFunc(func(r *Receiver) error {
+		if name == "" {
+			return errors.New("socket option name is required")
+		}
+
+		r.sockOpts = append(r.sockOpts, sockutil.Option{Name: name, Value: value})
+		return nil
+	})
+}
+
+// WithTLSConfig sets the TLS config used to listen on wss:// URLs, which must
+// include the certificate the Receiver presents.  It is ignored for the other
+// transports.  Listening on a wss URL without it fails.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return optionFunc(func(r *Receiver) {
+		r.tlsConfig = cfg
+	})
+}
+
+// WithMaxMessageBytes sets the largest buffer the Receiver accepts from the
+// network.  The limit is set as the socket's mangos.OptionMaxRecvSize, so the
+// transport drops the connection of a peer sending a larger buffer before it
+// is read into memory.  Larger buffers that still get through, such as when
+// WithSocketOption raises the limit or the frame is injected, are dropped
+// before they are decoded, and the decode error listeners are informed with
+// ErrMessageTooLarge.  A value of zero or less keeps the transport's default
+// limit, which is the default.
+func WithMaxMessageBytes(n int) Option {
+	return optionFunc(func(r *Receiver) {
+		r.maxBytes = n
+	})
+}
+
+// WithAcceptedTypes limits the messages dispatched to the handlers to the types
+// provided.  Messages of other types are dropped after they are decoded, and
+// the decode error listeners are informed with ErrTypeNotAccepted.  Using the
+// option more than once adds to the accepted types.  By default, all types are
+// accepted.
+func WithAcceptedTypes(types ...wrp.MessageType) Option {
+	return optionFunc(func(r *Receiver) {
+		r.accepted = append(r.accepted, types...)
+	})
+}
+
+// WithDecodeErrorListener adds a listener for when a received buffer can't be
+// turned into messages, with an optional cancel function parameter.
+//
+//   - There can be multiple listeners.
+//   - The order of the listeners is not guaranteed.
+//   - The error parameter is the reason the buffer was dropped.
+//   - The listeners are called on the receiving goroutine, so they should not
+//     block.
+func WithDecodeErrorListener(f func(error), cancel ...*func()) Option {
+	return optionFunc(func(r *Receiver) {
+		cancelFn := r.onDecode.Add(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
+// WithRawFrameObserver adds an observer that sees each frame as it is
+// received, before anything else is done with it, with an optional cancel
+// function parameter.  Every frame is observed, including frames that are
+// later dropped because they fail to decode.
+//
+//   - There can be multiple observers.
+//   - The order of the observers is not guaranteed.
+//   - The observers are called on the receiving goroutine, in the order the
+//     frames arrive, so they should not block.
+//   - The frame must not be modified, and must be copied if it is kept.
+func WithRawFrameObserver(f func([]byte), cancel ...*func()) Option {
+	return optionFunc(func(r *Receiver) {
+		cancelFn := r.onRaw.Add(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
+// WithPanicListener adds a listener for when a message handler panics, with an
+// optional cancel function parameter.
+//
+//   - There can be multiple listeners.
+//   - The order of the listeners is not guaranteed.
+//   - The error parameter wraps ErrHandlerPanic and describes the panic.
+//   - The listeners are called on the handler's goroutine.
+func WithPanicListener(f func(error), cancel ...*func()) Option {
+	return optionFunc(func(r *Receiver) {
+		cancelFn := r.onPanic.Add(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
+// WithPipeEventListener adds a listener for changes to the pipes of the
+// Receiver's socket, such as a peer connecting or disconnecting, with an
+// optional cancel function parameter.
+//
+//   - There can be multiple listeners.
+//   - The order of the listeners is not guaranteed.
+//   - The listeners are called while the pipe is being changed, so they should
+//     not block.
+func WithPipeEventListener(f func(PipeEvent), cancel ...*func()) Option {
+	return optionFunc(func(r *Receiver) {
+		cancelFn := r.onPipe.Add(f)
 		for i := range cancel {
 			if cancel[i] != nil {
 				*cancel[i] = cancelFn
@@ -87,6 +376,16 @@ func validate() Option {
 		if r.url == "" {
 			return errors.New("url is required")
 		}
+
+		for _, f := range r.formats {
+			if f != wrp.Msgpack && f != wrp.JSON {
+				return errors.New("unsupported format")
+			}
+		}
+
+		if r.batch && r.protocol == ProtocolRep {
+			return errors.New("batch decoding can't be used with the rep protocol")
+		}
 		return nil
 	})
 }
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// PipeEventType is the kind of change to a pipe, which is a single transport
+// level connection with a peer.
+type PipeEventType = sockutil.PipeEventType
+
+const (
+	PipeAttaching = sockutil.PipeAttaching
+	PipeAttached  = sockutil.PipeAttached
+	PipeDetached  = sockutil.PipeDetached
+)
+
+// PipeEvent describes a change to one of the pipes of the Receiver's socket.
+type PipeEvent = sockutil.PipeEvent
+
+// pipeEvent informs the pipe event listeners of the mangos pipe event.
+func (r *Receiver) pipeEvent(ev mangos.PipeEvent, p mangos.Pipe) {
+	sockutil.NotifyPipeEvent(&r.onPipe, ev, p)
+}
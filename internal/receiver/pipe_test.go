@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.nanomsg.org/mangos/v3/protocol/push"
+
+	// register transports
+	_ "go.nanomsg.org/mangos/v3/transport/inproc"
+)
+
+func nextPipeEvent(t *testing.T, events <-chan PipeEvent) PipeEvent {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for a pipe event")
+	}
+	return PipeEvent{}
+}
+
+func TestWithPipeEventListener(t *testing.T) {
+	const url = "inproc://receiver-pipe-events"
+
+	events := make(chan PipeEvent, 10)
+	var canceled int
+	var cancel func()
+	r, err := New(
+		WithURL(url),
+		WithRecvTimeout(10*time.Millisecond),
+		WithPipeEventListener(func(e PipeEvent) {
+			events <- e
+		}),
+		WithPipeEventListener(func(PipeEvent) {
+			canceled++
+		}, &cancel),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, cancel)
+	cancel()
+
+	require.NoError(t, r.Listen())
+	defer r.Close() // nolint:errcheck
+
+	peer, err := push.NewSocket()
+	require.NoError(t, err)
+	require.NoError(t, peer.Dial(url))
+
+	attaching := nextPipeEvent(t, events)
+	assert.Equal(t, PipeAttaching, attaching.Type)
+	assert.Equal(t, url, attaching.Address)
+
+	attached := nextPipeEvent(t, events)
+	assert.Equal(t, PipeAttached, attached.Type)
+	assert.Equal(t, attaching.ID, attached.ID)
+
+	// The peer going away detaches the pipe.
+	require.NoError(t, peer.Close())
+
+	detached := nextPipeEvent(t, events)
+	assert.Equal(t, PipeDetached, detached.Type)
+	assert.Equal(t, attached.ID, detached.ID)
+
+	assert.Zero(t, canceled)
+}
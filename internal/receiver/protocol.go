@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"context"
+
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// Protocol is the mangos protocol the Receiver listens with.
+type Protocol int
+
+const (
+	// ProtocolPull receives the messages pushed by push sockets.  It is the
+	// default.
+	ProtocolPull Protocol = iota
+
+	// ProtocolSub receives the messages published by pub sockets.  It is the
+	// same as WithSubscribe without any topics.
+	ProtocolSub
+
+	// ProtocolRep receives requests from req sockets and replies to them.  The
+	// reply is the message returned by the first handler that returns no
+	// error.  If no handler does, the request is not answered.
+	ProtocolRep
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolPull:
+		return "pull"
+	case ProtocolSub:
+		return "sub"
+	case ProtocolRep:
+		return "rep"
+	default:
+		return "unknown"
+	}
+}
+
+// recv receives the next buffer from the socket.  If the Receiver uses
+// ProtocolRep, the buffer is received using its own context, which is
+// returned so the reply can be sent using it.
+func (r *Receiver) recv(sock mangos.Socket) ([]byte, mangos.Context, error) {
+	if r.protocol != ProtocolRep {
+		buf, err := sock.Recv()
+		return buf, nil, err
+	}
+
+	c, err := sock.OpenContext()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Contexts don't get the socket's receive deadline.
+	var buf []byte
+	err = c.SetOption(mangos.OptionRecvDeadline, r.timeout)
+	if err == nil {
+		buf, err = c.Recv()
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, nil, err
+	}
+
+	return buf, c, nil
+}
+
+// respond passes the request to the handlers, and sends the first reply using
+// the request's context.  Each handler is called in turn, since the reply
+// depends on their results.  Batch decoding can't be used with ProtocolRep, so
+// the request is a single message.
+func (r *Receiver) respond(ctx context.Context, c mangos.Context, buf []byte) {
+	defer c.Close() // nolint:errcheck
+
+	ctx = context.WithoutCancel(ctx)
+
+	msgs, _ := r.messages(buf)
+	if len(msgs) == 0 {
+		return
+	}
+
+	var reply *wrp.Message
+	for _, m := range listeners(&r.onMsg) {
+		out, err := r.modify(ctx, m, msgs[0])
+		if err == nil && reply == nil {
+			reply = &out
+		}
+	}
+	if reply == nil {
+		return
+	}
+
+	// If the reply can't be sent, the requester times out the same as if it
+	// wasn't answered.
+	var out []byte
+	if err := wrp.NewEncoderBytes(&out, r.replyFormat()).Encode(reply); err == nil {
+		_ = c.Send(out)
+	}
+}
+
+// replyFormat returns the format replies are encoded with, which is the first
+// accepted format, or msgpack if none are configured.
+func (r *Receiver) replyFormat() wrp.Format {
+	if len(r.formats) > 0 {
+		return r.formats[0]
+	}
+	return wrp.Msgpack
+}
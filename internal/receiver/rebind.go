@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"context"
+	"time"
+)
+
+// rebind opens the socket again after the receive loop failed with err, and
+// receives on the new socket.  Failed attempts are retried using the backoff.
+// A socket whose receive loop fails before receiving anything counts as a
+// failed attempt, so one that keeps failing right after it opens still gives
+// up.  It returns nil once the Receiver is closed, or the last error once the
+// backoff gives up.
+func (r *Receiver) rebind(ctx context.Context, err error) error {
+	failures := 0
+	after := time.After
+	if r.after != nil {
+		after = r.after
+	}
+
+	for !r.rebindBackoff.GiveUp(failures) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-after(r.rebindBackoff.Delay(failures)):
+		}
+
+		sock, oerr := r.openSocket()
+		if oerr != nil {
+			err = oerr
+			failures++
+			continue
+		}
+
+		r.wg.Add(1)
+		received, rerr := r.receive(ctx, sock)
+		if rerr == nil {
+			return nil
+		}
+
+		err = rerr
+		failures++
+		if received {
+			failures = 0
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,322 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/backoff"
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/push"
+)
+
+func TestWithRebindBackoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		initial     time.Duration
+		maxDelay    time.Duration
+		maxFailures int
+		expectError bool
+	}{
+		{name: "valid", initial: time.Millisecond, maxDelay: time.Second, maxFailures: 3},
+		{name: "zero"},
+		{name: "negative initial", initial: -1, expectError: true},
+		{name: "negative max", maxDelay: -1, expectError: true},
+		{name: "negative failures", maxFailures: -1, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(
+				WithURL("tcp://127.0.0.1:0"),
+				WithRebindBackoff(tt.initial, tt.maxDelay, tt.maxFailures),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, r)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &backoff.Policy{
+				Initial:     tt.initial,
+				Max:         tt.maxDelay,
+				MaxFailures: tt.maxFailures,
+			}, r.rebindBackoff)
+		})
+	}
+}
+
+// failReceiving starts the receive loop on a socket that fails right away,
+// the same as Listen would start it on a real socket.
+func failReceiving(r *Receiver) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	r.lock.Lock()
+	r.cancel = cancel
+	r.stopped = stopped
+	r.lock.Unlock()
+
+	r.wg.Add(1)
+	go r.wrapper(ctx, &failingSocket{err: mangos.ErrClosed}, stopped)
+}
+
+func TestRebind(t *testing.T) {
+	// Hold the port so binding fails until it is released.
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	url := fmt.Sprintf("tcp://%s", held.Addr())
+
+	closed := make(chan error, 1)
+	got := make(chan wrp.Message, 1)
+	r, err := New(
+		WithURL(url),
+		WithRecvTimeout(10*time.Millisecond),
+		WithRebindBackoff(5*time.Millisecond, 20*time.Millisecond, 0),
+		WithCloseListener(func(err error) {
+			closed <- err
+		}),
+		WithModifyWRP(wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+			got <- msg
+			return msg, nil
+		})),
+	)
+	require.NoError(t, err)
+
+	failReceiving(r)
+
+	// The Receiver keeps trying while the port is taken.
+	select {
+	case err := <-closed:
+		require.Fail(t, "the receiver closed", "%v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, held.Close())
+
+	peer, err := push.NewSocket()
+	require.NoError(t, err)
+	defer peer.Close() // nolint:errcheck
+	require.NoError(t, peer.SetOption(mangos.OptionDialAsynch, true))
+	require.NoError(t, peer.Dial(url))
+
+	var buf []byte
+	require.NoError(t, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "mac:112233445566",
+	}))
+	require.NoError(t, peer.Send(buf))
+
+	select {
+	case msg := <-got:
+		assert.Equal(t, "mac:112233445566", msg.Source)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the receiver did not bind again")
+	}
+
+	require.NoError(t, r.Close())
+
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the close listener was not called")
+	}
+}
+
+func TestRebindGivesUp(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer held.Close() // nolint:errcheck
+
+	closed := make(chan error, 1)
+	r, err := New(
+		WithURL(fmt.Sprintf("tcp://%s", held.Addr())),
+		WithRebindBackoff(time.Millisecond, time.Millisecond, 3),
+		WithCloseListener(func(err error) {
+			closed <- err
+		}),
+	)
+	require.NoError(t, err)
+
+	failReceiving(r)
+
+	select {
+	case err := <-closed:
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, mangos.ErrClosed)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the receiver did not give up")
+	}
+
+	require.NoError(t, r.Close())
+}
+
+func TestRebindDelays(t *testing.T) {
+	errOpen := errors.New("open failed")
+
+	closed := make(chan error, 1)
+	r, err := New(
+		WithURL("tcp://127.0.0.1:0"),
+		WithRebindBackoff(10*time.Millisecond, 30*time.Millisecond, 4),
+		WithCloseListener(func(err error) {
+			closed <- err
+		}),
+	)
+	require.NoError(t, err)
+
+	r.newSock = func() (mangos.Socket, error) {
+		return nil, errOpen
+	}
+
+	// Each wait only ends when the test fires it, so no time passes.
+	waits := make(chan time.Duration)
+	fire := make(chan time.Time)
+	r.after = func(d time.Duration) <-chan time.Time {
+		waits <- d
+		return fire
+	}
+
+	failReceiving(r)
+
+	for _, want := range []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		30 * time.Millisecond,
+		30 * time.Millisecond,
+	} {
+		select {
+		case d := <-waits:
+			assert.Equal(t, want, d)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the receiver did not wait to rebind")
+		}
+		fire <- time.Time{}
+	}
+
+	select {
+	case err := <-closed:
+		assert.ErrorIs(t, err, errOpen)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the receiver did not give up")
+	}
+
+	require.NoError(t, r.Close())
+}
+
+// flakySocket receives bufs, then fails every Recv with err.
+type flakySocket struct {
+	mangos.Socket
+	lock sync.Mutex
+	bufs [][]byte
+	err  error
+}
+
+func (f *flakySocket) Recv() ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.bufs) == 0 {
+		return nil, f.err
+	}
+
+	buf := f.bufs[0]
+	f.bufs = f.bufs[1:]
+	return buf, nil
+}
+
+func (f *flakySocket) Close() error {
+	return nil
+}
+
+func TestRebindReceiveFailures(t *testing.T) {
+	errRecv := errors.New("receive failed")
+
+	var buf []byte
+	require.NoError(t, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "mac:112233445566",
+	}))
+
+	tests := []struct {
+		name string
+
+		// receives reports if the socket opened for the attempt receives a
+		// message before failing.
+		receives   func(attempt int) bool
+		expectGive bool
+	}{
+		{
+			name:       "Never receives",
+			receives:   func(int) bool { return false },
+			expectGive: true,
+		}, {
+			name: "Receives on every other socket",
+			receives: func(attempt int) bool {
+				return attempt%2 == 1
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closed := make(chan error, 1)
+			r, err := New(
+				WithURL("tcp://127.0.0.1:0"),
+				WithRebindBackoff(time.Millisecond, time.Millisecond, 2),
+				WithCloseListener(func(err error) {
+					closed <- err
+				}),
+			)
+			require.NoError(t, err)
+
+			var opens atomic.Int64
+			r.newSock = func() (mangos.Socket, error) {
+				s := &flakySocket{err: errRecv}
+				if tt.receives(int(opens.Add(1))) {
+					s.bufs = [][]byte{buf}
+				}
+				return s, nil
+			}
+
+			failReceiving(r)
+
+			if tt.expectGive {
+				select {
+				case err := <-closed:
+					assert.ErrorIs(t, err, errRecv)
+				case <-time.After(5 * time.Second):
+					require.Fail(t, "the receiver did not give up")
+				}
+				assert.Equal(t, int64(2), opens.Load())
+				require.NoError(t, r.Close())
+				return
+			}
+
+			// Receiving on a socket starts the count over, so it never reaches
+			// the limit.
+			require.Eventually(t, func() bool {
+				return opens.Load() > 10
+			}, 5*time.Second, time.Millisecond)
+			require.NoError(t, r.Close())
+
+			select {
+			case err := <-closed:
+				assert.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "the close listener was not called")
+			}
+		})
+	}
+}
@@ -4,32 +4,87 @@
 package receiver
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/xmidt-org/eventor"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/backoff"
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
 	"go.nanomsg.org/mangos/v3"
 	"go.nanomsg.org/mangos/v3/protocol/pull"
+	"go.nanomsg.org/mangos/v3/protocol/rep"
+	"go.nanomsg.org/mangos/v3/protocol/sub"
 )
 
+var (
+	ErrMessageTooLarge    = errors.New("message too large")
+	ErrTypeNotAccepted    = errors.New("message type not accepted")
+	ErrHandlerPanic       = errors.New("message handler panicked")
+	ErrUnknownFormat      = errors.New("unknown message format")
+	ErrInvalidCompression = errors.New("invalid compressed message")
+)
+
+// DefaultRecvTimeout is the receive timeout used if none is configured.
+const DefaultRecvTimeout = time.Second
+
 // Receiver is a simple listener for incoming messages.  It is safe for concurrent
 // use.
 type Receiver struct {
-	url       string
-	timeout   time.Duration
-	onMsg     eventor.Eventor[wrp.Modifier]
-	onFailure eventor.Eventor[func(error)]
-	wg        sync.WaitGroup
-	lock      sync.Mutex
-	cancel    context.CancelFunc
+	url           string
+	timeout       time.Duration
+	batch         bool
+	formats       []wrp.Format
+	detect        func([]byte) wrp.Format
+	inflates      bool
+	protocol      Protocol
+	topics        []string
+	onMsg         eventor.Eventor[wrp.Modifier]
+	onFailure     eventor.Eventor[func(error)]
+	onDecode      eventor.Eventor[func(error)]
+	onPipe        eventor.Eventor[func(PipeEvent)]
+	onPanic       eventor.Eventor[func(error)]
+	onRaw         eventor.Eventor[func([]byte)]
+	maxBytes      int
+	readQLen      int
+	workers       int
+	sockOpts      []sockutil.Option
+	tlsConfig     *tls.Config
+	rebindBackoff *backoff.Policy
+	accepted      []wrp.MessageType
+	lock          sync.Mutex
+	cancel        context.CancelFunc
+
+	// wg tracks the goroutines of the latest receive loop, including its
+	// handlers.  Listen starts a new one, so waiting for the handlers of a
+	// stopped loop neither blocks nor includes a loop started afterwards.
+	wg *sync.WaitGroup
+
+	// newSock replaces the normal socket creation when set.  It is only used
+	// for testing.
+	newSock func() (mangos.Socket, error)
+
+	// after replaces time.After while waiting to rebind when set.  It is only
+	// used for testing.
+	after func(time.Duration) <-chan time.Time
+
+	// stopped is closed once the running socket is closed.  It is nil when
+	// nothing is running.
+	stopped chan struct{}
 }
 
 // New creates a new Receiver.  The receiver is not started until Start is called.
 func New(opts ...Option) (*Receiver, error) {
-	r := &Receiver{}
+	r := &Receiver{
+		timeout: DefaultRecvTimeout,
+		wg:      new(sync.WaitGroup),
+	}
 
 	opts = append(opts, validate())
 
@@ -48,49 +103,176 @@ func (r *Receiver) Listen() error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	// If it already has a cancel function, it's already running.
-	if r.cancel != nil {
-		return nil
+	for {
+		// If it already has a cancel function, it's already running.
+		if r.cancel != nil {
+			return nil
+		}
+
+		// A previous socket may still be closing, and listening again before
+		// it is closed would fail to bind.
+		stopped := r.stopped
+		if stopped == nil {
+			break
+		}
+
+		r.lock.Unlock()
+		<-stopped
+		r.lock.Lock()
 	}
 
-	sock, err := newSocket(r.url, r.timeout)
+	sock, err := r.openSocket()
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// The handlers can tell where their messages came from.
+	ctx, cancel := context.WithCancel(withURL(context.Background(), r.url))
+	stopped := make(chan struct{})
 
 	r.cancel = cancel
+	r.stopped = stopped
+	r.wg = new(sync.WaitGroup)
 
 	r.wg.Add(1)
-	go r.wrapper(ctx, sock)
+	go r.wrapper(ctx, sock, stopped)
 
 	return nil
 }
 
-// Close halts the receiver.  It is safe to call Close multiple times.
+// Close halts the receiver and waits for any in-flight handlers to finish.  It
+// is safe to call Close multiple times, and concurrently with Listen.  Since
+// Close waits for the handlers, a handler that calls it would wait for itself;
+// a handler should call Drain with a deadline, or Close on another goroutine.
 func (r *Receiver) Close() error {
+	stopped, wg := r.stop()
+	if stopped != nil {
+		<-stopped
+	}
+	wg.Wait()
+	return nil
+}
+
+// Drain stops the receiver from accepting new messages and waits for the
+// handlers of any messages already received to finish.  If the context expires
+// before the handlers finish, the context error is returned and the remaining
+// handlers are left to finish on their own.  It is safe to call Drain multiple
+// times, and to call Close after Drain.
+func (r *Receiver) Drain(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	stopped, wg := r.stop()
+	if stopped == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		<-stopped
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// stop cancels the running receive loop, if there is one, and returns the
+// channel that is closed once it has stopped, along with the wait group of the
+// loop's handlers.  The lock is not held while waiting, so the loop can finish,
+// a concurrent Listen waits for it, and a handler may call Listen.
+func (r *Receiver) stop() (<-chan struct{}, *sync.WaitGroup) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
 	if r.cancel != nil {
 		r.cancel()
 		r.cancel = nil
-		r.wg.Wait()
 	}
-	return nil
+	return r.stopped, r.wg
+}
+
+// AddModifier adds a WRP message handler, the same as WithModifyWRP, and returns
+// a function that removes it.  It is safe to call while the Receiver is
+// running, and the handler is called for messages dispatched after it is added.
+// It and the returned function may be called from a handler; the change
+// applies from the next message.  A nil handler is ignored.
+func (r *Receiver) AddModifier(m wrp.Modifier) (cancel func()) {
+	if m == nil {
+		return func() {}
+	}
+	return r.onMsg.Add(m)
+}
+
+// AddCloseListener adds a listener for when the Receiver closes, the same as
+// WithCloseListener, and returns a function that removes it.  It is safe to
+// call while the Receiver is running, but must not be called from a close
+// listener.  A nil listener is ignored.
+func (r *Receiver) AddCloseListener(f func(error)) (cancel func()) {
+	if f == nil {
+		return func() {}
+	}
+	return r.onFailure.Add(f)
+}
+
+// openSocket creates the socket for the Receiver's protocol and listens on it.
+func (r *Receiver) openSocket() (mangos.Socket, error) {
+	if r.newSock != nil {
+		return r.newSock()
+	}
+
+	opts := r.socketOptions()
+
+	switch r.protocol {
+	case ProtocolSub:
+		return newSubSocket(r.url, r.timeout, r.readQLen, r.topics, opts, r.tlsConfig, r.pipeEvent)
+	case ProtocolRep:
+		return newSocket(rep.NewSocket, r.url, r.timeout, r.readQLen, opts, r.tlsConfig, r.pipeEvent)
+	default:
+		return newSocket(pull.NewSocket, r.url, r.timeout, r.readQLen, opts, r.tlsConfig, r.pipeEvent)
+	}
+}
+
+// socketOptions returns the mangos options set on the socket.  The maximum
+// message size is enforced by the transport, so oversized buffers are never
+// read into memory.  It is set first so WithSocketOption can override it.
+func (r *Receiver) socketOptions() []sockutil.Option {
+	if r.maxBytes <= 0 {
+		return r.sockOpts
+	}
+
+	opts := make([]sockutil.Option, 0, len(r.sockOpts)+1)
+	opts = append(opts, sockutil.Option{Name: mangos.OptionMaxRecvSize, Value: r.maxBytes})
+	return append(opts, r.sockOpts...)
 }
 
-func newSocket(url string, timeout time.Duration) (mangos.Socket, error) {
+// newSocket creates a socket using open and listens on it.  The TLS config is
+// only used for wss URLs.
+func newSocket(open func() (mangos.Socket, error), url string, timeout time.Duration, qlen int, opts []sockutil.Option, tlsConfig *tls.Config, hook mangos.PipeEventHook) (mangos.Socket, error) {
 	// These checks are extremely defensive, and unless the upstream code changes
 	// the normal flow of execution, they should never happen.
-	sock, err := pull.NewSocket()
+	sock, err := open()
 	if err == nil {
+		// Set the hook before listening so no pipe events are missed.
+		sock.SetPipeEventHook(hook)
+
 		// Use SetOption to set the receive deadline.  The other ways to set the
 		// receive deadline don't seem to work.
 		err = sock.SetOption(mangos.OptionRecvDeadline, timeout)
+		if err == nil && qlen > 0 {
+			err = sock.SetOption(mangos.OptionReadQLen, qlen)
+		}
+		if err == nil {
+			err = sockutil.ApplyOptions(sock, opts)
+		}
 		if err == nil {
-			err = sock.Listen(url)
+			err = sockutil.Listen(sock, url, tlsConfig)
 			if err == nil {
 				return sock, nil
 			}
@@ -100,13 +282,65 @@ func newSocket(url string, timeout time.Duration) (mangos.Socket, error) {
 	return nil, err
 }
 
+// newSubSocket is like newSocket, but creates a sub socket subscribed to the
+// topics.  If there are no topics, the socket is subscribed to all messages.
+func newSubSocket(url string, timeout time.Duration, qlen int, topics []string, opts []sockutil.Option, tlsConfig *tls.Config, hook mangos.PipeEventHook) (mangos.Socket, error) {
+	sock, err := sub.NewSocket()
+	if err != nil {
+		return nil, err
+	}
+	sock.SetPipeEventHook(hook)
+
+	if len(topics) == 0 {
+		topics = []string{""}
+	}
+
+	for _, topic := range topics {
+		if err = sock.SetOption(mangos.OptionSubscribe, []byte(topic)); err != nil {
+			_ = sock.Close()
+			return nil, err
+		}
+	}
+
+	err = sock.SetOption(mangos.OptionRecvDeadline, timeout)
+	if err == nil && qlen > 0 {
+		err = sock.SetOption(mangos.OptionReadQLen, qlen)
+	}
+	if err == nil {
+		err = sockutil.ApplyOptions(sock, opts)
+	}
+	if err == nil {
+		err = sockutil.Listen(sock, url, tlsConfig)
+		if err == nil {
+			return sock, nil
+		}
+	}
+
+	_ = sock.Close()
+	return nil, err
+}
+
 // wrapper is a helper function that wraps the receive function.  It is used to
 // handle the context and timeouts correctly, and to call the closure/failure
 // handlers.
-func (r *Receiver) wrapper(ctx context.Context, sock mangos.Socket) {
-	err := r.receive(ctx, sock)
+func (r *Receiver) wrapper(ctx context.Context, sock mangos.Socket, stopped chan struct{}) {
+	_, err := r.receive(ctx, sock)
+	if err != nil && r.rebindBackoff != nil {
+		err = r.rebind(ctx, err)
+	}
 
-	_ = r.Close()
+	// The socket is closed, so the Receiver can listen again.  The close
+	// listeners are called afterwards, so they may call Listen or Close.
+	r.lock.Lock()
+	if r.stopped == stopped {
+		if r.cancel != nil {
+			r.cancel()
+			r.cancel = nil
+		}
+		r.stopped = nil
+	}
+	r.lock.Unlock()
+	close(stopped)
 
 	r.onFailure.Visit(func(f func(error)) {
 		f(err)
@@ -118,62 +352,337 @@ func (r *Receiver) wrapper(ctx context.Context, sock mangos.Socket) {
 //
 // The code is a bit more involved to handle the context and timeouts correctly.
 // The mangos library doesn't support context, so we have to handle it ourselves.
-func (r *Receiver) receive(ctx context.Context, sock mangos.Socket) error {
-	defer r.wg.Done()
+// A single goroutine reads from the socket for the life of the loop.  Closing
+// the socket unblocks a pending Recv, and closing done unblocks a pending
+// result, so the reader always exits when the loop does.  The returned bool
+// reports if anything was received on the socket.
+func (r *Receiver) receive(ctx context.Context, sock mangos.Socket) (received bool, err error) {
+	// The loop's goroutines use its wait group even after Listen starts a new
+	// one.
+	wg := r.wg
+	defer wg.Done()
+
+	type result struct {
+		buf   []byte
+		reply mangos.Context
+		err   error
+	}
 
-	for {
-		// Use a separate goroutine to receive from the socket
-		recvChan := make(chan []byte, 1)
-		errChan := make(chan error, 1)
+	results := make(chan result)
+	done := make(chan struct{})
+	defer close(done)
+
+	var jobs chan<- []byte
+	if r.workers > 0 {
+		jobs = r.startWorkers(ctx, wg)
+		defer close(jobs)
+	}
 
-		r.wg.Add(1)
-		go func() {
-			defer r.wg.Done()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
 
-			bytes, err := sock.Recv()
-			if err != nil {
-				errChan <- err
-			} else {
-				recvChan <- bytes
+		for {
+			buf, reply, err := r.recv(sock)
+
+			select {
+			case results <- result{buf: buf, reply: reply, err: err}:
+			case <-done:
+				if reply != nil {
+					_ = reply.Close()
+				}
+				return
 			}
-		}()
 
-		var buf []byte
-		var err error
+			if err != nil && !errors.Is(err, mangos.ErrRecvTimeout) {
+				return
+			}
+		}
+	}()
+
+	for {
+		var res result
 
 		select {
 		case <-ctx.Done():
-			err = ctx.Err()
-		case err = <-errChan:
-		case buf = <-recvChan:
-		}
-
-		if buf != nil {
-			var msg wrp.Message
-			if err := wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&msg); err == nil {
-				// We got a message.  Tell everyone, but we don't care what they
-				// do with it.  Do it in a separate goroutine so we don't block
-				// the receiver.
-				go func() {
-					r.onMsg.Visit(func(m wrp.Modifier) {
-						_, _ = m.ModifyWRP(context.Background(), msg)
-					})
-				}()
+			// Only Close and Drain cancel the context, so this is a clean
+			// shutdown.
+			_ = sock.Close()
+			return received, nil
+		case res = <-results:
+		}
+
+		if res.err == nil {
+			received = true
+			r.visitOnRaw(res.buf)
+
+			// Each request is answered using its own context, so requests
+			// are handled concurrently like other messages.
+			if res.reply != nil {
+				wg.Add(1)
+				go func(res result) {
+					defer wg.Done()
+					r.respond(ctx, res.reply, res.buf)
+				}(res)
+				continue
 			}
 
 			// If we get any error processing the message, we ignore the error
 			// and keep going.
+			if jobs == nil {
+				r.dispatch(ctx, wg, res.buf)
+				continue
+			}
+
+			// Wait for a worker, which holds off reading more messages.
+			select {
+			case jobs <- res.buf:
+			case <-ctx.Done():
+			}
 			continue
 		}
 
 		// Timeouts are ok, keep going.
-		if errors.Is(err, mangos.ErrRecvTimeout) {
+		if errors.Is(res.err, mangos.ErrRecvTimeout) {
 			continue
 		}
 
 		_ = sock.Close()
 
-		// If the context was canceled, return that error, too.
-		return errors.Join(err, ctx.Err())
+		// A failure racing with Close is still a clean shutdown.
+		if ctx.Err() != nil {
+			return received, nil
+		}
+		return received, res.err
+	}
+}
+
+// dispatch decodes the received buffer and forwards the resulting messages to
+// the registered handlers.
+//
+// The handlers are passed the receive loop's context, but without its
+// cancelation, since Close waits for them to finish.  The handler goroutines
+// are tracked using the loop's wait group.
+func (r *Receiver) dispatch(ctx context.Context, wg *sync.WaitGroup, buf []byte) {
+	ctx = context.WithoutCancel(ctx)
+
+	msgs, _ := r.messages(buf)
+	for _, msg := range msgs {
+		// A worker handles the message itself, so the number of goroutines
+		// stays bounded.
+		if r.workers > 0 {
+			r.handle(ctx, msg)
+			continue
+		}
+
+		// We got a message.  Tell everyone, but we don't care what they do
+		// with it.  Do it in a separate goroutine so we don't block the
+		// receiver.  The goroutine is tracked so Close and Drain can wait for
+		// the in-flight handlers to finish, and gets its own copy of the
+		// message.
+		wg.Add(1)
+		go func(msg wrp.Message) {
+			defer wg.Done()
+			r.handle(ctx, msg)
+		}(msg)
+	}
+}
+
+// messages decodes the received buffer.  Buffers larger than the maximum are
+// rejected before anything else is done.  The transport normally drops them
+// first, so this is only a backstop.  If the receiver subscribes to
+// topics, the topic is removed first.  If batch decoding is enabled, the
+// buffer is split into frames next.  Compressed frames are decompressed if
+// decompression is enabled.  Any frame that fails to decode, or is a type that
+// isn't accepted, is dropped.  The decode error listeners are informed of each
+// dropped frame, and the reasons are also returned.
+func (r *Receiver) messages(buf []byte) ([]wrp.Message, error) {
+	var errs error
+	drop := func(err error) {
+		r.visitOnDecodeErr(err)
+		errs = errors.Join(errs, err)
+	}
+
+	if r.maxBytes > 0 && len(buf) > r.maxBytes {
+		drop(fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(buf)))
+		return nil, errs
+	}
+
+	if r.protocol == ProtocolSub {
+		var err error
+		buf, err = splitTopic(buf)
+		if err != nil {
+			drop(err)
+			return nil, errs
+		}
+	}
+
+	frames := [][]byte{buf}
+	if r.batch {
+		var err error
+		frames, err = splitBatch(buf)
+		if err != nil {
+			drop(err)
+			return nil, errs
+		}
+	}
+
+	msgs := make([]wrp.Message, 0, len(frames))
+	for _, frame := range frames {
+		if r.inflates && isCompressed(frame) {
+			var err error
+			frame, err = r.inflate(frame)
+			if err != nil {
+				drop(err)
+				continue
+			}
+		}
+
+		msg, err := r.decode(frame)
+		if err != nil {
+			drop(err)
+			continue
+		}
+
+		if !r.accepts(msg.Type) {
+			drop(fmt.Errorf("%w: %s", ErrTypeNotAccepted, msg.Type))
+			continue
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, errs
+}
+
+// Inject handles the frame as if it had been received, so recorded frames can
+// be replayed without a live producer.  The frame is decoded the same way as a
+// received frame, and the handlers are called before Inject returns.  If the
+// frame, or any frame in a batch, can't be decoded, the decode error listeners
+// are informed and the reasons are returned; the messages that were decoded
+// are still handled.  The raw frame observers are not called, so replaying a
+// recording doesn't record it again.  The Receiver doesn't need to be
+// listening.
+func (r *Receiver) Inject(ctx context.Context, frame []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	msgs, err := r.messages(frame)
+	for _, msg := range msgs {
+		r.handle(ctx, msg)
+	}
+
+	return err
+}
+
+// handle passes the message to each of the handlers.
+func (r *Receiver) handle(ctx context.Context, msg wrp.Message) {
+	for _, m := range listeners(&r.onMsg) {
+		_, _ = r.modify(ctx, m, msg)
 	}
 }
+
+// listeners returns a copy of the listeners, so they are called without holding
+// the eventor's lock and may add or remove listeners themselves.
+func listeners[T any](e *eventor.Eventor[T]) []T {
+	var rv []T
+	e.Visit(func(l T) {
+		rv = append(rv, l)
+	})
+	return rv
+}
+
+// modify passes the message to the handler.  A panic in the handler is
+// recovered and passed to the panic listeners, so one bad handler can't stop
+// the others or crash the process.
+func (r *Receiver) modify(ctx context.Context, m wrp.Modifier, msg wrp.Message) (out wrp.Message, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%w: %v", ErrHandlerPanic, v)
+			r.onPanic.Visit(func(f func(error)) {
+				f(err)
+			})
+		}
+	}()
+
+	return m.ModifyWRP(ctx, msg)
+}
+
+// accepts reports if messages of the type are dispatched.  All types are
+// accepted unless WithAcceptedTypes was used.
+func (r *Receiver) accepts(t wrp.MessageType) bool {
+	return len(r.accepted) == 0 || slices.Contains(r.accepted, t)
+}
+
+// decode decodes the frame using the first accepted format that succeeds.  If
+// no formats are configured, msgpack is used.  If a format detector is set, only
+// the detected format is tried.
+func (r *Receiver) decode(frame []byte) (wrp.Message, error) {
+	if r.detect != nil {
+		return r.decodeDetected(frame)
+	}
+
+	formats := r.formats
+	if len(formats) == 0 {
+		formats = []wrp.Format{wrp.Msgpack}
+	}
+
+	var errs error
+	for _, f := range formats {
+		var msg wrp.Message
+		err := wrp.NewDecoderBytes(frame, f).Decode(&msg)
+		if err == nil {
+			return msg, nil
+		}
+		errs = errors.Join(errs, err)
+	}
+
+	return wrp.Message{}, errs
+}
+
+// decodeDetected decodes the frame using the format picked by the detector.  The
+// format must be one of the formats set using WithFormats, if any were set.
+func (r *Receiver) decodeDetected(frame []byte) (wrp.Message, error) {
+	f := r.detect(frame)
+	if f != wrp.Msgpack && f != wrp.JSON {
+		return wrp.Message{}, fmt.Errorf("%w: %d", ErrUnknownFormat, f)
+	}
+	if len(r.formats) > 0 && !slices.Contains(r.formats, f) {
+		return wrp.Message{}, fmt.Errorf("%w: %s", ErrUnknownFormat, f.ContentType())
+	}
+
+	var msg wrp.Message
+	err := wrp.NewDecoderBytes(frame, f).Decode(&msg)
+	return msg, err
+}
+
+// DetectFormat returns JSON if the first byte of the frame that isn't white
+// space is an opening brace, and msgpack otherwise.  A msgpack encoded message
+// always starts with a map header, which can't be a brace.
+func DetectFormat(frame []byte) wrp.Format {
+	trimmed := bytes.TrimLeft(frame, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return wrp.JSON
+	}
+	return wrp.Msgpack
+}
+
+// visitOnRaw passes the received frame to the raw frame observers.  The
+// observers are checked for first, since most Receivers don't have any.
+func (r *Receiver) visitOnRaw(buf []byte) {
+	if r.onRaw.Len() == 0 {
+		return
+	}
+
+	r.onRaw.Visit(func(f func([]byte)) {
+		f(buf)
+	})
+}
+
+// visitOnDecodeErr informs the decode error listeners of the error.
+func (r *Receiver) visitOnDecodeErr(err error) {
+	r.onDecode.Visit(func(f func(error)) {
+		f(err)
+	})
+}
@@ -4,10 +4,18 @@
 package receiver
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
 )
 
 func TestNewStart(t *testing.T) {
@@ -39,20 +47,29 @@ func TestNewStart(t *testing.T) {
 				WithURL("invalid-url"),
 			},
 			want: &Receiver{
-				url: "invalid-url",
+				url:     "invalid-url",
+				timeout: DefaultRecvTimeout,
 			},
 			startErr: true,
 		},
 		{
-			name: "With negative timeout, should be ignored",
+			name: "With negative timeout",
 			options: []Option{
 				WithURL("tcp://127.0.0.1:0"),
-				WithRecvTimeout(100 * time.Millisecond),
 				WithRecvTimeout(-100 * time.Millisecond),
 			},
+			newErr: true,
+		},
+		{
+			name: "With zero timeout, uses the default",
+			options: []Option{
+				WithURL("tcp://127.0.0.1:0"),
+				WithRecvTimeout(100 * time.Millisecond),
+				WithRecvTimeout(0),
+			},
 			want: &Receiver{
 				url:     "tcp://127.0.0.1:0",
-				timeout: 100 * time.Millisecond,
+				timeout: DefaultRecvTimeout,
 			},
 		},
 	}
@@ -86,3 +103,387 @@ func TestNewStart(t *testing.T) {
 		})
 	}
 }
+
+func TestCloseWithLongTimeout(t *testing.T) {
+	r, err := New(
+		WithURL("tcp://127.0.0.1:0"),
+		WithRecvTimeout(time.Hour),
+	)
+	require.NoError(t, err)
+	require.NoError(t, r.Listen())
+
+	// Let the receive loop block in Recv.
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Close()
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Close did not return promptly")
+	}
+}
+
+func TestCloseDoesNotLeakGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		r, err := New(
+			WithURL("tcp://127.0.0.1:0"),
+			WithRecvTimeout(time.Hour),
+		)
+		require.NoError(t, err)
+		require.NoError(t, r.Listen())
+		require.NoError(t, r.Close())
+	}
+
+	// The socket's own goroutines may take a moment to exit.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// failingSocket is a socket whose Recv fails with err.
+type failingSocket struct {
+	mangos.Socket
+	err error
+}
+
+func (f *failingSocket) Recv() ([]byte, error) {
+	return nil, f.err
+}
+
+func (f *failingSocket) Close() error {
+	return nil
+}
+
+func TestCloseListenerError(t *testing.T) {
+	t.Run("clean close", func(t *testing.T) {
+		got := make(chan error, 1)
+		r, err := New(
+			WithURL("tcp://127.0.0.1:0"),
+			WithRecvTimeout(10*time.Millisecond),
+			WithCloseListener(func(err error) {
+				got <- err
+			}),
+		)
+		require.NoError(t, err)
+		require.NoError(t, r.Listen())
+		require.NoError(t, r.Close())
+
+		select {
+		case err := <-got:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the close listener was not called")
+		}
+	})
+
+	t.Run("transport failure", func(t *testing.T) {
+		got := make(chan error, 1)
+		r, err := New(
+			WithURL("tcp://127.0.0.1:0"),
+			WithCloseListener(func(err error) {
+				got <- err
+			}),
+		)
+		require.NoError(t, err)
+
+		r.wg.Add(1)
+		r.wrapper(context.Background(), &failingSocket{err: mangos.ErrClosed}, make(chan struct{}))
+
+		select {
+		case err := <-got:
+			assert.ErrorIs(t, err, mangos.ErrClosed)
+			assert.NotErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the close listener was not called")
+		}
+	})
+}
+
+func TestWithRecvBufferSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		expectQLen  int
+		expectError bool
+	}{
+		{
+			name:       "default",
+			expectQLen: 128,
+		}, {
+			name:       "larger queue",
+			opts:       []Option{WithRecvBufferSize(512)},
+			expectQLen: 512,
+		}, {
+			name:       "larger queue with subscribe",
+			opts:       []Option{WithRecvBufferSize(512), WithSubscribe()},
+			expectQLen: 512,
+		}, {
+			name:       "zero uses the default",
+			opts:       []Option{WithRecvBufferSize(512), WithRecvBufferSize(0)},
+			expectQLen: 128,
+		}, {
+			name:        "negative",
+			opts:        []Option{WithRecvBufferSize(-1)},
+			expectError: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://recv-buffer-size-%d", i)
+
+			r, err := New(append([]Option{WithURL(url)}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, r)
+				return
+			}
+			require.NoError(t, err)
+
+			// The Receiver doesn't keep the socket, so create it the same way
+			// Listen does.
+			sock, err := r.openSocket()
+			require.NoError(t, err)
+			defer sock.Close() // nolint:errcheck
+
+			qlen, err := sock.GetOption(mangos.OptionReadQLen)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectQLen, qlen)
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	var msgpack []byte
+	require.NoError(t, wrp.NewEncoderBytes(&msgpack, wrp.Msgpack).Encode(wrp.Message{
+		Type: wrp.SimpleEventMessageType,
+	}))
+
+	tests := []struct {
+		name   string
+		frame  []byte
+		expect wrp.Format
+	}{
+		{name: "empty", expect: wrp.Msgpack},
+		{name: "msgpack", frame: msgpack, expect: wrp.Msgpack},
+		{name: "json", frame: []byte(`{"msg_type":4}`), expect: wrp.JSON},
+		{name: "json with white space", frame: []byte(" \n\t{}"), expect: wrp.JSON},
+		{name: "white space only", frame: []byte("  "), expect: wrp.Msgpack},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, DetectFormat(tt.frame))
+		})
+	}
+}
+
+func TestWithSocketOption(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		option       string
+		expect       any
+		expectError  bool
+		expectListen error
+	}{
+		{
+			name:   "max receive size",
+			opts:   []Option{WithSocketOption(mangos.OptionMaxRecvSize, 4096)},
+			option: mangos.OptionMaxRecvSize,
+			expect: 4096,
+		}, {
+			name: "overrides the receiver's own options",
+			opts: []Option{
+				WithSocketOption(mangos.OptionReadQLen, 64),
+				WithRecvBufferSize(512),
+			},
+			option: mangos.OptionReadQLen,
+			expect: 64,
+		}, {
+			name:   "with subscribe",
+			opts:   []Option{WithSubscribe(), WithSocketOption(mangos.OptionMaxRecvSize, 4096)},
+			option: mangos.OptionMaxRecvSize,
+			expect: 4096,
+		}, {
+			name:   "max message bytes",
+			opts:   []Option{WithMaxMessageBytes(2048)},
+			option: mangos.OptionMaxRecvSize,
+			expect: 2048,
+		}, {
+			name: "overrides max message bytes",
+			opts: []Option{
+				WithSocketOption(mangos.OptionMaxRecvSize, 4096),
+				WithMaxMessageBytes(2048),
+			},
+			option: mangos.OptionMaxRecvSize,
+			expect: 4096,
+		}, {
+			name:        "empty name",
+			opts:        []Option{WithSocketOption("", 1)},
+			expectError: true,
+		}, {
+			name:         "unknown option",
+			opts:         []Option{WithSocketOption("NOT-AN-OPTION", 1)},
+			expectListen: mangos.ErrBadOption,
+		}, {
+			name:         "invalid value",
+			opts:         []Option{WithSocketOption(mangos.OptionMaxRecvSize, "big")},
+			expectListen: mangos.ErrBadValue,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://socket-option-%d", i)
+
+			r, err := New(append([]Option{WithURL(url)}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, r)
+				return
+			}
+			require.NoError(t, err)
+
+			if tt.expectListen != nil {
+				assert.ErrorIs(t, r.Listen(), tt.expectListen)
+				return
+			}
+
+			// The Receiver doesn't keep the socket, so create it the same way
+			// Listen does.
+			sock, err := r.openSocket()
+			require.NoError(t, err)
+			defer sock.Close() // nolint:errcheck
+
+			got, err := sock.GetOption(tt.option)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestWithProtocol(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		expectName  string
+		expectError bool
+	}{
+		{
+			name:       "default",
+			expectName: "pull",
+		}, {
+			name:       "pull",
+			opts:       []Option{WithProtocol(ProtocolPull)},
+			expectName: "pull",
+		}, {
+			name:       "sub",
+			opts:       []Option{WithProtocol(ProtocolSub)},
+			expectName: "sub",
+		}, {
+			name:       "subscribe",
+			opts:       []Option{WithSubscribe("event:")},
+			expectName: "sub",
+		}, {
+			name:       "rep",
+			opts:       []Option{WithProtocol(ProtocolRep)},
+			expectName: "rep",
+		}, {
+			name:        "invalid",
+			opts:        []Option{WithProtocol(ProtocolRep + 1)},
+			expectError: true,
+		}, {
+			name:        "rep with batches",
+			opts:        []Option{WithProtocol(ProtocolRep), WithBatchDecoding()},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(append([]Option{WithURL("tcp://127.0.0.1:0")}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, r)
+				return
+			}
+			require.NoError(t, err)
+
+			sock, err := r.openSocket()
+			require.NoError(t, err)
+			defer sock.Close() // nolint:errcheck
+
+			assert.Equal(t, tt.expectName, sock.Info().SelfName)
+			assert.Equal(t, tt.expectName, r.protocol.String())
+		})
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	compress := func(n int) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(make([]byte, n))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		size      int
+		expectErr error
+	}{
+		{
+			name: "within the default limit",
+			size: 1024,
+		}, {
+			name:      "over the default limit",
+			size:      DefaultMaxDecompressedBytes + 1,
+			expectErr: ErrMessageTooLarge,
+		}, {
+			name: "within the max message size",
+			opts: []Option{WithMaxMessageBytes(2048)},
+			size: 2048,
+		}, {
+			name:      "over the max message size",
+			opts:      []Option{WithMaxMessageBytes(2048)},
+			size:      2049,
+			expectErr: ErrMessageTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithURL("inproc://inflate"), WithDecompression()}, tt.opts...)
+			r, err := New(opts...)
+			require.NoError(t, err)
+
+			frame := compress(tt.size)
+			got, err := r.inflate(frame)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				assert.Nil(t, got)
+
+				// The frame is dropped before it is decoded.
+				assert.ErrorIs(t, r.Inject(context.Background(), frame), tt.expectErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, got, tt.size)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrInvalidTopic = errors.New("invalid topic frame")
+)
+
+// topicSeparator separates the topic from the encoded message in a topic frame.
+const topicSeparator = 0
+
+// splitTopic removes the topic from a topic frame and returns the encoded
+// message.  A topic frame is:
+//
+//   - the topic, which is the WRP destination of the message
+//   - a single zero byte
+//   - the encoded WRP message
+//
+// Since nanomsg subscriptions match the start of each frame, subscribing to a
+// prefix of a destination receives all the messages sent to destinations with
+// that prefix.  For example, subscribing to "event:device-status/" receives
+// all device status events.
+func splitTopic(buf []byte) ([]byte, error) {
+	i := bytes.IndexByte(buf, topicSeparator)
+	if i < 0 {
+		return nil, ErrInvalidTopic
+	}
+
+	return buf[i+1:], nil
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitTopic(t *testing.T) {
+	tests := []struct {
+		name        string
+		buf         []byte
+		expect      []byte
+		expectedErr error
+	}{
+		{
+			name:   "Topic and message",
+			buf:    []byte("event:device-status/online\x00msg"),
+			expect: []byte("msg"),
+		}, {
+			name:   "Empty topic",
+			buf:    []byte("\x00msg"),
+			expect: []byte("msg"),
+		}, {
+			name:   "Empty message",
+			buf:    []byte("event:foo\x00"),
+			expect: []byte{},
+		}, {
+			name:        "No separator",
+			buf:         []byte("event:foo"),
+			expectedErr: ErrInvalidTopic,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitTopic(tt.buf)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, got)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
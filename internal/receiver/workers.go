@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package receiver
+
+import (
+	"context"
+	"sync"
+)
+
+// startWorkers starts the workers that decode and dispatch the received
+// buffers sent to the returned channel.  The workers exit once the channel is
+// closed and the buffers already handed to them are handled.  They are
+// tracked by the receive loop's wait group, so Close and Drain wait for them.
+func (r *Receiver) startWorkers(ctx context.Context, wg *sync.WaitGroup) chan<- []byte {
+	// The channel is unbuffered so a buffer is only taken from the socket once
+	// a worker is free to handle it.
+	jobs := make(chan []byte)
+
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for buf := range jobs {
+				r.dispatch(ctx, wg, buf)
+			}
+		}()
+	}
+
+	return jobs
+}
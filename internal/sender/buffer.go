@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+)
+
+// enqueue adds the encoded message to the send buffer.  If the buffer is full,
+// the message is dropped when its QOS policy or the Sender drops on full,
+// otherwise enqueue waits for room until the context is done.
+func (s *Sender) enqueue(ctx context.Context, e *encoder) error {
+	s.lock.Lock()
+	if s.sock == nil && !s.autoRedial {
+		s.lock.Unlock()
+		putEncoder(e)
+		return s.wrapErr(ErrConnClosed)
+	}
+	s.startWorker()
+	s.lock.Unlock()
+
+	s.addQueued(1)
+
+	if s.drops(e.policy) {
+		select {
+		case s.buffer <- e:
+			return nil
+		default:
+		}
+		s.addQueued(-1)
+		putEncoder(e)
+		return s.wrapErr(ErrDropped)
+	}
+
+	select {
+	case s.buffer <- e:
+		return nil
+	case <-ctx.Done():
+		s.addQueued(-1)
+		putEncoder(e)
+		return ctx.Err()
+	}
+}
+
+// startWorker starts the worker that drains the send buffer if it isn't
+// running.  The lock must be held.
+func (s *Sender) startWorker() {
+	if s.stopWorker != nil {
+		return
+	}
+
+	s.stopWorker = make(chan struct{})
+	s.workerWG.Add(1)
+	go s.drain(s.stopWorker)
+}
+
+// drain sends the buffered messages until stop is closed.  Send failures are
+// handled the same as for unbuffered sends, so a dead connection is still
+// closed, and are available from Health.
+func (s *Sender) drain(stop <-chan struct{}) {
+	defer s.workerWG.Done()
+
+	// Stopping the worker also stops any retries in progress.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case e := <-s.buffer:
+			if sock := s.socket(); sock != nil {
+				_, _ = s.sendWithRetries(ctx, sock, e)
+			} else {
+				s.recordSend(s.wrapErr(ErrConnClosed))
+			}
+			putEncoder(e)
+			s.addQueued(-1)
+		}
+	}
+}
+
+// stop stops the worker and discards any messages still in the buffer.  The
+// lock must not be held, since the worker may need it to finish a send.
+func (s *Sender) stop(stop chan struct{}) {
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	s.workerWG.Wait()
+
+	for {
+		select {
+		case e := <-s.buffer:
+			putEncoder(e)
+			s.addQueued(-1)
+		default:
+			return
+		}
+	}
+}
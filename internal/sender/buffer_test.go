@@ -0,0 +1,331 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3/protocol/rep"
+)
+
+// blockedSocket returns a socket that records the payload of each message sent,
+// but blocks each send until release is closed.  Each send is announced on the
+// returned channel before it blocks.
+func blockedSocket(t *testing.T, release <-chan struct{}) (*mockSocket, <-chan string, func() []string) {
+	var lock sync.Mutex
+	var sent []string
+	started := make(chan string, 100)
+
+	sock := &mockSocket{
+		onSend: func(buf []byte) {
+			var msg wrp.Message
+			require.NoError(t, wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&msg))
+
+			started <- string(msg.Payload)
+			<-release
+
+			lock.Lock()
+			sent = append(sent, string(msg.Payload))
+			lock.Unlock()
+		},
+	}
+
+	return sock, started, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, sent...)
+	}
+}
+
+func newBuffered(t *testing.T, opts ...Option) *Sender {
+	s, err := New(append([]Option{WithURL("tcp://127.0.0.1:0")}, opts...)...)
+	require.NoError(t, err)
+	return s
+}
+
+func sendPayload(ctx context.Context, s *Sender, payload string) error {
+	return s.ProcessWRP(ctx, wrp.Message{
+		Type:    wrp.SimpleEventMessageType,
+		Payload: []byte(payload),
+	})
+}
+
+func TestSendBuffer_Burst(t *testing.T) {
+	release := make(chan struct{})
+	sock, started, sent := blockedSocket(t, release)
+
+	s := newBuffered(t, WithSendBuffer(4))
+	s.sock = sock
+	defer s.Close() // nolint:errcheck
+
+	// The whole burst is accepted while the connection is stuck on the first
+	// message.
+	burst := []string{"1", "2", "3", "4", "5"}
+	require.NoError(t, sendPayload(context.Background(), s, burst[0]))
+	assert.Equal(t, "1", <-started)
+	for _, payload := range burst[1:] {
+		require.NoError(t, sendPayload(context.Background(), s, payload))
+	}
+	assert.Equal(t, len(burst), s.Health().Queued)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return s.Health().Queued == 0
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, burst, sent())
+}
+
+func TestSendBuffer_Overflow(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		expectErr error
+	}{
+		{
+			name:      "Block until the context is done",
+			expectErr: context.DeadlineExceeded,
+		}, {
+			name:      "Drop",
+			opts:      []Option{WithDropOnFull()},
+			expectErr: ErrDropped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			sock, started, sent := blockedSocket(t, release)
+
+			s := newBuffered(t, append(tt.opts, WithSendBuffer(1))...)
+			s.sock = sock
+			defer s.Close() // nolint:errcheck
+
+			// One message is being sent and one fills the buffer.
+			require.NoError(t, sendPayload(context.Background(), s, "1"))
+			<-started
+			require.NoError(t, sendPayload(context.Background(), s, "2"))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := sendPayload(ctx, s, "3")
+			assert.ErrorIs(t, err, tt.expectErr)
+			assert.Equal(t, 2, s.Health().Queued)
+
+			close(release)
+			assert.Eventually(t, func() bool {
+				return s.Health().Queued == 0
+			}, 5*time.Second, time.Millisecond)
+			assert.Equal(t, []string{"1", "2"}, sent())
+		})
+	}
+}
+
+func TestSendBuffer_DeadConnection(t *testing.T) {
+	sendErr := errors.New("send error")
+	closed := make(chan error, 1)
+
+	s := newBuffered(t,
+		WithSendBuffer(4),
+		WithCloseListener(func(err error) {
+			closed <- err
+		}),
+	)
+	s.sock = &mockSocket{sendRv: sendErr}
+
+	// The failure happens after ProcessWRP returns, but still closes the
+	// connection.
+	require.NoError(t, sendPayload(context.Background(), s, "1"))
+
+	select {
+	case err := <-closed:
+		assert.ErrorIs(t, err, sendErr)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the connection was not closed")
+	}
+
+	assert.Eventually(t, func() bool {
+		return s.Health().Queued == 0
+	}, 5*time.Second, time.Millisecond)
+	assert.ErrorIs(t, s.Health().LastErr, sendErr)
+	assert.ErrorIs(t, sendPayload(context.Background(), s, "2"), ErrConnClosed)
+	assert.NoError(t, s.Close())
+}
+
+func TestSendBuffer_Close(t *testing.T) {
+	release := make(chan struct{})
+	sock, started, sent := blockedSocket(t, release)
+
+	s := newBuffered(t, WithSendBuffer(4))
+	s.sock = sock
+
+	require.NoError(t, sendPayload(context.Background(), s, "1"))
+	<-started
+	require.NoError(t, sendPayload(context.Background(), s, "2"))
+	require.NoError(t, sendPayload(context.Background(), s, "3"))
+
+	// Close waits for the message being sent and discards the rest.
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, s.Close())
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Close did not return")
+	}
+
+	assert.Zero(t, s.Health().Queued)
+	assert.Equal(t, []string{"1"}, sent())
+	assert.ErrorIs(t, sendPayload(context.Background(), s, "4"), ErrConnClosed)
+}
+
+func TestWithSendBuffer(t *testing.T) {
+	_, err := New(WithURL("tcp://127.0.0.1:0"), WithSendBuffer(-1))
+	assert.Error(t, err)
+
+	_, err = New(WithURL("tcp://127.0.0.1:0"), WithSendBuffer(1), WithReqRep())
+	assert.Error(t, err)
+
+	s, err := New(WithURL("tcp://127.0.0.1:0"), WithSendBuffer(0))
+	require.NoError(t, err)
+	assert.Nil(t, s.buffer)
+}
+
+func TestFlush(t *testing.T) {
+	release := make(chan struct{})
+	sock, started, sent := blockedSocket(t, release)
+
+	s := newBuffered(t, WithSendBuffer(4))
+	s.sock = sock
+	defer s.Close() // nolint:errcheck
+
+	// Nothing is queued yet.
+	require.NoError(t, s.Flush(context.Background()))
+
+	for _, payload := range []string{"1", "2", "3"} {
+		require.NoError(t, sendPayload(context.Background(), s, payload))
+	}
+	assert.Equal(t, "1", <-started)
+
+	// The messages can't be sent, so Flush times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Flush(ctx), context.DeadlineExceeded)
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- s.Flush(context.Background())
+	}()
+
+	select {
+	case err := <-flushed:
+		require.Fail(t, "Flush returned early", "%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-flushed)
+
+	// Every message was handed to the socket before Flush returned.
+	assert.Equal(t, []string{"1", "2", "3"}, sent())
+}
+
+func TestFlush_Push(t *testing.T) {
+	l := mockListener{deadline: 5 * time.Second}
+	require.NoError(t, l.Listen())
+	defer l.Close() // nolint:errcheck
+
+	s := newBuffered(t, WithSendBuffer(8))
+	s.url = l.url
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	payloads := []string{"1", "2", "3", "4", "5"}
+	for _, payload := range payloads {
+		require.NoError(t, sendPayload(context.Background(), s, payload))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Flush(ctx))
+	assert.Zero(t, s.Health().Queued)
+
+	// The buffer was drained into the connection, so the listener gets every
+	// message.
+	for _, payload := range payloads {
+		buf, err := l.sock.Recv()
+		require.NoError(t, err)
+
+		var msg wrp.Message
+		require.NoError(t, wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&msg))
+		assert.Equal(t, payload, string(msg.Payload))
+	}
+}
+
+func TestFlush_ReqRep(t *testing.T) {
+	url, err := findOpenPort()
+	require.NoError(t, err)
+
+	svc, err := rep.NewSocket()
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen(url))
+	defer svc.Close() // nolint:errcheck
+
+	// The service holds its replies until release is closed.
+	const count = 3
+	release := make(chan struct{})
+	var received atomic.Int64
+	for i := 0; i < count; i++ {
+		c, err := svc.OpenContext()
+		require.NoError(t, err)
+
+		go func() {
+			buf, err := c.Recv()
+			if err != nil {
+				return
+			}
+			received.Add(1)
+			<-release
+			_ = c.Send(buf)
+		}()
+	}
+
+	s, err := New(WithURL(url), WithReqRep(), WithSendTimeout(5*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	for i := 0; i < count; i++ {
+		go func() {
+			_ = sendPayload(context.Background(), s, "request")
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return received.Load() == count
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The service received the requests, but Flush waits for the replies.
+	assert.Equal(t, 3, s.Health().Queued)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Flush(ctx), context.DeadlineExceeded)
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Flush(ctx))
+	assert.Zero(t, s.Health().Queued)
+}
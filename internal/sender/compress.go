@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// compress replaces the encoded message with its gzip compressed form.  The
+// gzip header marks the frame as compressed, so a receiver using decompression
+// can tell it apart from a plain msgpack or JSON frame.  If compressing doesn't
+// make the frame smaller, the frame is sent as is.
+func (e *encoder) compress(level int) error {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err = zw.Write(e.buf); err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if buf.Len() < len(e.buf) {
+		e.buf = buf.Bytes()
+	}
+	return nil
+}
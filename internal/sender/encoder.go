@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"sync"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// maxPooledBuffer is the largest buffer kept for reuse.  Larger buffers are
+// dropped so an occasional large message doesn't pin memory in the pool.
+const maxPooledBuffer = 64 * 1024
+
+// encoder is a reusable encode buffer and the encoder that writes to it.
+type encoder struct {
+	buf    []byte
+	enc    wrp.Encoder
+	format wrp.Format
+
+	// policy is the QOS policy of the encoded message.
+	policy QOSPolicy
+
+	// idempotent is true if the encoded message can be retried.
+	idempotent bool
+}
+
+var encoders sync.Pool
+
+// getEncoder returns an empty encoder for the format, reusing a pooled one if
+// possible.  Call putEncoder once the buffer is no longer used.
+func getEncoder(f wrp.Format) *encoder {
+	e, _ := encoders.Get().(*encoder)
+	if e == nil || e.format != f {
+		e = &encoder{
+			format: f,
+		}
+		e.enc = wrp.NewEncoderBytes(&e.buf, f)
+	}
+
+	e.buf = e.buf[:0]
+	e.enc.ResetBytes(&e.buf)
+	return e
+}
+
+// encode encodes the message into the buffer.
+func (e *encoder) encode(msg wrp.Message) error {
+	return e.enc.Encode(msg)
+}
+
+// putEncoder returns the encoder to the pool.  The buffer must not be used
+// afterwards.
+func putEncoder(e *encoder) {
+	if cap(e.buf) > maxPooledBuffer {
+		return
+	}
+	encoders.Put(e)
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestEncoder(t *testing.T) {
+	msgs := []wrp.Message{
+		{
+			Type:    wrp.SimpleEventMessageType,
+			Payload: []byte("a much longer payload that grows the buffer"),
+		}, {
+			Type:    wrp.SimpleEventMessageType,
+			Payload: []byte("short"),
+		},
+	}
+
+	// Alternate formats so pooled encoders are both reused and replaced.
+	for _, f := range []wrp.Format{wrp.Msgpack, wrp.Msgpack, wrp.JSON, wrp.Msgpack} {
+		for _, msg := range msgs {
+			e := getEncoder(f)
+			require.NoError(t, e.encode(msg))
+
+			var got wrp.Message
+			require.NoError(t, wrp.NewDecoderBytes(e.buf, f).Decode(&got))
+			assert.Equal(t, msg.Payload, got.Payload)
+
+			putEncoder(e)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+// Error is a failure to send to a remote service.  It carries the URL of the
+// service, and matches the reason, such as ErrConnClosed or ErrFailedToSend,
+// using errors.Is.
+type Error struct {
+	// URL is the URL of the remote service.
+	URL string
+
+	// Err is the reason for the failure.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.URL + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrapErr adds the Sender's URL to the error.
+func (s *Sender) wrapErr(err error) error {
+	return &Error{
+		URL: s.url,
+		Err: err,
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestError(t *testing.T) {
+	const url = "tcp://127.0.0.1:0"
+	sendErr := errors.New("send error")
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		sock      mangos.Socket
+		expectErr []error
+	}{
+		{
+			name:      "connection closed",
+			expectErr: []error{ErrConnClosed},
+		}, {
+			name:      "connection closed with a send buffer",
+			opts:      []Option{WithSendBuffer(1)},
+			expectErr: []error{ErrConnClosed},
+		}, {
+			name:      "send failed",
+			sock:      &mockSocket{sendRv: sendErr},
+			expectErr: []error{ErrFailedToSend, sendErr},
+		}, {
+			name:      "dropped",
+			opts:      []Option{WithDropOnFull()},
+			sock:      &mockSocket{sendRv: mangos.ErrSendTimeout},
+			expectErr: []error{ErrDropped, mangos.ErrSendTimeout},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var closeErr error
+			s, err := New(append([]Option{
+				WithURL(url),
+				WithCloseListener(func(err error) {
+					closeErr = err
+				}),
+			}, tt.opts...)...)
+			require.NoError(t, err)
+			if tt.sock != nil {
+				s.sock = tt.sock
+			}
+
+			err = s.ProcessWRP(context.Background(), wrp.Message{
+				Type: wrp.SimpleEventMessageType,
+			})
+			for _, want := range tt.expectErr {
+				assert.ErrorIs(t, err, want)
+			}
+
+			var se *Error
+			require.ErrorAs(t, err, &se)
+			assert.Equal(t, url, se.URL)
+			assert.Contains(t, err.Error(), url)
+
+			// The close listeners get the same error.
+			if errors.Is(err, ErrFailedToSend) {
+				assert.Equal(t, err, closeErr)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ type mockListener struct {
 	url      string
 	deadline time.Duration
 	sock     protocol.Socket
+	attached chan struct{}
 }
 
 func (m *mockListener) Listen() error {
@@ -44,6 +45,16 @@ func (m *mockListener) Listen() error {
 		url = m.url
 	}
 
+	m.attached = make(chan struct{}, 1)
+	sock.SetPipeEventHook(func(ev mangos.PipeEvent, _ mangos.Pipe) {
+		if ev == mangos.PipeEventAttached {
+			select {
+			case m.attached <- struct{}{}:
+			default:
+			}
+		}
+	})
+
 	if err = sock.Listen(url); err != nil {
 		return err
 	}
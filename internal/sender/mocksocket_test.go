@@ -7,6 +7,10 @@ import "go.nanomsg.org/mangos/v3"
 
 type mockSocket struct {
 	sendRv error
+	// sendErrs are returned by the first sends, in order, before sendRv.
+	sendErrs []error
+	onSend   func([]byte)
+	onClose  func()
 }
 
 var _ mangos.Socket = (*mockSocket)(nil)
@@ -16,10 +20,21 @@ func (m *mockSocket) Info() mangos.ProtocolInfo {
 }
 
 func (m *mockSocket) Close() error {
+	if m.onClose != nil {
+		m.onClose()
+	}
 	return nil
 }
 
-func (m *mockSocket) Send([]byte) error {
+func (m *mockSocket) Send(buf []byte) error {
+	if m.onSend != nil {
+		m.onSend(buf)
+	}
+	if len(m.sendErrs) > 0 {
+		err := m.sendErrs[0]
+		m.sendErrs = m.sendErrs[1:]
+		return err
+	}
 	return m.sendRv
 }
 
@@ -4,8 +4,14 @@
 package sender
 
 import (
+	"compress/gzip"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
 )
 
 type Option interface {
@@ -41,6 +47,247 @@ func WithSendTimeout(timeout time.Duration) Option {
 	})
 }
 
+// WithCloseTimeout bounds how long Close waits for the connection to close,
+// including any send still in progress.  After the timeout, Close returns
+// ErrCloseTimeout and the connection is left to close in the background.  The
+// default of zero waits as long as it takes.  A negative timeout is an error.
+func WithCloseTimeout(timeout time.Duration) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if timeout < 0 {
+			return errors.New("close timeout must not be negative")
+		}
+
+		c.closeTimeout = timeout
+		return nil
+	})
+}
+
+// WithFormat sets the format used to encode messages.  The default is msgpack.
+func WithFormat(f wrp.Format) Option {
+	return optionFunc(func(c *Sender) {
+		c.format = f
+	})
+}
+
+// WithCompression makes the Sender gzip each encoded message using the
+// compression level, such as gzip.DefaultCompression or gzip.BestSpeed.
+// Messages that don't get smaller are sent uncompressed.  The remote service
+// must use decompression to read the compressed messages.  By default,
+// messages are not compressed.
+func WithCompression(level int) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return fmt.Errorf("invalid compression level: %d", level)
+		}
+
+		c.compress = true
+		c.compressLevel = level
+		return nil
+	})
+}
+
+// WithReqRep makes the Sender use a req socket instead of a push socket.  The
+// remote service must use a rep socket and reply to each message.  Each send
+// then waits for the reply, which is available using Sender.Request.  The send
+// timeout also bounds how long the reply is waited for.
+func WithReqRep() Option {
+	return optionFunc(func(c *Sender) {
+		c.protocol = ProtocolReq
+	})
+}
+
+// WithProtocol sets the protocol the Sender connects with.  The write queue
+// length and send timeout apply to ProtocolPush.  ProtocolPub uses the write
+// queue length for each subscriber, but never waits to send.  ProtocolReq is
+// the same as WithReqRep.  The default is ProtocolPush.
+func WithProtocol(p Protocol) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if p < ProtocolPush || p > ProtocolReq {
+			return fmt.Errorf("invalid protocol: %d", p)
+		}
+
+		c.protocol = p
+		return nil
+	})
+}
+
+// WithDropOnFull makes the Sender drop a message instead of closing the
+// connection when the send times out because the queue is full.  The send
+// fails with ErrDropped and the connection stays open.  This suits lossy event
+// streams.  By default, a send timeout closes the connection the same as any
+// other send failure.
+func WithDropOnFull() Option {
+	return optionFunc(func(c *Sender) {
+		c.dropOnFull = true
+	})
+}
+
+// WithSendBuffer puts a queue holding up to n messages in front of the
+// connection, so bursts don't fail while the connection's own short queue is
+// full.  ProcessWRP returns once the message is queued, and a worker sends the
+// queued messages in order.  Send failures are handled the same as without the
+// buffer, and are reported by Health.  When the queue is full, ProcessWRP waits
+// for room until its context is done, or if WithDropOnFull is used, fails right
+// away with ErrDropped.  Messages still queued when the Sender is closed are
+// discarded.  The buffer can't be used with WithReqRep.  The default of zero
+// means no buffer.
+func WithSendBuffer(n int) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if n < 0 {
+			return errors.New("send buffer size must not be negative")
+		}
+
+		c.buffer = nil
+		if n > 0 {
+			c.buffer = make(chan *encoder, n)
+		}
+		return nil
+	})
+}
+
+// WithOrderedSends makes the Sender write messages in the order ProcessWRP was
+// called, even when callers are concurrent or give up before their send is
+// done.  Each send waits for the one before it to finish, so a dead connection
+// is still detected by the send that fails.  A buffered Sender already sends in
+// order, so the option has no effect with WithSendBuffer.
+func WithOrderedSends() Option {
+	return optionFunc(func(c *Sender) {
+		c.order = make(chan struct{}, 1)
+	})
+}
+
+// WithQOSPolicy sets how messages with the QOS level are sent.  It can be used
+// once for each level.  By default, messages are sent the same regardless of
+// their QOS value.
+func WithQOSPolicy(level wrp.QOSLevel, p QOSPolicy) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if level < wrp.QOSLow || level > wrp.QOSCritical {
+			return fmt.Errorf("invalid QOS level: %d", level)
+		}
+
+		if p.Retries < 0 {
+			return errors.New("QOS retries must not be negative")
+		}
+
+		c.qos[level] = p
+		return nil
+	})
+}
+
+// WithWriteQueueLen sets the length of the socket's write queue, in messages.
+// The default of 1 means a send only succeeds once the previous message was
+// handed to the network, so a dead connection is detected by the next send.
+//
+// A longer queue absorbs bursts, but sends succeed while the queue has room
+// even if the connection is dead.  A failure is only detected once the queue
+// is full, and the queued messages are lost when the connection is closed.
+// Values above 1 trade prompt failure detection for buffering; WithSendBuffer
+// buffers bursts without this tradeoff.
+//
+// The length must be at least 1.  It can't be used with WithReqRep.
+func WithWriteQueueLen(n int) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if n < 1 {
+			return errors.New("write queue length must be at least 1")
+		}
+
+		c.writeQLen = n
+		return nil
+	})
+}
+
+// WithSendBufferSize sets the size of the socket's send buffer, in messages,
+// which mangos calls the write queue.  It is the same as WithWriteQueueLen,
+// with the same tradeoffs, except zero uses the default of 1.  It pairs with
+// the receiver's WithRecvBufferSize.  A negative size is an error.
+func WithSendBufferSize(n int) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if n < 0 {
+			return errors.New("send buffer size must not be negative")
+		}
+
+		c.writeQLen = n
+		return nil
+	})
+}
+
+// WithSendRetries makes the Sender retry a failed send up to count times,
+// waiting backoff before each retry.  Since a failed send closes the
+// connection, each retry dials the remote service again first.  Only messages
+// that are safe to send twice are retried: authorization, simple event,
+// retrieve, service registration and service alive messages.  Other types,
+// such as requests, fail on the first error.  Messages dropped because the
+// queue is full are not retried, and retries stop when the send's context is
+// done or the Sender is closed.  By default, sends are not retried.
+func WithSendRetries(count int, backoff time.Duration) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if count < 0 {
+			return errors.New("send retries must not be negative")
+		}
+
+		if backoff < 0 {
+			return errors.New("send retry backoff must not be negative")
+		}
+
+		c.retries = count
+		c.retryBackoff = backoff
+		return nil
+	})
+}
+
+// WithReconnectInterval sets how long the socket waits before dialing the
+// remote service again after the connection is lost.  The wait starts at
+// initial and doubles after each failed attempt up to maximum.  A maximum of zero
+// means the wait doesn't grow.  By default the wait is 100ms and doesn't grow.
+// This only applies while the Sender is connected; a Sender closed by a send
+// failure or Close is not dialed again by the socket.
+func WithReconnectInterval(initial, maximum time.Duration) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if initial < 0 || maximum < 0 {
+			return errors.New("reconnect intervals must not be negative")
+		}
+
+		c.reconnect = reconnectTimes{initial: initial, max: maximum}
+		return nil
+	})
+}
+
+// WithSocketOption sets a mangos socket option, such as
+// mangos.OptionKeepAlive, on each socket the Sender creates, before it is
+// dialed.  This allows tuning options that don't have their own Option.  The
+// options are set in order after the Sender's own options, so they take
+// precedence.  If the socket rejects the option, dialing fails with the error.
+func WithSocketOption(name string, value any) Option {
+	return errOptionFunc(func(c *Sender) error {
+		if name == "" {
+			return errors.New("socket option name is required")
+		}
+
+		c.sockOpts = append(c.sockOpts, sockutil.Option{Name: name, Value: value})
+		return nil
+	})
+}
+
+// WithTLSConfig sets the TLS config used to dial wss:// URLs, such as the root
+// CAs trusted to verify the remote service.  It is ignored for the other
+// transports.  By default, the system's root CAs are trusted.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return optionFunc(func(c *Sender) {
+		c.tlsConfig = cfg
+	})
+}
+
+// WithAutoRedial makes the Sender attempt to dial the remote service again
+// when a message is sent after the connection was closed, such as after a send
+// failure.  Only a single attempt is made per message; if it fails, the send
+// fails with ErrConnClosed.  A Sender stopped with Close is not dialed again.
+// By default, Dial must be called explicitly.
+func WithAutoRedial() Option {
+	return optionFunc(func(c *Sender) {
+		c.autoRedial = true
+	})
+}
+
 // WithCloseListener sets the function to call when the connection is closed.
 // If cancel is provided, it will be populated with a function that can be used
 // to remove the listener.
@@ -56,6 +303,23 @@ func WithCloseListener(f func(error), cancel ...*func()) Option {
 	})
 }
 
+// WithPipeEventListener adds a listener for changes to the pipes of the
+// connection, such as the remote service accepting or dropping the connection.
+// If cancel is provided, it will be populated with a function that can be used
+// to remove the listener.  The listener is called while the connection is
+// being changed, so it must not block.
+func WithPipeEventListener(f func(PipeEvent), cancel ...*func()) Option {
+	return optionFunc(func(c *Sender) {
+		cancelFn := c.onPipe.Add(f)
+
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
 // -- Only Validators Below ----------------------------------------------------
 func validate() Option {
 	return errOptionFunc(func(c *Sender) error {
@@ -63,6 +327,18 @@ func validate() Option {
 			return errors.New("url is required")
 		}
 
+		if c.format != wrp.Msgpack && c.format != wrp.JSON {
+			return errors.New("unsupported format")
+		}
+
+		if c.protocol == ProtocolReq && c.buffer != nil {
+			return errors.New("a send buffer can't be used with req/rep")
+		}
+
+		if c.protocol == ProtocolReq && c.writeQLen != 0 {
+			return errors.New("a write queue length can't be used with req/rep")
+		}
+
 		return nil
 	})
 }
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestOrderedSends_CallerGivesUp(t *testing.T) {
+	release := make(chan struct{})
+	sock, started, sent := blockedSocket(t, release)
+
+	s := newBuffered(t, WithOrderedSends())
+	s.sock = sock
+	defer s.Close() // nolint:errcheck
+
+	// The first caller gives up while its message is stuck, but the second
+	// message still waits for it.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := sendPayload(ctx, s, "1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "1", <-started)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sendPayload(context.Background(), s, "2")
+	}()
+
+	select {
+	case payload := <-started:
+		assert.Fail(t, "message sent out of order", payload)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"1", "2"}, sent())
+}
+
+func TestOrderedSends_Concurrent(t *testing.T) {
+	const (
+		callers = 8
+		count   = 50
+	)
+
+	var lock sync.Mutex
+	var received []string
+	sock := &mockSocket{
+		onSend: func(buf []byte) {
+			var msg wrp.Message
+			require.NoError(t, wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&msg))
+
+			lock.Lock()
+			received = append(received, string(msg.Payload))
+			lock.Unlock()
+			time.Sleep(10 * time.Microsecond)
+		},
+	}
+
+	s := newBuffered(t, WithOrderedSends())
+	s.sock = sock
+	defer s.Close() // nolint:errcheck
+
+	// Each caller gives up right away, so without ordering its messages could
+	// overtake each other.
+	var wg sync.WaitGroup
+	for c := 0; c < callers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond)
+				err := sendPayload(ctx, s, fmt.Sprintf("%d-%d", c, i))
+				cancel()
+				if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+					assert.NoError(t, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Eventually(t, func() bool {
+		return s.Health().Queued == 0
+	}, 5*time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The messages from each caller arrive in the order they were sent.
+	next := make([]int, callers)
+	for _, payload := range received {
+		var c, i int
+		_, err := fmt.Sscanf(payload, "%d-%d", &c, &i)
+		require.NoError(t, err)
+		assert.Greater(t, i, next[c]-1, payload)
+		next[c] = i + 1
+	}
+}
+
+func TestOrderedSends_DeadConnection(t *testing.T) {
+	sock := &mockSocket{sendRv: errors.New("broken pipe")}
+
+	s := newBuffered(t, WithOrderedSends())
+	s.sock = sock
+	defer s.Close() // nolint:errcheck
+
+	err := sendPayload(context.Background(), s, "1")
+	assert.Error(t, err)
+	assert.False(t, s.IsConnected())
+
+	// The order is released by the failed send.
+	err = sendPayload(context.Background(), s, "2")
+	assert.ErrorIs(t, err, ErrConnClosed)
+}
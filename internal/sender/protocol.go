@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"crypto/tls"
+
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/pub"
+)
+
+// Protocol is the mangos protocol the Sender connects with.
+type Protocol int
+
+const (
+	// ProtocolPush sends each message to a pull socket.  It is the default.
+	ProtocolPush Protocol = iota
+
+	// ProtocolPub publishes each message to the sub sockets it is connected
+	// to.  Each frame is the WRP destination of the message, a single zero
+	// byte, then the encoded message, so subscribers can filter on the
+	// destination.  A subscriber that can't keep up misses messages instead
+	// of slowing the Sender down, so failed deliveries are not detected.
+	ProtocolPub
+
+	// ProtocolReq sends each message as a request to a rep socket and waits
+	// for the reply.  It is the same as WithReqRep.
+	ProtocolReq
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolPush:
+		return "push"
+	case ProtocolPub:
+		return "pub"
+	case ProtocolReq:
+		return "req"
+	default:
+		return "unknown"
+	}
+}
+
+// topicSeparator separates the topic from the encoded message in a pub frame.
+const topicSeparator = 0
+
+// prefixTopic puts the topic and separator in front of the encoded message.
+func (e *encoder) prefixTopic(topic string) {
+	buf := make([]byte, 0, len(topic)+1+len(e.buf))
+	buf = append(buf, topic...)
+	buf = append(buf, topicSeparator)
+	e.buf = append(buf, e.buf...)
+}
+
+// dialNewPubSocket is like dialNewSocket, but creates a pub socket.  A pub
+// socket never blocks sending, so there is no send deadline.  The write queue
+// is kept for each subscriber, and messages are dropped for a subscriber whose
+// queue is full.
+func dialNewPubSocket(url string, qlen int, opts []sockutil.Option, tlsConfig *tls.Config, hook mangos.PipeEventHook) (mangos.Socket, error) {
+	if qlen == 0 {
+		qlen = defaultWriteQLen
+	}
+
+	sock, err := pub.NewSocket()
+	if err != nil {
+		return nil, err
+	}
+	sock.SetPipeEventHook(hook)
+
+	err = sock.SetOption(mangos.OptionWriteQLen, qlen)
+	if err == nil {
+		err = sockutil.ApplyOptions(sock, opts)
+	}
+	if err == nil {
+		err = sockutil.Dial(sock, url, tlsConfig)
+	}
+	if err != nil {
+		_ = sock.Close()
+		return nil, err
+	}
+
+	return sock, nil
+}
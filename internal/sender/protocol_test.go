@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/pull"
+	"go.nanomsg.org/mangos/v3/protocol/sub"
+)
+
+func TestWithProtocol(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		expectName  string
+		expectError bool
+	}{
+		{
+			name:       "default",
+			expectName: "push",
+		}, {
+			name:       "push",
+			opts:       []Option{WithProtocol(ProtocolPush)},
+			expectName: "push",
+		}, {
+			name:       "pub",
+			opts:       []Option{WithProtocol(ProtocolPub), WithWriteQueueLen(16)},
+			expectName: "pub",
+		}, {
+			name:       "req",
+			opts:       []Option{WithProtocol(ProtocolReq)},
+			expectName: "req",
+		}, {
+			name:        "invalid",
+			opts:        []Option{WithProtocol(ProtocolReq + 1)},
+			expectError: true,
+		}, {
+			name:        "req with a write queue length",
+			opts:        []Option{WithProtocol(ProtocolReq), WithWriteQueueLen(16)},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Dialing asynchronously creates the socket even though nothing is
+			// listening.
+			opts := []Option{
+				WithURL("tcp://127.0.0.1:0"),
+				WithSocketOption(mangos.OptionDialAsynch, true),
+			}
+			s, err := New(append(opts, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectName, s.protocol.String())
+
+			sock, err := s.openSocket()
+			require.NoError(t, err)
+			defer sock.Close() // nolint:errcheck
+			assert.Equal(t, tt.expectName, sock.Info().SelfName)
+		})
+	}
+}
+
+func TestProtocolPush(t *testing.T) {
+	url, err := findOpenPort()
+	require.NoError(t, err)
+
+	svc, err := pull.NewSocket()
+	require.NoError(t, err)
+	require.NoError(t, svc.SetOption(mangos.OptionRecvDeadline, 5*time.Second))
+	require.NoError(t, svc.Listen(url))
+	defer svc.Close() // nolint:errcheck
+
+	s, err := New(WithURL(url), WithProtocol(ProtocolPush))
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Destination: "event:device-status/mac:112233445566/online",
+	}
+	require.NoError(t, s.ProcessWRP(context.Background(), msg))
+
+	buf, err := svc.Recv()
+	require.NoError(t, err)
+
+	var got wrp.Message
+	require.NoError(t, wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&got))
+	assert.Equal(t, msg.Destination, got.Destination)
+}
+
+func TestProtocolPub(t *testing.T) {
+	url, err := findOpenPort()
+	require.NoError(t, err)
+
+	// The subscriber only gets the device status events.
+	topic := "event:device-status/"
+	svc, err := sub.NewSocket()
+	require.NoError(t, err)
+	require.NoError(t, svc.SetOption(mangos.OptionSubscribe, []byte(topic)))
+	require.NoError(t, svc.SetOption(mangos.OptionRecvDeadline, 10*time.Millisecond))
+	require.NoError(t, svc.Listen(url))
+	defer svc.Close() // nolint:errcheck
+
+	s, err := New(WithURL(url), WithProtocol(ProtocolPub))
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	status := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Destination: topic + "mac:112233445566/online",
+	}
+	other := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Destination: "event:other/mac:112233445566",
+	}
+
+	// Messages published before the subscriber is connected are dropped, so
+	// publish until one arrives.
+	var buf []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for buf == nil && time.Now().Before(deadline) {
+		require.NoError(t, s.ProcessWRP(context.Background(), status))
+		buf, _ = svc.Recv()
+	}
+	require.NotNil(t, buf, "no message was received")
+
+	// Drop the extra copies published while connecting.
+	for err == nil {
+		_, err = svc.Recv()
+	}
+
+	// The frames are delivered in order, so getting the status event first
+	// shows the other message was filtered out.
+	require.NoError(t, s.ProcessWRP(context.Background(), other))
+	require.NoError(t, s.ProcessWRP(context.Background(), status))
+	require.NoError(t, svc.SetOption(mangos.OptionRecvDeadline, 5*time.Second))
+	buf, err = svc.Recv()
+	require.NoError(t, err)
+
+	topicFrame, encoded, ok := bytes.Cut(buf, []byte{topicSeparator})
+	require.True(t, ok)
+	assert.Equal(t, status.Destination, string(topicFrame))
+
+	var got wrp.Message
+	require.NoError(t, wrp.NewDecoderBytes(encoded, wrp.Msgpack).Decode(&got))
+	assert.Equal(t, status.Destination, got.Destination)
+}
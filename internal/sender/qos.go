@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// QOSPolicy describes how messages with a given QOS level are sent.  The zero
+// value sends the message the same as when no policy is set.
+type QOSPolicy struct {
+	// Retries is the number of times a send that timed out because the queue
+	// is full is tried again.  Each attempt waits up to the send timeout, so
+	// retries extend how long the message may wait to be sent.
+	Retries int
+
+	// Drop makes a message that can't be queued be dropped with ErrDropped
+	// instead of closing the connection, the same as WithDropOnFull but only
+	// for this QOS level.
+	Drop bool
+}
+
+// policy returns the QOS policy for the message's QOS value.
+func (s *Sender) policy(qos wrp.QOSValue) QOSPolicy {
+	return s.qos[qos.Level()]
+}
+
+// drops reports if a message sent using the policy is dropped when the queue
+// is full.
+func (s *Sender) drops(p QOSPolicy) bool {
+	return s.dropOnFull || p.Drop
+}
+
+// retry calls send, trying again up to the policy's number of retries while
+// the send times out and the context isn't done.
+func retry(ctx context.Context, p QOSPolicy, send func() error) error {
+	err := send()
+	for i := 0; i < p.Retries && errors.Is(err, mangos.ErrSendTimeout); i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		err = send()
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func qosOptions() []Option {
+	return []Option{
+		WithQOSPolicy(wrp.QOSLow, QOSPolicy{Drop: true}),
+		WithQOSPolicy(wrp.QOSHigh, QOSPolicy{Retries: 1}),
+		WithQOSPolicy(wrp.QOSCritical, QOSPolicy{Retries: 3}),
+	}
+}
+
+func TestQOS(t *testing.T) {
+	sendErr := errors.New("send error")
+	timeouts := func(n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = mangos.ErrSendTimeout
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name        string
+		qos         wrp.QOSValue
+		sendErrs    []error
+		expectErr   error
+		expectSends int
+		expectClose bool
+	}{
+		{
+			name:        "low is sent",
+			qos:         wrp.QOSLowValue,
+			expectSends: 1,
+		}, {
+			name:        "low is dropped when the queue is full",
+			qos:         wrp.QOSLowValue,
+			sendErrs:    timeouts(1),
+			expectErr:   ErrDropped,
+			expectSends: 1,
+		}, {
+			name:        "low still closes on other failures",
+			qos:         wrp.QOSLowValue,
+			sendErrs:    []error{sendErr},
+			expectErr:   sendErr,
+			expectSends: 1,
+			expectClose: true,
+		}, {
+			name:        "medium uses the default behavior",
+			qos:         wrp.QOSMediumValue,
+			sendErrs:    timeouts(1),
+			expectErr:   mangos.ErrSendTimeout,
+			expectSends: 1,
+			expectClose: true,
+		}, {
+			name:        "high is retried",
+			qos:         wrp.QOSHighValue,
+			sendErrs:    timeouts(1),
+			expectSends: 2,
+		}, {
+			name:        "high runs out of retries",
+			qos:         wrp.QOSHighValue,
+			sendErrs:    timeouts(2),
+			expectErr:   mangos.ErrSendTimeout,
+			expectSends: 2,
+			expectClose: true,
+		}, {
+			name:        "critical is retried more",
+			qos:         wrp.QOSCriticalValue,
+			sendErrs:    timeouts(3),
+			expectSends: 4,
+		}, {
+			name:        "values above the range are critical",
+			qos:         wrp.QOSValue(250),
+			sendErrs:    timeouts(3),
+			expectSends: 4,
+		}, {
+			name:        "critical doesn't retry other failures",
+			qos:         wrp.QOSCriticalValue,
+			sendErrs:    []error{sendErr},
+			expectErr:   sendErr,
+			expectSends: 1,
+			expectClose: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var closed []error
+			opts := append([]Option{
+				WithURL("tcp://127.0.0.1:0"),
+				WithCloseListener(func(err error) {
+					closed = append(closed, err)
+				}),
+			}, qosOptions()...)
+
+			s, err := New(opts...)
+			require.NoError(t, err)
+
+			var sends int
+			s.sock = &mockSocket{
+				sendErrs: tt.sendErrs,
+				onSend: func([]byte) {
+					sends++
+				},
+			}
+
+			err = s.ProcessWRP(context.Background(), wrp.Message{
+				Type:             wrp.SimpleEventMessageType,
+				QualityOfService: tt.qos,
+			})
+			if tt.expectErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectErr)
+			}
+			assert.Equal(t, tt.expectSends, sends)
+
+			if tt.expectClose {
+				assert.Nil(t, s.sock)
+				assert.Len(t, closed, 1)
+				return
+			}
+			assert.NotNil(t, s.sock)
+			assert.Empty(t, closed)
+		})
+	}
+}
+
+func TestQOS_SendBuffer(t *testing.T) {
+	release := make(chan struct{})
+	sock, started, _ := blockedSocket(t, release)
+
+	s := newBuffered(t, append(qosOptions(), WithSendBuffer(1))...)
+	s.sock = sock
+	defer s.Close() // nolint:errcheck
+	defer close(release)
+
+	// Fill the worker and the buffer.
+	require.NoError(t, sendPayload(context.Background(), s, "1"))
+	<-started
+	require.NoError(t, sendPayload(context.Background(), s, "2"))
+
+	// Low QOS messages are dropped right away.
+	err := s.ProcessWRP(context.Background(), wrp.Message{
+		Type:             wrp.SimpleEventMessageType,
+		QualityOfService: wrp.QOSLowValue,
+	})
+	assert.ErrorIs(t, err, ErrDropped)
+
+	// Others wait for room.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.ProcessWRP(ctx, wrp.Message{
+		Type:             wrp.SimpleEventMessageType,
+		QualityOfService: wrp.QOSCriticalValue,
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRetry_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls int
+	err := retry(ctx, QOSPolicy{Retries: 5}, func() error {
+		calls++
+		return mangos.ErrSendTimeout
+	})
+	assert.ErrorIs(t, err, mangos.ErrSendTimeout)
+	assert.Equal(t, 1, calls)
+}
+
+func TestWithQOSPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       wrp.QOSLevel
+		policy      QOSPolicy
+		expectError bool
+	}{
+		{
+			name:   "valid",
+			level:  wrp.QOSHigh,
+			policy: QOSPolicy{Retries: 2},
+		}, {
+			name:        "level too low",
+			level:       wrp.QOSLow - 1,
+			expectError: true,
+		}, {
+			name:        "level too high",
+			level:       wrp.QOSCritical + 1,
+			expectError: true,
+		}, {
+			name:        "negative retries",
+			level:       wrp.QOSHigh,
+			policy:      QOSPolicy{Retries: -1},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(
+				WithURL("tcp://127.0.0.1:0"),
+				WithQOSPolicy(tt.level, tt.policy),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.policy, s.qos[tt.level])
+		})
+	}
+}
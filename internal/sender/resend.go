@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// idempotent reports if a message of the type can be sent again without harm,
+// so a failed send can be retried.  Requests and the create, update and delete
+// operations change state or expect a single reply, so they are never retried.
+func idempotent(t wrp.MessageType) bool {
+	switch t {
+	case wrp.AuthorizationMessageType,
+		wrp.SimpleEventMessageType,
+		wrp.RetrieveMessageType,
+		wrp.ServiceRegistrationMessageType,
+		wrp.ServiceAliveMessageType:
+		return true
+	}
+
+	return false
+}
+
+// sendWithRetries sends the encoded message, and if the send fails and the
+// message is idempotent, dials again and resends it up to the configured
+// number of retries.  Dropped messages are not retried, and retries stop when
+// the context is done or the Sender was closed.
+func (s *Sender) sendWithRetries(ctx context.Context, sock mangos.Socket, e *encoder) ([]byte, error) {
+	reply, err := s.send(ctx, sock, e)
+	if !e.idempotent {
+		return reply, err
+	}
+
+	for i := 0; i < s.retries && err != nil && !errors.Is(err, ErrDropped); i++ {
+		if !sleep(ctx, s.retryBackoff) {
+			break
+		}
+
+		if sock = s.redial(); sock == nil {
+			break
+		}
+
+		reply, err = s.send(ctx, sock, e)
+	}
+
+	return reply, err
+}
+
+// redial returns the Sender's connection, dialing again if the connection was
+// closed because a send failed.  If the Sender was closed using Close, or the
+// dial fails, nil is returned.
+func (s *Sender) redial() mangos.Socket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	_ = s.dial()
+	return s.sock
+}
+
+// sleep waits for the duration, and returns false if the context was done
+// first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
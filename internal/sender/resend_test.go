@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestSendRetries(t *testing.T) {
+	sendErr := errors.New("send error")
+	failures := func(n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = sendErr
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		msgType     wrp.MessageType
+		sendErrs    []error
+		expectErr   error
+		expectSends int
+		expectDials int
+	}{
+		{
+			name:        "no retries by default",
+			msgType:     wrp.SimpleEventMessageType,
+			sendErrs:    failures(1),
+			expectErr:   sendErr,
+			expectSends: 1,
+			expectDials: 1,
+		}, {
+			name:        "succeeds without retrying",
+			opts:        []Option{WithSendRetries(3, 0)},
+			msgType:     wrp.SimpleEventMessageType,
+			expectSends: 1,
+			expectDials: 1,
+		}, {
+			name:        "event succeeds after retries",
+			opts:        []Option{WithSendRetries(3, time.Millisecond)},
+			msgType:     wrp.SimpleEventMessageType,
+			sendErrs:    failures(2),
+			expectSends: 3,
+			expectDials: 3,
+		}, {
+			name:        "service alive succeeds after retries",
+			opts:        []Option{WithSendRetries(1, 0)},
+			msgType:     wrp.ServiceAliveMessageType,
+			sendErrs:    failures(1),
+			expectSends: 2,
+			expectDials: 2,
+		}, {
+			name:        "retries run out",
+			opts:        []Option{WithSendRetries(2, 0)},
+			msgType:     wrp.SimpleEventMessageType,
+			sendErrs:    failures(3),
+			expectErr:   sendErr,
+			expectSends: 3,
+			expectDials: 3,
+		}, {
+			name:        "requests are not retried",
+			opts:        []Option{WithSendRetries(3, 0)},
+			msgType:     wrp.SimpleRequestResponseMessageType,
+			sendErrs:    failures(1),
+			expectErr:   sendErr,
+			expectSends: 1,
+			expectDials: 1,
+		}, {
+			name:        "creates are not retried",
+			opts:        []Option{WithSendRetries(3, 0)},
+			msgType:     wrp.CreateMessageType,
+			sendErrs:    failures(1),
+			expectErr:   sendErr,
+			expectSends: 1,
+			expectDials: 1,
+		}, {
+			name:        "dropped messages are not retried",
+			opts:        []Option{WithSendRetries(3, 0), WithDropOnFull()},
+			msgType:     wrp.SimpleEventMessageType,
+			sendErrs:    []error{mangos.ErrSendTimeout},
+			expectErr:   ErrDropped,
+			expectSends: 1,
+			expectDials: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sends, dials int
+			sock := &mockSocket{
+				sendErrs: tt.sendErrs,
+				onSend: func([]byte) {
+					sends++
+				},
+			}
+
+			s, err := New(append([]Option{WithURL("tcp://127.0.0.1:0")}, tt.opts...)...)
+			require.NoError(t, err)
+			s.newSocket = func(string, time.Duration) (mangos.Socket, error) {
+				dials++
+				return sock, nil
+			}
+			require.NoError(t, s.Dial())
+
+			err = s.ProcessWRP(context.Background(), wrp.Message{Type: tt.msgType})
+			if tt.expectErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectErr)
+			}
+			assert.Equal(t, tt.expectSends, sends)
+			assert.Equal(t, tt.expectDials, dials)
+		})
+	}
+}
+
+func TestSendRetries_Closed(t *testing.T) {
+	s, err := New(
+		WithURL("tcp://127.0.0.1:0"),
+		WithSendRetries(5, 10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	var dials int
+	s.newSocket = func(string, time.Duration) (mangos.Socket, error) {
+		dials++
+		return &mockSocket{
+			sendRv: errors.New("send error"),
+			onSend: func([]byte) {
+				// Close the Sender while the send fails.
+				_ = s.Close()
+			},
+		}, nil
+	}
+	require.NoError(t, s.Dial())
+
+	err = s.ProcessWRP(context.Background(), wrp.Message{
+		Type: wrp.SimpleEventMessageType,
+	})
+	assert.Error(t, err)
+
+	// The closed Sender isn't dialed again.
+	assert.Equal(t, 1, dials)
+	assert.False(t, s.IsConnected())
+}
+
+func TestSendRetries_ContextDone(t *testing.T) {
+	s, err := New(
+		WithURL("tcp://127.0.0.1:0"),
+		WithSendRetries(5, time.Hour),
+	)
+	require.NoError(t, err)
+	s.sock = &mockSocket{sendRv: errors.New("send error")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = s.ProcessWRP(ctx, wrp.Message{
+		Type: wrp.SimpleEventMessageType,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestWithSendRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		count       int
+		backoff     time.Duration
+		expectError bool
+	}{
+		{
+			name:    "valid",
+			count:   3,
+			backoff: time.Second,
+		}, {
+			name:        "negative count",
+			count:       -1,
+			expectError: true,
+		}, {
+			name:        "negative backoff",
+			count:       3,
+			backoff:     -time.Second,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(
+				WithURL("tcp://127.0.0.1:0"),
+				WithSendRetries(tt.count, tt.backoff),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.count, s.retries)
+			assert.Equal(t, tt.backoff, s.retryBackoff)
+		})
+	}
+}
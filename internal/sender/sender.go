@@ -5,23 +5,27 @@ package sender
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xmidt-org/eventor"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
 	"go.nanomsg.org/mangos/v3"
 	"go.nanomsg.org/mangos/v3/protocol"
 	"go.nanomsg.org/mangos/v3/protocol/push"
-
-	// register transports
-	_ "go.nanomsg.org/mangos/v3/transport/tcp"
+	"go.nanomsg.org/mangos/v3/protocol/req"
 )
 
 var (
 	ErrConnClosed   = errors.New("connection closed")
 	ErrFailedToSend = errors.New("failed to send message")
+	ErrNotReqRep    = errors.New("sender is not using req/rep")
+	ErrDropped      = errors.New("message dropped, the send queue is full")
+	ErrCloseTimeout = errors.New("close timed out, the connection was abandoned")
 )
 
 // Sender is a simple connection to an external service.  It is safe for concurrent
@@ -29,9 +33,85 @@ var (
 type Sender struct {
 	url          string
 	onClose      eventor.Eventor[func(error)]
+	onPipe       eventor.Eventor[func(PipeEvent)]
 	lock         sync.Mutex
 	sock         protocol.Socket
 	sendDeadline time.Duration
+	closeTimeout time.Duration
+	writeQLen    int
+	format       wrp.Format
+	protocol     Protocol
+	autoRedial   bool
+	dropOnFull   bool
+	retries      int
+	retryBackoff time.Duration
+	reconnect    reconnectTimes
+	sockOpts     []sockutil.Option
+	tlsConfig    *tls.Config
+
+	// compressLevel is the gzip level used when compress is true.
+	compress      bool
+	compressLevel int
+
+	// closed is true once Close is called, until the Sender is dialed again.
+	closed bool
+
+	// closes counts the calls to Close, so a dial that was started before a
+	// Close can tell that its socket must not be used.
+	closes uint64
+
+	// qos holds the policies set using WithQOSPolicy, indexed by QOS level.
+	qos [wrp.QOSCritical + 1]QOSPolicy
+
+	// buffer holds messages waiting for the worker to send them when the
+	// Sender was created using WithSendBuffer.
+	buffer     chan *encoder
+	stopWorker chan struct{}
+	workerWG   sync.WaitGroup
+
+	// order is held by the send in progress when the Sender was created
+	// using WithOrderedSends.  Waiting senders are let through in the order
+	// they arrived.
+	order chan struct{}
+
+	// flushed is closed when no messages are queued, waking the Flush calls
+	// waiting on it.  It is created by the first Flush that has to wait.
+	flushLock sync.Mutex
+	flushed   chan struct{}
+
+	// newSocket replaces the normal socket creation when set.  It is only
+	// used for testing.
+	newSocket func(url string, deadline time.Duration) (mangos.Socket, error)
+
+	// The health related fields are tracked separately from the socket lock
+	// so a snapshot can be taken while a send is in progress.
+	connected  atomic.Bool
+	queued     atomic.Int64
+	statsLock  sync.Mutex
+	dialed     bool
+	reconnects int
+	lastSend   time.Time
+	lastErr    error
+}
+
+// Health is a snapshot of the state of a Sender.
+type Health struct {
+	// Connected is true if the Sender has a dialed socket.
+	Connected bool
+
+	// LastSend is the time of the last successful send.  It is the zero value
+	// if no message has been sent.
+	LastSend time.Time
+
+	// LastErr is the error from the last send attempt, or nil if it succeeded.
+	LastErr error
+
+	// Queued is the number of messages waiting to be sent.
+	Queued int
+
+	// Reconnects is the number of times the Sender has been dialed after the
+	// first time.
+	Reconnects int
 }
 
 // New creates a new Sender.  The Sender is not connected to the remote service
@@ -58,43 +138,198 @@ func New(opts ...Option) (*Sender, error) {
 }
 
 // Dial connects the Sender to the remote service.  This method is idempotent.
+// It is the same as calling DialContext with context.Background().
 func (s *Sender) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// DialContext connects the Sender to the remote service.  This method is
+// idempotent.  The lock is not held while dialing, so Close and other calls
+// are not blocked by a slow dial.  If the context is canceled before the dial
+// completes, the context error is returned and the socket is closed once the
+// dial finishes.  If Close is called while dialing, the new socket is closed
+// and ErrConnClosed is returned.
+func (s *Sender) DialContext(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	s.lock.Lock()
-	defer s.lock.Unlock()
+	connected := s.sock != nil
+	closes := s.closes
+	s.lock.Unlock()
+
+	if connected {
+		return nil
+	}
+
+	type result struct {
+		sock mangos.Socket
+		err  error
+	}
+	rv := make(chan result, 1)
+
+	go func() {
+		sock, err := s.openSocket()
+		rv <- result{sock: sock, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Don't leak the socket if the dial eventually succeeds.
+		go func() {
+			if r := <-rv; r.sock != nil {
+				_ = r.sock.Close()
+			}
+		}()
+		return ctx.Err()
+	case r := <-rv:
+		if r.err != nil {
+			return r.err
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		// Another dial finished first, so keep that one.
+		if s.sock != nil {
+			_ = r.sock.Close()
+			return nil
+		}
+
+		return s.attach(r.sock, closes)
+	}
+}
 
+// dial connects the Sender to the remote service if it isn't already
+// connected.  The lock must be held.
+func (s *Sender) dial() error {
 	if s.sock != nil {
 		return nil
 	}
 
-	sock, err := dialNewSocket(s.url, s.sendDeadline)
+	sock, err := s.openSocket()
 	if err != nil {
 		return err
 	}
 
+	return s.attach(sock, s.closes)
+}
+
+// openSocket creates a new socket connected to the remote service.  It only
+// uses fields that don't change after New, so the lock isn't needed.
+func (s *Sender) openSocket() (mangos.Socket, error) {
+	if s.newSocket != nil {
+		return s.newSocket(s.url, s.sendDeadline)
+	}
+
+	switch s.protocol {
+	case ProtocolReq:
+		return dialNewReqSocket(s.url, s.sendDeadline, s.socketOptions(), s.tlsConfig, s.pipeEvent)
+	case ProtocolPub:
+		return dialNewPubSocket(s.url, s.writeQLen, s.socketOptions(), s.tlsConfig, s.pipeEvent)
+	default:
+		return dialNewSocket(s.url, s.sendDeadline, s.writeQLen, s.socketOptions(), s.tlsConfig, s.pipeEvent)
+	}
+}
+
+// attach makes the socket the Sender's connection.  The socket was dialed
+// when Close had been called the number of times in closes; if Close has been
+// called since, the socket is closed instead and ErrConnClosed is returned.
+// The lock must be held.
+func (s *Sender) attach(sock mangos.Socket, closes uint64) error {
+	if s.closes != closes {
+		_ = sock.Close()
+		return s.wrapErr(ErrConnClosed)
+	}
+
 	s.sock = sock
+	s.closed = false
+	s.connected.Store(true)
 
+	s.statsLock.Lock()
+	if s.dialed {
+		s.reconnects++
+	}
+	s.dialed = true
+	s.statsLock.Unlock()
 	return nil
 }
 
+// URL returns the URL of the remote service.
+func (s *Sender) URL() string {
+	return s.url
+}
+
+// Format returns the format used to encode messages.
+func (s *Sender) Format() wrp.Format {
+	return s.format
+}
+
+// IsConnected returns true if the Sender currently has an open socket.  It does
+// not wait for a send in progress to finish.
+func (s *Sender) IsConnected() bool {
+	return s.connected.Load()
+}
+
+// Health returns a snapshot of the state of the Sender.
+func (s *Sender) Health() Health {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+
+	return Health{
+		Connected:  s.connected.Load(),
+		LastSend:   s.lastSend,
+		LastErr:    s.lastErr,
+		Queued:     int(s.queued.Load()),
+		Reconnects: s.reconnects,
+	}
+}
+
+// recordSend records the outcome of a send attempt for Health.
+func (s *Sender) recordSend(err error) {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+
+	s.lastErr = err
+	if err == nil {
+		s.lastSend = time.Now()
+	}
+}
+
 // dialNewSocket is a helper function that creates a new socket and connects it
 // to the specified URL.  The deadline parameter is used to set the send timeout
-// for the socket.
-func dialNewSocket(url string, deadline time.Duration) (mangos.Socket, error) {
+// for the socket, and qlen the length of the write queue, where zero means the
+// default of 1.  The other socket options and hook are set before dialing so
+// they apply to the dialer and no pipe events are missed.  The TLS config is
+// only used for wss URLs.
+func dialNewSocket(url string, deadline time.Duration, qlen int, opts []sockutil.Option, tlsConfig *tls.Config, hook mangos.PipeEventHook) (mangos.Socket, error) {
+	if qlen == 0 {
+		qlen = defaultWriteQLen
+	}
+
 	// These checks are extremely defensive, and unless the upstream code changes
 	// the normal flow of execution, they should never happen.
 	sock, err := push.NewSocket()
 	if err == nil {
-		// Set the write queue length to 1.  This is the only way to ensure that
-		// message delivery faiures are detected
-		err = sock.SetOption(mangos.OptionWriteQLen, 1)
+		sock.SetPipeEventHook(hook)
+
+		// The write queue length defaults to 1.  This is the only way to
+		// ensure that message delivery faiures are detected promptly.  A
+		// longer queue (see WithWriteQueueLen) lets sends succeed on a dead
+		// connection until the queue fills.
+		err = sock.SetOption(mangos.OptionWriteQLen, qlen)
 		if err == nil {
 			// Set the send timeout to the configured value.  The other methods of
 			// setting the timeout are not supported by the mangos library
 			err = sock.SetOption(mangos.OptionSendDeadline, deadline)
 			if err == nil {
-				err = sock.Dial(url)
+				err = sockutil.ApplyOptions(sock, opts)
 				if err == nil {
-					return sock, nil
+					err = sockutil.Dial(sock, url, tlsConfig)
+					if err == nil {
+						return sock, nil
+					}
 				}
 			}
 		}
@@ -103,84 +338,353 @@ func dialNewSocket(url string, deadline time.Duration) (mangos.Socket, error) {
 	return nil, err
 }
 
+// dialNewReqSocket is like dialNewSocket, but creates a req socket.  The
+// deadline is used for both sending the request and receiving the reply.
+func dialNewReqSocket(url string, deadline time.Duration, opts []sockutil.Option, tlsConfig *tls.Config, hook mangos.PipeEventHook) (mangos.Socket, error) {
+	sock, err := req.NewSocket()
+	if err == nil {
+		sock.SetPipeEventHook(hook)
+
+		err = sock.SetOption(mangos.OptionSendDeadline, deadline)
+		if err == nil {
+			err = sock.SetOption(mangos.OptionRecvDeadline, deadline)
+			if err == nil {
+				err = sockutil.ApplyOptions(sock, opts)
+				if err == nil {
+					err = sockutil.Dial(sock, url, tlsConfig)
+					if err == nil {
+						return sock, nil
+					}
+				}
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// defaultWriteQLen is the length of the socket's write queue unless
+// WithWriteQueueLen is used.
+const defaultWriteQLen = 1
+
+// Flush waits until every message passed to ProcessWRP, including the
+// messages in the send buffer, has been handed to the connection or has
+// failed, or until the context is done.  It returns as soon as the last
+// message is handed over.  Messages passed to ProcessWRP while Flush waits are
+// also waited for.
+//
+// What has happened to the messages when Flush returns nil depends on the
+// protocol:
+//
+//   - With req/rep, the remote service has replied to every message, so it
+//     has received them.
+//   - With push and pub, the messages are in the socket's write queue, which
+//     the socket writes to the network in the background.  Up to the write
+//     queue length of messages, one by default, plus the one being written
+//     may not have reached the remote service yet, and they are lost if the
+//     connection fails.  Use req/rep when delivery must be confirmed.
+func (s *Sender) Flush(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s.flushLock.Lock()
+	if s.queued.Load() == 0 {
+		s.flushLock.Unlock()
+		return nil
+	}
+	if s.flushed == nil {
+		s.flushed = make(chan struct{})
+	}
+	flushed := s.flushed
+	s.flushLock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-flushed:
+		return nil
+	}
+}
+
+// addQueued adds n to the number of messages waiting to be sent.  When none
+// are left, the Flush calls waiting for them are woken.
+func (s *Sender) addQueued(n int64) {
+	if s.queued.Add(n) != 0 {
+		return
+	}
+
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	if s.flushed != nil {
+		close(s.flushed)
+		s.flushed = nil
+	}
+}
+
 // Close closes the connection to the remote service.  This method is idempotent.
+// If the Sender was created using WithCloseTimeout and closing the connection
+// takes longer, the connection is abandoned and ErrCloseTimeout is returned.
 func (s *Sender) Close() error {
-	var trigger bool
-
 	s.lock.Lock()
-	if s.sock != nil {
-		trigger = true
-		_ = s.sock.Close()
+	s.closed = true
+	s.closes++
+	sock := s.sock
+	if sock != nil {
 		s.sock = nil
+		s.connected.Store(false)
 	}
+	stop := s.stopWorker
+	s.stopWorker = nil
 	s.lock.Unlock()
 
-	if trigger {
+	err := s.shutdown(sock, stop)
+
+	if sock != nil {
 		s.visitOnClose(nil)
 	}
-	return nil
+	return err
+}
+
+// shutdown closes the socket, if there is one, and stops the worker.  The
+// lock must not be held, so a send stuck on the socket can't hold up other
+// callers.  If the close timeout passes first, they are left to finish in the
+// background.
+func (s *Sender) shutdown(sock mangos.Socket, stop chan struct{}) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if sock != nil {
+			_ = sock.Close()
+		}
+		s.stop(stop)
+	}()
+
+	if s.closeTimeout <= 0 {
+		<-done
+		return nil
+	}
+
+	t := time.NewTimer(s.closeTimeout)
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-t.C:
+		return s.wrapErr(ErrCloseTimeout)
+	}
 }
 
 // ProcessWRP sends a WRP message to the remote service.  The context is used to
 // set a timeout for the send operation.  If the context is canceled, the send
 // operation will fail with a context.Canceled error.  If the connection is closed,
 // the send operation will fail with ErrConnClosed.  If the send operation fails
-// for any other reason, the error will be wrapped with ErrFailedToSend.
+// for any other reason, the error will be wrapped with ErrFailedToSend.  These
+// errors are an *Error, which includes the URL of the remote service.
 // ProcessWRP will never return wrp.ErrNotHandled.
+//
+// By default, any send failure, including a send timeout because the queue is
+// full, closes the connection.  If the Sender was created using WithDropOnFull,
+// a send timeout instead drops the message, returns ErrDropped and keeps the
+// connection open.
+//
+// If the Sender was created using WithReqRep, ProcessWRP also waits for the
+// reply from the remote service, but the reply is discarded.
 func (s *Sender) ProcessWRP(ctx context.Context, msg wrp.Message) error {
-	if ctx == nil {
-		ctx = context.Background()
+	_, err := s.roundTrip(ctx, msg)
+	return err
+}
+
+// Request sends a WRP message to the remote service and waits for the reply.
+// The Sender must have been created using WithReqRep, otherwise ErrNotReqRep
+// is returned.  The context is used the same way as for ProcessWRP.  If the
+// reply is not received, the connection is left open.
+func (s *Sender) Request(ctx context.Context, msg wrp.Message) (wrp.Message, error) {
+	if s.protocol != ProtocolReq {
+		return wrp.Message{}, ErrNotReqRep
 	}
 
-	var buf []byte
-	if err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(msg); err != nil {
-		return err
+	buf, err := s.roundTrip(ctx, msg)
+	if err != nil {
+		return wrp.Message{}, err
 	}
 
-	s.lock.Lock()
-	if s.sock == nil {
-		s.lock.Unlock()
-		return ErrConnClosed
+	var reply wrp.Message
+	if err := wrp.NewDecoderBytes(buf, s.format).Decode(&reply); err != nil {
+		return wrp.Message{}, err
 	}
 
-	rv := make(chan error, 1)
+	return reply, nil
+}
 
-	go func() {
-		// Only when we're done sending the message or timing out can we
-		// release the lock.  This may be after ProcessWRP() returns, but that's
-		// correct.
-		err := s.sock.Send(buf)
+// roundTrip sends the message, and if the Sender uses req/rep, waits for the
+// reply.  The reply is nil if the Sender uses push.
+func (s *Sender) roundTrip(ctx context.Context, msg wrp.Message) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-		if err != nil { // This error is not recoverable.  Close the connection.
-			_ = s.sock.Close()
-			s.sock = nil
+	// The buffer is pooled, so it is returned once the send is done with it.
+	e := getEncoder(s.format)
+	err := e.encode(msg)
+	if err == nil && s.compress {
+		err = e.compress(s.compressLevel)
+	}
+	if err == nil && s.protocol == ProtocolPub {
+		e.prefixTopic(msg.Destination)
+	}
+	if err != nil {
+		putEncoder(e)
+		return nil, err
+	}
+	e.policy = s.policy(msg.QualityOfService)
+	e.idempotent = idempotent(msg.Type)
 
-			s.lock.Unlock()
+	if s.buffer != nil {
+		return nil, s.enqueue(ctx, e)
+	}
 
-			s.visitOnClose(errors.Join(err, ErrFailedToSend))
-			rv <- err
-			return
+	if s.order != nil {
+		select {
+		case s.order <- struct{}{}:
+		case <-ctx.Done():
+			putEncoder(e)
+			return nil, ctx.Err()
 		}
+	}
 
-		s.lock.Unlock()
+	s.addQueued(1)
+	sock := s.socket()
+	if sock == nil {
+		s.addQueued(-1)
+		s.unorder()
+		putEncoder(e)
+		return nil, s.wrapErr(ErrConnClosed)
+	}
 
-		if ctx.Err() != nil {
+	type result struct {
+		reply []byte
+		err   error
+	}
+	rv := make(chan result, 1)
+
+	go func() {
+		// The send may finish after roundTrip() returns, but that's correct.
+		// The socket copies the buffer, so it can be reused as soon as the
+		// send returns.
+		reply, err := s.sendWithRetries(ctx, sock, e)
+		putEncoder(e)
+		s.addQueued(-1)
+		s.unorder()
+
+		if err == nil && ctx.Err() != nil {
 			// The context was canceled, but the connection is fine.  Just return
 			// the error, but don't close the connection.
 			err = ctx.Err()
 		}
 
-		rv <- err
+		rv <- result{reply: reply, err: err}
 	}()
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-rv:
-		return err
+		return nil, ctx.Err()
+	case r := <-rv:
+		return r.reply, r.err
+	}
+}
+
+// unorder lets the next ordered send through.  The send in progress holds the
+// order until it is done, even if its caller has already returned.
+func (s *Sender) unorder() {
+	if s.order != nil {
+		<-s.order
 	}
 }
 
+// socket returns the current socket, or nil if there is no connection.  If the
+// connection was closed and the Sender auto redials, a dial is attempted first.
+// The lock is only held long enough to get the socket, so concurrent sends
+// don't serialize behind each other or block Close.
+func (s *Sender) socket() mangos.Socket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sock == nil && s.autoRedial && !s.closed {
+		// Only a single attempt is made, and the error is not interesting
+		// since the connection is still closed.
+		_ = s.dial()
+	}
+
+	return s.sock
+}
+
+// send sends the encoded message using the socket, retrying as allowed by the
+// message's QOS policy, and if the Sender uses req/rep, waits for the reply.
+func (s *Sender) send(ctx context.Context, sock mangos.Socket, e *encoder) ([]byte, error) {
+	if s.protocol != ProtocolReq {
+		err := retry(ctx, e.policy, func() error {
+			return sock.Send(e.buf)
+		})
+		s.recordSend(err)
+		if err != nil {
+			return nil, s.failed(sock, err, e.policy)
+		}
+		return nil, nil
+	}
+
+	// Each request uses its own context so the replies can't be confused with
+	// each other without serializing the requests.
+	c, err := sock.OpenContext()
+	if err == nil {
+		defer c.Close() // nolint:errcheck
+		err = retry(ctx, e.policy, func() error {
+			return c.Send(e.buf)
+		})
+	}
+
+	s.recordSend(err)
+	if err != nil {
+		return nil, s.failed(sock, err, e.policy)
+	}
+
+	return c.Recv()
+}
+
+// failed handles a send failure and returns the error for the caller.  If the
+// message is dropped when the queue is full, a send timeout only drops the
+// message.  Otherwise the connection is considered dead and is closed.
+func (s *Sender) failed(sock mangos.Socket, err error, p QOSPolicy) error {
+	if s.drops(p) && errors.Is(err, mangos.ErrSendTimeout) {
+		return s.wrapErr(errors.Join(ErrDropped, err))
+	}
+
+	err = s.wrapErr(errors.Join(ErrFailedToSend, err))
+	s.disconnect(sock, err)
+	return err
+}
+
+// disconnect closes the socket if it is still the Sender's connection and
+// calls the close listeners.  Concurrent sends that fail on the same socket
+// only call the listeners once.
+func (s *Sender) disconnect(sock mangos.Socket, err error) {
+	s.lock.Lock()
+	if s.sock != sock {
+		s.lock.Unlock()
+		return
+	}
+
+	_ = sock.Close()
+	s.sock = nil
+	s.connected.Store(false)
+	s.lock.Unlock()
+
+	s.visitOnClose(err)
+}
+
 // visitOnClose is a helper function that calls all of the functions registered
 // with the onClose eventor.
 func (s *Sender) visitOnClose(err error) {
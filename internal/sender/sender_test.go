@@ -4,14 +4,23 @@
 package sender
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/wrp-go/v3"
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/pull"
+	"go.nanomsg.org/mangos/v3/protocol/rep"
 )
 
 func TestNewDial(t *testing.T) {
@@ -127,6 +136,66 @@ func TestProcessWRP(t *testing.T) {
 	}
 }
 
+func TestDropOnFull(t *testing.T) {
+	sendErr := errors.New("send error")
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		sendErr     error
+		expectErr   error
+		expectClose bool
+	}{
+		{
+			name:        "By default a full queue closes the connection",
+			sendErr:     mangos.ErrSendTimeout,
+			expectErr:   mangos.ErrSendTimeout,
+			expectClose: true,
+		}, {
+			name:      "A full queue drops the message",
+			opts:      []Option{WithDropOnFull()},
+			sendErr:   mangos.ErrSendTimeout,
+			expectErr: ErrDropped,
+		}, {
+			name:        "Other failures still close the connection",
+			opts:        []Option{WithDropOnFull()},
+			sendErr:     sendErr,
+			expectErr:   sendErr,
+			expectClose: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var closed []error
+			opts := append([]Option{
+				WithURL("tcp://127.0.0.1:0"),
+				WithCloseListener(func(err error) {
+					closed = append(closed, err)
+				}),
+			}, tt.opts...)
+
+			s, err := New(opts...)
+			require.NoError(t, err)
+			s.sock = &mockSocket{sendRv: tt.sendErr}
+
+			err = s.ProcessWRP(context.Background(), wrp.Message{})
+			assert.ErrorIs(t, err, tt.expectErr)
+
+			if tt.expectClose {
+				assert.NotErrorIs(t, err, ErrDropped)
+				assert.Nil(t, s.sock)
+				require.Len(t, closed, 1)
+				assert.ErrorIs(t, closed[0], tt.sendErr)
+				return
+			}
+
+			assert.NotNil(t, s.sock)
+			assert.Empty(t, closed)
+		})
+	}
+}
+
 func TestEnd2End(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -215,3 +284,888 @@ func TestEnd2End(t *testing.T) {
 
 	assert.Equal(1, marker)
 }
+
+func TestRequest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	url, err := findOpenPort()
+	require.NoError(err)
+
+	// The remote service replies to each request with the payload reversed.
+	svc, err := rep.NewSocket()
+	require.NoError(err)
+	require.NoError(svc.SetOption(mangos.OptionRecvDeadline, 100*time.Millisecond))
+	require.NoError(svc.Listen(url))
+	defer svc.Close() // nolint:errcheck
+
+	go func() {
+		for {
+			buf, err := svc.Recv()
+			if err != nil {
+				if errors.Is(err, mangos.ErrRecvTimeout) {
+					continue
+				}
+				return
+			}
+
+			var msg wrp.Message
+			if err := wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&msg); err != nil {
+				return
+			}
+
+			reply := wrp.Message{
+				Type:    wrp.SimpleRequestResponseMessageType,
+				Payload: []byte{msg.Payload[1], msg.Payload[0]},
+			}
+			buf = nil
+			if err := wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(reply); err != nil {
+				return
+			}
+			_ = svc.Send(buf)
+		}
+	}()
+
+	sdr, err := New(
+		WithURL(url),
+		WithReqRep(),
+		WithSendTimeout(5*time.Second),
+	)
+	require.NoError(err)
+	require.NoError(sdr.Dial())
+	defer sdr.Close() // nolint:errcheck
+
+	got, err := sdr.Request(context.Background(), wrp.Message{
+		Type:    wrp.SimpleRequestResponseMessageType,
+		Payload: []byte("ab"),
+	})
+	require.NoError(err)
+	assert.Equal(wrp.SimpleRequestResponseMessageType, got.Type)
+	assert.Equal([]byte("ba"), got.Payload)
+
+	// ProcessWRP waits for the reply as well, but discards it.
+	err = sdr.ProcessWRP(context.Background(), wrp.Message{
+		Type:    wrp.SimpleRequestResponseMessageType,
+		Payload: []byte("cd"),
+	})
+	assert.NoError(err)
+
+	got, err = sdr.Request(context.Background(), wrp.Message{
+		Type:    wrp.SimpleRequestResponseMessageType,
+		Payload: []byte("ef"),
+	})
+	require.NoError(err)
+	assert.Equal([]byte("fe"), got.Payload)
+}
+
+func TestRequest_NotReqRep(t *testing.T) {
+	sdr, err := New(WithURL("tcp://127.0.0.1:0"))
+	require.NoError(t, err)
+
+	_, err = sdr.Request(context.Background(), wrp.Message{})
+	assert.ErrorIs(t, err, ErrNotReqRep)
+}
+
+func TestIsConnected(t *testing.T) {
+	ml := mockListener{}
+	require.NoError(t, ml.Listen())
+	defer ml.Close() // nolint:errcheck
+
+	sdr, err := New(WithURL(ml.url))
+	require.NoError(t, err)
+
+	assert.False(t, sdr.IsConnected(), "before dial")
+
+	require.NoError(t, sdr.Dial())
+	assert.True(t, sdr.IsConnected(), "after dial")
+
+	// Let the listener finish the handshake before anything is closed.
+	select {
+	case <-ml.attached:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the listener never saw the connection")
+	}
+
+	require.NoError(t, sdr.Close())
+	assert.False(t, sdr.IsConnected(), "after close")
+}
+
+func TestAutoRedial(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoRedial bool
+		expectErr  error
+	}{
+		{
+			name:       "Redial after the socket drops",
+			autoRedial: true,
+		}, {
+			name:      "Stay closed by default",
+			expectErr: ErrConnClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socks := []*mockSocket{
+				{sendRv: errors.New("send error")},
+				{},
+			}
+			var dials int
+
+			opts := []Option{WithURL("tcp://127.0.0.1:0")}
+			if tt.autoRedial {
+				opts = append(opts, WithAutoRedial())
+			}
+
+			s, err := New(opts...)
+			require.NoError(t, err)
+
+			s.newSocket = func(string, time.Duration) (mangos.Socket, error) {
+				sock := socks[dials]
+				dials++
+				return sock, nil
+			}
+
+			require.NoError(t, s.Dial())
+
+			// The first send fails and drops the socket.
+			err = s.ProcessWRP(context.Background(), wrp.Message{})
+			require.Error(t, err)
+			assert.False(t, s.IsConnected())
+
+			err = s.ProcessWRP(context.Background(), wrp.Message{})
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				assert.Equal(t, 1, dials)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, 2, dials)
+			assert.True(t, s.IsConnected())
+			assert.Equal(t, 1, s.Health().Reconnects)
+		})
+	}
+}
+
+func TestDialContext_Canceled(t *testing.T) {
+	s, err := New(WithURL("tcp://127.0.0.1:0"))
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	s.newSocket = func(string, time.Duration) (mangos.Socket, error) {
+		<-release
+		return &mockSocket{
+			onClose: func() {
+				close(closed)
+			},
+		}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = s.DialContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, s.IsConnected())
+
+	// Close must not be blocked by the pending dial.
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Close()
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Close was blocked by the dial")
+	}
+
+	// Once the slow dial finishes, the socket is cleaned up.
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the abandoned socket was not closed")
+	}
+	assert.False(t, s.IsConnected())
+}
+
+func TestDialContext_CloseDuringDial(t *testing.T) {
+	s, err := New(WithURL("tcp://127.0.0.1:0"))
+	require.NoError(t, err)
+
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	s.newSocket = func(string, time.Duration) (mangos.Socket, error) {
+		close(dialing)
+		<-release
+		return &mockSocket{
+			onClose: func() {
+				close(closed)
+			},
+		}, nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.DialContext(context.Background())
+	}()
+
+	<-dialing
+	require.NoError(t, s.Close())
+	close(release)
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrConnClosed)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the dial did not finish")
+	}
+
+	// The socket dialed before Close isn't kept.
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the socket was not closed")
+	}
+	assert.False(t, s.IsConnected())
+}
+
+// echoService starts a rep socket that replies to each request with the
+// same message after the delay.  Several requests are handled at once.
+func echoService(t testing.TB, delay time.Duration) string {
+	url, err := findOpenPort()
+	require.NoError(t, err)
+
+	svc, err := rep.NewSocket()
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen(url))
+	t.Cleanup(func() {
+		_ = svc.Close()
+	})
+
+	for i := 0; i < 16; i++ {
+		c, err := svc.OpenContext()
+		require.NoError(t, err)
+
+		go func() {
+			for {
+				buf, err := c.Recv()
+				if err != nil {
+					return
+				}
+				time.Sleep(delay)
+				_ = c.Send(buf)
+			}
+		}()
+	}
+
+	return url
+}
+
+// drainService starts a pull socket that discards everything it receives and
+// returns a counter of the received messages.
+func drainService(t testing.TB) (string, *sync.WaitGroup) {
+	url, err := findOpenPort()
+	require.NoError(t, err)
+
+	svc, err := pull.NewSocket()
+	require.NoError(t, err)
+	require.NoError(t, svc.SetOption(mangos.OptionRecvDeadline, 100*time.Millisecond))
+	require.NoError(t, svc.Listen(url))
+	t.Cleanup(func() {
+		_ = svc.Close()
+	})
+
+	var received sync.WaitGroup
+	go func() {
+		for {
+			_, err := svc.Recv()
+			if err != nil {
+				if errors.Is(err, mangos.ErrRecvTimeout) {
+					continue
+				}
+				return
+			}
+			received.Done()
+		}
+	}()
+
+	return url, &received
+}
+
+func TestProcessWRP_Concurrent(t *testing.T) {
+	const (
+		workers = 8
+		count   = 50
+	)
+
+	url, received := drainService(t)
+	received.Add(workers * count)
+
+	sdr, err := New(WithURL(url), WithSendTimeout(5*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, sdr.Dial())
+	defer sdr.Close() // nolint:errcheck
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				assert.NoError(t, sdr.ProcessWRP(context.Background(), wrp.Message{
+					Type: wrp.SimpleEventMessageType,
+				}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		received.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "not all messages were received")
+	}
+	assert.True(t, sdr.IsConnected())
+	assert.Zero(t, sdr.Health().Queued)
+}
+
+func TestRequest_Concurrent(t *testing.T) {
+	const (
+		workers = 8
+		count   = 20
+	)
+
+	sdr, err := New(
+		WithURL(echoService(t, 0)),
+		WithReqRep(),
+		WithSendTimeout(5*time.Second),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sdr.Dial())
+	defer sdr.Close() // nolint:errcheck
+
+	// Each reply must match its own request, even when the requests overlap.
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				id := fmt.Sprintf("%d-%d", i, j)
+				got, err := sdr.Request(context.Background(), wrp.Message{
+					Type:            wrp.SimpleRequestResponseMessageType,
+					TransactionUUID: id,
+				})
+				if assert.NoError(t, err) {
+					assert.Equal(t, id, got.TransactionUUID)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkProcessWRP_Parallel(b *testing.B) {
+	url, received := drainService(b)
+	received.Add(b.N)
+
+	sdr, err := New(WithURL(url), WithSendTimeout(5*time.Second))
+	require.NoError(b, err)
+	require.NoError(b, sdr.Dial())
+	defer sdr.Close() // nolint:errcheck
+
+	msg := wrp.Message{
+		Type:    wrp.SimpleEventMessageType,
+		Payload: make([]byte, 256),
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := sdr.ProcessWRP(context.Background(), msg); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	received.Wait()
+}
+
+func BenchmarkRequest_Parallel(b *testing.B) {
+	sdr, err := New(
+		WithURL(echoService(b, time.Millisecond)),
+		WithReqRep(),
+		WithSendTimeout(5*time.Second),
+	)
+	require.NoError(b, err)
+	require.NoError(b, sdr.Dial())
+	defer sdr.Close() // nolint:errcheck
+
+	msg := wrp.Message{
+		Type:    wrp.SimpleRequestResponseMessageType,
+		Payload: make([]byte, 256),
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := sdr.Request(context.Background(), msg); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestProcessWRP_ConcurrentBuffers(t *testing.T) {
+	const (
+		workers = 8
+		count   = 100
+	)
+
+	var lock sync.Mutex
+	got := make(map[string]int)
+
+	s, err := New(WithURL("tcp://127.0.0.1:0"))
+	require.NoError(t, err)
+	s.sock = &mockSocket{
+		onSend: func(buf []byte) {
+			// Decode right away since the buffer is reused once Send returns,
+			// the same as it is for a real socket.
+			var msg wrp.Message
+			require.NoError(t, wrp.NewDecoderBytes(buf, wrp.Msgpack).Decode(&msg))
+
+			lock.Lock()
+			got[string(msg.Payload)]++
+			lock.Unlock()
+		},
+	}
+
+	// Payloads of different sizes so a shared buffer would be noticed.
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				payload := strings.Repeat(fmt.Sprintf("%d-%d,", i, j), j%10+1)
+				assert.NoError(t, s.ProcessWRP(context.Background(), wrp.Message{
+					Type:    wrp.SimpleEventMessageType,
+					Payload: []byte(payload),
+				}))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Len(t, got, workers*count)
+	for payload, n := range got {
+		assert.Equal(t, 1, n, payload)
+	}
+}
+
+func BenchmarkProcessWRP(b *testing.B) {
+	s, err := New(WithURL("tcp://127.0.0.1:0"))
+	require.NoError(b, err)
+	s.sock = &mockSocket{}
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/service",
+		Payload:     make([]byte, 1024),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.ProcessWRP(context.Background(), msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteQueueLen(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		expectQLen  int
+		expectError bool
+	}{
+		{
+			name:       "default",
+			expectQLen: 1,
+		}, {
+			name:       "zero uses the default",
+			opts:       []Option{WithSendBufferSize(8), WithSendBufferSize(0)},
+			expectQLen: 1,
+		}, {
+			name:       "larger queue",
+			opts:       []Option{WithSendBufferSize(8)},
+			expectQLen: 8,
+		}, {
+			name:        "negative",
+			opts:        []Option{WithSendBufferSize(-1)},
+			expectError: true,
+		}, {
+			name:        "with req/rep",
+			opts:        []Option{WithReqRep(), WithSendBufferSize(8)},
+			expectError: true,
+		}, {
+			name:       "write queue length",
+			opts:       []Option{WithWriteQueueLen(16)},
+			expectQLen: 16,
+		}, {
+			name:       "write queue length of 1",
+			opts:       []Option{WithWriteQueueLen(16), WithWriteQueueLen(1)},
+			expectQLen: 1,
+		}, {
+			name:        "zero write queue length",
+			opts:        []Option{WithWriteQueueLen(0)},
+			expectError: true,
+		}, {
+			name:        "negative write queue length",
+			opts:        []Option{WithWriteQueueLen(-1)},
+			expectError: true,
+		}, {
+			name:        "write queue length with req/rep",
+			opts:        []Option{WithReqRep(), WithWriteQueueLen(4)},
+			expectError: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://write-queue-len-%d", i)
+
+			s, err := New(append([]Option{WithURL(url)}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+
+			peer, err := pull.NewSocket()
+			require.NoError(t, err)
+			require.NoError(t, peer.Listen(url))
+			defer peer.Close() // nolint:errcheck
+
+			require.NoError(t, s.Dial())
+			defer s.Close() // nolint:errcheck
+
+			qlen, err := s.sock.GetOption(mangos.OptionWriteQLen)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectQLen, qlen)
+		})
+	}
+}
+
+func TestReconnectInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		expectInitial time.Duration
+		expectMax     time.Duration
+		expectError   bool
+	}{
+		{
+			name:          "default",
+			expectInitial: 100 * time.Millisecond,
+		}, {
+			name:          "initial only",
+			opts:          []Option{WithReconnectInterval(time.Second, 0)},
+			expectInitial: time.Second,
+		}, {
+			name:          "initial and max",
+			opts:          []Option{WithReconnectInterval(10*time.Millisecond, time.Second)},
+			expectInitial: 10 * time.Millisecond,
+			expectMax:     time.Second,
+		}, {
+			name:        "negative initial",
+			opts:        []Option{WithReconnectInterval(-1, 0)},
+			expectError: true,
+		}, {
+			name:        "negative max",
+			opts:        []Option{WithReconnectInterval(0, -1)},
+			expectError: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://reconnect-interval-%d", i)
+
+			s, err := New(append([]Option{WithURL(url)}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+
+			peer, err := pull.NewSocket()
+			require.NoError(t, err)
+			require.NoError(t, peer.Listen(url))
+			defer peer.Close() // nolint:errcheck
+
+			require.NoError(t, s.Dial())
+			defer s.Close() // nolint:errcheck
+
+			initial, err := s.sock.GetOption(mangos.OptionReconnectTime)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectInitial, initial)
+
+			maximum, err := s.sock.GetOption(mangos.OptionMaxReconnectTime)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectMax, maximum)
+		})
+	}
+}
+
+func TestWithSocketOption(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		option      string
+		expect      any
+		expectError bool
+		expectDial  error
+	}{
+		{
+			name:   "send deadline",
+			opts:   []Option{WithSocketOption(mangos.OptionSendDeadline, 3*time.Second)},
+			option: mangos.OptionSendDeadline,
+			expect: 3 * time.Second,
+		}, {
+			name: "overrides the sender's own options",
+			opts: []Option{
+				WithSocketOption(mangos.OptionMaxReconnectTime, 2*time.Second),
+				WithReconnectInterval(10*time.Millisecond, time.Second),
+			},
+			option: mangos.OptionMaxReconnectTime,
+			expect: 2 * time.Second,
+		}, {
+			name:        "empty name",
+			opts:        []Option{WithSocketOption("", 1)},
+			expectError: true,
+		}, {
+			name:       "unknown option",
+			opts:       []Option{WithSocketOption("NOT-AN-OPTION", 1)},
+			expectDial: mangos.ErrBadOption,
+		}, {
+			name:       "invalid value",
+			opts:       []Option{WithSocketOption(mangos.OptionSendDeadline, "soon")},
+			expectDial: mangos.ErrBadValue,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://socket-option-%d", i)
+
+			s, err := New(append([]Option{WithURL(url)}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+
+			peer, err := pull.NewSocket()
+			require.NoError(t, err)
+			require.NoError(t, peer.Listen(url))
+			defer peer.Close() // nolint:errcheck
+
+			err = s.Dial()
+			if tt.expectDial != nil {
+				assert.ErrorIs(t, err, tt.expectDial)
+				return
+			}
+			require.NoError(t, err)
+			defer s.Close() // nolint:errcheck
+
+			got, err := s.sock.GetOption(tt.option)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	large := wrp.Message{
+		Type:    wrp.SimpleEventMessageType,
+		Source:  "large",
+		Payload: bytes.Repeat([]byte("compressible payload "), 256),
+	}
+	small := wrp.Message{
+		Type:   wrp.SimpleEventMessageType,
+		Source: "small",
+	}
+
+	tests := []struct {
+		name           string
+		opts           []Option
+		msg            wrp.Message
+		expectCompress bool
+		expectError    bool
+	}{
+		{
+			name: "off by default",
+			msg:  large,
+		}, {
+			name:           "large message",
+			opts:           []Option{WithCompression(gzip.DefaultCompression)},
+			msg:            large,
+			expectCompress: true,
+		}, {
+			name:           "json",
+			opts:           []Option{WithFormat(wrp.JSON), WithCompression(gzip.BestSpeed)},
+			msg:            large,
+			expectCompress: true,
+		}, {
+			name: "small message is sent as is",
+			opts: []Option{WithCompression(gzip.BestCompression)},
+			msg:  small,
+		}, {
+			name:        "invalid level",
+			opts:        []Option{WithCompression(gzip.BestCompression + 1)},
+			expectError: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://compression-%d", i)
+
+			s, err := New(append([]Option{WithURL(url)}, tt.opts...)...)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, s)
+				return
+			}
+			require.NoError(t, err)
+
+			peer, err := pull.NewSocket()
+			require.NoError(t, err)
+			require.NoError(t, peer.Listen(url))
+			defer peer.Close() // nolint:errcheck
+
+			require.NoError(t, s.Dial())
+			defer s.Close() // nolint:errcheck
+
+			require.NoError(t, s.ProcessWRP(context.Background(), tt.msg))
+			got, err := peer.Recv()
+			require.NoError(t, err)
+
+			var plain []byte
+			require.NoError(t, wrp.NewEncoderBytes(&plain, s.format).Encode(tt.msg))
+
+			if !tt.expectCompress {
+				assert.Equal(t, plain, got)
+				return
+			}
+
+			assert.Equal(t, []byte{0x1f, 0x8b}, got[:2])
+			assert.Less(t, len(got), len(plain)/4)
+
+			zr, err := gzip.NewReader(bytes.NewReader(got))
+			require.NoError(t, err)
+			inflated, err := io.ReadAll(zr)
+			require.NoError(t, err)
+			assert.Equal(t, plain, inflated)
+		})
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		expectErr error
+	}{
+		{
+			name:      "abandoned",
+			timeout:   20 * time.Millisecond,
+			expectErr: ErrCloseTimeout,
+		}, {
+			name: "waits without a timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			sock, started, _ := blockedSocket(t, release)
+
+			// The socket only finishes closing once the stuck send is released.
+			closing := make(chan struct{})
+			sock.onClose = func() {
+				close(closing)
+				<-release
+			}
+
+			var closes int
+			s, err := New(
+				WithURL("tcp://127.0.0.1:0"),
+				WithCloseTimeout(tt.timeout),
+				WithCloseListener(func(error) {
+					closes++
+				}),
+			)
+			require.NoError(t, err)
+			s.sock = sock
+
+			sent := make(chan error, 1)
+			go func() {
+				sent <- sendPayload(context.Background(), s, "stuck")
+			}()
+			<-started
+
+			rv := make(chan error, 1)
+			go func() {
+				rv <- s.Close()
+			}()
+
+			if tt.expectErr == nil {
+				<-closing
+				select {
+				case <-rv:
+					require.Fail(t, "Close returned before the connection closed")
+				case <-time.After(20 * time.Millisecond):
+				}
+				close(release)
+			}
+
+			select {
+			case err := <-rv:
+				assert.ErrorIs(t, err, tt.expectErr)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "Close did not return")
+			}
+			assert.False(t, s.IsConnected())
+			assert.Equal(t, 1, closes)
+
+			if tt.expectErr != nil {
+				close(release)
+			}
+			<-sent
+		})
+	}
+}
+
+func TestWithCloseTimeout(t *testing.T) {
+	_, err := New(WithURL("tcp://127.0.0.1:0"), WithCloseTimeout(-time.Second))
+	assert.Error(t, err)
+}
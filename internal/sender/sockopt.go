@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sender
+
+import (
+	"time"
+
+	"github.com/xmidt-org/wrpnng/internal/sockutil"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// reconnectTimes controls how often the socket dials the remote service again
+// after the connection is lost.  Zero values use the mangos defaults.
+type reconnectTimes struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// socketOptions returns the options to set on each new socket.  The options
+// set using WithSocketOption are last so they win.
+func (s *Sender) socketOptions() []sockutil.Option {
+	var opts []sockutil.Option
+	if s.reconnect.initial > 0 {
+		opts = append(opts, sockutil.Option{Name: mangos.OptionReconnectTime, Value: s.reconnect.initial})
+	}
+	if s.reconnect.max > 0 {
+		opts = append(opts, sockutil.Option{Name: mangos.OptionMaxReconnectTime, Value: s.reconnect.max})
+	}
+
+	return append(opts, s.sockOpts...)
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sockutil
+
+import (
+	"github.com/xmidt-org/eventor"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// PipeEventType is the kind of change to a pipe, which is a single transport
+// level connection with a peer.
+type PipeEventType int
+
+const (
+	// PipeAttaching occurs when a peer connects, before the pipe is used.
+	PipeAttaching PipeEventType = iota
+
+	// PipeAttached occurs once the pipe can be used to exchange messages.
+	PipeAttached
+
+	// PipeDetached occurs after the peer disconnected or the pipe was closed.
+	PipeDetached
+)
+
+// PipeEvent describes a change to one of the pipes of a socket.
+type PipeEvent struct {
+	// Type is the kind of change.
+	Type PipeEventType
+
+	// ID identifies the pipe.  It is unique among the open pipes in the
+	// process, but may be reused once the pipe is detached.
+	ID uint32
+
+	// Address is the URL the pipe was dialed or accepted on.
+	Address string
+}
+
+// NotifyPipeEvent translates the mangos pipe event and informs the listeners.
+// Events that don't change the state of a pipe are ignored.
+func NotifyPipeEvent(listeners *eventor.Eventor[func(PipeEvent)], ev mangos.PipeEvent, p mangos.Pipe) {
+	var t PipeEventType
+	switch ev {
+	case mangos.PipeEventAttaching:
+		t = PipeAttaching
+	case mangos.PipeEventAttached:
+		t = PipeAttached
+	case mangos.PipeEventDetached:
+		t = PipeDetached
+	default:
+		return
+	}
+
+	e := PipeEvent{
+		Type:    t,
+		ID:      p.ID(),
+		Address: p.Address(),
+	}
+	listeners.Visit(func(f func(PipeEvent)) {
+		f(e)
+	})
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sockutil
+
+import (
+	"fmt"
+
+	"go.nanomsg.org/mangos/v3"
+)
+
+// Option is a mangos socket option, such as one set using WithSocketOption.
+type Option struct {
+	Name  string
+	Value any
+}
+
+// ApplyOptions sets the options on the socket in order.
+func ApplyOptions(sock mangos.Socket, opts []Option) error {
+	for _, opt := range opts {
+		if err := sock.SetOption(opt.Name, opt.Value); err != nil {
+			return fmt.Errorf("socket option %s: %w", opt.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sockutil
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"go.nanomsg.org/mangos/v3"
+
+	// register transports
+	_ "go.nanomsg.org/mangos/v3/transport/tcp"
+	_ "go.nanomsg.org/mangos/v3/transport/ws"
+	_ "go.nanomsg.org/mangos/v3/transport/wss"
+)
+
+// Dial dials the URL on the socket.  The TLS config is used if the URL is for
+// the wss transport, and ignored otherwise.
+func Dial(sock mangos.Socket, url string, cfg *tls.Config) error {
+	if opts := tlsOptions(url, cfg); opts != nil {
+		return sock.DialOptions(url, opts)
+	}
+	return sock.Dial(url)
+}
+
+// Listen listens on the URL using the socket.  The TLS config is used if the
+// URL is for the wss transport, and ignored otherwise.
+func Listen(sock mangos.Socket, url string, cfg *tls.Config) error {
+	if opts := tlsOptions(url, cfg); opts != nil {
+		return sock.ListenOptions(url, opts)
+	}
+	return sock.Listen(url)
+}
+
+// tlsOptions returns the dialer or listener options carrying the TLS config,
+// or nil if there is no config or the transport doesn't use TLS.  The other
+// transports reject the option, so it is only set for wss.
+func tlsOptions(url string, cfg *tls.Config) map[string]any {
+	if cfg == nil || !strings.HasPrefix(url, "wss://") {
+		return nil
+	}
+	return map[string]any{mangos.OptionTLSConfig: cfg}
+}
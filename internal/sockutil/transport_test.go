@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package sockutil
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestTLSOptions(t *testing.T) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	tests := []struct {
+		name   string
+		url    string
+		cfg    *tls.Config
+		expect map[string]any
+	}{
+		{
+			name:   "wss",
+			url:    "wss://127.0.0.1:8080/wrp",
+			cfg:    cfg,
+			expect: map[string]any{mangos.OptionTLSConfig: cfg},
+		}, {
+			name: "wss without a config",
+			url:  "wss://127.0.0.1:8080/wrp",
+		}, {
+			name: "ws",
+			url:  "ws://127.0.0.1:8080/wrp",
+			cfg:  cfg,
+		}, {
+			name: "tcp",
+			url:  "tcp://127.0.0.1:8080",
+			cfg:  cfg,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, tlsOptions(tt.url, tt.cfg))
+		})
+	}
+}
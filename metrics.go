@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+// Metrics receives measurements from the Server.  A Metrics is typically a
+// small adapter around the instruments of a metrics library, such as a
+// Prometheus gauge.  The methods are called while the Server holds internal
+// locks, so they must be quick and must not call back into the Server.
+type Metrics interface {
+	// SetSenderCount is called with the number of registered services each
+	// time a service is added or removed, including when a sender closes on
+	// its own.
+	SetSenderCount(n int)
+}
+
+// WithMetrics sets the Metrics that are informed of the Server's state.  The
+// current values are reported right away.  By default, nothing is reported.
+func WithMetrics(m Metrics) ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.metrics = m
+		srv.senders.reportCount()
+	})}
+}
+
+// reportCount informs the metrics of the number of senders.  The lock must be
+// held.
+func (sm *senderMap) reportCount() {
+	if sm.metrics != nil {
+		sm.metrics.SetSenderCount(len(sm.senders))
+	}
+}
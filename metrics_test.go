@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+)
+
+// gauge records the sender counts it is set to.
+type gauge struct {
+	lock   sync.Mutex
+	counts []int
+}
+
+func (g *gauge) SetSenderCount(n int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.counts = append(g.counts, n)
+}
+
+func (g *gauge) last() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.counts[len(g.counts)-1]
+}
+
+func TestWithMetrics_SenderCount(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	g := &gauge{}
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithMetrics(g),
+	)
+	require.NoError(t, err)
+
+	// The starting count is reported.
+	assert.Equal(t, 0, g.last())
+
+	for _, name := range []string{"a", "b", "c"} {
+		_, err = srv.senders.Upsert(context.Background(), name, []sender.Option{sender.WithURL(url)})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, g.last())
+
+	// Replacing a sender doesn't change the count.
+	replaced, err := srv.senders.Upsert(context.Background(), "c", []sender.Option{
+		sender.WithURL(url),
+		sender.WithFormat(wrp.JSON),
+	})
+	require.NoError(t, err)
+	assert.True(t, replaced)
+	assert.Equal(t, 3, g.last())
+
+	require.NoError(t, srv.senders.Remove("a"))
+	assert.Equal(t, 2, g.last())
+
+	// A sender that closes on its own is removed by its close listener.
+	srv.senders.lock.RLock()
+	b := srv.senders.senders["b"]
+	srv.senders.lock.RUnlock()
+	require.NoError(t, b.Close())
+	assert.Eventually(t, func() bool {
+		return g.last() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, srv.Stop())
+	assert.Equal(t, 0, g.last())
+}
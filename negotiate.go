@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// FormatsMetadataKey is the registration message metadata key a service uses
+// to advertise the WRP formats it supports.  The value is a comma separated
+// list of content types (see wrp.Format.ContentType) in the order the service
+// prefers them.  A service that does not advertise any formats is assumed to
+// only support msgpack.  A Client advertises the formats set using
+// WithClientFormats.
+const FormatsMetadataKey = "wrpnng-formats"
+
+var (
+	errNoCommonFormat = errors.New("no common format")
+)
+
+// advertisedFormats returns the formats advertised in the registration
+// message.  Unknown content types are ignored.
+func advertisedFormats(msg wrp.Message) []wrp.Format {
+	val, ok := msg.Metadata[FormatsMetadataKey]
+	if !ok {
+		return []wrp.Format{wrp.Msgpack}
+	}
+
+	var formats []wrp.Format
+	for _, ct := range strings.Split(val, ",") {
+		f, err := wrp.FormatFromContentType(strings.TrimSpace(ct))
+		if err == nil {
+			formats = append(formats, f)
+		}
+	}
+
+	return formats
+}
+
+// encodableFormats are the formats a sender can encode messages using.
+var encodableFormats = []wrp.Format{wrp.Msgpack, wrp.JSON}
+
+// serviceFormat returns the format used to send messages to the service that
+// sent the registration message.  If the Server has preferred formats, they
+// are negotiated with the formats the service advertises.  Otherwise the
+// service's own preference is used.
+func (srv *Server) serviceFormat(msg wrp.Message) (wrp.Format, error) {
+	if len(srv.formats) > 0 {
+		return negotiateFormat(srv.formats, advertisedFormats(msg))
+	}
+	return negotiateFormat(advertisedFormats(msg), encodableFormats)
+}
+
+// negotiateFormat returns the first of the preferred formats that is also
+// supported by the peer.  If there is no common format, errNoCommonFormat is
+// returned.
+func negotiateFormat(preferred, supported []wrp.Format) (wrp.Format, error) {
+	for _, p := range preferred {
+		for _, s := range supported {
+			if p == s {
+				return p, nil
+			}
+		}
+	}
+
+	return wrp.Msgpack, errNoCommonFormat
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestAdvertisedFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		expect   []wrp.Format
+	}{
+		{
+			name:   "Not advertised",
+			expect: []wrp.Format{wrp.Msgpack},
+		}, {
+			name: "Single format",
+			metadata: map[string]string{
+				FormatsMetadataKey: wrp.MimeTypeJson,
+			},
+			expect: []wrp.Format{wrp.JSON},
+		}, {
+			name: "Multiple formats with unknown",
+			metadata: map[string]string{
+				FormatsMetadataKey: "application/json, text/plain ,application/msgpack",
+			},
+			expect: []wrp.Format{wrp.JSON, wrp.Msgpack},
+		}, {
+			name: "Empty",
+			metadata: map[string]string{
+				FormatsMetadataKey: "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := advertisedFormats(wrp.Message{Metadata: tt.metadata})
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferred   []wrp.Format
+		supported   []wrp.Format
+		expect      wrp.Format
+		expectedErr error
+	}{
+		{
+			name:      "Same preferences",
+			preferred: []wrp.Format{wrp.Msgpack, wrp.JSON},
+			supported: []wrp.Format{wrp.Msgpack, wrp.JSON},
+			expect:    wrp.Msgpack,
+		}, {
+			name:      "Different preferences use the preferred order",
+			preferred: []wrp.Format{wrp.JSON, wrp.Msgpack},
+			supported: []wrp.Format{wrp.Msgpack, wrp.JSON},
+			expect:    wrp.JSON,
+		}, {
+			name:      "Only one in common",
+			preferred: []wrp.Format{wrp.Msgpack, wrp.JSON},
+			supported: []wrp.Format{wrp.JSON},
+			expect:    wrp.JSON,
+		}, {
+			name:        "Nothing in common",
+			preferred:   []wrp.Format{wrp.Msgpack},
+			supported:   []wrp.Format{wrp.JSON},
+			expectedErr: errNoCommonFormat,
+		}, {
+			name:        "Nothing supported",
+			preferred:   []wrp.Format{wrp.Msgpack},
+			expectedErr: errNoCommonFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := negotiateFormat(tt.preferred, tt.supported)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestServer_serviceFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferred   []wrp.Format
+		advertised  string
+		expect      wrp.Format
+		expectedErr error
+	}{
+		{
+			name:   "Nothing advertised",
+			expect: wrp.Msgpack,
+		}, {
+			name:       "The service prefers JSON",
+			advertised: wrp.MimeTypeJson + "," + wrp.MimeTypeMsgpack,
+			expect:     wrp.JSON,
+		}, {
+			name:       "The first format that can be encoded",
+			advertised: "text/plain," + wrp.MimeTypeMsgpack,
+			expect:     wrp.Msgpack,
+		}, {
+			name:        "Nothing that can be encoded",
+			advertised:  "text/plain",
+			expectedErr: errNoCommonFormat,
+		}, {
+			name:       "The Server's preference wins",
+			preferred:  []wrp.Format{wrp.Msgpack, wrp.JSON},
+			advertised: wrp.MimeTypeJson + "," + wrp.MimeTypeMsgpack,
+			expect:     wrp.Msgpack,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{formats: tt.preferred}
+
+			msg := wrp.Message{Type: wrp.ServiceRegistrationMessageType}
+			if tt.advertised != "" {
+				msg.Metadata = map[string]string{FormatsMetadataKey: tt.advertised}
+			}
+
+			f, err := srv.serviceFormat(msg)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, f)
+		})
+	}
+}
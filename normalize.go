@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// Normify returns a modifier that applies the wrp normalizing options, such as
+// wrp.EnsureTransactionUUID, to the message.  If an option fails, the error is
+// returned and the message is not sent.
+func Normify(opts ...wrp.NormifierOption) wrp.Modifier {
+	n := wrp.NewNormifier(opts...)
+
+	return wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+		if err := n.Normify(&msg); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	})
+}
+
+// DefaultContentType returns a modifier that sets the content type of messages
+// that don't have one.
+func DefaultContentType(contentType string) wrp.Modifier {
+	return wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+		if msg.ContentType != "" {
+			return msg, wrp.ErrNotHandled
+		}
+
+		msg.ContentType = contentType
+		return msg, nil
+	})
+}
+
+// DefaultSource returns a modifier that sets the source of messages that don't
+// have one.
+func DefaultSource(source string) wrp.Modifier {
+	return wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+		if msg.Source != "" {
+			return msg, wrp.ErrNotHandled
+		}
+
+		msg.Source = source
+		return msg, nil
+	})
+}
+
+// DefaultOutboundNormalizers returns the modifiers used by
+// WithOutboundNormalizer when none are provided.  Messages are given a
+// transaction UUID and a content type of application/octet-stream if they
+// don't have them.
+func DefaultOutboundNormalizers() []wrp.Modifier {
+	return []wrp.Modifier{
+		Normify(wrp.EnsureTransactionUUID()),
+		DefaultContentType(wrp.MimeTypeOctetStream),
+	}
+}
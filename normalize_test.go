@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// recordingSender keeps the messages sent to it.
+type recordingSender struct {
+	mockSender
+	got []wrp.Message
+}
+
+func (r *recordingSender) ProcessWRP(_ context.Context, msg wrp.Message) error {
+	r.got = append(r.got, msg)
+	return nil
+}
+
+func TestWithOutboundNormalizer(t *testing.T) {
+	normErr := errors.New("normalizer error")
+
+	tests := []struct {
+		name        string
+		opts        []ServerOption
+		msg         wrp.Message
+		expectErr   error
+		expectSent  bool
+		expectCheck func(*testing.T, wrp.Message)
+	}{
+		{
+			name: "not normalized by default",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/service",
+			},
+			expectSent: true,
+			expectCheck: func(t *testing.T, msg wrp.Message) {
+				assert.Empty(t, msg.TransactionUUID)
+				assert.Empty(t, msg.ContentType)
+			},
+		}, {
+			name: "defaults assign a transaction uuid and content type",
+			opts: []ServerOption{WithOutboundNormalizer()},
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/service",
+			},
+			expectSent: true,
+			expectCheck: func(t *testing.T, msg wrp.Message) {
+				assert.Len(t, msg.TransactionUUID, 36)
+				assert.Equal(t, wrp.MimeTypeOctetStream, msg.ContentType)
+			},
+		}, {
+			name: "existing values are kept",
+			opts: []ServerOption{WithOutboundNormalizer()},
+			msg: wrp.Message{
+				Type:            wrp.SimpleEventMessageType,
+				Destination:     "mac:112233445566/service",
+				TransactionUUID: "existing",
+				ContentType:     wrp.MimeTypeJson,
+			},
+			expectSent: true,
+			expectCheck: func(t *testing.T, msg wrp.Message) {
+				assert.Equal(t, "existing", msg.TransactionUUID)
+				assert.Equal(t, wrp.MimeTypeJson, msg.ContentType)
+			},
+		}, {
+			name: "custom modifiers in order",
+			opts: []ServerOption{
+				WithOutboundNormalizer(DefaultContentType(wrp.MimeTypeJson)),
+				WithOutboundNormalizer(
+					DefaultContentType(wrp.MimeTypeMsgpack),
+					Normify(wrp.SetSessionID("session")),
+				),
+			},
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/service",
+			},
+			expectSent: true,
+			expectCheck: func(t *testing.T, msg wrp.Message) {
+				assert.Empty(t, msg.TransactionUUID)
+				assert.Equal(t, wrp.MimeTypeJson, msg.ContentType)
+				assert.Equal(t, "session", msg.SessionID)
+			},
+		}, {
+			name: "a failing modifier stops the send",
+			opts: []ServerOption{
+				WithOutboundNormalizer(wrp.ModifierFunc(
+					func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+						return msg, normErr
+					},
+				)),
+			},
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/service",
+			},
+			expectErr: normErr,
+		}, {
+			name: "a failing normifier stops the send",
+			opts: []ServerOption{
+				WithOutboundNormalizer(Normify(wrp.ValidateSource())),
+			},
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/service",
+			},
+			expectErr: wrp.ErrInvalidSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ServerOption{withReceiver(&mockReceiver{})}, tt.opts...)
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			rec := &recordingSender{}
+			srv.senders.senders = map[string]limitedSender{
+				"service": rec,
+			}
+
+			err = srv.ProcessWRP(context.Background(), tt.msg)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if !tt.expectSent {
+				assert.Empty(t, rec.got)
+				return
+			}
+			require.Len(t, rec.got, 1)
+			tt.expectCheck(t, rec.got[0])
+		})
+	}
+}
+
+func TestWithOutboundSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []ServerOption
+		source string
+		expect string
+	}{
+		{
+			name:   "not stamped by default",
+			expect: "",
+		}, {
+			name:   "unset source is stamped",
+			opts:   []ServerOption{WithOutboundSource("dns:server-1.example.com")},
+			expect: "dns:server-1.example.com",
+		}, {
+			name:   "existing source is kept",
+			opts:   []ServerOption{WithOutboundSource("dns:server-1.example.com")},
+			source: "mac:112233445566",
+			expect: "mac:112233445566",
+		}, {
+			name: "the last option wins",
+			opts: []ServerOption{
+				WithOutboundSource("dns:server-1.example.com"),
+				WithOutboundSource("dns:server-2.example.com"),
+			},
+			expect: "dns:server-2.example.com",
+		}, {
+			name: "an empty source turns it off",
+			opts: []ServerOption{
+				WithOutboundSource("dns:server-1.example.com"),
+				WithOutboundSource(""),
+			},
+			expect: "",
+		}, {
+			name: "the normalizers see the stamped source",
+			opts: []ServerOption{
+				WithOutboundSource("dns:server-1.example.com"),
+				WithOutboundNormalizer(DefaultSource("dns:normalizer.example.com")),
+			},
+			expect: "dns:server-1.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ServerOption{withReceiver(&mockReceiver{})}, tt.opts...)
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			rs := &recordingSender{}
+			srv.senders.senders = map[string]limitedSender{
+				"service": rs,
+			}
+
+			err = srv.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      tt.source,
+				Destination: "mac:112233445566/service",
+			})
+			require.NoError(t, err)
+			require.Len(t, rs.got, 1)
+			assert.Equal(t, tt.expect, rs.got[0].Source)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+)
+
+// QOSPolicy describes how messages with a given QOS level are sent to the
+// services.  The zero value sends the message the same as when no policy is
+// set: a send that times out because the service's queue is full fails and
+// closes the connection.
+type QOSPolicy struct {
+	// Retries is the number of times a send that timed out because the queue
+	// is full is tried again.  Each attempt waits up to the send timeout, so
+	// retries extend how long an important message may wait to be sent.
+	Retries int
+
+	// Drop makes a message that can't be queued be dropped instead of closing
+	// the connection.
+	Drop bool
+}
+
+// DefaultQOSPolicies returns the suggested QOS policies for use with
+// WithQOSPolicies.  Low messages are dropped under pressure, medium messages
+// use the default behavior, high messages are retried once and critical
+// messages are retried three times.
+func DefaultQOSPolicies() map[wrp.QOSLevel]QOSPolicy {
+	return map[wrp.QOSLevel]QOSPolicy{
+		wrp.QOSLow:      {Drop: true},
+		wrp.QOSMedium:   {},
+		wrp.QOSHigh:     {Retries: 1},
+		wrp.QOSCritical: {Retries: 3},
+	}
+}
+
+func (p QOSPolicy) toSender() sender.QOSPolicy {
+	return sender.QOSPolicy{
+		Retries: p.Retries,
+		Drop:    p.Drop,
+	}
+}
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestWithQOSPolicies(t *testing.T) {
+	tests := []struct {
+		description string
+		policies    map[wrp.QOSLevel]QOSPolicy
+		expectOpts  int
+		expectError bool
+	}{
+		{
+			description: "no policies",
+		}, {
+			description: "default policies",
+			policies:    DefaultQOSPolicies(),
+			expectOpts:  4,
+		}, {
+			description: "one policy",
+			policies: map[wrp.QOSLevel]QOSPolicy{
+				wrp.QOSCritical: {Retries: 5},
+			},
+			expectOpts: 1,
+		}, {
+			description: "invalid level",
+			policies: map[wrp.QOSLevel]QOSPolicy{
+				wrp.QOSCritical + 1: {Retries: 5},
+			},
+			expectError: true,
+		}, {
+			description: "negative retries",
+			policies: map[wrp.QOSLevel]QOSPolicy{
+				wrp.QOSHigh: {Retries: -1},
+			},
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithQOSPolicies(tt.policies),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, srv)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, srv.sOpts, tt.expectOpts)
+		})
+	}
+}
+
+func TestDefaultQOSPolicies(t *testing.T) {
+	p := DefaultQOSPolicies()
+	assert.True(t, p[wrp.QOSLow].Drop)
+	assert.Equal(t, QOSPolicy{}, p[wrp.QOSMedium])
+	assert.Less(t, p[wrp.QOSHigh].Retries, p[wrp.QOSCritical].Retries)
+
+	// The result can be changed without changing the defaults.
+	p[wrp.QOSLow] = QOSPolicy{}
+	assert.True(t, DefaultQOSPolicies()[wrp.QOSLow].Drop)
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// replayBuffer is a bounded ring buffer of the most recently observed messages.
+// It is safe for concurrent access.  A nil replayBuffer ignores all messages.
+type replayBuffer struct {
+	msgs  []wrp.Message
+	next  int
+	count int
+	lock  sync.Mutex
+}
+
+var _ wrp.Observer = (*replayBuffer)(nil)
+
+// newReplayBuffer creates a replayBuffer that retains the last size messages.
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{
+		msgs: make([]wrp.Message, size),
+	}
+}
+
+// ObserveWRP records the message, replacing the oldest message if the buffer
+// is full.
+func (rb *replayBuffer) ObserveWRP(_ context.Context, msg wrp.Message) {
+	if rb == nil || len(rb.msgs) == 0 {
+		return
+	}
+
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rb.msgs[rb.next] = msg
+	rb.next = (rb.next + 1) % len(rb.msgs)
+	if rb.count < len(rb.msgs) {
+		rb.count++
+	}
+}
+
+// Messages returns a copy of the retained messages, oldest first.
+func (rb *replayBuffer) Messages() []wrp.Message {
+	if rb == nil {
+		return nil
+	}
+
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rv := make([]wrp.Message, 0, rb.count)
+	start := (rb.next - rb.count + len(rb.msgs)) % len(rb.msgs)
+	for i := 0; i < rb.count; i++ {
+		rv = append(rv, rb.msgs[(start+i)%len(rb.msgs)])
+	}
+
+	return rv
+}
+
+// injector is implemented by receivers that can handle recorded frames.
+type injector interface {
+	Inject(context.Context, []byte) error
+}
+
+// InjectRaw handles the raw frame as if the receiver had just read it from
+// its socket, so captured traffic can be replayed without a live producer.
+// The frame is decoded using the receiver's options, such as WithFormats and
+// WithPayloadCompression, and the messages go through the same processing as
+// received messages before InjectRaw returns.  If the frame can't be decoded,
+// an error matching ErrInvalidMessage is returned.  When the receiver decodes
+// batches, the messages of a batch that can be decoded are still processed,
+// the same as when the batch is received, and the error reports the rest.  The
+// Server doesn't need to be started.
+func (srv *Server) InjectRaw(ctx context.Context, frame []byte) error {
+	inj, ok := srv.r.(injector)
+	if !ok {
+		return errors.New("the receiver can't inject frames")
+	}
+
+	if err := inj.Inject(ctx, frame); err != nil {
+		return errors.Join(ErrInvalidMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		send   int
+		expect []string
+	}{
+		{
+			name: "Empty buffer",
+			size: 3,
+		}, {
+			name:   "Partially full",
+			size:   3,
+			send:   2,
+			expect: []string{"0", "1"},
+		}, {
+			name:   "Exactly full",
+			size:   3,
+			send:   3,
+			expect: []string{"0", "1", "2"},
+		}, {
+			name:   "Overflowing",
+			size:   3,
+			send:   7,
+			expect: []string{"4", "5", "6"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := newReplayBuffer(tt.size)
+
+			for i := 0; i < tt.send; i++ {
+				rb.ObserveWRP(context.Background(), wrp.Message{
+					TransactionUUID: fmt.Sprintf("%d", i),
+				})
+			}
+
+			got := rb.Messages()
+			assert.Len(t, got, len(tt.expect))
+			for i := range tt.expect {
+				assert.Equal(t, tt.expect[i], got[i].TransactionUUID)
+			}
+		})
+	}
+}
+
+func TestReplayBuffer_Nil(t *testing.T) {
+	var rb *replayBuffer
+
+	rb.ObserveWRP(context.Background(), wrp.Message{})
+	assert.Nil(t, rb.Messages())
+}
+
+func TestServer_InjectRaw(t *testing.T) {
+	rxURL, err := findOpenURL()
+	require.NoError(t, err)
+
+	var got []wrp.Message
+	srv, err := NewServer(
+		RXURL(rxURL),
+		WithReplayBuffer(10),
+		WithEgressModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				got = append(got, msg)
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	svcURL, err := findOpenURL()
+	require.NoError(t, err)
+	svc, err := receiver.New(receiver.WithURL(svcURL))
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	encode := func(msg wrp.Message) []byte {
+		var buf []byte
+		require.NoError(t, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(msg))
+		return buf
+	}
+
+	// A recorded registration registers the service, without the Server
+	// listening.
+	err = srv.InjectRaw(context.Background(), encode(wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "config",
+		URL:         svcURL,
+	}))
+	require.NoError(t, err)
+	assert.True(t, srv.IsServiceConnected("config"))
+
+	// A recorded event is passed to the egress modifiers.
+	event := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/config",
+		Destination: "event:device-status/mac:112233445566/online",
+	}
+	require.NoError(t, srv.InjectRaw(context.Background(), encode(event)))
+	require.Len(t, got, 1)
+	assert.Equal(t, event.Destination, got[0].Destination)
+	assert.Len(t, srv.RecentMessages(), 2)
+
+	// An undecodable frame isn't processed.
+	err = srv.InjectRaw(context.Background(), []byte("not a wrp message"))
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+	assert.Len(t, got, 1)
+	assert.Len(t, srv.RecentMessages(), 2)
+}
+
+func TestServer_InjectRawPartialBatch(t *testing.T) {
+	rxURL, err := findOpenURL()
+	require.NoError(t, err)
+
+	var got []string
+	srv, err := NewServer(
+		RXURL(rxURL),
+		serverOptionFunc(func(srv *Server) {
+			srv.rOpts = append(srv.rOpts, receiver.WithBatchDecoding())
+		}),
+		WithEgressModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				got = append(got, msg.Destination)
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	var batch []byte
+	add := func(frame []byte) {
+		batch = binary.BigEndian.AppendUint32(batch, uint32(len(frame)))
+		batch = append(batch, frame...)
+	}
+	online := "event:device-status/mac:112233445566/online"
+	offline := "event:device-status/mac:112233445566/offline"
+	for _, dest := range []string{online, "", offline} {
+		if dest == "" {
+			add([]byte("not a wrp message"))
+			continue
+		}
+
+		var buf []byte
+		require.NoError(t, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "mac:112233445566/config",
+			Destination: dest,
+		}))
+		add(buf)
+	}
+
+	// The bad message is reported, and the good ones around it are processed.
+	err = srv.InjectRaw(context.Background(), batch)
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+	assert.Equal(t, []string{online, offline}, got)
+}
+
+func TestServer_InjectRawUnsupported(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+	assert.Error(t, srv.InjectRaw(context.Background(), nil))
+}
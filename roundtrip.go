@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// DefaultRoundTripTimeout is the time RoundTrip waits for a response unless
+// WithRoundTripTimeout is used.
+const DefaultRoundTripTimeout = 30 * time.Second
+
+// roundTrips tracks the requests waiting for a response, by transaction UUID.
+type roundTrips struct {
+	lock    sync.Mutex
+	waiters map[string]chan wrp.Message
+}
+
+// add registers a waiter for the transaction.  The returned function removes
+// it.
+func (rt *roundTrips) add(id string) (<-chan wrp.Message, func(), error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if _, found := rt.waiters[id]; found {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDuplicateTransaction, id)
+	}
+
+	if rt.waiters == nil {
+		rt.waiters = make(map[string]chan wrp.Message)
+	}
+
+	ch := make(chan wrp.Message, 1)
+	rt.waiters[id] = ch
+
+	return ch, func() {
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+
+		if rt.waiters[id] == ch {
+			delete(rt.waiters, id)
+		}
+	}, nil
+}
+
+// pending returns the number of requests waiting for a response.
+func (rt *roundTrips) pending() int {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return len(rt.waiters)
+}
+
+// ObserveWRP resolves the waiter for the response's transaction, if any.
+func (rt *roundTrips) ObserveWRP(_ context.Context, msg wrp.Message) {
+	if msg.Type != wrp.SimpleRequestResponseMessageType || msg.TransactionUUID == "" {
+		return
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if ch, found := rt.waiters[msg.TransactionUUID]; found {
+		delete(rt.waiters, msg.TransactionUUID)
+		ch <- msg
+	}
+}
+
+// RoundTrip sends the SimpleRequestResponse message using ProcessWRP and waits
+// for the response with the same transaction UUID to be received from the
+// network.  A transaction UUID is assigned if the message doesn't have one.
+// The wait ends when the context is done or the timeout set using
+// WithRoundTripTimeout passes, whichever is first.  The response is still
+// passed to the egress modifiers.
+func (srv *Server) RoundTrip(ctx context.Context, msg wrp.Message) (wrp.Message, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if msg.Type != wrp.SimpleRequestResponseMessageType {
+		return wrp.Message{}, fmt.Errorf("%w: %s", ErrNotRequest, msg.Type)
+	}
+
+	msg, err := Normify(wrp.EnsureTransactionUUID()).ModifyWRP(ctx, msg)
+	if err != nil {
+		return wrp.Message{}, err
+	}
+
+	if srv.roundTripTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.roundTripTimeout)
+		defer cancel()
+	}
+
+	// Wait before sending, so a fast response isn't missed.
+	response, remove, err := srv.roundTrips.add(msg.TransactionUUID)
+	if err != nil {
+		return wrp.Message{}, err
+	}
+	defer remove()
+
+	if err := srv.ProcessWRP(ctx, msg); err != nil {
+		return wrp.Message{}, err
+	}
+
+	select {
+	case got := <-response:
+		return got, nil
+	case <-ctx.Done():
+		return wrp.Message{}, ctx.Err()
+	}
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// respondingSender simulates a service that answers each request by passing
+// the response to the Server's rx chain, as if it was received.
+type respondingSender struct {
+	mockSender
+	srv     *Server
+	respond func(wrp.Message) []wrp.Message
+}
+
+func (r *respondingSender) ProcessWRP(_ context.Context, msg wrp.Message) error {
+	go func() {
+		for _, response := range r.respond(msg) {
+			_ = r.srv.rxChain.ProcessWRP(context.Background(), response)
+		}
+	}()
+	return r.processErr
+}
+
+func TestServer_RoundTrip(t *testing.T) {
+	sendErr := errors.New("send failed")
+
+	reply := func(request wrp.Message) wrp.Message {
+		return wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          request.Destination,
+			Destination:     request.Source,
+			TransactionUUID: request.TransactionUUID,
+			Payload:         []byte("response"),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		msg       wrp.Message
+		respond   func(wrp.Message) []wrp.Message
+		sendErr   error
+		timeout   time.Duration
+		expectErr error
+	}{
+		{
+			name: "response",
+			msg: wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:example.com",
+				Destination:     "mac:112233445566/service",
+				TransactionUUID: "1234",
+			},
+			respond: func(request wrp.Message) []wrp.Message {
+				return []wrp.Message{reply(request)}
+			},
+		}, {
+			name: "transaction uuid assigned",
+			msg: wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			respond: func(request wrp.Message) []wrp.Message {
+				return []wrp.Message{reply(request)}
+			},
+		}, {
+			name: "other responses are ignored",
+			msg: wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:example.com",
+				Destination:     "mac:112233445566/service",
+				TransactionUUID: "1234",
+			},
+			respond: func(request wrp.Message) []wrp.Message {
+				other := reply(request)
+				other.TransactionUUID = "5678"
+				event := reply(request)
+				event.Type = wrp.SimpleEventMessageType
+				return []wrp.Message{other, event}
+			},
+			timeout:   50 * time.Millisecond,
+			expectErr: context.DeadlineExceeded,
+		}, {
+			name: "no response",
+			msg: wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			timeout:   10 * time.Millisecond,
+			expectErr: context.DeadlineExceeded,
+		}, {
+			name: "not a request",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			expectErr: ErrNotRequest,
+		}, {
+			name: "no route",
+			msg: wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/unknown",
+			},
+			expectErr: ErrNoRoute,
+		}, {
+			name: "send fails",
+			msg: wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			sendErr:   sendErr,
+			expectErr: sendErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var egressed []wrp.Message
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithRoundTripTimeout(tt.timeout),
+				WithEgressModifier(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+						lock.Lock()
+						defer lock.Unlock()
+						egressed = append(egressed, msg)
+					}),
+				)),
+			)
+			require.NoError(t, err)
+
+			respond := tt.respond
+			if respond == nil {
+				respond = func(wrp.Message) []wrp.Message { return nil }
+			}
+			srv.senders.senders = map[string]limitedSender{
+				"service": &respondingSender{
+					mockSender: mockSender{processErr: tt.sendErr},
+					srv:        srv,
+					respond:    respond,
+				},
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			got, err := srv.RoundTrip(ctx, tt.msg)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				assert.Zero(t, srv.roundTrips.pending())
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, wrp.SimpleRequestResponseMessageType, got.Type)
+			assert.Equal(t, "mac:112233445566/service", got.Source)
+			assert.Equal(t, []byte("response"), got.Payload)
+			assert.NotEmpty(t, got.TransactionUUID)
+			if tt.msg.TransactionUUID != "" {
+				assert.Equal(t, tt.msg.TransactionUUID, got.TransactionUUID)
+			}
+			assert.Zero(t, srv.roundTrips.pending())
+
+			// The response is still passed to the egress modifiers.
+			assert.Eventually(t, func() bool {
+				lock.Lock()
+				defer lock.Unlock()
+				return len(egressed) == 1
+			}, 5*time.Second, time.Millisecond)
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, got, egressed[0])
+		})
+	}
+}
+
+func TestServer_RoundTripDuplicate(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+
+	srv.senders.senders = map[string]limitedSender{
+		"service": &mockSender{},
+	}
+
+	msg := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:example.com",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "1234",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := srv.RoundTrip(ctx, msg)
+		done <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		return srv.roundTrips.pending() == 1
+	}, 5*time.Second, time.Millisecond)
+
+	_, err = srv.RoundTrip(context.Background(), msg)
+	assert.ErrorIs(t, err, ErrDuplicateTransaction)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+)
+
+// Router sends WRP messages to named services the same way the Server routes
+// the messages passed to its ProcessWRP, so other controllers can be built
+// without a receiver.  It is safe for concurrent use.
+type Router struct {
+	senders senderMap
+	sOpts   []sender.Option
+}
+
+// NewRouter creates a Router.  Only the routing options, which are
+// WithRouteKey, WithRouteKeyFunc, WithMetadataRoute, WithWildcardRoutes,
+// WithRejectURLChange, WithMaxSenders, WithBroadcastTimeout, WithDialRetry,
+// WithMetrics and WithLoopGuard, and the options for the connections to the
+// services, which are WithTLSConfig, WithPayloadCompression, WithQOSPolicies,
+// WithOrderedSends, WithSendRetries, WithDropOnFull, WithSendBuffer,
+// WithAutoRedial, WithSenderCloseTimeout, WithWriteQueueLen and
+// WithSenderSocketOption, can be used.  The other options, such as the receiver
+// and ingress options, cause an error since a Router has no receiver or
+// ingress chain.
+func NewRouter(opts ...ServerOption) (*Router, error) {
+	srv := Server{
+		senders: senderMap{
+			sendTimeout: DefaultBroadcastTimeout,
+		},
+	}
+
+	for i, opt := range opts {
+		if opt != nil {
+			if _, ok := opt.(routeOption); !ok {
+				return nil, fmt.Errorf("option %d can't be used with a Router", i)
+			}
+			if err := opt.apply(&srv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Router{
+		senders: senderMap{
+			rejectURLChange: srv.senders.rejectURLChange,
+			wildcards:       srv.senders.wildcards,
+			max:             srv.senders.max,
+			sendTimeout:     srv.senders.sendTimeout,
+			key:             srv.senders.key,
+			keyFunc:         srv.senders.keyFunc,
+			metadataKey:     srv.senders.metadataKey,
+			dialBackoff:     srv.senders.dialBackoff,
+			metrics:         srv.senders.metrics,
+			loopGuard:       srv.senders.loopGuard,
+		},
+		sOpts: srv.sOpts,
+	}, nil
+}
+
+// Register connects to the service at the URL and routes the messages for
+// name to it.  If a service is already registered under name, it is replaced
+// unless it is connected to the same URL, and replaced is true.  The name is
+// matched against the key selected by WithRouteKey, or returned by the
+// function set using WithRouteKeyFunc.  As with a registration message sent to
+// the Server, the service is sent an authorization message once connected.
+func (r *Router) Register(name, url string) (replaced bool, err error) {
+	if name == "" || url == "" {
+		return false, errors.New("service name and url are required")
+	}
+
+	// Clip so concurrent registrations don't share the appended options.
+	opts := append(slices.Clip(r.sOpts), sender.WithURL(url))
+	return r.senders.Upsert(context.Background(), name, opts)
+}
+
+// Remove closes the connection to the named service and stops routing to it.
+// Removing a service that isn't registered does nothing.
+func (r *Router) Remove(name string) {
+	_ = r.senders.Remove(name)
+}
+
+// ProcessWRP sends the message to the service it is routed to.  A
+// ServiceAlive message is sent to every service instead.  The errors are the
+// same as for Server.ProcessWRP: if there is no registered service for the
+// message, the error matches both ErrNoRoute and wrp.ErrNotHandled, and if the
+// send fails, the error is a *SendError naming the service.  A nil context is
+// treated as context.Background().
+func (r *Router) ProcessWRP(ctx context.Context, msg wrp.Message) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	err := r.senders.ProcessWRP(ctx, msg)
+	if errors.Is(err, wrp.ErrNotHandled) {
+		return errors.Join(ErrNoRoute, err)
+	}
+
+	return err
+}
+
+// IsConnected returns true if the named service is registered and connected.
+func (r *Router) IsConnected(name string) bool {
+	return r.senders.IsConnected(name)
+}
+
+// SenderHealth returns the health snapshot of the sender for the named
+// service.  If the service is not registered, false is returned.
+func (r *Router) SenderHealth(name string) (SenderHealth, bool) {
+	h, ok := r.senders.Health(name)
+	if !ok {
+		return SenderHealth{}, false
+	}
+
+	return senderHealth(h), true
+}
+
+// Close closes the connections to all of the services, and a Register waiting
+// to retry its dial gives up.  The Router can still be used afterwards, and
+// starts out with no services.
+func (r *Router) Close() error {
+	return r.senders.Close()
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+)
+
+func TestNewRouter(t *testing.T) {
+	byHeader := func(msg wrp.Message) (string, error) {
+		return msg.Headers[0], nil
+	}
+
+	r, err := NewRouter(
+		WithRouteKey(RouteBySchemeAndService),
+		WithRouteKeyFunc(byHeader),
+		WithWildcardRoutes(),
+		WithRejectURLChange(),
+		WithMaxSenders(3),
+		WithOrderedSends(),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, RouteBySchemeAndService, r.senders.key)
+	assert.NotNil(t, r.senders.keyFunc)
+	assert.True(t, r.senders.wildcards)
+	assert.True(t, r.senders.rejectURLChange)
+	assert.Equal(t, 3, r.senders.max)
+	assert.Equal(t, DefaultBroadcastTimeout, r.senders.sendTimeout)
+	assert.Len(t, r.sOpts, 1)
+
+	r, err = NewRouter(WithMaxSenders(-1))
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	// The receiver and ingress options have nothing to configure.
+	for _, opt := range []ServerOption{
+		RXURL("tcp://127.0.0.1:6000"),
+		WithFanout(func(wrp.Message) []string { return nil }),
+	} {
+		r, err = NewRouter(WithRouteKey(RouteByService), opt)
+		assert.Error(t, err)
+		assert.Nil(t, r)
+	}
+}
+
+func TestRouter_ProcessWRP(t *testing.T) {
+	errBroken := errors.New("broken")
+	tests := []struct {
+		name       string
+		msg        wrp.Message
+		expectErr  error
+		expectSent map[string]int
+	}{
+		{
+			name: "routed",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/service",
+			},
+			expectSent: map[string]int{"service": 1},
+		}, {
+			name: "no route",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/unknown",
+			},
+			expectErr: ErrNoRoute,
+		}, {
+			name:       "service alive",
+			msg:        wrp.Message{Type: wrp.ServiceAliveMessageType},
+			expectSent: map[string]int{"service": 1, "broken": 1},
+		}, {
+			name: "send error",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/broken",
+			},
+			expectErr:  errBroken,
+			expectSent: map[string]int{"broken": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRouter()
+			require.NoError(t, err)
+
+			senders := map[string]*mockSender{
+				"service": {},
+				"broken":  {processErr: errBroken},
+			}
+			r.senders.senders = make(map[string]limitedSender)
+			for name, s := range senders {
+				r.senders.senders[name] = s
+			}
+
+			err = r.ProcessWRP(context.Background(), tt.msg)
+			if tt.expectErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectErr)
+			}
+
+			switch tt.expectErr {
+			case ErrNoRoute:
+				assert.ErrorIs(t, err, wrp.ErrNotHandled)
+			case errBroken:
+				var se *SendError
+				require.ErrorAs(t, err, &se)
+				assert.Equal(t, "broken", se.Service)
+			}
+
+			for name, s := range senders {
+				assert.Equal(t, tt.expectSent[name], s.processCount, name)
+			}
+		})
+	}
+}
+
+func TestRouter_Register(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	got := make(chan wrp.Message, 10)
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				if msg.Type == wrp.SimpleEventMessageType {
+					got <- msg
+				}
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	r, err := NewRouter()
+	require.NoError(t, err)
+	defer r.Close() // nolint:errcheck
+
+	_, err = r.Register("", url)
+	assert.Error(t, err)
+
+	replaced, err := r.Register("config", url)
+	require.NoError(t, err)
+	assert.False(t, replaced)
+	assert.True(t, r.IsConnected("config"))
+
+	h, ok := r.SenderHealth("config")
+	assert.True(t, ok)
+	assert.True(t, h.Connected)
+
+	replaced, err = r.Register("config", url)
+	require.NoError(t, err)
+	assert.True(t, replaced)
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/config",
+	}
+	require.NoError(t, r.ProcessWRP(context.Background(), msg))
+
+	select {
+	case m := <-got:
+		assert.Equal(t, msg.Destination, m.Destination)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "message not received")
+	}
+
+	r.Remove("config")
+	assert.False(t, r.IsConnected("config"))
+	_, ok = r.SenderHealth("config")
+	assert.False(t, ok)
+	assert.ErrorIs(t, r.ProcessWRP(context.Background(), msg), ErrNoRoute)
+
+	// Removing it again does nothing.
+	r.Remove("config")
+	require.NoError(t, r.Close())
+}
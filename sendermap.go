@@ -5,16 +5,73 @@ package wrpnng
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/wrpnng/internal/sender"
+	"go.nanomsg.org/mangos/v3"
 )
 
+// SendError is a failure to send a message to a registered service.  It
+// carries the name and URL of the service, and matches the reason, such as
+// ErrConnClosed or ErrFailedToSend, using errors.Is.
+type SendError struct {
+	// Service is the name the service registered with.
+	Service string
+
+	// URL is the URL of the service.
+	URL string
+
+	// Err is the reason for the failure.
+	Err error
+}
+
+func (e *SendError) Error() string {
+	return "service " + e.Service + " at " + e.URL + ": " + e.Err.Error()
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// newSendError wraps the error from sending to the named sender, or returns
+// nil if there is no error.
+func newSendError(name string, s limitedSender, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// The sender's own error only adds the URL, which is already included.
+	if se, ok := err.(*sender.Error); ok { // nolint:errorlint
+		err = se.Err
+	}
+
+	// The sender is still in the map, but its connection was closed.
+	if errors.Is(err, sender.ErrConnClosed) {
+		err = fmt.Errorf("%w: %w", ErrServiceDisconnected, err)
+	}
+
+	return &SendError{
+		Service: name,
+		URL:     s.URL(),
+		Err:     err,
+	}
+}
+
 type limitedSender interface {
 	ProcessWRP(context.Context, wrp.Message) error
 	Dial() error
 	Close() error
+	Health() sender.Health
+	IsConnected() bool
+	URL() string
+	Format() wrp.Format
 }
 
 type limitedSenderFactory func(...sender.Option) (limitedSender, error)
@@ -24,8 +81,84 @@ type limitedSenderFactory func(...sender.Option) (limitedSender, error)
 //
 // If a sender is closed, it is removed from the map automatically.
 type senderMap struct {
-	senders map[string]limitedSender
-	lock    sync.RWMutex
+	senders         map[string]limitedSender
+	rejectURLChange bool
+	wildcards       bool
+	max             int
+	sendTimeout     time.Duration
+	key             RouteKey
+	keyFunc         func(wrp.Message) (string, error)
+	metadataKey     string
+	dialBackoff     Backoff
+	metrics         Metrics
+	loopGuard       bool
+	panicked        func(error)
+	stop            chan struct{}
+	lock            sync.RWMutex
+}
+
+// broadcast sends the message to all senders at the same time.  A sender that
+// blocks or panics can't hold up the other senders, and a panic is passed to
+// the panicked handler if there is one.  Each send gets its own
+// context, bounded by the send timeout if there is one, and broadcast returns
+// once all sends are done, the send timeout passes, or the context is done,
+// whichever is first.
+func (sm *senderMap) broadcast(ctx context.Context, msg wrp.Message) {
+	// Only lock while making a copy of the sender list.
+	sm.lock.RLock()
+	senders := make([]limitedSender, 0, len(sm.senders))
+	for _, s := range sm.senders {
+		senders = append(senders, s)
+	}
+	timeout := sm.sendTimeout
+	panicked := sm.panicked
+	sm.lock.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, s := range senders {
+		wg.Add(1)
+		go func(s limitedSender) {
+			defer wg.Done()
+			defer func() {
+				if v := recover(); v != nil && panicked != nil {
+					panicked(fmt.Errorf("%w: sender: %v", ErrObserverPanic, v))
+				}
+			}()
+
+			sendCtx, cancel := sendContext(ctx, timeout)
+			defer cancel()
+
+			_ = s.ProcessWRP(sendCtx, msg)
+		}(s)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// A sender may ignore its context, so stop waiting at the timeout too.
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	select {
+	case <-done:
+	case <-expired:
+	case <-ctx.Done():
+	}
+}
+
+// sendContext returns the context for a single send of a broadcast.
+func sendContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 // ProcessWRP sends the message to the appropriate sender.  If the message is a
@@ -33,71 +166,141 @@ type senderMap struct {
 // is not found, ErrNotHandled is returned.
 func (sm *senderMap) ProcessWRP(ctx context.Context, msg wrp.Message) error {
 	if msg.Type == wrp.ServiceAliveMessageType {
-		// Send the message to all senders.
-
-		senders := make([]limitedSender, 0, len(sm.senders))
-
-		// Only lock while making a copy of the sender list.
-		sm.lock.RLock()
-		for _, s := range sm.senders {
-			senders = append(senders, s)
-		}
-		sm.lock.RUnlock()
-
-		for _, s := range senders {
-			_ = s.ProcessWRP(ctx, msg)
-		}
+		sm.broadcast(ctx, msg)
 		return nil
 	}
 
 	// Send the message to the appropriate sender.
-	dest, err := wrp.ParseLocator(msg.To())
+	key, err := sm.messageKey(msg)
 	if err != nil {
 		return err
 	}
 
+	// The source key may come from the route key function, so find it before
+	// taking the lock.
+	srcKey, guard := "", false
+	if sm.loopGuard {
+		srcKey, guard = sm.sourceKey(msg)
+	}
+
 	sm.lock.RLock()
-	target := sm.senders[dest.Service]
+	name, target := sm.route(key)
+	loop := target != nil && guard && sm.fromService(srcKey, name)
 	sm.lock.RUnlock()
 
+	if loop {
+		return fmt.Errorf("%w: %s", ErrRoutingLoop, name)
+	}
+
 	if target != nil {
-		return target.ProcessWRP(ctx, msg)
+		return newSendError(name, target, target.ProcessWRP(ctx, msg))
 	}
 
 	return wrp.ErrNotHandled
 }
 
+// sourceKey returns the name of the sender for the message's source, found the
+// same way messageKey finds it for the destination.  The route key function, if
+// there is one, is passed the message with its source and destination swapped.
+// The metadata route only names the destination, so it is not used.  False is
+// returned if the message has no usable source.
+func (sm *senderMap) sourceKey(msg wrp.Message) (string, bool) {
+	if msg.Source == "" {
+		return "", false
+	}
+
+	if sm.keyFunc != nil {
+		reply := msg
+		reply.Source, reply.Destination = msg.Destination, msg.Source
+		key, err := sm.keyFunc(reply)
+		return key, err == nil
+	}
+
+	src, err := wrp.ParseLocator(msg.Source)
+	if err != nil {
+		return "", false
+	}
+	return sm.routeKey(src), true
+}
+
+// fromService reports if the source key routes to the named sender, meaning
+// the message would go back where it came from.  The lock must be held.
+func (sm *senderMap) fromService(srcKey, name string) bool {
+	srcName, s := sm.route(srcKey)
+	return s != nil && srcName == name
+}
+
+// sendTo sends the message to the sender registered under name, bypassing
+// routing.  ErrNoRoute is returned if there is no such sender.
+func (sm *senderMap) sendTo(ctx context.Context, name string, msg wrp.Message) error {
+	sm.lock.RLock()
+	target := sm.senders[name]
+	sm.lock.RUnlock()
+
+	if target == nil {
+		return ErrNoRoute
+	}
+
+	return newSendError(name, target, target.ProcessWRP(ctx, msg))
+}
+
 // Upsert adds or updates a sender in the map.  If a sender with the same name
 // already exists, it is closed and replaced with the new sender.  The new
-// sender is dialed being added to the map.
+// sender is dialed being added to the map.  If the existing sender is connected
+// to the same URL using the same format it is kept instead, so re-registering
+// doesn't cause a new connection.
 //
-// Upsert also sends the sender an authorization message.
-func (sm *senderMap) Upsert(name string, opts []sender.Option) error {
+// Upsert also sends the sender an authorization message.  The returned bool
+// is true if an existing sender was replaced.  Waiting to retry the dial stops
+// when the context is done or the map is closed.
+func (sm *senderMap) Upsert(ctx context.Context, name string, opts []sender.Option) (bool, error) {
 	factory := func(opts ...sender.Option) (limitedSender, error) {
 		return sender.New(opts...)
 	}
-	return sm.upsert(name, opts, factory)
+	return sm.upsert(ctx, name, opts, factory)
 }
 
 // upsert is broken out for testing purposes.  Mainly so we can inject a mock
 // sender factory.
-func (sm *senderMap) upsert(name string,
+func (sm *senderMap) upsert(ctx context.Context,
+	name string,
 	opts []sender.Option,
 	factory limitedSenderFactory,
-) error {
+) (bool, error) {
+	stop := sm.stopped()
+
+	var s limitedSender
 	opts = append(opts, sender.WithCloseListener(func(error) {
-		_ = sm.Remove(name)
+		sm.removeIfSame(name, s)
 	}))
 
 	s, err := factory(opts...)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	err = s.Dial()
+	// Check before dialing so a rejected URL is never connected to.
+	sm.lock.RLock()
+	err = sm.check(name, s)
+	existing := sm.senders[name]
+	sm.lock.RUnlock()
 	if err != nil {
 		_ = s.Close()
-		return err
+		return false, err
+	}
+
+	// The new sender was never dialed, so closing it doesn't disturb the
+	// existing one.
+	if sameConn(existing, s) {
+		_ = s.Close()
+		authorize(existing)
+		return true, nil
+	}
+
+	err = sm.dial(ctx, stop, s)
+	if err != nil {
+		_ = s.Close()
+		return false, err
 	}
 
 	sm.lock.Lock()
@@ -106,48 +309,286 @@ func (sm *senderMap) upsert(name string,
 		sm.senders = make(map[string]limitedSender)
 	}
 
-	existing := sm.senders[name]
-	if existing != nil {
-		_ = existing.Close()
+	// Check again in case another registration happened while dialing.
+	if err = sm.check(name, s); err != nil {
+		sm.lock.Unlock()
+		_ = s.Close()
+		return false, err
 	}
+
+	existing = sm.senders[name]
 	sm.senders[name] = s
+	sm.reportCount()
 
 	sm.lock.Unlock()
 
-	// Send a message to the new sender to authorize it.
+	// Close outside the lock since closing triggers the close listener.
+	if existing != nil {
+		_ = existing.Close()
+	}
+
+	authorize(s)
+
+	return existing != nil, nil
+}
+
+// dial connects the sender, retrying transient failures using the dial
+// backoff.  Only one attempt is made unless the backoff allows more failures.
+// Waiting to retry ends early, returning the last dial error, if the context is
+// done or stop is closed.
+func (sm *senderMap) dial(ctx context.Context, stop <-chan struct{}, s limitedSender) error {
+	for failures := 0; ; {
+		err := s.Dial()
+		if err == nil || permanentDialErr(err) {
+			return err
+		}
+
+		failures++
+		if failures >= max(1, sm.dialBackoff.MaxFailures) {
+			return err
+		}
+
+		t := time.NewTimer(sm.dialBackoff.delay(failures - 1))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-stop:
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// stopped returns a channel that is closed when the map is closed.
+func (sm *senderMap) stopped() <-chan struct{} {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if sm.stop == nil {
+		sm.stop = make(chan struct{})
+	}
+	return sm.stop
+}
+
+// permanentDialErr reports if retrying the dial can't help, because the URL or
+// the socket configuration is wrong.
+func permanentDialErr(err error) bool {
+	for _, perm := range []error{
+		mangos.ErrBadAddr,
+		mangos.ErrBadTran,
+		mangos.ErrBadProto,
+		mangos.ErrBadOption,
+		mangos.ErrBadValue,
+		mangos.ErrTLSNoConfig,
+		mangos.ErrTLSNoCert,
+	} {
+		if errors.Is(err, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameConn reports if the existing sender can be kept in place of s, because
+// it is connected to the same URL and sends using the same format.  All other
+// sender options are the same for every registration.
+func sameConn(existing, s limitedSender) bool {
+	return existing != nil &&
+		existing.IsConnected() &&
+		existing.URL() == s.URL() &&
+		existing.Format() == s.Format()
+}
+
+// authorize sends the sender an authorization message.
+func authorize(s limitedSender) {
 	status := int64(200)
 	_ = s.ProcessWRP(context.Background(), wrp.Message{
 		Type:   wrp.AuthorizationMessageType,
 		Status: &status,
 	})
+}
 
-	return nil
+// Health returns the health snapshot of the named sender.  If the sender is
+// not found, false is returned.
+func (sm *senderMap) Health(name string) (sender.Health, bool) {
+	sm.lock.RLock()
+	s := sm.senders[name]
+	sm.lock.RUnlock()
+
+	if s == nil {
+		return sender.Health{}, false
+	}
+
+	return s.Health(), true
+}
+
+// IsConnected returns true if the named sender is found and connected.
+func (sm *senderMap) IsConnected(name string) bool {
+	sm.lock.RLock()
+	s := sm.senders[name]
+	sm.lock.RUnlock()
+
+	return s != nil && s.IsConnected()
 }
 
 // Remove removes a sender from the map.  If the sender is found, it is closed
 // and removed.
 func (sm *senderMap) Remove(name string) error {
 	sm.lock.Lock()
-	defer sm.lock.Unlock()
-
 	s := sm.senders[name]
+	delete(sm.senders, name)
+	sm.reportCount()
+	sm.lock.Unlock()
+
+	// Close outside the lock since closing triggers the close listener.
 	if s != nil {
 		_ = s.Close()
-		delete(sm.senders, name)
 	}
 
 	return nil
 }
 
-// Close closes all senders in the map.
-func (sm *senderMap) Close() error {
+// removeIfSame removes the named sender from the map only if it is still the
+// sender provided.  This prevents a sender that was replaced from removing its
+// replacement when it closes.  A removed sender is closed as well, since it
+// would otherwise still redial to retry a send or with auto redial, leaving a
+// connection the map no longer knows about.
+func (sm *senderMap) removeIfSame(name string, s limitedSender) {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
 
-	for _, s := range sm.senders {
-		_ = s.Close()
+	if s != nil && sm.senders[name] == s {
+		delete(sm.senders, name)
+		sm.reportCount()
+
+		// The close listener may run on the sender's send worker, which Close
+		// waits for, so the sender is closed on its own goroutine.
+		go func() {
+			_ = s.Close()
+		}()
 	}
+}
 
+// Close closes all senders in the map.  The errors from the senders that fail
+// to close are joined, each naming its service.
+func (sm *senderMap) Close() error {
+	sm.lock.Lock()
+	senders := sm.senders
 	sm.senders = nil
+	sm.reportCount()
+
+	// Stop the registrations waiting to retry a dial.
+	if sm.stop != nil {
+		close(sm.stop)
+		sm.stop = nil
+	}
+	sm.lock.Unlock()
+
+	// Close outside the lock since closing triggers the close listener.  The
+	// names are sorted so the joined error is stable.
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(senders)) {
+		if err := senders[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// messageKey returns the name of the sender for the message.  The metadata
+// route key takes precedence if the message has it, then the route key
+// function if there is one, otherwise the destination locator is used.
+func (sm *senderMap) messageKey(msg wrp.Message) (string, error) {
+	if sm.metadataKey != "" {
+		if name := msg.Metadata[sm.metadataKey]; name != "" {
+			return name, nil
+		}
+	}
+
+	if sm.keyFunc != nil {
+		return sm.keyFunc(msg)
+	}
+
+	dest, err := wrp.ParseLocator(msg.To())
+	if err != nil {
+		return "", err
+	}
+	return sm.routeKey(dest), nil
+}
+
+// routeKey returns the name of the sender for the locator.  The service is
+// always last so wildcard prefixes work with any key.
+func (sm *senderMap) routeKey(l wrp.Locator) string {
+	switch sm.key {
+	case RouteBySchemeAndService:
+		return l.Scheme + ":" + l.Service
+	case RouteByLocator:
+		if l.HasDeviceID() {
+			return string(l.ID) + "/" + l.Service
+		}
+		return l.Scheme + ":" + l.Authority + "/" + l.Service
+	default:
+		return l.Service
+	}
+}
+
+// route returns the name and sender for the service, or a nil sender if there
+// isn't one.  An exact match is preferred.  If wildcards are enabled, a sender named with a
+// trailing "*" matches services starting with the rest of its name, and the
+// longest matching prefix wins.  A sender named "*" matches any service, but
+// only if nothing else does.  The lock must be held.
+func (sm *senderMap) route(service string) (string, limitedSender) {
+	if s := sm.senders[service]; s != nil || !sm.wildcards {
+		return service, s
+	}
+
+	var best limitedSender
+	var bestName string
+	bestLen := -1
+	for name, s := range sm.senders {
+		prefix, ok := strings.CutSuffix(name, "*")
+		if ok && len(prefix) > bestLen && strings.HasPrefix(service, prefix) {
+			best, bestName, bestLen = s, name, len(prefix)
+		}
+	}
+
+	return bestName, best
+}
+
+// check returns an error if s may not be registered under name.  The lock
+// must be held.
+func (sm *senderMap) check(name string, s limitedSender) error {
+	if err := sm.checkURL(name, s); err != nil {
+		return err
+	}
+	return sm.checkMax(name)
+}
+
+// checkMax returns ErrTooManySenders if there is a limit on the number of
+// senders, it was reached, and name is not one of them.  The lock must be held.
+func (sm *senderMap) checkMax(name string) error {
+	if sm.max <= 0 || len(sm.senders) < sm.max {
+		return nil
+	}
+
+	if _, ok := sm.senders[name]; ok {
+		return nil
+	}
+	return ErrTooManySenders
+}
+
+// checkURL returns ErrURLChanged if URL changes are rejected and the named
+// sender exists with a different URL than s.  The lock must be held.
+func (sm *senderMap) checkURL(name string, s limitedSender) error {
+	if !sm.rejectURLChange {
+		return nil
+	}
+
+	if existing := sm.senders[name]; existing != nil && existing.URL() != s.URL() {
+		return ErrURLChanged
+	}
 	return nil
 }
@@ -6,18 +6,32 @@ package wrpnng
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/wrpnng/internal/sender"
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/pull"
 )
 
 type mockSender struct {
 	processErr   error
 	processCount int
 	dialErr      error
+	dialErrs     []error
+	dialCount    int
+	closeErr     error
+	closeCount   int
+	health       sender.Health
+	connected    bool
+	url          string
+	format       wrp.Format
 }
 
 func (m *mockSender) ProcessWRP(_ context.Context, _ wrp.Message) error {
@@ -26,18 +40,145 @@ func (m *mockSender) ProcessWRP(_ context.Context, _ wrp.Message) error {
 }
 
 func (m *mockSender) Close() error {
-	return nil
+	m.closeCount++
+	return m.closeErr
 }
 
 func (m *mockSender) Dial() error {
+	m.dialCount++
+	if len(m.dialErrs) > 0 {
+		err := m.dialErrs[0]
+		m.dialErrs = m.dialErrs[1:]
+		return err
+	}
 	return m.dialErr
 }
 
+func (m *mockSender) Health() sender.Health {
+	return m.health
+}
+
+func (m *mockSender) IsConnected() bool {
+	return m.connected
+}
+
+func (m *mockSender) URL() string {
+	return m.url
+}
+
+func (m *mockSender) Format() wrp.Format {
+	return m.format
+}
+
+// stuckSender blocks until released, ignoring the context.
+type stuckSender struct {
+	mockSender
+	release chan struct{}
+}
+
+func (s *stuckSender) ProcessWRP(context.Context, wrp.Message) error {
+	<-s.release
+	return nil
+}
+
+// panicSender panics on every send.
+type panicSender struct {
+	mockSender
+}
+
+func (*panicSender) ProcessWRP(context.Context, wrp.Message) error {
+	panic("send failed")
+}
+
+// countingSender counts the messages sent, and is safe for concurrent use.
+type countingSender struct {
+	mockSender
+	count atomic.Int64
+}
+
+func (s *countingSender) ProcessWRP(context.Context, wrp.Message) error {
+	s.count.Add(1)
+	return nil
+}
+
+func TestSenderMap_Broadcast(t *testing.T) {
+	stuck := &stuckSender{release: make(chan struct{})}
+	defer close(stuck.release)
+
+	counter := &countingSender{}
+	panics := make(chan error, 1)
+	sm := &senderMap{
+		senders: map[string]limitedSender{
+			"stuck":   stuck,
+			"panics":  &panicSender{},
+			"counter": counter,
+		},
+		panicked: func(err error) {
+			panics <- err
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := sm.ProcessWRP(ctx, wrp.Message{Type: wrp.ServiceAliveMessageType})
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int64(1), counter.count.Load())
+
+	// The panic was passed to the handler instead of being swallowed.
+	select {
+	case err := <-panics:
+		assert.ErrorIs(t, err, ErrObserverPanic)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the panic wasn't reported")
+	}
+}
+
+// deadlineSender records if its context had a deadline.
+type deadlineSender struct {
+	mockSender
+	hadDeadline atomic.Bool
+}
+
+func (s *deadlineSender) ProcessWRP(ctx context.Context, _ wrp.Message) error {
+	_, ok := ctx.Deadline()
+	s.hadDeadline.Store(ok)
+	return nil
+}
+
+func TestSenderMap_BroadcastTimeout(t *testing.T) {
+	stuck := &stuckSender{release: make(chan struct{})}
+	defer close(stuck.release)
+
+	counter := &countingSender{}
+	deadline := &deadlineSender{}
+	sm := &senderMap{
+		senders: map[string]limitedSender{
+			"stuck":    stuck,
+			"counter":  counter,
+			"deadline": deadline,
+		},
+		sendTimeout: 20 * time.Millisecond,
+	}
+
+	// The caller's context has no deadline, but the slow sender can't stall
+	// the broadcast.
+	start := time.Now()
+	err := sm.ProcessWRP(context.Background(), wrp.Message{Type: wrp.ServiceAliveMessageType})
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int64(1), counter.count.Load())
+	assert.True(t, deadline.hadDeadline.Load())
+}
+
 func TestSenderMap_ProcessWRP(t *testing.T) {
 	randomErr := errors.New("random error")
 	tests := []struct {
 		name        string
 		senders     map[string]*mockSender
+		keyFunc     func(wrp.Message) (string, error)
 		msg         wrp.Message
 		expect      map[string]*mockSender
 		expectedErr error
@@ -97,6 +238,40 @@ func TestSenderMap_ProcessWRP(t *testing.T) {
 				Destination: "service_1/ignored",
 			},
 			expectedErr: wrp.ErrorInvalidLocator,
+		}, {
+			name: "Route key function",
+			senders: map[string]*mockSender{
+				"service_1": {},
+				"service_2": {},
+			},
+			keyFunc: func(msg wrp.Message) (string, error) {
+				return msg.Headers[0], nil
+			},
+			msg: wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Destination: "mac:112233445566/service_1/ignored",
+				Headers:     []string{"service_2"},
+			},
+			expect: map[string]*mockSender{
+				"service_1": {},
+				"service_2": {processCount: 1},
+			},
+		}, {
+			name: "Route key function error",
+			senders: map[string]*mockSender{
+				"service_1": {},
+			},
+			keyFunc: func(wrp.Message) (string, error) {
+				return "", randomErr
+			},
+			msg: wrp.Message{
+				Type:        wrp.SimpleRequestResponseMessageType,
+				Destination: "mac:112233445566/service_1/ignored",
+			},
+			expectedErr: randomErr,
+			expect: map[string]*mockSender{
+				"service_1": {},
+			},
 		},
 	}
 
@@ -104,6 +279,7 @@ func TestSenderMap_ProcessWRP(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			sm := &senderMap{
 				senders: make(map[string]limitedSender),
+				keyFunc: tt.keyFunc,
 			}
 
 			for k, v := range tt.senders {
@@ -127,6 +303,237 @@ func TestSenderMap_ProcessWRP(t *testing.T) {
 	}
 }
 
+func TestSenderMap_route(t *testing.T) {
+	names := []string{"config", "config*", "conf*", "*"}
+
+	tests := []struct {
+		name      string
+		senders   []string
+		wildcards bool
+		service   string
+		want      string
+	}{
+		{
+			name:      "Exact match",
+			senders:   names,
+			wildcards: true,
+			service:   "config",
+			want:      "config",
+		}, {
+			name:      "Longest prefix match",
+			senders:   names,
+			wildcards: true,
+			service:   "config-v2",
+			want:      "config*",
+		}, {
+			name:      "Shorter prefix match",
+			senders:   names,
+			wildcards: true,
+			service:   "conference",
+			want:      "conf*",
+		}, {
+			name:      "Catch-all",
+			senders:   names,
+			wildcards: true,
+			service:   "metrics",
+			want:      "*",
+		}, {
+			name:      "No match",
+			senders:   []string{"config", "conf*"},
+			wildcards: true,
+			service:   "metrics",
+		}, {
+			name:    "Exact match only by default",
+			senders: names,
+			service: "config-v2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &senderMap{
+				senders:   make(map[string]limitedSender),
+				wildcards: tt.wildcards,
+			}
+			for _, name := range tt.senders {
+				sm.senders[name] = &mockSender{url: name}
+			}
+
+			msg := wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/" + tt.service + "/ignored",
+			}
+			err := sm.ProcessWRP(context.Background(), msg)
+
+			if tt.want == "" {
+				assert.ErrorIs(t, err, wrp.ErrNotHandled)
+				for _, name := range tt.senders {
+					assert.Zero(t, sm.senders[name].(*mockSender).processCount, name)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			for _, name := range tt.senders {
+				want := 0
+				if name == tt.want {
+					want = 1
+				}
+				assert.Equal(t, want, sm.senders[name].(*mockSender).processCount, name)
+			}
+		})
+	}
+}
+
+func TestSenderMap_SendError(t *testing.T) {
+	errUnknown := errors.New("unknown")
+
+	tests := []struct {
+		name       string
+		processErr error
+		expectErr  []error
+	}{
+		{
+			name: "success",
+		}, {
+			name:       "plain error",
+			processErr: errUnknown,
+			expectErr:  []error{errUnknown},
+		}, {
+			name: "sender error",
+			processErr: &sender.Error{
+				URL: "tcp://127.0.0.1:6000",
+				Err: errors.Join(sender.ErrFailedToSend, errUnknown),
+			},
+			expectErr: []error{ErrFailedToSend, errUnknown},
+		}, {
+			name: "connection closed",
+			processErr: &sender.Error{
+				URL: "tcp://127.0.0.1:6000",
+				Err: sender.ErrConnClosed,
+			},
+			expectErr: []error{ErrConnClosed, ErrServiceDisconnected},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &senderMap{
+				senders: map[string]limitedSender{
+					"config": &mockSender{
+						url:        "tcp://127.0.0.1:6000",
+						processErr: tt.processErr,
+					},
+				},
+			}
+
+			msg := wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: "mac:112233445566/config",
+			}
+			errs := map[string]error{
+				"routed": sm.ProcessWRP(context.Background(), msg),
+				"sendTo": sm.sendTo(context.Background(), "config", msg),
+			}
+
+			for how, err := range errs {
+				if tt.expectErr == nil {
+					assert.NoError(t, err, how)
+					continue
+				}
+
+				for _, want := range tt.expectErr {
+					assert.ErrorIs(t, err, want, how)
+				}
+
+				var se *SendError
+				require.ErrorAs(t, err, &se, how)
+				assert.Equal(t, "config", se.Service, how)
+				assert.Equal(t, "tcp://127.0.0.1:6000", se.URL, how)
+
+				// The URL is only included once.
+				assert.Equal(t, 1, strings.Count(err.Error(), se.URL), how)
+			}
+		})
+	}
+}
+
+func TestSenderMap_routeKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     RouteKey
+		senders []string
+		dest    string
+		want    string
+	}{
+		{
+			name:    "Service only",
+			senders: []string{"config"},
+			dest:    "serial:1234/config",
+			want:    "config",
+		}, {
+			name:    "Scheme and service, mac",
+			key:     RouteBySchemeAndService,
+			senders: []string{"mac:config", "serial:config"},
+			dest:    "mac:112233445566/config",
+			want:    "mac:config",
+		}, {
+			name:    "Scheme and service, serial",
+			key:     RouteBySchemeAndService,
+			senders: []string{"mac:config", "serial:config"},
+			dest:    "serial:1234/config",
+			want:    "serial:config",
+		}, {
+			name:    "Scheme and service, no match",
+			key:     RouteBySchemeAndService,
+			senders: []string{"mac:config", "serial:config"},
+			dest:    "uuid:1234/config",
+		}, {
+			name:    "Locator, device",
+			key:     RouteByLocator,
+			senders: []string{"mac:112233445566/config", "mac:aabbccddeeff/config"},
+			dest:    "MAC:11-22-33-44-55-66/config",
+			want:    "mac:112233445566/config",
+		}, {
+			name:    "Locator, dns",
+			key:     RouteByLocator,
+			senders: []string{"dns:example.com/config", "mac:112233445566/config"},
+			dest:    "dns:example.com/config",
+			want:    "dns:example.com/config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &senderMap{
+				senders: make(map[string]limitedSender),
+				key:     tt.key,
+			}
+			for _, name := range tt.senders {
+				sm.senders[name] = &mockSender{}
+			}
+
+			err := sm.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Destination: tt.dest,
+			})
+
+			if tt.want == "" {
+				assert.ErrorIs(t, err, wrp.ErrNotHandled)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, name := range tt.senders {
+				want := 0
+				if name == tt.want {
+					want = 1
+				}
+				assert.Equal(t, want, sm.senders[name].(*mockSender).processCount, name)
+			}
+		})
+	}
+}
+
 func TestSenderMap_upsert(t *testing.T) {
 	factory := func(opts ...sender.Option) (limitedSender, error) {
 		return &mockSender{}, nil
@@ -138,7 +545,10 @@ func TestSenderMap_upsert(t *testing.T) {
 		upsertName     string
 		factory        limitedSenderFactory
 		opts           []sender.Option
+		rejectURL      bool
+		max            int
 		expectError    bool
+		expectErrIs    error
 	}{
 		{
 			name:       "Upsert new sender",
@@ -165,22 +575,89 @@ func TestSenderMap_upsert(t *testing.T) {
 				}, nil
 			},
 			expectError: true,
+		}, {
+			name: "Re-register with the same url when url changes are rejected",
+			initialSenders: map[string]limitedSender{
+				"service_1": &mockSender{url: "tcp://127.0.0.1:1"},
+			},
+			upsertName: "service_1",
+			factory: func(opts ...sender.Option) (limitedSender, error) {
+				return &mockSender{url: "tcp://127.0.0.1:1"}, nil
+			},
+			rejectURL: true,
+		}, {
+			name: "Re-register with a different url when url changes are rejected",
+			initialSenders: map[string]limitedSender{
+				"service_1": &mockSender{url: "tcp://127.0.0.1:1"},
+			},
+			upsertName: "service_1",
+			factory: func(opts ...sender.Option) (limitedSender, error) {
+				return &mockSender{url: "tcp://127.0.0.1:2"}, nil
+			},
+			rejectURL:   true,
+			expectError: true,
+			expectErrIs: ErrURLChanged,
+		}, {
+			name: "Re-register with a different url",
+			initialSenders: map[string]limitedSender{
+				"service_1": &mockSender{url: "tcp://127.0.0.1:1"},
+			},
+			upsertName: "service_1",
+			factory: func(opts ...sender.Option) (limitedSender, error) {
+				return &mockSender{url: "tcp://127.0.0.1:2"}, nil
+			},
+		}, {
+			name: "New sender below the max",
+			initialSenders: map[string]limitedSender{
+				"service_1": new(mockSender),
+			},
+			upsertName: "service_2",
+			max:        2,
+		}, {
+			name: "New sender at the max",
+			initialSenders: map[string]limitedSender{
+				"service_1": new(mockSender),
+				"service_2": new(mockSender),
+			},
+			upsertName:  "service_3",
+			max:         2,
+			expectError: true,
+			expectErrIs: ErrTooManySenders,
+		}, {
+			name: "Existing sender at the max",
+			initialSenders: map[string]limitedSender{
+				"service_1": new(mockSender),
+				"service_2": new(mockSender),
+			},
+			upsertName: "service_2",
+			max:        2,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			sm := &senderMap{
-				senders: tt.initialSenders,
+				senders:         tt.initialSenders,
+				rejectURLChange: tt.rejectURL,
+				max:             tt.max,
 			}
+			existing := sm.senders[tt.upsertName]
 
 			if tt.factory == nil {
 				tt.factory = factory
 			}
 
-			err := sm.upsert(tt.upsertName, tt.opts, tt.factory)
+			_, err := sm.upsert(context.Background(), tt.upsertName, tt.opts, tt.factory)
 			if tt.expectError {
 				assert.Error(t, err)
+				if tt.expectErrIs != nil {
+					assert.ErrorIs(t, err, tt.expectErrIs)
+				}
+				if existing != nil {
+					assert.Same(t, existing, sm.senders[tt.upsertName])
+				} else {
+					assert.NotContains(t, sm.senders, tt.upsertName)
+				}
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, sm.senders[tt.upsertName])
@@ -189,6 +666,85 @@ func TestSenderMap_upsert(t *testing.T) {
 	}
 }
 
+// dialCounter counts the dials of the senders it creates.
+type dialCounter struct {
+	dials int
+	url   string
+}
+
+func (d *dialCounter) factory(...sender.Option) (limitedSender, error) {
+	return &countingDialSender{
+		mockSender: mockSender{url: d.url, connected: true},
+		counter:    d,
+	}, nil
+}
+
+type countingDialSender struct {
+	mockSender
+	counter *dialCounter
+}
+
+func (s *countingDialSender) Dial() error {
+	s.counter.dials++
+	return nil
+}
+
+func TestSenderMap_UpsertReuse(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    *mockSender
+		url         string
+		expectReuse bool
+	}{
+		{
+			name:        "same url",
+			existing:    &mockSender{url: "tcp://127.0.0.1:1", connected: true},
+			url:         "tcp://127.0.0.1:1",
+			expectReuse: true,
+		}, {
+			name:     "different url",
+			existing: &mockSender{url: "tcp://127.0.0.1:1", connected: true},
+			url:      "tcp://127.0.0.1:2",
+		}, {
+			name:     "same url but disconnected",
+			existing: &mockSender{url: "tcp://127.0.0.1:1"},
+			url:      "tcp://127.0.0.1:1",
+		}, {
+			name: "same url but a different format",
+			existing: &mockSender{
+				url:       "tcp://127.0.0.1:1",
+				connected: true,
+				format:    wrp.JSON,
+			},
+			url: "tcp://127.0.0.1:1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &senderMap{
+				senders: map[string]limitedSender{
+					"service": tt.existing,
+				},
+			}
+			d := &dialCounter{url: tt.url}
+
+			replaced, err := sm.upsert(context.Background(), "service", nil, d.factory)
+			require.NoError(t, err)
+			assert.True(t, replaced)
+
+			if tt.expectReuse {
+				assert.Zero(t, d.dials)
+				assert.Same(t, tt.existing, sm.senders["service"])
+				assert.Equal(t, 1, tt.existing.processCount, "authorized again")
+				return
+			}
+			assert.Equal(t, 1, d.dials)
+			assert.NotSame(t, tt.existing, sm.senders["service"])
+		})
+	}
+}
+
 func TestSenderMap_Remove(t *testing.T) {
 	sm := &senderMap{
 		senders: make(map[string]limitedSender),
@@ -212,3 +768,317 @@ func TestSenderMap_Close(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, sm.senders)
 }
+
+func TestSenderMap_CloseError(t *testing.T) {
+	errClose := errors.New("close failed")
+
+	sm := &senderMap{
+		senders: map[string]limitedSender{
+			"service1": &mockSender{},
+			"service2": &mockSender{closeErr: errClose},
+		},
+	}
+
+	err := sm.Close()
+	assert.ErrorIs(t, err, errClose)
+	assert.ErrorContains(t, err, "service2")
+	assert.NotContains(t, err.Error(), "service1")
+	assert.Nil(t, sm.senders)
+}
+
+func TestSenderMap_CloseErrorClosesOthers(t *testing.T) {
+	errClose1 := errors.New("close 1 failed")
+	errClose3 := errors.New("close 3 failed")
+
+	senders := []*mockSender{
+		{closeErr: errClose1},
+		{},
+		{closeErr: errClose3},
+		{},
+	}
+
+	sm := &senderMap{
+		senders: make(map[string]limitedSender),
+	}
+	for i, s := range senders {
+		sm.senders[fmt.Sprintf("service%d", i)] = s
+	}
+
+	err := sm.Close()
+	assert.ErrorIs(t, err, errClose1)
+	assert.ErrorIs(t, err, errClose3)
+
+	// Every sender was closed exactly once, even after a failure.
+	for i, s := range senders {
+		assert.Equal(t, 1, s.closeCount, "service%d", i)
+	}
+}
+
+func TestSenderMap_IsConnected(t *testing.T) {
+	sm := &senderMap{
+		senders: map[string]limitedSender{
+			"connected":    &mockSender{connected: true},
+			"disconnected": &mockSender{},
+		},
+	}
+
+	assert.True(t, sm.IsConnected("connected"))
+	assert.False(t, sm.IsConnected("disconnected"))
+	assert.False(t, sm.IsConnected("missing"))
+}
+
+func TestSenderMap_DialRetry(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	retry := Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, MaxFailures: 3}
+
+	tests := []struct {
+		name        string
+		backoff     Backoff
+		dialErrs    []error
+		dialErr     error
+		expectDials int
+		expectErr   error
+	}{
+		{
+			name:        "Connects the first time",
+			backoff:     retry,
+			expectDials: 1,
+		}, {
+			name:        "No retry by default",
+			dialErrs:    []error{errRefused},
+			expectDials: 1,
+			expectErr:   errRefused,
+		}, {
+			name:        "Fails once then connects",
+			backoff:     retry,
+			dialErrs:    []error{errRefused},
+			expectDials: 2,
+		}, {
+			name:        "Gives up",
+			backoff:     retry,
+			dialErr:     errRefused,
+			expectDials: 3,
+			expectErr:   errRefused,
+		}, {
+			name:        "Permanent failure",
+			backoff:     retry,
+			dialErr:     mangos.ErrBadTran,
+			expectDials: 1,
+			expectErr:   mangos.ErrBadTran,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := &mockSender{
+				dialErrs: tt.dialErrs,
+				dialErr:  tt.dialErr,
+			}
+			sm := &senderMap{
+				dialBackoff: tt.backoff,
+			}
+
+			_, err := sm.upsert(context.Background(), "service", nil, func(...sender.Option) (limitedSender, error) {
+				return ms, nil
+			})
+
+			assert.Equal(t, tt.expectDials, ms.dialCount)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				assert.Nil(t, sm.senders["service"])
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, ms, sm.senders["service"])
+		})
+	}
+}
+
+func TestSenderMap_DialRetryStops(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	retry := Backoff{Initial: time.Hour, MaxFailures: 3}
+
+	tests := []struct {
+		name      string
+		stop      func(*senderMap, context.CancelFunc)
+		expectErr error
+	}{
+		{
+			name: "Context canceled",
+			stop: func(_ *senderMap, cancel context.CancelFunc) {
+				cancel()
+			},
+			expectErr: context.Canceled,
+		}, {
+			name: "Map closed",
+			stop: func(sm *senderMap, _ context.CancelFunc) {
+				_ = sm.Close()
+			},
+			expectErr: errRefused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := &mockSender{dialErr: errRefused}
+			sm := &senderMap{dialBackoff: retry}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := sm.upsert(ctx, "service", nil, func(...sender.Option) (limitedSender, error) {
+					return ms, nil
+				})
+				done <- err
+			}()
+
+			// The first dial has failed once the upsert is waiting to retry.
+			time.Sleep(50 * time.Millisecond)
+			tt.stop(sm, cancel)
+
+			select {
+			case err := <-done:
+				assert.ErrorIs(t, err, tt.expectErr)
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "the dial retry wasn't stopped")
+			}
+			assert.Equal(t, 1, ms.dialCount)
+			assert.Nil(t, sm.senders["service"])
+		})
+	}
+}
+
+func TestSenderMap_DialRetryBadURL(t *testing.T) {
+	sm := &senderMap{
+		dialBackoff: Backoff{Initial: time.Hour, MaxFailures: 3},
+	}
+
+	// A retry would wait for an hour, so returning at all shows there was none.
+	start := time.Now()
+	_, err := sm.Upsert(context.Background(), "service", []sender.Option{
+		sender.WithURL("bogus://127.0.0.1:1"),
+	})
+	assert.ErrorIs(t, err, mangos.ErrBadTran)
+	assert.Less(t, time.Since(start), time.Minute)
+	assert.Nil(t, sm.senders["service"])
+}
+
+func TestPermanentDialErr(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		url       string
+		permanent bool
+	}{
+		{name: "Unknown transport", url: "bogus://127.0.0.1:1", permanent: true},
+		{name: "Nothing listening", url: url},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := sender.New(sender.WithURL(tt.url))
+			require.NoError(t, err)
+			defer s.Close() // nolint:errcheck
+
+			err = s.Dial()
+			require.Error(t, err)
+			assert.Equal(t, tt.permanent, permanentDialErr(err))
+		})
+	}
+}
+
+func TestWithDialRetry(t *testing.T) {
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithDialRetry(Backoff{Initial: time.Millisecond, MaxFailures: 3}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, Backoff{Initial: time.Millisecond, MaxFailures: 3}, srv.senders.dialBackoff)
+
+	srv, err = NewServer(
+		withReceiver(&mockReceiver{}),
+		WithDialRetry(Backoff{MaxFailures: -1}),
+	)
+	assert.Error(t, err)
+	assert.Nil(t, srv)
+}
+
+func TestSenderMap_NoOrphanAfterFailedSend(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []sender.Option
+	}{
+		{
+			name: "Send retries",
+			opts: []sender.Option{sender.WithSendRetries(5, 100*time.Millisecond)},
+		}, {
+			name: "Auto redial",
+			opts: []sender.Option{sender.WithAutoRedial()},
+		},
+	}
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566",
+		Destination: "event:service",
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("inproc://sendermap-orphan-%d", i)
+			peer, err := pull.NewSocket()
+			require.NoError(t, err)
+			require.NoError(t, peer.Listen(url))
+
+			var s *sender.Sender
+			sm := &senderMap{}
+			opts := append([]sender.Option{
+				sender.WithURL(url),
+				sender.WithSendTimeout(20 * time.Millisecond),
+			}, tt.opts...)
+			_, err = sm.upsert(context.Background(), "service", opts,
+				func(opts ...sender.Option) (limitedSender, error) {
+					var err error
+					s, err = sender.New(opts...)
+					return s, err
+				})
+			require.NoError(t, err)
+			defer s.Close() // nolint:errcheck
+
+			// Once the peer is gone, the queue fills and a send fails, which
+			// removes the sender from the map.
+			require.NoError(t, peer.Close())
+			failed := make(chan struct{})
+			go func() {
+				defer close(failed)
+				for j := 0; j < 3; j++ {
+					if s.ProcessWRP(context.Background(), msg) != nil {
+						return
+					}
+				}
+			}()
+
+			// The peer comes back while the failed send is still retrying.
+			time.Sleep(50 * time.Millisecond)
+			peer, err = pull.NewSocket()
+			require.NoError(t, err)
+			require.NoError(t, peer.Listen(url))
+			defer peer.Close() // nolint:errcheck
+			<-failed
+
+			sm.lock.RLock()
+			assert.Nil(t, sm.senders["service"])
+			sm.lock.RUnlock()
+
+			// The removed sender must not connect again outside the map.
+			assert.Eventually(t, func() bool {
+				_ = s.ProcessWRP(context.Background(), msg)
+				return !s.IsConnected()
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
@@ -6,18 +6,91 @@ package wrpnng
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xmidt-org/eventor"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/filters"
 	"github.com/xmidt-org/wrpnng/internal/processors/stopping"
 	"github.com/xmidt-org/wrpnng/internal/receiver"
 	"github.com/xmidt-org/wrpnng/internal/sender"
 )
 
 var (
+	// ErrNoRoute is returned by Server.ProcessWRP when the message was not
+	// rejected, but there is no registered service for its destination.  It
+	// is returned joined with wrp.ErrNotHandled.
+	ErrNoRoute = errors.New("no route to destination")
+
+	// ErrInvalidMessage is returned by Server.ProcessWRP when message
+	// validation is enabled and the message is not valid.
+	ErrInvalidMessage = filters.ErrInvalidMessage
+
+	// ErrURLChanged is returned when a service re-registers with a different
+	// URL and the Server was created using WithRejectURLChange.
+	ErrURLChanged = errors.New("service registered with a different url")
+
+	// ErrTooManySenders is returned when a new service registers and the
+	// number of registered services set using WithMaxSenders was reached.
+	ErrTooManySenders = errors.New("too many registered services")
+
+	// ErrStartTimeout is returned by Start when the receiver doesn't start
+	// within the timeout set using WithStartTimeout.
+	ErrStartTimeout = errors.New("timed out starting the receiver")
+
+	// ErrReceiverRestart is returned by Run when the receiver failed and could
+	// not be restarted within the limits of the restart Backoff.
+	ErrReceiverRestart = errors.New("receiver restart failed")
+
+	// ErrConnClosed is returned, wrapped in a SendError, when the connection
+	// to a registered service is closed.
+	ErrConnClosed = sender.ErrConnClosed
+
+	// ErrServiceDisconnected is returned, wrapped in a SendError along with
+	// ErrConnClosed, when a message is routed to a service that is registered
+	// but whose connection is closed.  Unlike ErrNoRoute, the service may
+	// still be removed or re-register, so the send may be retried.
+	ErrServiceDisconnected = errors.New("service disconnected")
+
+	// ErrFailedToSend is returned, wrapped in a SendError, when sending to a
+	// registered service failed, closing the connection.
+	ErrFailedToSend = sender.ErrFailedToSend
+
+	// ErrDropped is returned, wrapped in a SendError, when a message was
+	// dropped because the connection's queue was full.  See WithDropOnFull.
+	ErrDropped = sender.ErrDropped
+
+	// ErrNotRequest is returned by RoundTrip when the message is not a
+	// SimpleRequestResponse message.
+	ErrNotRequest = errors.New("message is not a request")
+
+	// ErrDuplicateTransaction is returned by RoundTrip when another RoundTrip
+	// is already waiting for a response with the same transaction UUID.
+	ErrDuplicateTransaction = errors.New("transaction already in progress")
+
+	// ErrRoutingLoop is returned by Server.ProcessWRP when WithLoopGuard is
+	// used and the message would be sent to the service it came from.
+	ErrRoutingLoop = errors.New("message routed back to its source")
+
+	// ErrInboundTimeout is returned, along with context.DeadlineExceeded,
+	// when a message received from the network isn't handled within the
+	// timeout set using WithInboundTimeout.  It is also returned by
+	// Server.ProcessWRP when it is passed the context of such a message.
+	ErrInboundTimeout = errors.New("timed out handling an inbound message")
+
+	// ErrObserverPanic is passed to the observer error handler when an
+	// observer or modifier panics.
+	ErrObserverPanic = receiver.ErrHandlerPanic
+
 	errInvalidMsg = errors.New("invalid message")
+	errNotRunning = errors.New("server is not running")
 )
 
 // Server is a simple controller for managing a receiver and a set of senders.
@@ -30,20 +103,54 @@ var (
 //   - tx describes the messages being sent out.
 //   - rx describes the messages being received.
 type Server struct {
-	rOpts []receiver.Option
-	r     *receiver.Receiver
-
-	sOpts []sender.Option
-
-	egress eventor.Eventor[wrp.Modifier]
+	name string
 
-	senders senderMap
-
-	rxObservers  wrp.Observers
-	txObservers  wrp.Observers
-	ingressChain stopping.Processors
-
-	heartbeatInterval time.Duration
+	rOpts []receiver.Option
+	r     receiverIface
+
+	sOpts   []sender.Option
+	formats []wrp.Format
+
+	egress      eventor.Eventor[wrp.Modifier]
+	egressMods  []*egressEntry
+	egressProcs wrp.Modifiers
+	rxTransform wrp.Modifiers
+	hooks       []func(context.Context, *wrp.Message) error
+
+	senders       senderMap
+	sources       sourceRegistry
+	registered    eventor.Eventor[func(name, url string, reregistered bool)]
+	nameValidator func(string) error
+	authorizer    func(context.Context, wrp.Message) error
+
+	rxObservers    eventor.Eventor[wrp.Observer]
+	replay         *replayBuffer
+	txObservers    wrp.Observers
+	observerErr    func(error)
+	deadLetter     wrp.Observer
+	tracer         Tracer
+	roundTrips     roundTrips
+	rxChain        stopping.Processors
+	ingressChain   stopping.Processors
+	outbound       wrp.Modifiers
+	outboundSource wrp.Modifier
+	ingressProcs   map[Position][]wrp.Processor
+
+	rxFailed          chan error
+	rxFailure         eventor.Eventor[func(error)]
+	restartRX         bool
+	rxBackoff         Backoff
+	running           context.Context
+	baseCtx           context.Context
+	stopOnDone        func() bool
+	startTimeout      time.Duration
+	heartbeatInterval atomic.Int64
+	heartbeatChanged  chan struct{}
+	heartbeatJitter   float64
+	roundTripTimeout  time.Duration
+	inboundTimeout    time.Duration
+	clock             clock
+	random            func() float64
 	heartbeatCancel   context.CancelFunc
 	wg                sync.WaitGroup
 	lock              sync.Mutex
@@ -51,14 +158,39 @@ type Server struct {
 
 var _ wrp.Processor = (*Server)(nil)
 
+// unsupportedType rejects messages with a type that can't be processed.
+var unsupportedType = filters.ErrorOnUnsupportedMsgTypes()
+
+// egressEntry tracks an egress modifier so it can be removed later.
+type egressEntry struct {
+	m      wrp.Modifier
+	cancel func()
+}
+
+// receiverIface is the subset of the receiver.Receiver that the Server depends
+// on.  It allows tests to inject a fake receiver instead of using real sockets.
+type receiverIface interface {
+	Listen() error
+	Close() error
+}
+
 // NewServer creates a new Controller.  The controller is not started until Start is
 // called.  The controller handles the registration message and sends heartbeats
-// at regular intervals.  The default heartbeat interval is 30 seconds.
+// at regular intervals.  The default heartbeat interval is 30 seconds, and the
+// default source expiry is 1 hour.
 func NewServer(opts ...ServerOption) (*Server, error) {
-	var srv Server
+	srv := Server{
+		heartbeatChanged: make(chan struct{}, 1),
+	}
 
 	defaults := []ServerOption{ // nolint:prealloc
 		WithHeartbeatInterval(30 * time.Second),
+		WithSourceExpiry(time.Hour),
+		WithObserverErrorHandler(nil),
+		WithBroadcastTimeout(DefaultBroadcastTimeout),
+		WithRoundTripTimeout(DefaultRoundTripTimeout),
+		withClock(realClock{}),
+		withRandom(rand.Float64),
 	}
 
 	vadors := []ServerOption{
@@ -80,7 +212,10 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 	return &srv, nil
 }
 
-// Start begins listening for messages.  It is idempotent.
+// Start begins listening for messages.  It is idempotent.  If the Server was
+// created using WithBaseContext, it is stopped when that context is done.  If
+// the receiver fails to start, or doesn't start within the timeout set using
+// WithStartTimeout, the heartbeats are stopped and Start can be called again.
 func (srv *Server) Start() error {
 	srv.lock.Lock()
 	defer srv.lock.Unlock()
@@ -89,12 +224,167 @@ func (srv *Server) Start() error {
 		return nil
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	base := srv.baseCtx
+	if base == nil {
+		base = context.Background()
+	}
+
+	// Stop is called on its own goroutine, so it can't deadlock with a Stop
+	// that is already waiting for the lock.
+	srv.stopOnDone = context.AfterFunc(base, func() {
+		_ = srv.Stop()
+	})
+
+	ctx, cancel := context.WithCancel(base)
+	srv.running = ctx
 
+	// The heartbeats may be enabled later using SetHeartbeatInterval, so
+	// the loop always runs.
 	srv.heartbeatCancel = cancel
 	srv.wg.Add(1)
 	go srv.sendHeartbeat(ctx)
 
+	if err := srv.listen(ctx); err != nil {
+		srv.abortStart()
+		return err
+	}
+
+	return nil
+}
+
+// listen starts the receiver.  If a start timeout is set and the receiver
+// doesn't start in time, ErrStartTimeout is returned.  The receiver is closed
+// if it starts later, unless the Server was started again.  The lock must be
+// held.
+func (srv *Server) listen(ctx context.Context) error {
+	if srv.startTimeout <= 0 {
+		return srv.r.Listen()
+	}
+
+	rv := make(chan error, 1)
+	go func() {
+		rv <- srv.r.Listen()
+	}()
+
+	t := time.NewTimer(srv.startTimeout)
+	defer t.Stop()
+
+	select {
+	case err := <-rv:
+		return err
+	case <-t.C:
+	}
+
+	go func() {
+		if err := <-rv; err != nil {
+			return
+		}
+
+		srv.lock.Lock()
+		defer srv.lock.Unlock()
+
+		if srv.running == ctx {
+			_ = srv.r.Close()
+		}
+	}()
+
+	return ErrStartTimeout
+}
+
+// abortStart undoes a Start that failed, stopping the heartbeats, so Start
+// can be called again.  The lock must be held.
+func (srv *Server) abortStart() {
+	if srv.stopOnDone != nil {
+		srv.stopOnDone()
+		srv.stopOnDone = nil
+	}
+
+	srv.heartbeatCancel()
+	srv.heartbeatCancel = nil
+	srv.wg.Wait()
+}
+
+// Run starts the Server and blocks until the context is done or the receiver
+// fails, then stops the Server.  Canceling the context is a clean shutdown, so
+// only errors from the receiver and from stopping are returned.
+func (srv *Server) Run(ctx context.Context) error {
+	if err := srv.Start(); err != nil {
+		return errors.Join(err, srv.Stop())
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-srv.rxFailed:
+	}
+
+	return errors.Join(err, srv.Stop())
+}
+
+// receiverClosed is called when the receiver stops.  Closing the receiver is
+// expected, but any other reason is a failure.  Failures are passed to the
+// failure listeners, and unless the receiver is restarted, reported to Run.
+func (srv *Server) receiverClosed(err error) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	srv.rxFailure.Visit(func(f func(error)) {
+		f(err)
+	})
+
+	if srv.restartRX {
+		rerr := srv.restartReceiver()
+		if rerr == nil {
+			return
+		}
+		err = errors.Join(err, rerr)
+	}
+
+	select {
+	case srv.rxFailed <- err:
+	default:
+	}
+}
+
+// restartReceiver listens again after the backoff delay, retrying until it
+// works, the Server is stopped, or the backoff gives up.
+func (srv *Server) restartReceiver() error {
+	srv.lock.Lock()
+	ctx := srv.running
+	srv.lock.Unlock()
+
+	if ctx == nil {
+		return errNotRunning
+	}
+
+	var err error
+	for failures := 0; !srv.rxBackoff.giveUp(failures); failures++ {
+		select {
+		case <-ctx.Done():
+			return errNotRunning
+		case <-srv.clock.After(srv.rxBackoff.delay(failures)):
+		}
+
+		err = srv.listenIfRunning(ctx)
+		if err == nil || errors.Is(err, errNotRunning) {
+			return err
+		}
+	}
+
+	return errors.Join(ErrReceiverRestart, err)
+}
+
+// listenIfRunning starts the receiver unless the Server was stopped.  The lock
+// keeps Stop from closing the receiver while it is being started.
+func (srv *Server) listenIfRunning(ctx context.Context) error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if ctx.Err() != nil {
+		return errNotRunning
+	}
+
 	return srv.r.Listen()
 }
 
@@ -103,6 +393,11 @@ func (srv *Server) Stop() error {
 	srv.lock.Lock()
 	defer srv.lock.Unlock()
 
+	if srv.stopOnDone != nil {
+		srv.stopOnDone()
+		srv.stopOnDone = nil
+	}
+
 	if srv.heartbeatCancel != nil {
 		srv.heartbeatCancel()
 		srv.heartbeatCancel = nil
@@ -117,12 +412,267 @@ func (srv *Server) Stop() error {
 	return err
 }
 
-// ProcessWRP is called when a message should be sent to the network.
+// ProcessWRP is called when a message should be sent to the network.  If the
+// message is not rejected, but there is no registered service for the
+// destination, the error returned matches both ErrNoRoute and wrp.ErrNotHandled.
+// A message with an invalid type, such as a zero-value message, is rejected with
+// an error matching ErrInvalidMessage before any processors see it.  If sending
+// to the service fails, the error is a *SendError naming the service; if the
+// service is registered but disconnected, it matches ErrServiceDisconnected.  A
+// nil context is treated as context.Background().  If a Tracer was set using
+// WithTracer, the message is processed in a span named SpanProcess.
 func (srv *Server) ProcessWRP(ctx context.Context, msg wrp.Message) error {
-	return srv.ingressChain.ProcessWRP(ctx, msg)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, end := srv.startSpan(ctx, SpanProcess, msg)
+	err := srv.process(ctx, msg)
+	end(err)
+
+	return err
+}
+
+// process is ProcessWRP without the span.
+func (srv *Server) process(ctx context.Context, msg wrp.Message) error {
+	if err := unsupportedType(ctx, msg); !errors.Is(err, wrp.ErrNotHandled) {
+		return errors.Join(ErrInvalidMessage, err)
+	}
+
+	if srv.outboundSource != nil {
+		msg, _ = srv.outboundSource.ModifyWRP(ctx, msg)
+	}
+
+	msg, err := srv.outbound.ModifyWRP(ctx, msg)
+	if err != nil && !errors.Is(err, wrp.ErrNotHandled) {
+		return err
+	}
+
+	err = srv.ingressChain.ProcessWRP(ctx, msg)
+	if errors.Is(err, wrp.ErrNotHandled) {
+		return errors.Join(ErrNoRoute, err)
+	}
+
+	return inboundTimedOut(ctx, err)
+}
+
+// inboundTimedOut adds ErrInboundTimeout to the error if the inbound timeout
+// of the context expired.
+func inboundTimedOut(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrInboundTimeout) {
+		return errors.Join(ErrInboundTimeout, err)
+	}
+	return err
+}
+
+// AddEgressModifier adds a modifier to the list of modifiers that are informed
+// of messages leaving the controller, the same as WithEgressModifier.  It may be
+// called at any time, including from a modifier.  The returned function removes
+// the modifier, and may also be called from a modifier.  A change made while a
+// message is being passed to the modifiers applies from the next message.
+func (srv *Server) AddEgressModifier(m wrp.Modifier) (cancel func()) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	return srv.addEgressModifier(m)
+}
+
+// RemoveEgressModifier removes all instances of the modifier from the list of
+// modifiers that are informed of messages leaving the controller.  It returns
+// true if any were removed.  Modifiers that are not comparable, such as a
+// wrp.ModifierFunc, can't be found and must be removed using the cancel
+// function returned when they were added.
+func (srv *Server) RemoveEgressModifier(m wrp.Modifier) bool {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	var removed bool
+	for _, entry := range slices.Clone(srv.egressMods) {
+		if sameModifier(entry.m, m) {
+			srv.removeEgressEntry(entry)
+			removed = true
+		}
+	}
+
+	return removed
+}
+
+// EgressModifierCount returns the number of modifiers that are informed of
+// messages leaving the controller, added using WithEgressModifier or
+// AddEgressModifier.
+func (srv *Server) EgressModifierCount() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	return len(srv.egressMods)
+}
+
+// ClearEgressModifiers removes all the modifiers added using
+// WithEgressModifier or AddEgressModifier, and returns how many were removed.
+// Calling the cancel functions returned when they were added afterwards is
+// harmless.  It may be called from a modifier, and applies from the next
+// message.  The Server's own matching of RoundTrip responses isn't a modifier,
+// so it isn't affected.
+func (srv *Server) ClearEgressModifiers() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	n := len(srv.egressMods)
+	for _, entry := range srv.egressMods {
+		entry.cancel()
+	}
+	srv.egressMods = nil
+
+	return n
+}
+
+// addEgressModifier adds the modifier and tracks it so it can be removed.  The
+// lock must be held, or the server must still be under construction.
+func (srv *Server) addEgressModifier(m wrp.Modifier) func() {
+	entry := &egressEntry{
+		m:      m,
+		cancel: srv.egress.Add(m),
+	}
+	srv.egressMods = append(srv.egressMods, entry)
+
+	return func() {
+		srv.lock.Lock()
+		defer srv.lock.Unlock()
+
+		srv.removeEgressEntry(entry)
+	}
+}
+
+// removeEgressEntry removes the modifier.  The lock must be held.
+func (srv *Server) removeEgressEntry(entry *egressEntry) {
+	entry.cancel()
+	srv.egressMods = slices.DeleteFunc(srv.egressMods, func(e *egressEntry) bool {
+		return e == entry
+	})
+}
+
+// sameModifier returns true if the modifiers are the same, without panicking
+// on modifiers that are not comparable.
+func sameModifier(a, b wrp.Modifier) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// AddRXObserver adds an observer to the rx chain, the same as WithRXObserver.
+// It may be called at any time, including from an observer.  The returned
+// function removes the observer, and may also be called from an observer.  A
+// change made while a message is being observed applies from the next message.
+func (srv *Server) AddRXObserver(o wrp.Observer) (cancel func()) {
+	return srv.rxObservers.Add(o)
+}
+
+// observeRX informs the rx observers of the message.
+func (srv *Server) observeRX(ctx context.Context, msg wrp.Message) {
+	for _, o := range listeners(&srv.rxObservers) {
+		if o != nil {
+			func() {
+				defer srv.recoverObserver()
+				o.ObserveWRP(ctx, msg)
+			}()
+		}
+	}
+}
+
+// observeDeadLetter informs the dead-letter handler of the message.
+func (srv *Server) observeDeadLetter(ctx context.Context, msg wrp.Message) {
+	defer srv.recoverObserver()
+	srv.deadLetter.ObserveWRP(ctx, msg)
+}
+
+// recoverObserver recovers a panic from an observer or modifier and passes it
+// to the observer error handler.  It must be deferred.
+func (srv *Server) recoverObserver() {
+	if v := recover(); v != nil {
+		srv.observerErr(fmt.Errorf("%w: %v", ErrObserverPanic, v))
+	}
+}
+
+// logObserverErr is the default observer error handler.  The Server's name is
+// logged with the error so the Servers in one process can be told apart.
+func (srv *Server) logObserverErr(err error) {
+	slog.Error("wrpnng: observer failed", "server", srv.name, "error", err)
+}
+
+// RecentMessages returns a copy of the most recently received messages, oldest
+// first.  Messages are only retained if WithReplayBuffer was used.
+func (srv *Server) RecentMessages() []wrp.Message {
+	return srv.replay.Messages()
+}
+
+// Sources returns the sources of the messages passed to ProcessWRP that have
+// been seen within the source expiry, sorted by source.
+func (srv *Server) Sources() []SourceInfo {
+	return srv.sources.Sources()
+}
+
+// Name returns the name of the Server set using WithName.
+func (srv *Server) Name() string {
+	return srv.name
+}
+
+// IsServiceConnected returns true if the named service is registered and its
+// sender is connected.
+func (srv *Server) IsServiceConnected(name string) bool {
+	return srv.senders.IsConnected(name)
+}
+
+// SenderHealth is a snapshot of the state of the sender for a registered
+// service.
+type SenderHealth struct {
+	// Connected is true if the sender is connected to the service.
+	Connected bool
+
+	// LastSend is the time of the last successful send to the service.  It is
+	// the zero value if no message has been sent.
+	LastSend time.Time
+
+	// LastErr is the error from the last send attempt, or nil if it succeeded.
+	LastErr error
+
+	// Queued is the number of messages waiting to be sent to the service.
+	Queued int
+
+	// Reconnects is the number of times the sender has reconnected to the
+	// service.
+	Reconnects int
+}
+
+// SenderHealth returns the health snapshot of the sender for the named
+// service.  If the service is not registered, false is returned.
+func (srv *Server) SenderHealth(name string) (SenderHealth, bool) {
+	h, ok := srv.senders.Health(name)
+	if !ok {
+		return SenderHealth{}, false
+	}
+
+	return senderHealth(h), true
 }
 
-func (srv *Server) handleRegisterMsg(_ context.Context, msg wrp.Message) error {
+// senderHealth converts the sender's health snapshot.
+func senderHealth(h sender.Health) SenderHealth {
+	return SenderHealth{
+		Connected:  h.Connected,
+		LastSend:   h.LastSend,
+		LastErr:    h.LastErr,
+		Queued:     h.Queued,
+		Reconnects: h.Reconnects,
+	}
+}
+
+func (srv *Server) handleRegisterMsg(ctx context.Context, msg wrp.Message) error {
 	if msg.Type != wrp.ServiceRegistrationMessageType {
 		return wrp.ErrNotHandled
 	}
@@ -131,18 +681,152 @@ func (srv *Server) handleRegisterMsg(_ context.Context, msg wrp.Message) error {
 		return errInvalidMsg
 	}
 
-	opts := append(srv.sOpts, sender.WithURL(msg.URL))
-	return srv.senders.Upsert(msg.ServiceName, opts)
+	if srv.nameValidator != nil {
+		if err := srv.nameValidator(msg.ServiceName); err != nil {
+			return err
+		}
+	}
+
+	if srv.authorizer != nil {
+		if err := srv.authorizer(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	name := msg.ServiceName
+	if srv.senders.key != RouteByService {
+		src, err := wrp.ParseLocator(msg.Source)
+		if err != nil {
+			return errors.Join(errInvalidMsg, err)
+		}
+		src.Service = msg.ServiceName
+		name = srv.senders.routeKey(src)
+	}
+
+	// Clip so concurrent registrations don't share the appended options.
+	opts := append(slices.Clip(srv.sOpts), sender.WithURL(msg.URL))
+
+	f, err := srv.serviceFormat(msg)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, sender.WithFormat(f))
+
+	replaced, err := srv.senders.Upsert(ctx, name, opts)
+	if err != nil {
+		return err
+	}
+
+	srv.visitRegistered(name, msg.URL, replaced)
+	return nil
 }
 
-func (srv *Server) egressWRP(ctx context.Context, msg wrp.Message) error {
-	srv.egress.Visit(func(m wrp.Modifier) {
-		_, _ = m.ModifyWRP(ctx, msg)
+// visitRegistered calls the registration listeners on their own goroutine so
+// a slow listener can't block ingress.
+func (srv *Server) visitRegistered(name, url string, reregistered bool) {
+	go srv.registered.Visit(func(f func(string, string, bool)) {
+		f(name, url, reregistered)
 	})
+}
+
+func (srv *Server) egressWRP(ctx context.Context, msg wrp.Message) error {
+	for _, hook := range srv.hooks {
+		if err := hook(ctx, &msg); err != nil {
+			return err
+		}
+	}
+
+	msg, err := srv.egressProcs.ModifyWRP(ctx, msg)
+	if err != nil && !errors.Is(err, wrp.ErrNotHandled) {
+		return err
+	}
+
+	// Responses are matched to RoundTrip requests on their way out.  This is
+	// kept apart from the modifiers so clearing them doesn't break RoundTrip.
+	srv.roundTrips.ObserveWRP(ctx, msg)
+
+	for _, m := range listeners(&srv.egress) {
+		func() {
+			defer srv.recoverObserver()
+			_, _ = m.ModifyWRP(ctx, msg)
+		}()
+	}
 
 	return nil
 }
 
+// listeners returns a copy of the listeners, so they are called without holding
+// the eventor's lock and may add or remove listeners themselves.
+func listeners[T any](e *eventor.Eventor[T]) []T {
+	var rv []T
+	e.Visit(func(l T) {
+		rv = append(rv, l)
+	})
+	return rv
+}
+
+// transformRX applies the rx transforms to a received message and passes the
+// result to the rest of the rx chain.
+func (srv *Server) transformRX(next wrp.Processor) wrp.Processor {
+	return wrp.ProcessorFunc(func(ctx context.Context, msg wrp.Message) error {
+		msg, err := srv.rxTransform.ModifyWRP(ctx, msg)
+		if err != nil && !errors.Is(err, wrp.ErrNotHandled) {
+			return err
+		}
+		return next.ProcessWRP(ctx, msg)
+	})
+}
+
+// observeHeartbeat informs the tx observers of the heartbeat.  Each observer is
+// recovered on its own, so one that panics doesn't keep the heartbeat from the
+// rest.
+func (srv *Server) observeHeartbeat(ctx context.Context, msg wrp.Message) {
+	for _, o := range srv.txObservers {
+		if o != nil {
+			func() {
+				defer srv.recoverObserver()
+				o.ObserveWRP(ctx, msg)
+			}()
+		}
+	}
+}
+
+// heartbeatDelay returns the time until the next heartbeat.  It is the
+// heartbeat interval moved by a random amount of up to the jitter fraction in
+// either direction.
+func (srv *Server) heartbeatDelay() time.Duration {
+	interval := srv.interval()
+	if srv.heartbeatJitter == 0 {
+		return interval
+	}
+
+	offset := (2*srv.random() - 1) * srv.heartbeatJitter
+	return interval + time.Duration(offset*float64(interval))
+}
+
+// interval returns the current heartbeat interval.
+func (srv *Server) interval() time.Duration {
+	return time.Duration(srv.heartbeatInterval.Load())
+}
+
+// SetHeartbeatInterval changes the interval for sending heartbeats, the same
+// as WithHeartbeatInterval.  If the Server is running, the next heartbeat is
+// rescheduled using the new interval without waiting for the current one to
+// pass.  A zero or negative interval stops the heartbeats until a positive one
+// is set.  It doesn't block, so it can be called from anywhere, including an
+// observer.
+func (srv *Server) SetHeartbeatInterval(interval time.Duration) {
+	srv.heartbeatInterval.Store(int64(interval))
+
+	// Wake the loop to reschedule.  A wake up that is already pending covers
+	// this change too, since the loop reads the interval when it wakes, and one
+	// left while the Server isn't running is ignored.
+	select {
+	case srv.heartbeatChanged <- struct{}{}:
+	default:
+	}
+}
+
 // sendHeartbeat sends a ServiceAlive message at regular intervals until the
 // context is canceled.
 func (srv *Server) sendHeartbeat(ctx context.Context) {
@@ -152,13 +836,39 @@ func (srv *Server) sendHeartbeat(ctx context.Context) {
 		Type: wrp.ServiceAliveMessageType,
 	}
 
+	// A nil channel never fires, so the heartbeats are off.
+	var next <-chan time.Time
+	var scheduled time.Duration
 	for {
+		// Only reschedule when the interval changed, so a wake up for a change
+		// that was already picked up doesn't push back the next heartbeat.
+		if interval := srv.interval(); next == nil || interval != scheduled {
+			next, scheduled = nil, interval
+			if interval > 0 {
+				next = srv.clock.After(srv.heartbeatDelay())
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(srv.heartbeatInterval):
-			srv.txObservers.ObserveWRP(ctx, msg)
-			_ = srv.senders.ProcessWRP(ctx, msg)
+		case <-srv.heartbeatChanged:
+			// Reschedule if the interval changed.
+		case <-next:
+			next = nil
+
+			// The heartbeats may have been turned off before the change was
+			// picked up.
+			if srv.interval() <= 0 {
+				continue
+			}
+
+			srv.observeHeartbeat(ctx, msg)
+
+			// Bound the sends so a stuck sender can't delay the next heartbeat.
+			sendCtx, cancel := context.WithTimeout(ctx, srv.interval())
+			_ = srv.senders.ProcessWRP(sendCtx, msg)
+			cancel()
 		}
 	}
 }
@@ -4,12 +4,18 @@
 package wrpnng
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/xmidt-org/wrp-go/v3"
 	"github.com/xmidt-org/wrpnng/internal/filters"
 	"github.com/xmidt-org/wrpnng/internal/processors/stopping"
 	"github.com/xmidt-org/wrpnng/internal/receiver"
+	"github.com/xmidt-org/wrpnng/internal/sender"
 )
 
 // ServerOption is the interface implemented by types that can be used to
@@ -31,26 +37,321 @@ func serverOptionFunc(f func(*Server)) errServerOptionFunc {
 	})
 }
 
+// routeOption is a ServerOption that only configures the routing or the
+// connections to the services, so NewRouter accepts it as well.
+type routeOption struct {
+	errServerOptionFunc
+}
+
 // RXURL sets the URL used for listening to network clients.  This is required.
-// The URL should be in the format of "tcp://<ip>:<port>" unless other transports
-// are registered.  This URL represents the rx network side of the controller.
+// The URL should be in the format of "tcp://<ip>:<port>", or for WebSocket,
+// "ws://<ip>:<port>/<path>" or "wss://<ip>:<port>/<path>".  Other transports
+// can be used if the application registers them.  This URL represents the rx
+// network side of the controller.
+//
+// Over WebSocket, each WRP message is carried in one binary WebSocket message,
+// and the path must match the one the peers dial.  The clients can register
+// WebSocket URLs too.  A wss URL needs the certificate set using
+// WithTLSConfig.
 func RXURL(url string) ServerOption {
 	return serverOptionFunc(func(srv *Server) {
 		srv.rOpts = append(srv.rOpts, receiver.WithURL(url))
 	})
 }
 
-// RXTimeout sets the timeout for receiving messages.
+// WithTLSConfig sets the TLS config used for wss URLs.  It must include the
+// certificate the Server presents when listening, and is also used to dial
+// the clients that register wss URLs.  It is ignored for the other transports.
+func WithTLSConfig(cfg *tls.Config) ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.rOpts = append(srv.rOpts, receiver.WithTLSConfig(cfg))
+		srv.sOpts = append(srv.sOpts, sender.WithTLSConfig(cfg))
+	})}
+}
+
+// RXTimeout sets the timeout for receiving messages.  The timeout controls how
+// often the receiver wakes up while waiting for messages; it does not delay
+// Stop.  A zero timeout uses the default of 1 second.  A negative timeout
+// causes NewServer to return an error.
 func RXTimeout(timeout time.Duration) ServerOption {
 	return serverOptionFunc(func(srv *Server) {
 		srv.rOpts = append(srv.rOpts, receiver.WithRecvTimeout(timeout))
 	})
 }
 
-// WithHeartbeatInterval sets the interval for sending heartbeats.
+// WithReceiveWorkers makes a fixed pool of n workers decode and handle the
+// messages received from the network, so CPU usage and the number of
+// goroutines stay bounded under high message rates.  While all the workers are
+// busy, no more messages are read.  With one worker, messages are handled one
+// at a time in the order received; with more, the order is not guaranteed.
+// Zero handles each message on its own goroutine, which is the default.  A
+// negative n causes NewServer to return an error.
+func WithReceiveWorkers(n int) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.rOpts = append(srv.rOpts, receiver.WithReceiveWorkers(n))
+	})
+}
+
+// WithBaseContext sets the parent context for the Server's lifetime.  When the
+// context is done, the heartbeats stop and the Server is stopped as if Stop was
+// called.  The default is context.Background().
+func WithBaseContext(ctx context.Context) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.baseCtx = ctx
+	})
+}
+
+// WithTracer sets the Tracer used to start a span for each message.  Messages
+// received from the network are processed in a span named SpanReceive, and
+// messages passed to ProcessWRP in a span named SpanProcess.  The context
+// carrying the span is passed to the observers, processors, modifiers and
+// senders that handle the message.  By default, no spans are started.
+func WithTracer(t Tracer) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.tracer = t
+	})
+}
+
+// WithInboundTimeout bounds how long each message received from the network
+// is handled, so a slow send can't hold up the receiver forever.  The rx
+// chain, the egress processors and modifiers, and any ProcessWRP call made
+// with their context are given a context that expires after the timeout.
+// When it does, the error returned matches ErrInboundTimeout and
+// context.DeadlineExceeded.  A zero or negative timeout, the default, means
+// there is no limit.
+func WithInboundTimeout(d time.Duration) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.inboundTimeout = d
+	})
+}
+
+// WithStartTimeout sets how long Start waits for the receiver to start
+// listening.  If the receiver isn't listening in time, Start returns
+// ErrStartTimeout.  A zero or negative timeout waits as long as it takes,
+// which is the default.
+func WithStartTimeout(d time.Duration) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.startTimeout = d
+	})
+}
+
+// WithName sets the name of the Server, which is returned by Server.Name.  The
+// name distinguishes Servers when several run in one process: it is included in
+// the errors logged by the default observer error handler.  The Metrics set
+// using WithMetrics aren't told the name, so give each Server its own Metrics to
+// label them.  The default is an empty name.
+func WithName(name string) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.name = name
+	})
+}
+
+// WithFormatNegotiation enables negotiating the WRP format used with each
+// registered service.  The preferred formats are listed in the order the
+// Server prefers them, and are also the formats accepted by the rx side.  When
+// a service registers, the first preferred format the service advertises (see
+// FormatsMetadataKey) is used to send messages to it.  If the service does not
+// advertise a common format, the registration is rejected.  By default, the
+// first format the service advertises that the Server can encode is used, and
+// msgpack for services that don't advertise any.
+func WithFormatNegotiation(preferred ...wrp.Format) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.formats = append(srv.formats[:0:0], preferred...)
+		srv.rOpts = append(srv.rOpts, receiver.WithFormats(preferred...))
+	})
+}
+
+// WithFormatDetector sets a function that picks the format of each message
+// received from its encoded bytes, so clients using JSON and msgpack can share
+// the rx side.  Only the detected format is tried, and if
+// WithFormatNegotiation was used it must be one of the preferred formats.  A nil
+// detector treats messages starting with an opening brace as JSON and all
+// others as msgpack.
+func WithFormatDetector(detect func([]byte) wrp.Format) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.rOpts = append(srv.rOpts, receiver.WithFormatDetector(detect))
+	})
+}
+
+// WithPayloadCompression makes the Server gzip the messages it sends to the
+// registered services using the compression level, such as
+// gzip.DefaultCompression, and decompress the compressed messages it receives.
+// Messages that don't get smaller are sent uncompressed.  The services must
+// use WithClientPayloadCompression so they can read the compressed messages.
+// Received messages that decompress to more than the size set using
+// WithMaxMessageBytes, or 16 MiB if it isn't set, are dropped.  By default,
+// messages are not compressed.
+func WithPayloadCompression(level int) ServerOption {
+	return routeOption{errServerOptionFunc(func(srv *Server) error {
+		if err := validateCompression(level); err != nil {
+			return err
+		}
+
+		srv.sOpts = append(srv.sOpts, sender.WithCompression(level))
+		srv.rOpts = append(srv.rOpts, receiver.WithDecompression())
+		return nil
+	})}
+}
+
+// WithMaxMessageBytes sets the largest message the Server accepts from the
+// network.  A client sending a larger message has its connection dropped by
+// the transport before the message is read into memory, and when
+// WithPayloadCompression is used, it also limits the size of each message
+// once decompressed.  A value of zero or less keeps the transport's default
+// limit, which is the default.
+func WithMaxMessageBytes(n int) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.rOpts = append(srv.rOpts, receiver.WithMaxMessageBytes(n))
+	})
+}
+
+// WithOrderedSends makes the Server write the messages for each registered
+// service in the order they were processed, even when they are processed
+// concurrently or a caller gives up before its message is sent.  Messages to
+// different services are still sent independently.  By default, concurrent
+// messages to the same service may be sent in any order.
+func WithOrderedSends() ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.sOpts = append(srv.sOpts, sender.WithOrderedSends())
+	})}
+}
+
+// WithSendRetries makes the Server retry a failed send to a registered service
+// up to count times, waiting backoff before each retry and dialing the service
+// again first.  Only messages that are safe to send twice are retried, such as
+// simple events; requests fail on the first error.  By default, sends are not
+// retried.  Negative values are an error.
+func WithSendRetries(count int, backoff time.Duration) ServerOption {
+	return withSenderOption(sender.WithSendRetries(count, backoff))
+}
+
+// WithDropOnFull makes the Server drop a message to a registered service when
+// the send times out because the connection's queue is full, instead of
+// closing the connection.  The send fails with ErrDropped.  This suits lossy
+// event streams.
+func WithDropOnFull() ServerOption {
+	return withSenderOption(sender.WithDropOnFull())
+}
+
+// WithSendBuffer puts a queue holding up to n messages in front of the
+// connection to each registered service, so bursts don't fail while the
+// connection's own short queue is full.  ProcessWRP returns once the message
+// is queued.  Messages still queued when the service is removed are discarded.
+// The default of zero means no buffer.  A negative size is an error.
+func WithSendBuffer(n int) ServerOption {
+	return withSenderOption(sender.WithSendBuffer(n))
+}
+
+// WithAutoRedial makes the Server dial a registered service again when a
+// message is sent to it after its connection was closed, such as after a send
+// failure, instead of failing the send.  Only a single attempt is made per
+// message.
+func WithAutoRedial() ServerOption {
+	return withSenderOption(sender.WithAutoRedial())
+}
+
+// WithSenderCloseTimeout bounds how long closing the connection to a
+// registered service waits, including for a send still in progress.  After
+// the timeout the connection is left to close in the background.  The default
+// of zero waits as long as it takes.  A negative timeout is an error.
+func WithSenderCloseTimeout(d time.Duration) ServerOption {
+	return withSenderOption(sender.WithCloseTimeout(d))
+}
+
+// WithWriteQueueLen sets the length of the write queue of the connection to
+// each registered service, in messages.  The default of 1 means a dead
+// connection is detected by the next send.  A longer queue absorbs bursts, but
+// sends succeed while the queue has room even if the connection is dead, and
+// the queued messages are lost when it is closed.  WithSendBuffer buffers
+// bursts without this tradeoff.  The length must be at least 1.
+func WithWriteQueueLen(n int) ServerOption {
+	return withSenderOption(sender.WithWriteQueueLen(n))
+}
+
+// WithSenderSocketOption sets a mangos socket option, such as
+// mangos.OptionKeepAlive, on the connection to each registered service before
+// it is dialed.  If the socket rejects the option, the registration fails.
+// The name must not be empty.
+func WithSenderSocketOption(name string, value any) ServerOption {
+	return withSenderOption(sender.WithSocketOption(name, value))
+}
+
+// withSenderOption adds an option for the connections to the registered
+// services.  It is checked right away, so a bad value is reported by
+// NewServer instead of when a service registers.
+func withSenderOption(opt sender.Option) ServerOption {
+	return routeOption{errServerOptionFunc(func(srv *Server) error {
+		if err := checkSenderOption(opt); err != nil {
+			return err
+		}
+
+		srv.sOpts = append(srv.sOpts, opt)
+		return nil
+	})}
+}
+
+// checkSenderOption returns the error the option causes when creating a
+// sender, if any.  The sender isn't dialed.
+func checkSenderOption(opt sender.Option) error {
+	_, err := sender.New(opt, sender.WithURL("inproc://check"))
+	return err
+}
+
+// validateCompression checks the gzip compression level.
+func validateCompression(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level: %d", level)
+	}
+	return nil
+}
+
+// WithQOSPolicies sets how messages are sent to the registered services based
+// on the QOS level of each message.  Levels without a policy use the default
+// behavior.  DefaultQOSPolicies provides a suggested mapping.  By default, all
+// messages are sent the same regardless of their QOS value.
+func WithQOSPolicies(policies map[wrp.QOSLevel]QOSPolicy) ServerOption {
+	return routeOption{errServerOptionFunc(func(srv *Server) error {
+		for level, p := range policies {
+			if level < wrp.QOSLow || level > wrp.QOSCritical {
+				return fmt.Errorf("invalid QOS level: %d", level)
+			}
+			if p.Retries < 0 {
+				return fmt.Errorf("QOS retries must not be negative: %d", p.Retries)
+			}
+			srv.sOpts = append(srv.sOpts, sender.WithQOSPolicy(level, p.toSender()))
+		}
+		return nil
+	})}
+}
+
+// WithHeartbeatInterval sets the interval for sending heartbeats.  A zero or
+// negative interval disables heartbeats.
 func WithHeartbeatInterval(interval time.Duration) ServerOption {
 	return serverOptionFunc(func(srv *Server) {
-		srv.heartbeatInterval = interval
+		srv.heartbeatInterval.Store(int64(interval))
+	})
+}
+
+// WithHeartbeatJitter randomizes each heartbeat by up to the given fraction of
+// the heartbeat interval, earlier or later, so many servers started together
+// don't send their heartbeats at the same time.  The fraction must be at least
+// 0 and less than 1, so a heartbeat is never sent right after the one before
+// it; 0, the default, disables the jitter.
+func WithHeartbeatJitter(fraction float64) ServerOption {
+	return errServerOptionFunc(func(srv *Server) error {
+		if fraction < 0 || fraction >= 1 || math.IsNaN(fraction) {
+			return fmt.Errorf("invalid heartbeat jitter: %v", fraction)
+		}
+		srv.heartbeatJitter = fraction
+		return nil
+	})
+}
+
+// WithSourceExpiry sets how long a message source is tracked after it was last
+// seen.  A value of zero or less means sources never expire.
+func WithSourceExpiry(expiry time.Duration) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.sources.expiry = expiry
 	})
 }
 
@@ -58,7 +359,7 @@ func WithHeartbeatInterval(interval time.Duration) ServerOption {
 // processing of messages received from the network.
 func WithRXObserver(observer wrp.Observer) ServerOption {
 	return serverOptionFunc(func(srv *Server) {
-		srv.rxObservers = append(srv.rxObservers, observer)
+		srv.rxObservers.Add(observer)
 	})
 }
 
@@ -70,12 +371,432 @@ func WithTXObserver(observer wrp.Observer) ServerOption {
 	})
 }
 
+// WithDeadLetterHandler sets an observer that is informed of each message
+// passed to ProcessWRP that couldn't be routed because no registered service
+// matched its destination.  ProcessWRP still returns an error matching
+// ErrNoRoute for the message.  Messages rejected by the filters or processors,
+// or that fail to send, are not dead letters.  A panic in the handler is
+// passed to the observer error handler.  By default, unroutable messages are
+// dropped.
+func WithDeadLetterHandler(o wrp.Observer) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.deadLetter = o
+	})
+}
+
+// WithObserverErrorHandler sets the handler for panics in the rx and tx
+// observers, the egress modifiers, the receiver's message handlers and the
+// senders a message is broadcast to.  The panic is recovered and passed to the
+// handler as an error wrapping ErrObserverPanic, so a buggy observer can't
+// crash the process.  A nil handler logs the error using the default slog logger, which is the default.
+func WithObserverErrorHandler(f func(error)) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		if f == nil {
+			f = srv.logObserverErr
+		}
+		srv.observerErr = f
+		srv.senders.panicked = f
+	})
+}
+
+// WithReplayBuffer retains the last n messages received from the network so
+// they can be inspected using Server.RecentMessages.  A value of n that is
+// zero or less disables the buffer, which is the default.
+func WithReplayBuffer(n int) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.replay = nil
+		if n > 0 {
+			srv.replay = newReplayBuffer(n)
+		}
+	})
+}
+
+// WithMessageHook adds a hook that is called for each message received from
+// the network after it has been decoded and filtered, but before it is routed
+// to the egress processors and modifiers.  The hook may change the message in
+// place.  If the hook returns an error, the message is dropped.  Multiple hooks
+// are called in the order they are added.
+func WithMessageHook(hook func(context.Context, *wrp.Message) error) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		if hook != nil {
+			srv.hooks = append(srv.hooks, hook)
+		}
+	})
+}
+
+// WithEgressProcessor adds a processor to the list of processors that handle
+// messages leaving the controller.  Egress processors are called in the order
+// they are added, before any of the egress modifiers are informed.
+//
+//   - If a processor returns wrp.ErrNotHandled or nil, processing continues.
+//   - If a processor returns any other error, processing stops and the egress
+//     modifiers are not informed of the message.
+//   - If a processor also implements wrp.Modifier, ModifyWRP is called instead
+//     of ProcessWRP, and a message returned with a nil error replaces the
+//     message passed to the remaining processors and the egress modifiers.
+func WithEgressProcessor(p wrp.Processor) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		if p == nil {
+			return
+		}
+
+		m, ok := p.(wrp.Modifier)
+		if !ok {
+			m = wrp.ProcessorAsModifier(p)
+		}
+		srv.egressProcs = append(srv.egressProcs, m)
+	})
+}
+
+// WithRXTransform adds a modifier that rewrites the messages received from the
+// network before they are filtered, registrations are handled, or they leave
+// the controller.  The rx observers see the message as it was received.
+// Transforms are called in the order they are added, and a message returned
+// with a nil error replaces the message passed on.  If a transform returns an
+// error other than wrp.ErrNotHandled, the message is dropped.
+func WithRXTransform(m wrp.Modifier) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		if m != nil {
+			srv.rxTransform = append(srv.rxTransform, m)
+		}
+	})
+}
+
+// WithOutboundNormalizer normalizes the messages passed to Server.ProcessWRP
+// before any other processing, using the modifiers in order.  The modified
+// message is what the ingress chain sees and what is sent.  If a modifier
+// returns an error other than wrp.ErrNotHandled, the message is not sent and
+// the error is returned.  If no modifiers are provided, the
+// DefaultOutboundNormalizers are used.  Using the option more than once adds
+// to the modifiers.
+func WithOutboundNormalizer(mods ...wrp.Modifier) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		if len(mods) == 0 {
+			mods = DefaultOutboundNormalizers()
+		}
+		srv.outbound = append(srv.outbound, mods...)
+	})
+}
+
+// WithLoopGuard rejects messages that would be sent back to the service they
+// came from, which happens when the routing is misconfigured or the services
+// form a loop.  The message's source is routed the same way as its destination,
+// and if both resolve to the same service, the message is not sent and the
+// error returned matches ErrRoutingLoop.  With WithRouteKeyFunc, the source is
+// found by passing the function the message with its source and destination
+// swapped.  The metadata route set using WithMetadataRoute only names the
+// destination, so it isn't used for the source.  By default, there is no guard.
+func WithLoopGuard() ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.loopGuard = true
+	})}
+}
+
+// WithOutboundSource sets the source of the messages passed to
+// Server.ProcessWRP that don't have one, so the services they are sent to can
+// tell which Server they came through.  Only these outbound messages, which go
+// through the ingress chain to the registered services, are stamped; the
+// messages received from the network are left alone.  A source that is
+// already set is kept.  The source is set
+// before the outbound normalizers run, so they see it and may still change it.
+// An empty source, the default, leaves the messages alone.
+func WithOutboundSource(source string) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.outboundSource = nil
+		if source != "" {
+			srv.outboundSource = DefaultSource(source)
+		}
+	})
+}
+
+// Position is a named location in the ingress chain where a processor can be
+// inserted.
+type Position int
+
+const (
+	// BeforeFilters inserts the processor before any of the message filters.
+	BeforeFilters Position = iota
+
+	// AfterFilters inserts the processor after the message filters, but before
+	// the tx observers.
+	AfterFilters
+
+	// BeforeSenders inserts the processor after the tx observers, just before
+	// the message is sent to the network.
+	BeforeSenders
+)
+
+// WithIngressProcessor inserts a processor into the ingress chain at the
+// specified position.  The ingress chain represents the processing of messages
+// passed to Server.ProcessWRP.  Processors at the same position are called in
+// the order they are added.  If a processor returns anything other than
+// wrp.ErrNotHandled, the chain stops and the value is returned.
+func WithIngressProcessor(p wrp.Processor, at Position) ServerOption {
+	return errServerOptionFunc(func(srv *Server) error {
+		if at < BeforeFilters || at > BeforeSenders {
+			return fmt.Errorf("invalid ingress position: %d", at)
+		}
+
+		if p == nil {
+			return nil
+		}
+
+		if srv.ingressProcs == nil {
+			srv.ingressProcs = make(map[Position][]wrp.Processor)
+		}
+		srv.ingressProcs[at] = append(srv.ingressProcs[at], p)
+		return nil
+	})
+}
+
+// WithMessageValidation validates the messages passed to Server.ProcessWRP
+// after the built in filters.  Invalid messages are rejected with an error
+// wrapping ErrInvalidMessage.  The validators are wrp validating options, such
+// as wrp.ValidateSource.  If no validators are provided, the fields required
+// by each message type are validated.
+func WithMessageValidation(validators ...wrp.NormifierOption) ServerOption {
+	return WithIngressProcessor(filters.ValidateMessage(validators...), AfterFilters)
+}
+
+// RouteKey selects the parts of a destination locator used to find the sender
+// for a message.
+type RouteKey int
+
+const (
+	// RouteByService routes using only the service name.  Services register
+	// using their ServiceName.
+	RouteByService RouteKey = iota
+
+	// RouteBySchemeAndService routes using the scheme and the service name,
+	// so services with the same name under different schemes don't collide.
+	// The scheme is taken from the Source locator of the registration, and
+	// the service is known to the Server as "<scheme>:<service>".
+	RouteBySchemeAndService
+
+	// RouteByLocator routes using the scheme, authority and service name.
+	// These are taken from the Source locator of the registration, and the
+	// service is known to the Server as "<scheme>:<authority>/<service>".
+	RouteByLocator
+)
+
+// WithRouteKey sets how destinations are matched to registered services.  The
+// default is RouteByService.
+func WithRouteKey(key RouteKey) ServerOption {
+	return routeOption{errServerOptionFunc(func(srv *Server) error {
+		if key < RouteByService || key > RouteByLocator {
+			return fmt.Errorf("invalid route key: %d", key)
+		}
+
+		srv.senders.key = key
+		return nil
+	})}
+}
+
+// WithRouteKeyFunc replaces the locator based routing with a function that
+// returns the name of the service a message is sent to.  The name is matched
+// against the registered services the same way as the destination's service,
+// including wildcards.  If the function returns an error, the message is
+// rejected with it.  Registrations and service alive messages are not
+// affected.  A nil function restores the default, which takes the key from
+// the destination locator as set by WithRouteKey.
+func WithRouteKeyFunc(fn func(wrp.Message) (string, error)) ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.keyFunc = fn
+	})}
+}
+
+// WithMetadataRoute routes the messages that have the metadata key to the
+// service named by its value, instead of using the destination.  The name is
+// matched against the registered services the same way as the destination's
+// service, including wildcards.  Messages without the key, or with an empty
+// value, are routed as usual, including by the function set using
+// WithRouteKeyFunc.  An empty key, the default, turns it off.
+func WithMetadataRoute(key string) ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.metadataKey = key
+	})}
+}
+
+// WithServiceNameValidator sets a function that checks the service name of
+// each registration before the service is added.  If it returns an error, the
+// registration is rejected with that error.  A nil function restores the
+// default, which accepts any non-empty name.
+func WithServiceNameValidator(fn func(string) error) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.nameValidator = fn
+	})
+}
+
+// WithRegistrationAuthorizer sets a function that checks each registration
+// message, such as by verifying a token in its headers or metadata, before the
+// service is added.  If it returns an error, the registration is rejected with
+// that error and the existing routing is left alone.  A nil function restores
+// the default, which accepts every registration.
+func WithRegistrationAuthorizer(fn func(context.Context, wrp.Message) error) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.authorizer = fn
+	})
+}
+
+// WithFanout adds a processor to the ingress chain, just before the senders,
+// that sends a copy of each message to every service returned by targets.
+// The names are matched exactly against the registered services.  Fanning out
+// replaces routing: the message is only sent to the services returned, so
+// targets must include the service of the message's destination for it to
+// get the message too.  If targets returns no services the message is routed
+// normally.  Errors from the individual sends are joined; a missing service
+// results in ErrNoRoute.
+func WithFanout(targets func(wrp.Message) []string) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		if targets == nil {
+			return
+		}
+
+		if srv.ingressProcs == nil {
+			srv.ingressProcs = make(map[Position][]wrp.Processor)
+		}
+		srv.ingressProcs[BeforeSenders] = append(srv.ingressProcs[BeforeSenders],
+			&fanout{
+				senders: &srv.senders,
+				targets: targets,
+			})
+	})
+}
+
+// WithWildcardRoutes lets services register for a family of destinations.  A
+// service name ending in "*" receives messages for any service starting with
+// the rest of the name, and the name "*" receives messages that no other
+// service matches.  An exact service name match always wins, then the longest
+// prefix, then "*".  By default only exact matches are used.
+func WithWildcardRoutes() ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.wildcards = true
+	})}
+}
+
+// WithRejectURLChange rejects a registration for an already registered
+// service if the URL differs from the existing one.  The registration fails
+// with ErrURLChanged and the existing sender is kept.  This prevents anyone who
+// knows a service name from redirecting its traffic.  Re-registering with the
+// same URL is still allowed.
+func WithRejectURLChange() ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.rejectURLChange = true
+	})}
+}
+
+// DefaultBroadcastTimeout is the time allowed for each service to accept a
+// broadcast message, such as a heartbeat, unless WithBroadcastTimeout is used.
+const DefaultBroadcastTimeout = 5 * time.Second
+
+// WithBroadcastTimeout bounds the time each registered service is given to
+// accept a message sent to all services, such as a heartbeat or a ServiceAlive
+// message passed to Server.ProcessWRP, so one slow service doesn't hold up the
+// others.  A zero or negative timeout only uses the caller's context.  The
+// default is DefaultBroadcastTimeout.
+func WithBroadcastTimeout(d time.Duration) ServerOption {
+	return routeOption{serverOptionFunc(func(srv *Server) {
+		srv.senders.sendTimeout = d
+	})}
+}
+
+// WithRoundTripTimeout sets how long Server.RoundTrip waits for a response.  A
+// zero or negative timeout only uses the caller's context.  The default is
+// DefaultRoundTripTimeout.
+func WithRoundTripTimeout(d time.Duration) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.roundTripTimeout = d
+	})
+}
+
+// WithMaxSenders limits the number of registered services to n.  Once the
+// limit is reached, registrations for new service names fail with
+// ErrTooManySenders, while services that are already registered can still
+// re-register.  A value of zero means there is no limit, which is the
+// default.  A negative value causes NewServer to return an error.
+func WithMaxSenders(n int) ServerOption {
+	return routeOption{errServerOptionFunc(func(srv *Server) error {
+		if n < 0 {
+			return fmt.Errorf("max senders must not be negative: %d", n)
+		}
+
+		srv.senders.max = n
+		return nil
+	})}
+}
+
+// WithReceiverFailureListener adds a listener that is called when the receiver
+// stops for any reason other than the Server being stopped.  The optional
+// cancel function pointers are set to functions that remove the listener.
+func WithReceiverFailureListener(f func(error), cancel ...*func()) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		cancelFn := srv.rxFailure.Add(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
+// WithReceiverRestart makes the Server listen again after the receiver fails.
+// The backoff controls the delay between attempts and how many consecutive
+// failed attempts are made before giving up.  The failure listeners are still
+// called for each receiver failure.  If the Server gives up, Run returns an
+// error wrapping ErrReceiverRestart.
+func WithReceiverRestart(backoff Backoff) ServerOption {
+	return errServerOptionFunc(func(srv *Server) error {
+		if err := backoff.validate(); err != nil {
+			return err
+		}
+
+		srv.restartRX = true
+		srv.rxBackoff = backoff
+		return nil
+	})
+}
+
+// WithDialRetry retries dialing a newly registered service when the dial fails
+// for a reason that may pass, such as the connection being refused.  The
+// backoff controls the delay between attempts, and MaxFailures is the number
+// of failed attempts before the registration is rejected.  Errors that
+// retrying can't fix, such as a bad URL, are returned right away.  By default
+// a single attempt is made.  The registration handler blocks while retrying,
+// so keep the total delay small.
+func WithDialRetry(backoff Backoff) ServerOption {
+	return routeOption{errServerOptionFunc(func(srv *Server) error {
+		if err := backoff.validate(); err != nil {
+			return err
+		}
+
+		srv.senders.dialBackoff = backoff
+		return nil
+	})}
+}
+
+// WithRegistrationListener adds a listener that is called after a service
+// registers and its sender is connected.  The reregistered flag is true if the
+// service replaced an earlier registration with the same name.  Listeners are
+// called on their own goroutine so they don't block ingress.  The optional
+// cancel function pointers are set to functions that remove the listener.
+func WithRegistrationListener(f func(name, url string, reregistered bool), cancel ...*func()) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		cancelFn := srv.registered.Add(f)
+		for i := range cancel {
+			if cancel[i] != nil {
+				*cancel[i] = cancelFn
+			}
+		}
+	})
+}
+
 // WithEgressModifier adds a modifier to the list of modifiers that are informed
 // of messages leaving the controller.  Return values from the modifiers are
-// ignored.
+// ignored.  The modifiers are informed after all egress processors have
+// handled the message.
 func WithEgressModifier(modifier wrp.Modifier, cancel ...*func()) ServerOption {
 	return serverOptionFunc(func(srv *Server) {
-		cancelFn := srv.egress.Add(modifier)
+		cancelFn := srv.addEgressModifier(modifier)
 		for i := range cancel {
 			if cancel[i] != nil {
 				*cancel[i] = cancelFn
@@ -86,18 +807,57 @@ func WithEgressModifier(modifier wrp.Modifier, cancel ...*func()) ServerOption {
 
 //-----------------------------------------------------------------------------
 
+// withClock sets the clock used for the heartbeats, the receiver restarts and
+// the source expiry.  This is intended for testing.
+func withClock(c clock) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.clock = c
+		srv.sources.now = c.Now
+	})
+}
+
+// withRandom sets the source of random numbers in [0, 1) used for the
+// heartbeat jitter.  This is intended for testing.
+func withRandom(f func() float64) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.random = f
+	})
+}
+
+// withReceiver sets the receiver used by the Server instead of creating one
+// from the rx options.  This is intended for testing.
+func withReceiver(r receiverIface) ServerOption {
+	return serverOptionFunc(func(srv *Server) {
+		srv.r = r
+	})
+}
+
 func createReceiver() ServerOption {
 	return errServerOptionFunc(func(srv *Server) error {
-		chain := stopping.Processors{
-			wrp.ObserverAsProcessor(srv.rxObservers),
-			filters.ErrorOnUnsupportedMsgTypes(),
-			wrp.ProcessorFunc(srv.handleRegisterMsg),
-			filters.ErrorOnLocalMsgTypes(),
-			wrp.ProcessorFunc(srv.egressWRP),
+		srv.rxChain = stopping.Processors{
+			wrp.ObserverAsProcessor(srv.replay),
+			wrp.ObserverAsProcessor(wrp.ObserverFunc(srv.observeRX)),
+			srv.transformRX(stopping.Processors{
+				filters.ErrorOnUnsupportedMsgTypes(),
+				wrp.ProcessorFunc(srv.handleRegisterMsg),
+				filters.ErrorOnLocalMsgTypes(),
+				wrp.ProcessorFunc(srv.egressWRP),
+			}),
+		}
+
+		srv.rxFailed = make(chan error, 1)
+
+		// A receiver was provided, so there is nothing to create.
+		if srv.r != nil {
+			return nil
 		}
 
 		opts := append(srv.rOpts,
-			receiver.WithModifyWRP(wrp.ProcessorAsModifier(chain)),
+			receiver.WithModifyWRP(wrp.ProcessorAsModifier(wrp.ProcessorFunc(srv.receiveWRP))),
+			receiver.WithCloseListener(srv.receiverClosed),
+			receiver.WithPanicListener(func(err error) {
+				srv.observerErr(err)
+			}),
 		)
 
 		r, err := receiver.New(opts...)
@@ -112,12 +872,27 @@ func createReceiver() ServerOption {
 
 func createIngressChain() ServerOption {
 	return errServerOptionFunc(func(srv *Server) error {
-		srv.ingressChain = stopping.Processors{
+		var chain stopping.Processors
+
+		chain = append(chain, srv.ingressProcs[BeforeFilters]...)
+		chain = append(chain,
 			filters.ErrorOnUnsupportedMsgTypes(),
 			filters.ErrorOnLocalMsgTypes(),
+		)
+		chain = append(chain, srv.ingressProcs[AfterFilters]...)
+		chain = append(chain,
+			wrp.ObserverAsProcessor(&srv.sources),
 			wrp.ObserverAsProcessor(srv.txObservers),
-			&srv.senders,
+		)
+		chain = append(chain, srv.ingressProcs[BeforeSenders]...)
+		chain = append(chain, &srv.senders)
+
+		// Only reached when no service matched.
+		if srv.deadLetter != nil {
+			chain = append(chain, wrp.ObserverAsProcessor(wrp.ObserverFunc(srv.observeDeadLetter)))
 		}
+
+		srv.ingressChain = chain
 		return nil
 	})
 }
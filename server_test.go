@@ -4,15 +4,26 @@
 package wrpnng
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/filters"
+	"github.com/xmidt-org/wrpnng/internal/receiver"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+	"go.nanomsg.org/mangos/v3"
 )
 
 func TestNew(t *testing.T) {
@@ -37,6 +48,27 @@ func TestNew(t *testing.T) {
 				})),
 			},
 			expectError: false,
+		}, {
+			name: "Negative RX timeout",
+			options: []ServerOption{
+				RXURL("url"),
+				RXTimeout(-time.Second),
+			},
+			expectError: true,
+		}, {
+			name: "Invalid route key",
+			options: []ServerOption{
+				RXURL("url"),
+				WithRouteKey(RouteKey(-1)),
+			},
+			expectError: true,
+		}, {
+			name: "Invalid receiver restart backoff",
+			options: []ServerOption{
+				RXURL("url"),
+				WithReceiverRestart(Backoff{Initial: -time.Second}),
+			},
+			expectError: true,
 		},
 	}
 
@@ -56,45 +88,168 @@ func TestNew(t *testing.T) {
 	}
 }
 
-/*
-func TestController_Start(t *testing.T) {
+type mockReceiver struct {
+	listenErr   error
+	listenErrs  []error
+	listenCount int
+	closeCount  int
+}
+
+// Listen returns the listenErrs in order, then listenErr.
+func (m *mockReceiver) Listen() error {
+	m.listenCount++
+	if len(m.listenErrs) > 0 {
+		err := m.listenErrs[0]
+		m.listenErrs = m.listenErrs[1:]
+		return err
+	}
+	return m.listenErr
+}
+
+func (m *mockReceiver) Close() error {
+	m.closeCount++
+	return nil
+}
+
+// blockingReceiver blocks in Listen until released.
+type blockingReceiver struct {
+	release chan struct{}
+	closed  atomic.Int64
+}
+
+func (b *blockingReceiver) Listen() error {
+	<-b.release
+	return nil
+}
+
+func (b *blockingReceiver) Close() error {
+	b.closed.Add(1)
+	return nil
+}
+
+func TestServer_StartTimeout(t *testing.T) {
+	r := &blockingReceiver{release: make(chan struct{})}
+	srv, err := NewServer(
+		withReceiver(r),
+		WithStartTimeout(20*time.Millisecond),
+		WithHeartbeatInterval(5*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	start := time.Now()
+	err = srv.Start()
+	assert.ErrorIs(t, err, ErrStartTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// The heartbeats were stopped.  A broadcast that was canceled may still
+	// finish a send, so let it settle first.
+	time.Sleep(10 * time.Millisecond)
+	sent := counter.count.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, sent, counter.count.Load())
+
+	// The receiver is closed once it finally starts.
+	close(r.release)
+	assert.Eventually(t, func() bool {
+		return r.closed.Load() == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// Start can be tried again.
+	require.NoError(t, srv.Start())
+	assert.Eventually(t, func() bool {
+		return counter.count.Load() > sent
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, srv.Stop())
+}
+
+func TestServer_StartTimeoutNotReached(t *testing.T) {
+	r := &mockReceiver{}
+	srv, err := NewServer(
+		withReceiver(r),
+		WithStartTimeout(time.Minute),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.Start())
+	assert.Equal(t, 1, r.listenCount)
+	require.NoError(t, srv.Stop())
+}
+
+func TestServer_Start(t *testing.T) {
 	tests := []struct {
 		name        string
-		setup       func(c *Controller)
+		r           *mockReceiver
 		expectError bool
 	}{
 		{
 			name: "Start successfully",
-			setup: func(c *Controller) {
-				c.r = &receiver.Receiver{}
-				c.heartbeatInterval = time.Second
-			},
-			expectError: false,
-		},
-		{
-			name: "Receiver is nil",
-			setup: func(c *Controller) {
-				c.heartbeatInterval = time.Second
-			},
+			r:    &mockReceiver{},
+		}, {
+			name:        "Receiver fails to listen",
+			r:           &mockReceiver{listenErr: errors.New("listen error")},
 			expectError: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := &Controller{}
-			tt.setup(c)
+			srv, err := NewServer(
+				withReceiver(tt.r),
+				WithHeartbeatInterval(time.Second),
+			)
+			require.NoError(t, err)
+			require.NotNil(t, srv)
 
-			err := c.Start()
+			err = srv.Start()
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
 			}
+			assert.Equal(t, 1, tt.r.listenCount)
+
+			err = srv.Stop()
+			assert.NoError(t, err)
+			assert.Equal(t, 1, tt.r.closeCount)
 		})
 	}
 }
-*/
+
+func TestServer_StartListenFailsStopsHeartbeat(t *testing.T) {
+	r := &mockReceiver{listenErrs: []error{errors.New("listen error")}}
+	srv, err := NewServer(
+		withReceiver(r),
+		WithHeartbeatInterval(time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	assert.Error(t, srv.Start())
+	assert.Nil(t, srv.heartbeatCancel)
+
+	// The heartbeat goroutine has exited, so nothing more is sent.
+	time.Sleep(10 * time.Millisecond)
+	sent := counter.count.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, sent, counter.count.Load())
+
+	// A failed Start doesn't need a Stop before trying again.
+	require.NoError(t, srv.Start())
+	assert.Equal(t, 2, r.listenCount)
+	assert.Eventually(t, func() bool {
+		return counter.count.Load() > sent
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, srv.Stop())
+}
 
 func TestEnd2End(t *testing.T) {
 	url, err := findOpenURL()
@@ -104,9 +259,11 @@ func TestEnd2End(t *testing.T) {
 	var heartbeat int
 	var other int
 	var lock sync.Mutex
+	fc := newFakeClock()
 	c, err := NewServer(
 		RXURL(url),
 		WithHeartbeatInterval(100*time.Millisecond),
+		withClock(fc),
 		WithTXObserver(
 			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
 				if msg.Type == wrp.ServiceAliveMessageType {
@@ -136,18 +293,2537 @@ func TestEnd2End(t *testing.T) {
 		Type: wrp.SimpleEventMessageType,
 	})
 
+	// The heartbeat is sent once the interval passes, and the next one is
+	// scheduled once it is done.
+	fc.BlockUntil(1)
+	fc.Advance(100 * time.Millisecond)
+	fc.BlockUntil(1)
+
+	lock.Lock()
+	assert.Equal(t, 1, heartbeat)
+	assert.Positive(t, other)
+	lock.Unlock()
+
+	err = c.Stop()
+	assert.NoError(t, err)
+}
+
+func TestServer_SenderHealth(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	// Use a receiver as the service the server sends to.
+	var lock sync.Mutex
+	var got []wrp.Message
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				lock.Lock()
+				got = append(got, msg)
+				lock.Unlock()
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	_, ok := srv.SenderHealth("service")
+	assert.False(t, ok)
+	assert.False(t, srv.IsServiceConnected("service"))
+
+	err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "service",
+		URL:         url,
+	})
+	require.NoError(t, err)
+	assert.True(t, srv.IsServiceConnected("service"))
+
+	before := time.Now()
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/service/ignored",
+	})
+	require.NoError(t, err)
+
+	h, ok := srv.SenderHealth("service")
+	require.True(t, ok)
+	assert.True(t, h.Connected)
+	assert.NoError(t, h.LastErr)
+	assert.False(t, h.LastSend.Before(before))
+	assert.Zero(t, h.Queued)
+	assert.Zero(t, h.Reconnects)
+}
+
+func TestServer_FormatNegotiation(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	// The service only understands JSON.
+	got := make(chan wrp.Message, 1)
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+		receiver.WithFormats(wrp.JSON),
+		receiver.WithModifyWRP(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+				got <- msg
+			}),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	// The server prefers msgpack, but also supports JSON.
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithFormatNegotiation(wrp.Msgpack, wrp.JSON),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	// A service without a common format is rejected.
+	err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "service",
+		URL:         url,
+		Metadata: map[string]string{
+			FormatsMetadataKey: "text/plain",
+		},
+	})
+	assert.ErrorIs(t, err, errNoCommonFormat)
+
+	err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "service",
+		URL:         url,
+		Metadata: map[string]string{
+			FormatsMetadataKey: wrp.MimeTypeJson,
+		},
+	})
+	require.NoError(t, err)
+
+	// Skip the authorization message sent on registration.
 	for {
-		lock.Lock()
-		hb := heartbeat
-		o := other
-		lock.Unlock()
+		select {
+		case msg := <-got:
+			if msg.Type == wrp.AuthorizationMessageType {
+				err = srv.ProcessWRP(context.Background(), wrp.Message{
+					Type:        wrp.SimpleEventMessageType,
+					Source:      "dns:example.com",
+					Destination: "mac:112233445566/service/ignored",
+				})
+				require.NoError(t, err)
+				continue
+			}
 
-		if hb > 0 && o > 0 {
-			break
+			assert.Equal(t, wrp.SimpleEventMessageType, msg.Type)
+			assert.Equal(t, "dns:example.com", msg.Source)
+			return
+		case <-time.After(10 * time.Second):
+			require.Fail(t, "timed out waiting for message")
+			return
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
+}
 
-	err = c.Stop()
-	assert.NoError(t, err)
+// rewriter is an egress processor that also implements wrp.Modifier.
+type rewriter struct {
+	dest string
+}
+
+func (r *rewriter) ProcessWRP(context.Context, wrp.Message) error {
+	return wrp.ErrNotHandled
+}
+
+func (r *rewriter) ModifyWRP(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+	msg.Destination = r.dest
+	return msg, nil
+}
+
+func TestServer_EgressProcessor(t *testing.T) {
+	vetoErr := errors.New("veto")
+
+	tests := []struct {
+		name        string
+		procs       []wrp.Processor
+		expectDest  string
+		expectedErr error
+	}{
+		{
+			name:       "No processors",
+			expectDest: "mac:112233445566/service",
+		}, {
+			name: "Processor that does not handle the message",
+			procs: []wrp.Processor{
+				wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+					return wrp.ErrNotHandled
+				}),
+			},
+			expectDest: "mac:112233445566/service",
+		}, {
+			name: "Transform",
+			procs: []wrp.Processor{
+				&rewriter{dest: "mac:112233445566/other"},
+			},
+			expectDest: "mac:112233445566/other",
+		}, {
+			name: "Transform then veto",
+			procs: []wrp.Processor{
+				&rewriter{dest: "mac:112233445566/other"},
+				wrp.ProcessorFunc(func(_ context.Context, msg wrp.Message) error {
+					if msg.Destination == "mac:112233445566/other" {
+						return vetoErr
+					}
+					return nil
+				}),
+			},
+			expectedErr: vetoErr,
+		}, {
+			name: "Veto",
+			procs: []wrp.Processor{
+				wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+					return vetoErr
+				}),
+				&rewriter{dest: "mac:112233445566/other"},
+			},
+			expectedErr: vetoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []wrp.Message
+			opts := []ServerOption{
+				withReceiver(&mockReceiver{}),
+				WithEgressModifier(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+						got = append(got, msg)
+					}),
+				)),
+			}
+			for _, p := range tt.procs {
+				opts = append(opts, WithEgressProcessor(p))
+			}
+
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			err = srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			})
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Empty(t, got)
+				return
+			}
+
+			assert.NoError(t, err)
+			require.Len(t, got, 1)
+			assert.Equal(t, tt.expectDest, got[0].Destination)
+		})
+	}
+}
+
+func TestServer_MessageHook(t *testing.T) {
+	vetoErr := errors.New("veto")
+
+	tests := []struct {
+		name        string
+		hooks       []func(context.Context, *wrp.Message) error
+		expectDest  string
+		expectedErr error
+	}{
+		{
+			name: "Rewrite the destination",
+			hooks: []func(context.Context, *wrp.Message) error{
+				func(_ context.Context, msg *wrp.Message) error {
+					msg.Destination = "mac:112233445566/other"
+					return nil
+				},
+			},
+			expectDest: "mac:112233445566/other",
+		}, {
+			name: "Veto",
+			hooks: []func(context.Context, *wrp.Message) error{
+				func(context.Context, *wrp.Message) error {
+					return vetoErr
+				},
+				func(context.Context, *wrp.Message) error {
+					t.Error("should not be called")
+					return nil
+				},
+			},
+			expectedErr: vetoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []wrp.Message
+			opts := []ServerOption{
+				withReceiver(&mockReceiver{}),
+				WithEgressModifier(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+						got = append(got, msg)
+					}),
+				)),
+			}
+			for _, hook := range tt.hooks {
+				opts = append(opts, WithMessageHook(hook))
+			}
+
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			err = srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			})
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Empty(t, got)
+				return
+			}
+
+			assert.NoError(t, err)
+			require.Len(t, got, 1)
+			assert.Equal(t, tt.expectDest, got[0].Destination)
+		})
+	}
+}
+
+func TestServer_IngressProcessor(t *testing.T) {
+	var order []string
+	record := func(name string) wrp.Processor {
+		return wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+			order = append(order, name)
+			return wrp.ErrNotHandled
+		})
+	}
+
+	tests := []struct {
+		name        string
+		msg         wrp.Message
+		expect      []string
+		expectedErr error
+	}{
+		{
+			name: "All positions",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			expect: []string{
+				"before filters 1",
+				"before filters 2",
+				"after filters",
+				"tx observer",
+				"before senders",
+			},
+			expectedErr: wrp.ErrNotHandled,
+		}, {
+			name: "Stopped by the filters",
+			msg: wrp.Message{
+				Type: wrp.ServiceAliveMessageType,
+			},
+			expect: []string{
+				"before filters 1",
+				"before filters 2",
+			},
+			expectedErr: filters.ErrLocalDisallowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order = nil
+
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithIngressProcessor(record("before senders"), BeforeSenders),
+				WithIngressProcessor(record("after filters"), AfterFilters),
+				WithIngressProcessor(record("before filters 1"), BeforeFilters),
+				WithIngressProcessor(record("before filters 2"), BeforeFilters),
+				WithTXObserver(wrp.ObserverFunc(func(context.Context, wrp.Message) {
+					order = append(order, "tx observer")
+				})),
+			)
+			require.NoError(t, err)
+
+			err = srv.ProcessWRP(context.Background(), tt.msg)
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expect, order)
+		})
+	}
+}
+
+func TestWithIngressProcessor_InvalidPosition(t *testing.T) {
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithIngressProcessor(wrp.ProcessorFunc(func(context.Context, wrp.Message) error {
+			return nil
+		}), Position(99)),
+	)
+	assert.Error(t, err)
+	assert.Nil(t, srv)
+}
+
+func TestServer_ProcessWRP(t *testing.T) {
+	tests := []struct {
+		name        string
+		nilCtx      bool
+		msg         wrp.Message
+		expectedErr error
+		notErr      error
+		expectSent  int
+	}{
+		{
+			name: "Success",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			expectSent: 1,
+		}, {
+			name: "Filtered",
+			msg: wrp.Message{
+				Type: wrp.ServiceAliveMessageType,
+			},
+			expectedErr: filters.ErrLocalDisallowed,
+			notErr:      ErrNoRoute,
+		}, {
+			name: "No route",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/unknown",
+			},
+			expectedErr: ErrNoRoute,
+		}, {
+			name:   "Nil context",
+			nilCtx: true,
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			expectSent: 1,
+		}, {
+			name:        "Nil context and zero-value message",
+			nilCtx:      true,
+			expectedErr: ErrInvalidMessage,
+		}, {
+			name:        "Zero-value message",
+			expectedErr: ErrInvalidMessage,
+			notErr:      ErrNoRoute,
+		}, {
+			name: "Invalid message type",
+			msg: wrp.Message{
+				Type:        wrp.LastMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			expectedErr: filters.ErrUnsupported,
+			notErr:      ErrNoRoute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(withReceiver(&mockReceiver{}))
+			require.NoError(t, err)
+
+			ms := &mockSender{}
+			srv.senders.senders = map[string]limitedSender{
+				"service": ms,
+			}
+
+			ctx := context.Background()
+			if tt.nilCtx {
+				ctx = nil
+			}
+
+			err = srv.ProcessWRP(ctx, tt.msg)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				if errors.Is(tt.expectedErr, ErrNoRoute) {
+					assert.ErrorIs(t, err, wrp.ErrNotHandled)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.notErr != nil {
+				assert.NotErrorIs(t, err, tt.notErr)
+			}
+			assert.Equal(t, tt.expectSent, ms.processCount)
+		})
+	}
+}
+
+func TestServer_ProcessWRPDisconnected(t *testing.T) {
+	tests := []struct {
+		name        string
+		dest        string
+		expectedErr []error
+		notErr      error
+	}{
+		{
+			name:        "unknown service",
+			dest:        "mac:112233445566/unknown",
+			expectedErr: []error{ErrNoRoute, wrp.ErrNotHandled},
+			notErr:      ErrServiceDisconnected,
+		}, {
+			name:        "registered but disconnected",
+			dest:        "mac:112233445566/service",
+			expectedErr: []error{ErrServiceDisconnected, ErrConnClosed},
+			notErr:      ErrNoRoute,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(withReceiver(&mockReceiver{}))
+			require.NoError(t, err)
+
+			// A sender that was closed, but not yet removed from the map.
+			url := fmt.Sprintf("inproc://disconnected-%d", i)
+			s, err := sender.New(sender.WithURL(url))
+			require.NoError(t, err)
+			require.NoError(t, s.Close())
+
+			srv.senders.senders = map[string]limitedSender{
+				"service": s,
+			}
+
+			err = srv.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: tt.dest,
+			})
+			for _, want := range tt.expectedErr {
+				assert.ErrorIs(t, err, want)
+			}
+			assert.NotErrorIs(t, err, tt.notErr)
+		})
+	}
+}
+
+func TestServer_DeadLetterHandler(t *testing.T) {
+	sendErr := errors.New("send failed")
+
+	tests := []struct {
+		name        string
+		msg         wrp.Message
+		sendErr     error
+		panics      bool
+		expectedErr error
+		expectDead  bool
+	}{
+		{
+			name: "unknown destination",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/unknown",
+			},
+			expectedErr: ErrNoRoute,
+			expectDead:  true,
+		}, {
+			name: "routed",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+		}, {
+			name: "send fails",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			},
+			sendErr:     sendErr,
+			expectedErr: sendErr,
+		}, {
+			name: "filtered",
+			msg: wrp.Message{
+				Type: wrp.ServiceRegistrationMessageType,
+			},
+			expectedErr: filters.ErrLocalDisallowed,
+		}, {
+			name: "handler panics",
+			msg: wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/unknown",
+			},
+			panics:      true,
+			expectedErr: ErrNoRoute,
+			expectDead:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dead []wrp.Message
+			var observerErrs []error
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithDeadLetterHandler(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+					dead = append(dead, msg)
+					if tt.panics {
+						panic("dead letter")
+					}
+				})),
+				WithObserverErrorHandler(func(err error) {
+					observerErrs = append(observerErrs, err)
+				}),
+			)
+			require.NoError(t, err)
+
+			srv.senders.senders = map[string]limitedSender{
+				"service": &mockSender{processErr: tt.sendErr},
+			}
+
+			err = srv.ProcessWRP(context.Background(), tt.msg)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if !tt.expectDead {
+				assert.Empty(t, dead)
+				return
+			}
+			assert.Equal(t, []wrp.Message{tt.msg}, dead)
+			if tt.panics {
+				require.Len(t, observerErrs, 1)
+				assert.ErrorIs(t, observerErrs[0], ErrObserverPanic)
+			}
+		})
+	}
+}
+
+// countingModifier is a comparable egress modifier.
+type countingModifier struct {
+	count int
+}
+
+func (c *countingModifier) ModifyWRP(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+	c.count++
+	return msg, nil
+}
+
+func TestServer_AddRemoveEgressModifier(t *testing.T) {
+	first := &countingModifier{}
+	second := &countingModifier{}
+	var funcCount int
+	fn := wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+		funcCount++
+		return msg, nil
+	})
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithEgressModifier(first),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	send := func() {
+		err := srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "dns:example.com",
+			Destination: "mac:112233445566/service",
+		})
+		require.NoError(t, err)
+	}
+
+	send()
+	assert.Equal(t, 1, first.count)
+
+	// Add at runtime.
+	cancelSecond := srv.AddEgressModifier(second)
+	cancelFn := srv.AddEgressModifier(fn)
+	send()
+	assert.Equal(t, 2, first.count)
+	assert.Equal(t, 1, second.count)
+	assert.Equal(t, 1, funcCount)
+
+	// Remove at runtime, including one added as an option.
+	assert.True(t, srv.RemoveEgressModifier(first))
+	assert.False(t, srv.RemoveEgressModifier(first))
+	assert.False(t, srv.RemoveEgressModifier(fn))
+	send()
+	assert.Equal(t, 2, first.count)
+	assert.Equal(t, 2, second.count)
+	assert.Equal(t, 2, funcCount)
+
+	// Remove using the cancel functions.
+	cancelSecond()
+	cancelFn()
+	cancelFn()
+	send()
+	assert.Equal(t, 2, second.count)
+	assert.Equal(t, 2, funcCount)
+	assert.False(t, srv.RemoveEgressModifier(second))
+}
+
+func TestServer_EgressModifierChangedByModifier(t *testing.T) {
+	added := &countingModifier{}
+	var srv *Server
+	var calls int
+	var cancelSelf func()
+	self := wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+		calls++
+		cancelSelf()
+		srv.AddEgressModifier(added)
+		return msg, nil
+	})
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithEgressModifier(self, &cancelSelf),
+	)
+	require.NoError(t, err)
+
+	send := func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			})
+		}()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "changing the modifiers from a modifier deadlocked")
+		}
+	}
+
+	// The changes apply from the next message.
+	send()
+	assert.Equal(t, 1, calls)
+	assert.Zero(t, added.count)
+
+	send()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, added.count)
+}
+
+func TestServer_ClearEgressModifiers(t *testing.T) {
+	mods := []*countingModifier{{}, {}, {}, {}}
+
+	var cancelFirst func()
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithEgressModifier(mods[0], &cancelFirst),
+		WithEgressModifier(mods[1]),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 2, srv.EgressModifierCount())
+
+	srv.AddEgressModifier(mods[2])
+	cancelLast := srv.AddEgressModifier(mods[3])
+	assert.Equal(t, 4, srv.EgressModifierCount())
+
+	cancelLast()
+	assert.Equal(t, 3, srv.EgressModifierCount())
+
+	assert.Equal(t, 3, srv.ClearEgressModifiers())
+	assert.Zero(t, srv.EgressModifierCount())
+	assert.Zero(t, srv.ClearEgressModifiers())
+
+	// The cancel functions still work, and do nothing.
+	cancelFirst()
+	assert.Zero(t, srv.EgressModifierCount())
+
+	assert.Zero(t, srv.egress.Len())
+
+	// The RoundTrip responses are still matched.
+	response, remove, err := srv.roundTrips.add("tid")
+	require.NoError(t, err)
+	defer remove()
+
+	err = srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:example.com",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "tid",
+	})
+	require.NoError(t, err)
+	for _, m := range mods {
+		assert.Zero(t, m.count)
+	}
+
+	select {
+	case msg := <-response:
+		assert.Equal(t, "tid", msg.TransactionUUID)
+	default:
+		assert.Fail(t, "the response wasn't matched")
+	}
+
+	// Modifiers can be added again.
+	srv.AddEgressModifier(mods[0])
+	assert.Equal(t, 1, srv.EgressModifierCount())
+}
+
+func TestServer_HeartbeatWithStuckSender(t *testing.T) {
+	fc := newFakeClock()
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(20*time.Millisecond),
+		withClock(fc),
+	)
+	require.NoError(t, err)
+
+	stuck := &stuckSender{release: make(chan struct{})}
+	defer close(stuck.release)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"stuck":   stuck,
+		"counter": counter,
+	}
+
+	require.NoError(t, srv.Start())
+
+	// Each heartbeat is sent once the interval passes, and the next one is
+	// scheduled once the send is done.
+	for i := 0; i < 5; i++ {
+		fc.BlockUntil(1)
+		fc.Advance(20 * time.Millisecond)
+	}
+	fc.BlockUntil(1)
+	require.NoError(t, srv.Stop())
+
+	// The stuck sender never returns, but the heartbeats keep going.
+	assert.Equal(t, int64(5), counter.count.Load())
+}
+
+func TestServer_RegistrationListener(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	type registration struct {
+		name         string
+		url          string
+		reregistered bool
+	}
+	got := make(chan registration, 2)
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithRegistrationListener(func(name, url string, reregistered bool) {
+			got <- registration{name: name, url: url, reregistered: reregistered}
+		}),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	register := wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "service",
+		URL:         url,
+	}
+
+	for _, want := range []registration{
+		{name: "service", url: url},
+		{name: "service", url: url, reregistered: true},
+	} {
+		require.NoError(t, srv.handleRegisterMsg(context.Background(), register))
+
+		select {
+		case r := <-got:
+			assert.Equal(t, want, r)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the registration listener was not called")
+		}
+	}
+
+	// Failed registrations are not reported.
+	err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "service",
+	})
+	require.Error(t, err)
+
+	select {
+	case r := <-got:
+		assert.Fail(t, "unexpected registration", "%+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServer_RejectURLChange(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+	other, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithRejectURLChange(),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	register := func(url string) error {
+		return srv.handleRegisterMsg(context.Background(), wrp.Message{
+			Type:        wrp.ServiceRegistrationMessageType,
+			ServiceName: "service",
+			URL:         url,
+		})
+	}
+
+	require.NoError(t, register(url))
+	assert.NoError(t, register(url), "same url")
+	assert.ErrorIs(t, register(other), ErrURLChanged, "different url")
+	assert.True(t, srv.IsServiceConnected("service"))
+}
+
+func TestServer_MaxSenders(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithMaxSenders(1),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	register := func(name string) error {
+		return srv.handleRegisterMsg(context.Background(), wrp.Message{
+			Type:        wrp.ServiceRegistrationMessageType,
+			ServiceName: name,
+			URL:         url,
+		})
+	}
+
+	require.NoError(t, register("service"))
+	assert.NoError(t, register("service"), "existing name")
+	assert.ErrorIs(t, register("other"), ErrTooManySenders, "new name")
+	assert.True(t, srv.IsServiceConnected("service"))
+	assert.False(t, srv.IsServiceConnected("other"))
+
+	// Removing a service makes room for a new one.
+	require.NoError(t, srv.senders.Remove("service"))
+	assert.NoError(t, register("other"))
+}
+
+func TestServer_ReregisterKeepsConnection(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	var lock sync.Mutex
+	var reregistered []bool
+	srv.registered.Add(func(_, _ string, r bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		reregistered = append(reregistered, r)
+	})
+
+	register := func() {
+		require.NoError(t, srv.handleRegisterMsg(context.Background(), wrp.Message{
+			Type:        wrp.ServiceRegistrationMessageType,
+			ServiceName: "service",
+			URL:         url,
+		}))
+	}
+
+	register()
+	first := srv.senders.senders["service"]
+	register()
+	assert.Same(t, first, srv.senders.senders["service"])
+	assert.True(t, srv.IsServiceConnected("service"))
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reregistered) == 2
+	}, 5*time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.ElementsMatch(t, []bool{false, true}, reregistered)
+}
+
+func TestWithBroadcastTimeout(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBroadcastTimeout, srv.senders.sendTimeout)
+
+	srv, err = NewServer(
+		withReceiver(&mockReceiver{}),
+		WithBroadcastTimeout(time.Second),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, srv.senders.sendTimeout)
+}
+
+func TestWithMaxSenders(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		expectError bool
+	}{
+		{name: "no limit"},
+		{name: "limit", n: 10},
+		{name: "negative", n: -1, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithMaxSenders(tt.n),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, srv)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.n, srv.senders.max)
+		})
+	}
+}
+
+func TestWithReceiveWorkers(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		expectError bool
+	}{
+		{name: "goroutine per message"},
+		{name: "workers", n: 4},
+		{name: "negative", n: -1, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(
+				RXURL("tcp://127.0.0.1:6000"),
+				WithReceiveWorkers(tt.n),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, srv)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, srv)
+		})
+	}
+}
+
+func TestWithMaxMessageBytes(t *testing.T) {
+	srv, err := NewServer(
+		RXURL("tcp://127.0.0.1:6000"),
+		WithMaxMessageBytes(1024),
+	)
+	require.NoError(t, err)
+
+	var buf []byte
+	require.NoError(t, wrp.NewEncoderBytes(&buf, wrp.Msgpack).Encode(wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/config",
+		Destination: "event:status",
+		Payload:     make([]byte, 2048),
+	}))
+
+	err = srv.InjectRaw(context.Background(), buf)
+	assert.ErrorIs(t, err, receiver.ErrMessageTooLarge)
+}
+
+func TestServer_BaseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &mockReceiver{}
+	srv, err := NewServer(
+		withReceiver(r),
+		WithBaseContext(ctx),
+		WithHeartbeatInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	require.NoError(t, srv.Start())
+	assert.Eventually(t, func() bool {
+		return counter.count.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		srv.lock.Lock()
+		defer srv.lock.Unlock()
+		return srv.heartbeatCancel == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	srv.lock.Lock()
+	assert.Equal(t, 1, r.closeCount)
+	srv.lock.Unlock()
+
+	// The heartbeats have stopped.
+	stopped := counter.count.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, stopped, counter.count.Load())
+
+	// Stopping again is harmless.
+	assert.NoError(t, srv.Stop())
+}
+
+func TestServer_Run(t *testing.T) {
+	receiverErr := errors.New("receiver error")
+
+	tests := []struct {
+		name        string
+		r           *mockReceiver
+		fail        error
+		expectError error
+	}{
+		{
+			name: "Stop when the context is canceled",
+			r:    &mockReceiver{},
+		}, {
+			name:        "Stop when the receiver fails",
+			r:           &mockReceiver{},
+			fail:        receiverErr,
+			expectError: receiverErr,
+		}, {
+			name:        "Fail to start",
+			r:           &mockReceiver{listenErr: receiverErr},
+			expectError: receiverErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(withReceiver(tt.r))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- srv.Run(ctx)
+			}()
+
+			if tt.r.listenErr == nil {
+				// Wait for the Server to be running.
+				assert.Eventually(t, func() bool {
+					srv.lock.Lock()
+					defer srv.lock.Unlock()
+					return srv.heartbeatCancel != nil
+				}, 5*time.Second, time.Millisecond)
+
+				if tt.fail != nil {
+					srv.receiverClosed(tt.fail)
+				} else {
+					cancel()
+				}
+			}
+
+			select {
+			case err := <-done:
+				if tt.expectError != nil {
+					assert.ErrorIs(t, err, tt.expectError)
+				} else {
+					assert.NoError(t, err)
+				}
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "Run did not return")
+			}
+
+			// Run only returns after the Server is stopped.
+			srv.lock.Lock()
+			defer srv.lock.Unlock()
+			assert.Nil(t, srv.heartbeatCancel)
+			assert.Equal(t, 1, tt.r.closeCount)
+		})
+	}
+}
+
+func TestServer_ReceiverFailure(t *testing.T) {
+	receiverErr := errors.New("receiver error")
+	listenErr := errors.New("listen error")
+	backoff := Backoff{Initial: time.Millisecond, MaxFailures: 3}
+
+	tests := []struct {
+		name          string
+		opts          []ServerOption
+		stopped       bool
+		listenErrs    []error
+		expectListens int
+		expectRunErr  error
+	}{
+		{
+			name:          "Failure is reported",
+			expectListens: 1,
+			expectRunErr:  receiverErr,
+		}, {
+			name:          "Receiver is restarted",
+			opts:          []ServerOption{WithReceiverRestart(backoff)},
+			expectListens: 2,
+		}, {
+			name:          "Receiver is restarted after failed attempts",
+			opts:          []ServerOption{WithReceiverRestart(backoff)},
+			listenErrs:    []error{listenErr, listenErr},
+			expectListens: 4,
+		}, {
+			name:          "Restart gives up",
+			opts:          []ServerOption{WithReceiverRestart(backoff)},
+			listenErrs:    []error{listenErr, listenErr, listenErr},
+			expectListens: 4,
+			expectRunErr:  ErrReceiverRestart,
+		}, {
+			name:          "No restart after stopping",
+			opts:          []ServerOption{WithReceiverRestart(backoff)},
+			stopped:       true,
+			expectListens: 1,
+			expectRunErr:  receiverErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []error
+			r := &mockReceiver{}
+			opts := append([]ServerOption{
+				withReceiver(r),
+				WithReceiverFailureListener(func(err error) {
+					got = append(got, err)
+				}),
+			}, tt.opts...)
+
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+			require.NoError(t, srv.Start())
+			defer srv.Stop() // nolint:errcheck
+
+			if tt.stopped {
+				require.NoError(t, srv.Stop())
+			}
+
+			// Closing the receiver normally is not a failure.
+			srv.receiverClosed(context.Canceled)
+			assert.Empty(t, got)
+
+			r.listenErrs = tt.listenErrs
+			srv.receiverClosed(receiverErr)
+			require.Len(t, got, 1)
+			assert.ErrorIs(t, got[0], receiverErr)
+			assert.Equal(t, tt.expectListens, r.listenCount)
+
+			select {
+			case err := <-srv.rxFailed:
+				if assert.NotNil(t, tt.expectRunErr, "unexpected failure reported to Run") {
+					assert.ErrorIs(t, err, tt.expectRunErr)
+				}
+			default:
+				assert.Nil(t, tt.expectRunErr, "failure not reported to Run")
+			}
+		})
+	}
+}
+
+func TestServer_ReceiverRestartStopped(t *testing.T) {
+	fc := newFakeClock()
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithReceiverRestart(Backoff{Initial: time.Hour}),
+		WithHeartbeatInterval(0),
+		withClock(fc),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+
+	// Stopping the Server ends the wait for the next attempt.
+	done := make(chan struct{})
+	go func() {
+		srv.receiverClosed(errors.New("receiver error"))
+		close(done)
+	}()
+
+	fc.BlockUntil(1)
+	require.NoError(t, srv.Stop())
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the restart did not stop")
+	}
+}
+
+func TestServer_RouteKey(t *testing.T) {
+	// Two services with the same name under different schemes.
+	got := map[string]chan wrp.Message{
+		"mac":    make(chan wrp.Message, 10),
+		"serial": make(chan wrp.Message, 10),
+	}
+	urls := make(map[string]string)
+	for scheme, ch := range got {
+		url, err := findOpenURL()
+		require.NoError(t, err)
+		urls[scheme] = url
+
+		svc, err := receiver.New(
+			receiver.WithURL(url),
+			receiver.WithRecvTimeout(10*time.Millisecond),
+			receiver.WithModifyWRP(wrp.ObserverAsModifier(
+				wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+					if msg.Type == wrp.SimpleEventMessageType {
+						ch <- msg
+					}
+				}),
+			)),
+		)
+		require.NoError(t, err)
+		require.NoError(t, svc.Listen())
+		defer svc.Close() // nolint:errcheck
+	}
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithRouteKey(RouteBySchemeAndService),
+	)
+	require.NoError(t, err)
+	defer srv.Stop() // nolint:errcheck
+
+	sources := map[string]string{
+		"mac":    "mac:112233445566/config",
+		"serial": "serial:1234/config",
+	}
+	for scheme, src := range sources {
+		err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+			Type:        wrp.ServiceRegistrationMessageType,
+			Source:      src,
+			ServiceName: "config",
+			URL:         urls[scheme],
+		})
+		require.NoError(t, err)
+		assert.True(t, srv.IsServiceConnected(scheme+":config"))
+	}
+
+	// A registration without a source locator can't be keyed.
+	err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+		Type:        wrp.ServiceRegistrationMessageType,
+		ServiceName: "config",
+		URL:         urls["mac"],
+	})
+	assert.ErrorIs(t, err, errInvalidMsg)
+
+	for scheme, src := range sources {
+		err = srv.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "dns:example.com",
+			Destination: src,
+		})
+		require.NoError(t, err)
+
+		select {
+		case msg := <-got[scheme]:
+			assert.Equal(t, src, msg.Destination)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "message not received", scheme)
+		}
+	}
+
+	for scheme, ch := range got {
+		assert.Empty(t, ch, scheme)
+	}
+}
+
+func TestServer_RouteKeyFunc(t *testing.T) {
+	// Messages are routed by a "route:" header instead of the destination.
+	byHeader := func(msg wrp.Message) (string, error) {
+		for _, h := range msg.Headers {
+			if name, ok := strings.CutPrefix(h, "route:"); ok {
+				return name, nil
+			}
+		}
+		return "", errors.New("no route header")
+	}
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithRouteKeyFunc(byHeader),
+	)
+	require.NoError(t, err)
+
+	config, logs := &mockSender{}, &mockSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"config": config,
+		"logs":   logs,
+	}
+
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/config",
+		Headers:     []string{"route:logs"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, config.processCount)
+	assert.Equal(t, 1, logs.processCount)
+
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/config",
+	})
+	assert.ErrorContains(t, err, "no route header")
+	assert.Equal(t, 0, config.processCount)
+}
+
+func TestServer_MetadataRoute(t *testing.T) {
+	byHeader := func(msg wrp.Message) (string, error) {
+		for _, h := range msg.Headers {
+			if name, ok := strings.CutPrefix(h, "route:"); ok {
+				return name, nil
+			}
+		}
+		return "", errors.New("no route header")
+	}
+
+	tests := []struct {
+		name        string
+		opts        []ServerOption
+		metadata    map[string]string
+		headers     []string
+		expectRoute string
+		expectErr   bool
+	}{
+		{
+			name:        "Off by default",
+			metadata:    map[string]string{"route": "logs"},
+			expectRoute: "config",
+		}, {
+			name:        "Metadata names the service",
+			opts:        []ServerOption{WithMetadataRoute("route")},
+			metadata:    map[string]string{"route": "logs"},
+			expectRoute: "logs",
+		}, {
+			name:        "No metadata key uses the destination",
+			opts:        []ServerOption{WithMetadataRoute("route")},
+			metadata:    map[string]string{"other": "logs"},
+			expectRoute: "config",
+		}, {
+			name:        "Empty value uses the destination",
+			opts:        []ServerOption{WithMetadataRoute("route")},
+			metadata:    map[string]string{"route": ""},
+			expectRoute: "config",
+		}, {
+			name:        "Metadata matches wildcards",
+			opts:        []ServerOption{WithMetadataRoute("route"), WithWildcardRoutes()},
+			metadata:    map[string]string{"route": "metrics-east"},
+			expectRoute: "metrics-*",
+		}, {
+			name:      "Unknown service",
+			opts:      []ServerOption{WithMetadataRoute("route")},
+			metadata:  map[string]string{"route": "missing"},
+			expectErr: true,
+		}, {
+			name: "Metadata takes precedence over the route key function",
+			opts: []ServerOption{
+				WithRouteKeyFunc(byHeader),
+				WithMetadataRoute("route"),
+			},
+			metadata:    map[string]string{"route": "logs"},
+			headers:     []string{"route:metrics-east"},
+			expectRoute: "logs",
+		}, {
+			name: "The route key function without the metadata",
+			opts: []ServerOption{
+				WithMetadataRoute("route"),
+				WithRouteKeyFunc(byHeader),
+				WithWildcardRoutes(),
+			},
+			headers:     []string{"route:metrics-east"},
+			expectRoute: "metrics-*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ServerOption{withReceiver(&mockReceiver{})}, tt.opts...)
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			senders := map[string]*mockSender{
+				"config":    {},
+				"logs":      {},
+				"metrics-*": {},
+			}
+			srv.senders.senders = map[string]limitedSender{}
+			for name, s := range senders {
+				srv.senders.senders[name] = s
+			}
+
+			err = srv.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/config",
+				Metadata:    tt.metadata,
+				Headers:     tt.headers,
+			})
+
+			if tt.expectErr {
+				assert.ErrorIs(t, err, ErrNoRoute)
+				for _, s := range senders {
+					assert.Zero(t, s.processCount)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			for name, s := range senders {
+				if name == tt.expectRoute {
+					assert.Equal(t, 1, s.processCount, name)
+				} else {
+					assert.Zero(t, s.processCount, name)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Name(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+	assert.Empty(t, srv.Name())
+
+	srv, err = NewServer(withReceiver(&mockReceiver{}), WithName("ingress-a"))
+	require.NoError(t, err)
+	assert.Equal(t, "ingress-a", srv.Name())
+
+	// The name labels the errors logged by the default handler.
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	srv.observerErr(ErrObserverPanic)
+	assert.Contains(t, buf.String(), "server=ingress-a")
+}
+
+func TestServer_AddRXObserver(t *testing.T) {
+	var got []wrp.Message
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/service",
+	}
+
+	// Not attached yet.
+	_ = srv.rxChain.ProcessWRP(context.Background(), msg)
+
+	cancel := srv.AddRXObserver(wrp.ObserverFunc(func(_ context.Context, m wrp.Message) {
+		got = append(got, m)
+	}))
+	_ = srv.rxChain.ProcessWRP(context.Background(), msg)
+	require.Len(t, got, 1)
+	assert.Equal(t, msg, got[0])
+
+	// Detached.
+	cancel()
+	_ = srv.rxChain.ProcessWRP(context.Background(), msg)
+	assert.Len(t, got, 1)
+}
+
+func TestServer_RXObserverChangedByObserver(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+
+	var calls, addedCalls int
+	var cancelSelf func()
+	cancelSelf = srv.AddRXObserver(wrp.ObserverFunc(func(context.Context, wrp.Message) {
+		calls++
+		cancelSelf()
+		srv.AddRXObserver(wrp.ObserverFunc(func(context.Context, wrp.Message) {
+			addedCalls++
+		}))
+	}))
+
+	send := func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: "mac:112233445566/service",
+			})
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "changing the observers from an observer deadlocked")
+		}
+	}
+
+	// The changes apply from the next message.
+	send()
+	assert.Equal(t, 1, calls)
+	assert.Zero(t, addedCalls)
+
+	send()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, addedCalls)
+}
+
+func TestServer_MessageValidation(t *testing.T) {
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithMessageValidation(),
+	)
+	require.NoError(t, err)
+
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:        wrp.SimpleRequestResponseMessageType,
+		Source:      "dns:example.com",
+		Destination: "mac:112233445566/service",
+	})
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+	assert.NotErrorIs(t, err, ErrNoRoute)
+
+	err = srv.ProcessWRP(context.Background(), wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:example.com",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "1234",
+	})
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
+
+func TestServer_ObserverPanic(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	var got atomic.Int64
+	var lock sync.Mutex
+	var observerErrs []error
+	srv, err := NewServer(
+		RXURL(url),
+		WithRXObserver(wrp.ObserverFunc(func(context.Context, wrp.Message) {
+			panic("rx observer")
+		})),
+		WithRXObserver(wrp.ObserverFunc(func(context.Context, wrp.Message) {
+			got.Add(1)
+		})),
+		WithEgressModifier(wrp.ObserverAsModifier(
+			wrp.ObserverFunc(func(context.Context, wrp.Message) {
+				panic("egress modifier")
+			}),
+		)),
+		WithObserverErrorHandler(func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			observerErrs = append(observerErrs, err)
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	s, err := sender.New(sender.WithURL(url))
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	// The receiver keeps processing messages after the panics.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, s.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "mac:112233445566",
+			Destination: "event:test",
+		}))
+	}
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return got.Load() == 2 && len(observerErrs) == 4
+	}, 60*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, err := range observerErrs {
+		assert.ErrorIs(t, err, ErrObserverPanic)
+	}
+}
+
+func TestServer_HeartbeatObserverPanic(t *testing.T) {
+	errs := make(chan error, 10)
+	var observed atomic.Int64
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(time.Millisecond),
+		WithTXObserver(wrp.ObserverFunc(func(context.Context, wrp.Message) {
+			panic("tx observer")
+		})),
+		WithTXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+			if msg.Type == wrp.ServiceAliveMessageType {
+				observed.Add(1)
+			}
+		})),
+		WithObserverErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+
+	// The heartbeats continue after the observer panics.
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, ErrObserverPanic)
+			assert.ErrorContains(t, err, "tx observer")
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no heartbeat observer panic")
+		}
+	}
+	require.NoError(t, srv.Stop())
+
+	// The observer after the one that panics still sees the heartbeats.
+	assert.Positive(t, observed.Load())
+}
+
+func TestWithObserverErrorHandler_Default(t *testing.T) {
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithObserverErrorHandler(nil),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, srv.observerErr)
+
+	// The default handler only logs.
+	srv.observerErr(ErrObserverPanic)
+}
+
+func TestWithOrderedSends(t *testing.T) {
+	srv, err := NewServer(
+		RXURL("tcp://127.0.0.1:6000"),
+		WithOrderedSends(),
+	)
+	require.NoError(t, err)
+	assert.Len(t, srv.sOpts, 1)
+}
+
+func TestWithSenderOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		opt         ServerOption
+		expectError bool
+	}{
+		{name: "send retries", opt: WithSendRetries(3, time.Second)},
+		{name: "negative retries", opt: WithSendRetries(-1, 0), expectError: true},
+		{name: "drop on full", opt: WithDropOnFull()},
+		{name: "send buffer", opt: WithSendBuffer(16)},
+		{name: "negative send buffer", opt: WithSendBuffer(-1), expectError: true},
+		{name: "auto redial", opt: WithAutoRedial()},
+		{name: "close timeout", opt: WithSenderCloseTimeout(time.Second)},
+		{name: "negative close timeout", opt: WithSenderCloseTimeout(-1), expectError: true},
+		{name: "write queue length", opt: WithWriteQueueLen(8)},
+		{name: "empty write queue", opt: WithWriteQueueLen(0), expectError: true},
+		{name: "socket option", opt: WithSenderSocketOption(mangos.OptionKeepAlive, true)},
+		{name: "unnamed socket option", opt: WithSenderSocketOption("", true), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(withReceiver(&mockReceiver{}), tt.opt)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, srv)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, srv.sOpts, 1)
+
+			// The connections to the services can be set up for a Router too.
+			r, err := NewRouter(tt.opt)
+			require.NoError(t, err)
+			assert.Len(t, r.sOpts, 1)
+		})
+	}
+}
+
+func TestWithHeartbeatJitter(t *testing.T) {
+	tests := []struct {
+		name        string
+		fraction    float64
+		expectError bool
+	}{
+		{name: "none"},
+		{name: "half", fraction: 0.5},
+		{name: "almost full", fraction: 0.9},
+		{name: "full", fraction: 1, expectError: true},
+		{name: "negative", fraction: -0.1, expectError: true},
+		{name: "too large", fraction: 1.1, expectError: true},
+		{name: "NaN", fraction: math.NaN(), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithHeartbeatJitter(tt.fraction),
+			)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, srv)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.fraction, srv.heartbeatJitter)
+		})
+	}
+}
+
+func TestServer_HeartbeatJitterBounds(t *testing.T) {
+	const (
+		interval = 10 * time.Second
+		fraction = 0.2
+		samples  = 1000
+	)
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(interval),
+		WithHeartbeatJitter(fraction),
+	)
+	require.NoError(t, err)
+
+	lo := interval - time.Duration(fraction*float64(interval))
+	hi := interval + time.Duration(fraction*float64(interval))
+
+	var sum time.Duration
+	minDelay, maxDelay := hi, lo
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < samples; i++ {
+		d := srv.heartbeatDelay()
+		require.GreaterOrEqual(t, d, lo)
+		require.LessOrEqual(t, d, hi)
+
+		sum += d
+		minDelay = min(minDelay, d)
+		maxDelay = max(maxDelay, d)
+		seen[d] = struct{}{}
+	}
+
+	// The delays vary, use most of the range, and center on the interval.
+	assert.Greater(t, len(seen), samples/2)
+	assert.Less(t, minDelay, interval-time.Duration(0.8*fraction*float64(interval)))
+	assert.Greater(t, maxDelay, interval+time.Duration(0.8*fraction*float64(interval)))
+	assert.InDelta(t, float64(interval), float64(sum/samples), 0.05*float64(interval))
+}
+
+func TestServer_HeartbeatJitter(t *testing.T) {
+	fc := newFakeClock()
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(100*time.Millisecond),
+		WithHeartbeatJitter(0.5),
+		withClock(fc),
+		withRandom(func() float64 { return 0 }),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	require.NoError(t, srv.Start())
+
+	// With the lowest random value, each heartbeat comes at half the interval.
+	for i := 0; i < 3; i++ {
+		fc.BlockUntil(1)
+		fc.Advance(50 * time.Millisecond)
+	}
+	fc.BlockUntil(1)
+	require.NoError(t, srv.Stop())
+
+	assert.Equal(t, int64(3), counter.count.Load())
+}
+
+func TestServer_StopSenderCloseError(t *testing.T) {
+	errClose := errors.New("close failed")
+
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(0),
+	)
+	require.NoError(t, err)
+
+	srv.senders.senders = map[string]limitedSender{
+		"service": &mockSender{closeErr: errClose},
+	}
+
+	require.NoError(t, srv.Start())
+	err = srv.Stop()
+	assert.ErrorIs(t, err, errClose)
+	assert.ErrorContains(t, err, "service")
+}
+
+func TestServer_RXTransform(t *testing.T) {
+	errDrop := errors.New("drop")
+
+	legacy := wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+		if !strings.HasPrefix(msg.Destination, "legacy:") {
+			return msg, wrp.ErrNotHandled
+		}
+		msg.Destination = "mac:" + strings.TrimPrefix(msg.Destination, "legacy:") + "-v2"
+		return msg, nil
+	})
+
+	tests := []struct {
+		name        string
+		transforms  []wrp.Modifier
+		dest        string
+		expectRoute string
+		expectedErr error
+	}{
+		{
+			name:        "No transforms",
+			dest:        "mac:112233445566/service",
+			expectRoute: "service",
+		}, {
+			name:        "Transform not applied",
+			transforms:  []wrp.Modifier{legacy},
+			dest:        "mac:112233445566/service",
+			expectRoute: "service",
+		}, {
+			name:        "Rewritten destination",
+			transforms:  []wrp.Modifier{legacy},
+			dest:        "legacy:112233445566/service",
+			expectRoute: "service-v2",
+		}, {
+			name: "Transform drops the message",
+			transforms: []wrp.Modifier{
+				wrp.ModifierFunc(func(_ context.Context, msg wrp.Message) (wrp.Message, error) {
+					return msg, errDrop
+				}),
+				legacy,
+			},
+			dest:        "legacy:112233445566/service",
+			expectedErr: errDrop,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *Server
+			var observed []string
+
+			opts := []ServerOption{
+				withReceiver(&mockReceiver{}),
+				WithRXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+					observed = append(observed, msg.Destination)
+				})),
+				// The application forwards what it receives back to the
+				// registered services.
+				WithEgressModifier(wrp.ObserverAsModifier(
+					wrp.ObserverFunc(func(ctx context.Context, msg wrp.Message) {
+						_ = srv.ProcessWRP(ctx, msg)
+					}),
+				)),
+			}
+			for _, m := range tt.transforms {
+				opts = append(opts, WithRXTransform(m))
+			}
+
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			senders := map[string]*mockSender{
+				"service":    {},
+				"service-v2": {},
+			}
+			srv.senders.senders = map[string]limitedSender{}
+			for name, s := range senders {
+				srv.senders.senders[name] = s
+			}
+
+			err = srv.rxChain.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: tt.dest,
+			})
+
+			// The rx observers always see the message as received.
+			assert.Equal(t, []string{tt.dest}, observed)
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				for _, s := range senders {
+					assert.Zero(t, s.processCount)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			for name, s := range senders {
+				if name == tt.expectRoute {
+					assert.Equal(t, 1, s.processCount, name)
+				} else {
+					assert.Zero(t, s.processCount, name)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ServiceNameValidator(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	errBadName := errors.New("bad service name")
+	validator := func(name string) error {
+		if strings.ContainsAny(name, "/:") || strings.ContainsFunc(name, unicode.IsControl) {
+			return errBadName
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		validator   func(string) error
+		service     string
+		expectedErr error
+	}{
+		{
+			name:    "Default accepts any name",
+			service: "bad/service",
+		}, {
+			name:      "Accepted",
+			validator: validator,
+			service:   "service",
+		}, {
+			name:        "Path separator",
+			validator:   validator,
+			service:     "bad/service",
+			expectedErr: errBadName,
+		}, {
+			name:        "Control character",
+			validator:   validator,
+			service:     "bad\nservice",
+			expectedErr: errBadName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithServiceNameValidator(tt.validator),
+			)
+			require.NoError(t, err)
+			defer srv.Stop() // nolint:errcheck
+
+			err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+				Type:        wrp.ServiceRegistrationMessageType,
+				ServiceName: tt.service,
+				URL:         url,
+			})
+
+			srv.senders.lock.Lock()
+			_, registered := srv.senders.senders[tt.service]
+			srv.senders.lock.Unlock()
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.False(t, registered)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, registered)
+		})
+	}
+}
+
+func TestServer_RegistrationAuthorizer(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	svc, err := receiver.New(
+		receiver.WithURL(url),
+		receiver.WithRecvTimeout(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, svc.Listen())
+	defer svc.Close() // nolint:errcheck
+
+	errUnauthorized := errors.New("unauthorized")
+	authorizer := func(_ context.Context, msg wrp.Message) error {
+		if msg.Metadata["token"] != "secret" {
+			return errUnauthorized
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		authorizer  func(context.Context, wrp.Message) error
+		metadata    map[string]string
+		expectedErr error
+	}{
+		{
+			name: "Default accepts every registration",
+		}, {
+			name:       "Authorized",
+			authorizer: authorizer,
+			metadata:   map[string]string{"token": "secret"},
+		}, {
+			name:        "Wrong token",
+			authorizer:  authorizer,
+			metadata:    map[string]string{"token": "guess"},
+			expectedErr: errUnauthorized,
+		}, {
+			name:        "No token",
+			authorizer:  authorizer,
+			expectedErr: errUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var registrations atomic.Int64
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithRegistrationAuthorizer(tt.authorizer),
+				WithRegistrationListener(func(string, string, bool) {
+					registrations.Add(1)
+				}),
+			)
+			require.NoError(t, err)
+			defer srv.Stop() // nolint:errcheck
+
+			err = srv.handleRegisterMsg(context.Background(), wrp.Message{
+				Type:        wrp.ServiceRegistrationMessageType,
+				ServiceName: "service",
+				URL:         url,
+				Metadata:    tt.metadata,
+			})
+
+			srv.senders.lock.Lock()
+			_, registered := srv.senders.senders["service"]
+			srv.senders.lock.Unlock()
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.False(t, registered)
+				assert.Zero(t, registrations.Load())
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, registered)
+		})
+	}
+}
+
+func TestServer_SetHeartbeatInterval(t *testing.T) {
+	fc := newFakeClock()
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(100*time.Millisecond),
+		withClock(fc),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	fc.BlockUntil(1)
+	fc.Advance(100 * time.Millisecond)
+	fc.BlockUntil(1)
+	assert.Equal(t, int64(1), counter.count.Load())
+
+	// The new cadence takes over right away.  The abandoned wait for the old
+	// interval is still pending on the clock.
+	srv.SetHeartbeatInterval(30 * time.Millisecond)
+	for i := int64(2); i <= 3; i++ {
+		fc.BlockUntil(2)
+		fc.Advance(30 * time.Millisecond)
+		fc.BlockUntil(2)
+		assert.Equal(t, i, counter.count.Load())
+	}
+
+	// Disabled, nothing more is sent, however long it waits.
+	srv.SetHeartbeatInterval(0)
+	fc.Advance(time.Hour)
+	assert.Equal(t, int64(3), counter.count.Load())
+
+	// Enabled again.
+	srv.SetHeartbeatInterval(50 * time.Millisecond)
+	fc.BlockUntil(1)
+	fc.Advance(50 * time.Millisecond)
+	fc.BlockUntil(1)
+	assert.Equal(t, int64(4), counter.count.Load())
+	require.NoError(t, srv.Stop())
+
+	// Changing it when stopped only sets it for the next Start.
+	srv.SetHeartbeatInterval(time.Second)
+	assert.Equal(t, time.Second, srv.interval())
+}
+
+func TestServer_SetHeartbeatIntervalEnables(t *testing.T) {
+	fc := newFakeClock()
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(0),
+		withClock(fc),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	srv.SetHeartbeatInterval(10 * time.Millisecond)
+	fc.BlockUntil(1)
+	fc.Advance(10 * time.Millisecond)
+	fc.BlockUntil(1)
+	assert.Equal(t, int64(1), counter.count.Load())
+}
+
+func TestServer_SetHeartbeatIntervalFromObserver(t *testing.T) {
+	fc := newFakeClock()
+	var srv *Server
+	srv, err := NewServer(
+		withReceiver(&mockReceiver{}),
+		WithHeartbeatInterval(10*time.Millisecond),
+		withClock(fc),
+		WithTXObserver(wrp.ObserverFunc(func(_ context.Context, msg wrp.Message) {
+			// Runs on the heartbeat loop, which can't take the change until
+			// the observer returns.
+			if msg.Type == wrp.ServiceAliveMessageType {
+				srv.SetHeartbeatInterval(20 * time.Millisecond)
+				srv.SetHeartbeatInterval(30 * time.Millisecond)
+			}
+		})),
+	)
+	require.NoError(t, err)
+
+	counter := &countingSender{}
+	srv.senders.senders = map[string]limitedSender{
+		"counter": counter,
+	}
+
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	fc.BlockUntil(1)
+	fc.Advance(10 * time.Millisecond)
+	fc.BlockUntil(1)
+	assert.Equal(t, int64(1), counter.count.Load())
+
+	// The latest interval is used, and the wake up for the change doesn't
+	// schedule another heartbeat.
+	fc.Advance(30 * time.Millisecond)
+	fc.BlockUntil(1)
+	assert.Equal(t, int64(2), counter.count.Load())
+	assert.Equal(t, 30*time.Millisecond, srv.interval())
+}
+
+// slowSender blocks each send until the context is done.
+type slowSender struct {
+	mockSender
+}
+
+func (*slowSender) ProcessWRP(ctx context.Context, _ wrp.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestServer_InboundTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		sender  limitedSender
+		expect  error
+	}{
+		{
+			name:    "Slow sender",
+			timeout: 50 * time.Millisecond,
+			sender:  &slowSender{},
+			expect:  ErrInboundTimeout,
+		}, {
+			name:    "Fast sender",
+			timeout: 50 * time.Millisecond,
+			sender:  &mockSender{},
+		}, {
+			name:   "No timeout",
+			sender: &mockSender{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *Server
+			forwarded := make(chan error, 1)
+
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithInboundTimeout(tt.timeout),
+				// The application forwards the message using its context.
+				WithEgressProcessor(wrp.ProcessorFunc(func(ctx context.Context, msg wrp.Message) error {
+					err := srv.ProcessWRP(ctx, msg)
+					forwarded <- err
+					return err
+				})),
+			)
+			require.NoError(t, err)
+
+			srv.senders.senders = map[string]limitedSender{
+				"service": tt.sender,
+			}
+
+			start := time.Now()
+			err = srv.receiveWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "mac:112233445566",
+				Destination: "mac:112233445566/service",
+			})
+			fwdErr := <-forwarded
+
+			if tt.expect == nil {
+				assert.NoError(t, err)
+				assert.NoError(t, fwdErr)
+				return
+			}
+
+			assert.Less(t, time.Since(start), 5*time.Second)
+			assert.ErrorIs(t, err, ErrInboundTimeout)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.ErrorIs(t, fwdErr, ErrInboundTimeout)
+
+			var se *SendError
+			assert.ErrorAs(t, fwdErr, &se)
+		})
+	}
+}
+
+func TestServer_LoopGuard(t *testing.T) {
+	// Routes the alias service to service, as well as the usual locator routing.
+	alias := WithRouteKeyFunc(func(msg wrp.Message) (string, error) {
+		l, err := wrp.ParseLocator(msg.Destination)
+		if err != nil {
+			return "", err
+		}
+		if l.Service == "alias" {
+			return "service", nil
+		}
+		return l.Service, nil
+	})
+
+	tests := []struct {
+		name        string
+		opts        []ServerOption
+		source      string
+		dest        string
+		metadata    map[string]string
+		expectLoop  bool
+		expectRoute string
+	}{
+		{
+			name:        "Off by default",
+			source:      "mac:112233445566/service",
+			dest:        "mac:112233445566/service",
+			expectRoute: "service",
+		}, {
+			name:       "Back to the source",
+			opts:       []ServerOption{WithLoopGuard()},
+			source:     "mac:112233445566/service",
+			dest:       "mac:aabbccddeeff/service/ignored",
+			expectLoop: true,
+		}, {
+			name:        "To another service",
+			opts:        []ServerOption{WithLoopGuard()},
+			source:      "mac:112233445566/service",
+			dest:        "mac:112233445566/other",
+			expectRoute: "other",
+		}, {
+			name:        "Source that isn't a locator",
+			opts:        []ServerOption{WithLoopGuard()},
+			source:      "not a locator",
+			dest:        "mac:112233445566/service",
+			expectRoute: "service",
+		}, {
+			name:       "Through a wildcard",
+			opts:       []ServerOption{WithLoopGuard(), WithWildcardRoutes()},
+			source:     "mac:112233445566/other-1",
+			dest:       "mac:112233445566/other-2",
+			expectLoop: true,
+		}, {
+			name:       "Source through the route key function",
+			opts:       []ServerOption{WithLoopGuard(), alias},
+			source:     "mac:112233445566/alias",
+			dest:       "mac:112233445566/service",
+			expectLoop: true,
+		}, {
+			name:        "Route key function to another service",
+			opts:        []ServerOption{WithLoopGuard(), alias},
+			source:      "mac:112233445566/alias",
+			dest:        "mac:112233445566/other",
+			expectRoute: "other",
+		}, {
+			name:       "Metadata route back to the source",
+			opts:       []ServerOption{WithLoopGuard(), WithMetadataRoute("route")},
+			source:     "mac:112233445566/service",
+			dest:       "mac:112233445566/other",
+			metadata:   map[string]string{"route": "service"},
+			expectLoop: true,
+		}, {
+			name:        "Metadata route away from the source",
+			opts:        []ServerOption{WithLoopGuard(), WithMetadataRoute("route")},
+			source:      "mac:112233445566/service",
+			dest:        "mac:112233445566/service",
+			metadata:    map[string]string{"route": "other"},
+			expectRoute: "other",
+		}, {
+			name:        "Metadata route with the route key function",
+			opts:        []ServerOption{WithLoopGuard(), WithMetadataRoute("route"), alias},
+			source:      "mac:112233445566/alias",
+			dest:        "mac:112233445566/service",
+			metadata:    map[string]string{"route": "other"},
+			expectRoute: "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ServerOption{withReceiver(&mockReceiver{})}, tt.opts...)
+			srv, err := NewServer(opts...)
+			require.NoError(t, err)
+
+			senders := map[string]*mockSender{
+				"service": {},
+				"other":   {},
+				"other-*": {},
+			}
+			srv.senders.senders = map[string]limitedSender{}
+			for name, s := range senders {
+				srv.senders.senders[name] = s
+			}
+
+			err = srv.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      tt.source,
+				Destination: tt.dest,
+				Metadata:    tt.metadata,
+			})
+
+			if tt.expectLoop {
+				assert.ErrorIs(t, err, ErrRoutingLoop)
+				for _, s := range senders {
+					assert.Zero(t, s.processCount)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			for name, s := range senders {
+				if name == tt.expectRoute {
+					assert.Equal(t, 1, s.processCount, name)
+				} else {
+					assert.Zero(t, s.processCount, name)
+				}
+			}
+		})
+	}
 }
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// SourceInfo describes a message source that has been seen by the Server.
+type SourceInfo struct {
+	// Source is the msg.Source value.
+	Source string
+
+	// LastSeen is the last time a message from the source was seen.
+	LastSeen time.Time
+
+	// Count is the number of messages seen from the source.
+	Count int
+}
+
+// sourceRegistry tracks the sources of the messages it observes.  Sources that
+// have not been seen for longer than the expiry are dropped.  It is safe for
+// concurrent access.
+//
+// Expired sources are swept when the sources are listed, and at most once per
+// expiry while observing, so observing a message doesn't walk every source.
+type sourceRegistry struct {
+	expiry  time.Duration
+	now     func() time.Time
+	sources map[string]*SourceInfo
+	swept   time.Time
+	lock    sync.Mutex
+}
+
+var _ wrp.Observer = (*sourceRegistry)(nil)
+
+// ObserveWRP records the source of the message.  Messages without a source are
+// ignored.
+func (sr *sourceRegistry) ObserveWRP(_ context.Context, msg wrp.Message) {
+	if msg.Source == "" {
+		return
+	}
+
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+
+	now := sr.clock()
+	if sr.expiry > 0 && now.Sub(sr.swept) >= sr.expiry {
+		sr.expire(now)
+	}
+
+	if sr.sources == nil {
+		sr.sources = make(map[string]*SourceInfo)
+	}
+
+	// A source that expired but wasn't swept yet starts over.
+	info := sr.sources[msg.Source]
+	if info == nil || sr.expired(info, now) {
+		info = &SourceInfo{Source: msg.Source}
+		sr.sources[msg.Source] = info
+	}
+
+	info.LastSeen = now
+	info.Count++
+}
+
+// Sources returns the sources that have not expired, sorted by source.
+func (sr *sourceRegistry) Sources() []SourceInfo {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+
+	sr.expire(sr.clock())
+
+	rv := make([]SourceInfo, 0, len(sr.sources))
+	for _, info := range sr.sources {
+		rv = append(rv, *info)
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Source < rv[j].Source
+	})
+
+	return rv
+}
+
+// expire removes the sources that have not been seen within the expiry.  The
+// lock must be held.
+func (sr *sourceRegistry) expire(now time.Time) {
+	if sr.expiry <= 0 {
+		return
+	}
+
+	sr.swept = now
+	for k, info := range sr.sources {
+		if sr.expired(info, now) {
+			delete(sr.sources, k)
+		}
+	}
+}
+
+// expired reports whether the source has not been seen within the expiry.
+func (sr *sourceRegistry) expired(info *SourceInfo, now time.Time) bool {
+	return sr.expiry > 0 && now.Sub(info.LastSeen) > sr.expiry
+}
+
+func (sr *sourceRegistry) clock() time.Time {
+	if sr.now != nil {
+		return sr.now()
+	}
+	return time.Now()
+}
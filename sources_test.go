@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestSourceRegistry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sr := sourceRegistry{
+		expiry: time.Minute,
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	for _, src := range []string{"mac:112233445566", "mac:aabbccddeeff", "", "mac:112233445566"} {
+		sr.ObserveWRP(context.Background(), wrp.Message{Source: src})
+	}
+
+	assert.Equal(t, []SourceInfo{
+		{Source: "mac:112233445566", LastSeen: now, Count: 2},
+		{Source: "mac:aabbccddeeff", LastSeen: now, Count: 1},
+	}, sr.Sources())
+
+	// Only one of the sources is seen again before the other expires.
+	now = now.Add(45 * time.Second)
+	sr.ObserveWRP(context.Background(), wrp.Message{Source: "mac:aabbccddeeff"})
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, []SourceInfo{
+		{Source: "mac:aabbccddeeff", LastSeen: now.Add(-30 * time.Second), Count: 2},
+	}, sr.Sources())
+
+	now = now.Add(time.Hour)
+	assert.Empty(t, sr.Sources())
+}
+
+func TestSourceRegistry_SweepAmortized(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	sr := sourceRegistry{
+		expiry: time.Minute,
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	observe := func(after time.Duration, src string) {
+		now = start.Add(after)
+		sr.ObserveWRP(context.Background(), wrp.Message{Source: src})
+	}
+	count := func() int {
+		sr.lock.Lock()
+		defer sr.lock.Unlock()
+		return len(sr.sources)
+	}
+
+	observe(0, "dns:gone.example.com")
+	observe(50*time.Second, "mac:aabbccddeeff")
+
+	// A minute after the last sweep, observing sweeps the expired sources.
+	observe(100*time.Second, "mac:112233445566")
+	assert.Equal(t, 2, count())
+
+	// Within a minute of the last sweep, an expired source is left in place,
+	// but starts over when it is seen again.
+	observe(140*time.Second, "mac:112233445566")
+	assert.Equal(t, 2, count())
+	observe(150*time.Second, "mac:aabbccddeeff")
+
+	assert.Equal(t, []SourceInfo{
+		{Source: "mac:112233445566", LastSeen: start.Add(140 * time.Second), Count: 2},
+		{Source: "mac:aabbccddeeff", LastSeen: now, Count: 1},
+	}, sr.Sources())
+}
+
+func TestSourceRegistry_NoExpiry(t *testing.T) {
+	var sr sourceRegistry
+
+	sr.ObserveWRP(context.Background(), wrp.Message{Source: "mac:112233445566"})
+	sr.now = func() time.Time {
+		return time.Now().Add(1000 * time.Hour)
+	}
+
+	got := sr.Sources()
+	require.Len(t, got, 1)
+	assert.Equal(t, "mac:112233445566", got[0].Source)
+}
+
+func TestServer_Sources(t *testing.T) {
+	srv, err := NewServer(withReceiver(&mockReceiver{}))
+	require.NoError(t, err)
+
+	for _, src := range []string{"dns:a.example.com", "dns:b.example.com", "dns:a.example.com"} {
+		_ = srv.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      src,
+			Destination: "mac:112233445566/service",
+		})
+	}
+
+	got := srv.Sources()
+	require.Len(t, got, 2)
+	assert.Equal(t, "dns:a.example.com", got[0].Source)
+	assert.Equal(t, 2, got[0].Count)
+	assert.Equal(t, "dns:b.example.com", got[1].Source)
+	assert.Equal(t, 1, got[1].Count)
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// The names of the spans started by the Server.
+const (
+	// SpanReceive is the span covering a message received from the network,
+	// through the rx chain and the egress modifiers.
+	SpanReceive = "wrpnng.receive"
+
+	// SpanProcess is the span covering a message passed to
+	// Server.ProcessWRP, through the ingress chain until it is sent.
+	SpanProcess = "wrpnng.process"
+)
+
+// Tracer starts a span for each message the Server handles.  Start returns a
+// context carrying the span, which is passed through the rest of the
+// processing, and a function that ends the span with the result.  The result
+// is nil on success.  A Tracer is typically a small adapter around an
+// OpenTelemetry trace.Tracer.
+type Tracer interface {
+	Start(ctx context.Context, name string, msg wrp.Message) (context.Context, func(error))
+}
+
+// startSpan starts a span using the tracer, if there is one.
+func (srv *Server) startSpan(ctx context.Context, name string, msg wrp.Message) (context.Context, func(error)) {
+	if srv.tracer == nil {
+		return ctx, func(error) {}
+	}
+	return srv.tracer.Start(ctx, name, msg)
+}
+
+// receiveWRP processes a message received from the network in its own span.
+func (srv *Server) receiveWRP(ctx context.Context, msg wrp.Message) error {
+	ctx, end := srv.startSpan(ctx, SpanReceive, msg)
+
+	if srv.inboundTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, srv.inboundTimeout, ErrInboundTimeout)
+		defer cancel()
+	}
+
+	err := inboundTimedOut(ctx, srv.rxChain.ProcessWRP(ctx, msg))
+
+	// Reaching the end of the chain isn't a failure.
+	if errors.Is(err, wrp.ErrNotHandled) {
+		end(nil)
+	} else {
+		end(err)
+	}
+
+	return err
+}
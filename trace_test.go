@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnng
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng/internal/sender"
+)
+
+type spanKey struct{}
+
+// recordedSpan is a span started by the recordingTracer.
+type recordedSpan struct {
+	name   string
+	source string
+	ended  bool
+	err    error
+}
+
+// recordingTracer keeps the spans it starts.
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string, msg wrp.Message) (context.Context, func(error)) {
+	span := &recordedSpan{
+		name:   name,
+		source: msg.Source,
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.spans = append(rt.spans, span)
+
+	return context.WithValue(ctx, spanKey{}, span), func(err error) {
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+		span.ended = true
+		span.err = err
+	}
+}
+
+// ended returns a copy of the spans that have ended.
+func (rt *recordingTracer) ended() []recordedSpan {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	var spans []recordedSpan
+	for _, span := range rt.spans {
+		if span.ended {
+			spans = append(spans, *span)
+		}
+	}
+	return spans
+}
+
+// spanSources records the source of the span in the context of each message.
+type spanSources struct {
+	lock    sync.Mutex
+	sources []string
+}
+
+func (s *spanSources) ObserveWRP(ctx context.Context, _ wrp.Message) {
+	var source string
+	if span, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		source = span.source
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sources = append(s.sources, source)
+}
+
+func (s *spanSources) get() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.sources...)
+}
+
+func TestServer_TracerReceive(t *testing.T) {
+	url, err := findOpenURL()
+	require.NoError(t, err)
+
+	tracer := &recordingTracer{}
+	rx := &spanSources{}
+	egress := &spanSources{}
+	srv, err := NewServer(
+		RXURL(url),
+		WithHeartbeatInterval(0),
+		WithTracer(tracer),
+		WithRXObserver(rx),
+		WithEgressModifier(wrp.ObserverAsModifier(egress)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer srv.Stop() // nolint:errcheck
+
+	s, err := sender.New(sender.WithURL(url))
+	require.NoError(t, err)
+	require.NoError(t, s.Dial())
+	defer s.Close() // nolint:errcheck
+
+	var sources []string
+	for i := 0; i < 3; i++ {
+		source := fmt.Sprintf("mac:11223344556%d", i)
+		sources = append(sources, source)
+		require.NoError(t, s.ProcessWRP(context.Background(), wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      source,
+			Destination: "event:test",
+		}))
+	}
+
+	require.Eventually(t, func() bool {
+		return len(tracer.ended()) == 3
+	}, 60*time.Second, 10*time.Millisecond)
+
+	for _, span := range tracer.ended() {
+		assert.Equal(t, SpanReceive, span.name)
+		assert.NoError(t, span.err)
+	}
+
+	// Each message is observed in its own span.
+	assert.ElementsMatch(t, sources, rx.get())
+	assert.ElementsMatch(t, sources, egress.get())
+}
+
+func TestServer_TracerProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		dest      string
+		expectErr error
+	}{
+		{
+			name: "sent",
+			dest: "mac:112233445566/service",
+		}, {
+			name:      "no route",
+			dest:      "mac:112233445566/unknown",
+			expectErr: ErrNoRoute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordingTracer{}
+			ingress := &spanSources{}
+			srv, err := NewServer(
+				withReceiver(&mockReceiver{}),
+				WithTracer(tracer),
+				WithIngressProcessor(wrp.ObserverAsProcessor(ingress), AfterFilters),
+			)
+			require.NoError(t, err)
+
+			srv.senders.senders = map[string]limitedSender{
+				"service": &mockSender{},
+			}
+
+			err = srv.ProcessWRP(context.Background(), wrp.Message{
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:example.com",
+				Destination: tt.dest,
+			})
+
+			spans := tracer.ended()
+			require.Len(t, spans, 1)
+			assert.Equal(t, SpanProcess, spans[0].name)
+			assert.Equal(t, "dns:example.com", spans[0].source)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+				assert.ErrorIs(t, spans[0].err, tt.expectErr)
+			} else {
+				assert.NoError(t, err)
+				assert.NoError(t, spans[0].err)
+			}
+
+			// The processors see the span.
+			assert.Equal(t, []string{"dns:example.com"}, ingress.get())
+		})
+	}
+}
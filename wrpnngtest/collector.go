@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnngtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// ErrWaitTimeout is returned by Collector.Wait when the messages don't arrive
+// in time.
+var ErrWaitTimeout = errors.New("timed out waiting for messages")
+
+// Collector keeps the messages it observes so a test can wait for them.  It is
+// both a wrp.Observer and a wrp.Modifier, so it can be passed to options such
+// as wrpnng.WithRXObserver and wrpnng.WithReceivedModifier.  It is safe for
+// concurrent use.
+type Collector struct {
+	types []wrp.MessageType
+
+	lock     sync.Mutex
+	messages []wrp.Message
+	changed  chan struct{}
+}
+
+var (
+	_ wrp.Observer = (*Collector)(nil)
+	_ wrp.Modifier = (*Collector)(nil)
+)
+
+// NewCollector creates a Collector that keeps the messages of the types, or
+// all messages if no types are provided.
+func NewCollector(types ...wrp.MessageType) *Collector {
+	return &Collector{
+		types:   slices.Clone(types),
+		changed: make(chan struct{}),
+	}
+}
+
+// ObserveWRP keeps the message if it is one of the collected types.
+func (c *Collector) ObserveWRP(_ context.Context, msg wrp.Message) {
+	if len(c.types) > 0 && !slices.Contains(c.types, msg.Type) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = append(c.messages, msg)
+
+	// Wake up the waiters.
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// ModifyWRP keeps the message the same as ObserveWRP, and returns
+// wrp.ErrNotHandled so the message is passed on unchanged.
+func (c *Collector) ModifyWRP(ctx context.Context, msg wrp.Message) (wrp.Message, error) {
+	c.ObserveWRP(ctx, msg)
+	return msg, wrp.ErrNotHandled
+}
+
+// Messages returns a copy of the messages collected so far, in the order they
+// were observed.
+func (c *Collector) Messages() []wrp.Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return slices.Clone(c.messages)
+}
+
+// Len returns the number of messages collected so far.
+func (c *Collector) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.messages)
+}
+
+// Reset drops the messages collected so far.
+func (c *Collector) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = nil
+}
+
+// Wait waits until at least n messages are collected, and returns a copy of
+// them.  If they aren't collected within the timeout, the messages collected
+// so far are returned with ErrWaitTimeout.
+func (c *Collector) Wait(n int, timeout time.Duration) ([]wrp.Message, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	for {
+		c.lock.Lock()
+		got := slices.Clone(c.messages)
+		changed := c.changed
+		c.lock.Unlock()
+
+		if len(got) >= n {
+			return got, nil
+		}
+
+		select {
+		case <-changed:
+		case <-t.C:
+			return got, fmt.Errorf("%w: got %d of %d", ErrWaitTimeout, len(got), n)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnngtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestCollector(t *testing.T) {
+	event := wrp.Message{Type: wrp.SimpleEventMessageType, Source: "event"}
+	request := wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Source: "request"}
+
+	tests := []struct {
+		name      string
+		types     []wrp.MessageType
+		send      []wrp.Message
+		wait      int
+		expect    []wrp.Message
+		expectErr error
+	}{
+		{
+			name:   "all types",
+			send:   []wrp.Message{event, request},
+			wait:   2,
+			expect: []wrp.Message{event, request},
+		}, {
+			name:   "only events",
+			types:  []wrp.MessageType{wrp.SimpleEventMessageType},
+			send:   []wrp.Message{event, request, event},
+			wait:   2,
+			expect: []wrp.Message{event, event},
+		}, {
+			name:   "nothing to wait for",
+			expect: []wrp.Message{},
+		}, {
+			name:      "timeout",
+			types:     []wrp.MessageType{wrp.SimpleEventMessageType},
+			send:      []wrp.Message{event, request},
+			wait:      2,
+			expect:    []wrp.Message{event},
+			expectErr: ErrWaitTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector(tt.types...)
+
+			// Send concurrently, alternating between the two interfaces.
+			go func() {
+				for i, msg := range tt.send {
+					if i%2 == 0 {
+						c.ObserveWRP(context.Background(), msg)
+						continue
+					}
+					got, err := c.ModifyWRP(context.Background(), msg)
+					assert.ErrorIs(t, err, wrp.ErrNotHandled)
+					assert.Equal(t, msg, got)
+				}
+			}()
+
+			got, err := c.Wait(tt.wait, 50*time.Millisecond)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			if len(tt.expect) == 0 {
+				assert.Empty(t, got)
+			} else {
+				assert.Equal(t, tt.expect, got)
+			}
+			assert.Equal(t, len(got), c.Len())
+			assert.Equal(t, got, c.Messages())
+
+			c.Reset()
+			assert.Zero(t, c.Len())
+		})
+	}
+}
+
+func TestCollector_WaitForLater(t *testing.T) {
+	c := NewCollector()
+
+	// Collected while Wait is blocked.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.ObserveWRP(context.Background(), wrp.Message{Type: wrp.SimpleEventMessageType})
+	}()
+
+	got, err := c.Wait(1, 5*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+}
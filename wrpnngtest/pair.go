@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+// Package wrpnngtest provides helpers for testing code that uses wrpnng
+// without opening network sockets.
+package wrpnngtest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xmidt-org/wrpnng"
+
+	// register transports
+	_ "go.nanomsg.org/mangos/v3/transport/inproc"
+)
+
+var pairs atomic.Int64
+
+// Pair is a Server and a Client connected to it using the inproc transport.
+type Pair struct {
+	// Server is the started Server.
+	Server *wrpnng.Server
+
+	// Client is the started Client, connected to Server.
+	Client *wrpnng.Client
+
+	// ServerURL is the inproc URL the Server listens on.
+	ServerURL string
+
+	// ClientURL is the inproc URL used by the Client.
+	ClientURL string
+}
+
+// NewInProcPair creates and starts a Server and a Client connected over the
+// inproc transport, so tests don't need real sockets or open ports.  The
+// options are applied after the URL options, and may add anything else the
+// test needs.  Both are stopped when the test finishes.  If either can't be
+// created or started, the test fails.
+func NewInProcPair(tb testing.TB, srvOpts []wrpnng.ServerOption, clientOpts []wrpnng.ClientOption) *Pair {
+	tb.Helper()
+
+	n := pairs.Add(1)
+	p := Pair{
+		ServerURL: fmt.Sprintf("inproc://wrpnngtest-server-%d", n),
+		ClientURL: fmt.Sprintf("inproc://wrpnngtest-client-%d", n),
+	}
+
+	srv, err := wrpnng.NewServer(append([]wrpnng.ServerOption{
+		wrpnng.RXURL(p.ServerURL),
+	}, srvOpts...)...)
+	if err != nil {
+		tb.Fatalf("creating the server: %v", err)
+	}
+	if err = srv.Start(); err != nil {
+		tb.Fatalf("starting the server: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = srv.Stop()
+	})
+
+	client, err := wrpnng.NewClient(append([]wrpnng.ClientOption{
+		wrpnng.WithServerURL(p.ServerURL),
+		wrpnng.WithClientURL(p.ClientURL),
+	}, clientOpts...)...)
+	if err != nil {
+		tb.Fatalf("creating the client: %v", err)
+	}
+	if err = client.Start(); err != nil {
+		tb.Fatalf("starting the client: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = client.Stop()
+	})
+
+	p.Server = srv
+	p.Client = client
+	return &p
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrpnngtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v3"
+	"github.com/xmidt-org/wrpnng"
+)
+
+func TestNewInProcPair(t *testing.T) {
+	received := NewCollector(wrp.SimpleEventMessageType)
+
+	p := NewInProcPair(t,
+		[]wrpnng.ServerOption{
+			wrpnng.RXTimeout(10 * time.Millisecond),
+			wrpnng.WithRXObserver(received),
+		},
+		[]wrpnng.ClientOption{
+			wrpnng.WithClientHeartbeatInterval(0),
+		},
+	)
+	require.NotNil(t, p.Server)
+	require.NotNil(t, p.Client)
+
+	sent := wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "mac:112233445566/service",
+		Destination: "event:status",
+		Payload:     []byte("hello"),
+	}
+	require.NoError(t, p.Client.ProcessWRP(context.Background(), sent))
+
+	got, err := received.Wait(1, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, sent.Source, got[0].Source)
+	assert.Equal(t, sent.Payload, got[0].Payload)
+}
+
+func TestNewInProcPair_Unique(t *testing.T) {
+	a := NewInProcPair(t, nil, nil)
+	b := NewInProcPair(t, nil, nil)
+
+	assert.NotEqual(t, a.ServerURL, b.ServerURL)
+	assert.NotEqual(t, a.ClientURL, b.ClientURL)
+}